@@ -0,0 +1,75 @@
+package ngrok
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDraining is the reason given in the [AcceptEvent] for connections that
+// were rejected because the [Tunnel] was draining. See [Tunnel].SetDraining.
+var ErrDraining = errors.New("tunnel is draining")
+
+// ErrRateLimited is the reason given in the [AcceptEvent] for connections
+// that were rejected because they arrived faster than the [Tunnel] or its
+// [Session] allows. See [WithMaxConnsPerSecond].
+var ErrRateLimited = errors.New("connection rate limit exceeded")
+
+// AcceptEvent describes the outcome of a connection arriving at a [Tunnel],
+// as reported to the callback registered with [Tunnel].OnAccept.
+type AcceptEvent struct {
+	// An identifier for the connection, unique among those that arrived at
+	// the same tunnel. Accepted connections report it from their ConnID
+	// method.
+	ConnID uint64
+	// The tunnel that the connection arrived at.
+	TunnelID string
+	// The address of the client that initiated the connection at the ngrok
+	// edge.
+	ClientAddr string
+	// When the connection arrived.
+	Time time.Time
+	// Nil if the connection was returned from Accept. Otherwise, the reason
+	// that it was closed instead, such as [ErrDraining].
+	Rejected error
+}
+
+// Reports the outcome of a connection to the OnAccept callback, if any.
+// Returns an error if the callback panicked.
+func (t *tunnelImpl) notifyAccept(id uint64, clientAddr string, rejected error) error {
+	if rejected != nil {
+		atomic.AddUint64(&t.rejectedConns, 1)
+	} else {
+		atomic.AddUint64(&t.acceptedConns, 1)
+	}
+
+	// Checked first, since even discarded messages cost allocations on this
+	// path.
+	if t.logger != nil {
+		if rejected != nil {
+			t.logger.Debug("connection rejected", "conn", id, "client_addr", clientAddr, "reason", rejected)
+		} else {
+			t.logger.Debug("connection accepted", "conn", id, "client_addr", clientAddr)
+		}
+	}
+
+	fn, _ := t.onAccept.Load().(func(AcceptEvent))
+	if fn == nil {
+		return nil
+	}
+	event := AcceptEvent{
+		ConnID:     id,
+		TunnelID:   t.ID(),
+		ClientAddr: clientAddr,
+		Time:       clockOrSystem(t.clock).Now(),
+		Rejected:   rejected,
+	}
+	return t.guarded("accept", func() error {
+		fn(event)
+		return nil
+	})
+}
+
+func (t *tunnelImpl) OnAccept(fn func(AcceptEvent)) {
+	t.onAccept.Store(fn)
+}
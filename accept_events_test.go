@@ -0,0 +1,89 @@
+package ngrok
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnAccept(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	events := make(chan AcceptEvent, 2)
+	tun.OnAccept(func(event AcceptEvent) {
+		events <- event
+	})
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	accepted := <-events
+	require.NoError(t, accepted.Rejected)
+	require.Equal(t, "fake", accepted.TunnelID)
+	require.Equal(t, "127.0.0.1:1234", accepted.ClientAddr)
+	require.Equal(t, conn.(interface{ ConnID() uint64 }).ConnID(), accepted.ConnID)
+
+	// Rejected connections are reported before Accept moves on to the next.
+	tun.SetDraining(true)
+	rejected, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer rejected.Close()
+	go func() {
+		_, _ = tun.Accept()
+	}()
+
+	event := <-events
+	require.ErrorIs(t, event.Rejected, ErrDraining)
+	require.NotEqual(t, accepted.ConnID, event.ConnID)
+
+	tun.OnAccept(nil)
+	require.NoError(t, tun.Close())
+}
+
+func TestOnAcceptPanic(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	errs := make(chan error, 1)
+	impl.guard.errorHandler = func(ctx context.Context, sess Session, err error) {
+		errs <- err
+	}
+
+	panics := int32(1)
+	tun.OnAccept(func(event AcceptEvent) {
+		if atomic.AddInt32(&panics, -1) == 0 {
+			panic("oops")
+		}
+	})
+
+	first, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer first.Close()
+	second, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer second.Close()
+
+	// Only the connection whose callback panicked is closed.
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	err = <-errs
+	require.ErrorIs(t, err, errCallbackPanic{})
+	require.Equal(t, "accept", err.(errCallbackPanic).Callback)
+
+	require.NoError(t, first.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = first.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	_, err = io.WriteString(conn, "hi")
+	require.NoError(t, err)
+	_, err = io.ReadFull(second, make([]byte, 2))
+	require.NoError(t, err)
+}
@@ -0,0 +1,67 @@
+package ngrok
+
+import (
+	"net"
+	"sync"
+)
+
+// Accepts connections from a tunnel with several goroutines at once, handing
+// them out through a channel. The underlying tunnel_client.Tunnel hands out
+// connections from a channel of its own, so it's safe to call concurrently.
+type acceptWorkers struct {
+	results  chan acceptResult
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func startAcceptWorkers(n int, accept func() (net.Conn, error)) *acceptWorkers {
+	w := &acceptWorkers{
+		results: make(chan acceptResult),
+		done:    make(chan struct{}),
+	}
+	for i := 0; i < n; i++ {
+		go w.run(accept)
+	}
+	return w
+}
+
+func (w *acceptWorkers) run(accept func() (net.Conn, error)) {
+	for {
+		conn, err := accept()
+		select {
+		case w.results <- acceptResult{conn, err}:
+		case <-w.done:
+			// Nobody is left to hand the connection to.
+			if conn != nil {
+				_ = conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (w *acceptWorkers) accept() (net.Conn, error) {
+	select {
+	case res := <-w.results:
+		return res.conn, res.err
+	case <-w.done:
+		return nil, errAcceptFailed{Inner: net.ErrClosed}
+	}
+}
+
+func (w *acceptWorkers) stop() {
+	if w == nil {
+		return
+	}
+	w.stopOnce.Do(func() {
+		close(w.done)
+	})
+}
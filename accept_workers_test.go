@@ -0,0 +1,83 @@
+package ngrok
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+func TestAcceptWorkers(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.workers = startAcceptWorkers(4, impl.acceptOne)
+
+	const conns = 10
+	for i := 0; i < conns; i++ {
+		client, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer client.Close()
+	}
+
+	for i := 0; i < conns; i++ {
+		conn, err := tun.Accept()
+		require.NoError(t, err)
+		require.NotNil(t, conn.(*connImpl).Tun, "connections are wrapped by the workers")
+		_ = conn.Close()
+	}
+
+	require.NoError(t, tun.Close())
+	_, err := tun.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+	_, err = tun.Accept()
+	require.ErrorIs(t, err, net.ErrClosed, "Accept keeps failing once closed")
+}
+
+// A Tracer that takes a while to start each span, standing in for any
+// per-connection work done while accepting.
+type slowTracer struct{}
+
+func (slowTracer) StartConn(ctx context.Context, _ ConnAttributes) (context.Context, ConnSpan) {
+	time.Sleep(10 * time.Microsecond)
+	return ctx, nil
+}
+
+func BenchmarkAcceptConcurrency(b *testing.B) {
+	for _, bc := range []struct {
+		name        string
+		tracer      Tracer
+		concurrency int
+	}{
+		{"serial", nil, 1},
+		{"parallel", nil, 4},
+		{"slow/serial", slowTracer{}, 1},
+		{"slow/parallel", slowTracer{}, 4},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			tun := &tunnelImpl{
+				Tunnel: &benchClientTunnel{
+					conn: &tunnel_client.ProxyConn{Conn: nopConn{}},
+				},
+				tracer: bc.tracer,
+			}
+			if bc.concurrency > 1 {
+				tun.workers = startAcceptWorkers(bc.concurrency, tun.acceptOne)
+				defer tun.workers.stop()
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				conn, err := tun.Accept()
+				if err != nil {
+					b.Fatal(err)
+				}
+				_ = conn.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package ngrok
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat is the format of the lines written by [WithAccessLog].
+type AccessLogFormat int
+
+const (
+	// The Common Log Format:
+	//
+	//	host ident authuser [date] "request" status bytes duration
+	AccessLogCommon AccessLogFormat = iota
+	// The Combined Log Format, which adds the Referer and User-Agent:
+	//
+	//	host ident authuser [date] "request" status bytes "referer" "user-agent" duration
+	AccessLogCombined
+)
+
+// WithAccessLog configures [Serve], [ServeTLS], [ServeReverseProxy], and
+// [ServeBackends] to write a line to w for each request they serve, in the Apache Common or
+// Combined Log Format. The host is the address of the client that connected
+// to the ngrok edge, rather than that of the edge itself, and the size is the
+// number of bytes written for the response body. Each line ends with the time
+// taken to serve the request, in microseconds, as with Apache's %D.
+//
+// For [ServeBackends], lines end with the quoted URL of the backend that
+// served the request instead, after the time taken.
+//
+// Lines are written once the handler returns, or when the connection is
+// hijacked. Writes to w are serialized.
+func WithAccessLog(w io.Writer, format AccessLogFormat) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.AccessLog = &accessLog{w: w, format: format}
+	}
+}
+
+// Writes access log lines, as configured by WithAccessLog.
+type accessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format AccessLogFormat
+}
+
+// Wraps a handler so that each request it serves is logged.
+func accessLogHandler(handler http.Handler, log *accessLog) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		lw := &accessLogResponseWriter{ResponseWriter: rw}
+		start := time.Now()
+		entry := &accessLogEntry{}
+		req = req.WithContext(context.WithValue(req.Context(), accessLogKey{}, entry))
+		lw.onHijack = func() {
+			log.write(req, lw.status, lw.size, start, entry)
+		}
+		handler.ServeHTTP(lw, req)
+		if !lw.hijacked {
+			log.write(req, lw.status, lw.size, start, entry)
+		}
+	})
+}
+
+type accessLogKey struct{}
+
+// The details of a request that are filled in by the handler, for its access
+// log line.
+type accessLogEntry struct {
+	mu      sync.Mutex
+	backend string
+}
+
+// Records the backend that ServeBackends chose for the request, if it's being
+// logged.
+func setAccessLogBackend(req *http.Request, backend *url.URL) {
+	if entry, ok := req.Context().Value(accessLogKey{}).(*accessLogEntry); ok {
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		entry.backend = backend.String()
+	}
+}
+
+func (l *accessLog) write(req *http.Request, status int, size int64, start time.Time, entry *accessLogEntry) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	user := "-"
+	if name, _, ok := req.BasicAuth(); ok && name != "" {
+		user = name
+	}
+	bytes := "-"
+	if size > 0 {
+		bytes = strconv.FormatInt(size, 10)
+	}
+
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s - %s [%s] \"%s %s %s\" %d %s",
+		accessLogHost(req),
+		user,
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		req.Method,
+		req.RequestURI,
+		req.Proto,
+		status,
+		bytes,
+	)
+	if l.format == AccessLogCombined {
+		fmt.Fprintf(&line, " %s %s", accessLogQuote(req.Referer()), accessLogQuote(req.UserAgent()))
+	}
+	fmt.Fprintf(&line, " %d", time.Since(start).Microseconds())
+	entry.mu.Lock()
+	if entry.backend != "" {
+		fmt.Fprintf(&line, " %s", strconv.Quote(entry.backend))
+	}
+	entry.mu.Unlock()
+	line.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.w, line.String())
+}
+
+// Returns the IP of the client that connected to the ngrok edge for the
+// request, or of the request's remote address if it didn't arrive through a
+// tunnel.
+func accessLogHost(req *http.Request) string {
+	addr := req.RemoteAddr
+	if conn, ok := req.Context().Value(tunnelConnKey{}).(*connImpl); ok && conn.Proxy != nil && conn.Proxy.Header.ClientAddr != "" {
+		addr = conn.Proxy.Header.ClientAddr
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	if addr == "" {
+		return "-"
+	}
+	return addr
+}
+
+func accessLogQuote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
+
+// An http.ResponseWriter that records the status and size of the response.
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	size     int64
+	hijacked bool
+	onHijack func()
+}
+
+// Unwrap allows http.ResponseController to reach the methods of the
+// underlying http.ResponseWriter that aren't wrapped here.
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *accessLogResponseWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessLogResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *accessLogResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *accessLogResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+	conn, brw, err := hj.Hijack()
+	if err == nil {
+		w.hijacked = true
+		if w.status == 0 {
+			w.status = http.StatusSwitchingProtocols
+		}
+		w.onHijack()
+	}
+	return conn, brw, err
+}
@@ -0,0 +1,94 @@
+package ngrok
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A bytes.Buffer that's safe to read while the server writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Split(strings.TrimSuffix(b.buf.String(), "\n"), "\n")
+}
+
+func TestAccessLog(t *testing.T) {
+	for _, tc := range []struct {
+		format AccessLogFormat
+		line   *regexp.Regexp
+	}{
+		{
+			AccessLogCommon,
+			regexp.MustCompile(`^203\.0\.113\.5 - alice \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}\] "GET /hello\?name=world HTTP/1\.1" 201 14 \d+$`),
+		},
+		{
+			AccessLogCombined,
+			regexp.MustCompile(`^203\.0\.113\.5 - alice \[[^]]+\] "GET /hello\?name=world HTTP/1\.1" 201 14 "https://example\.com/" "test-agent" \d+$`),
+		},
+	} {
+		tun, addr := fakeTunnel(t)
+		tun.(*tunnelImpl).Tunnel.(*fakeClientTunnel).header.ClientAddr = "203.0.113.5:4444"
+
+		handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/empty" {
+				rw.WriteHeader(http.StatusNoContent)
+				return
+			}
+			rw.WriteHeader(http.StatusCreated)
+			_, _ = io.WriteString(rw, "Hello, world!\n")
+		})
+
+		var logs syncBuffer
+		ctx, cancel := context.WithCancel(context.Background())
+		exited := make(chan struct{})
+		go func() {
+			defer close(exited)
+			_ = Serve(ctx, tun, handler, WithAccessLog(&logs, tc.format))
+		}()
+
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/hello?name=world", nil)
+		require.NoError(t, err)
+		req.SetBasicAuth("alice", "secret")
+		req.Header.Set("Referer", "https://example.com/")
+		req.Header.Set("User-Agent", "test-agent")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		resp, err = http.Get("http://" + addr + "/empty")
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+
+		// Lines are written after the response, so they may lag behind it.
+		require.Eventually(t, func() bool {
+			return len(logs.Lines()) == 2
+		}, time.Second, 10*time.Millisecond)
+		cancel()
+		<-exited
+
+		lines := logs.Lines()
+		require.Regexp(t, tc.line, lines[0])
+		require.Contains(t, lines[1], `"GET /empty HTTP/1.1" 204 - `, "empty responses have no size")
+	}
+}
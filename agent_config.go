@@ -0,0 +1,511 @@
+package ngrok
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+// TunnelsFromConfigFile reads the tunnel definitions from an ngrok agent
+// configuration file, such as ngrok.yml, in either the version 2 or version 3
+// format, and returns the equivalent tunnel configurations, in the order of
+// their names. This lets applications that are moving from the agent to the
+// SDK keep using their existing configuration files.
+//
+// Each tunnel's addr is recorded as its forwarding address, as with
+// config.WithForwardsTo, so that it can be served with [Tunnel].Forward:
+//
+//	cfgs, err := ngrok.TunnelsFromConfigFile("ngrok.yml")
+//	...
+//	for _, cfg := range cfgs {
+//		tun, err := sess.Listen(ctx, cfg)
+//		...
+//		go tun.Forward(ctx, tun.ForwardsTo())
+//	}
+//
+// As the agent does, HTTP tunnels with both the "http" and "https" schemes are
+// started as one tunnel for each, and files named by mutual_tls_cas, crt, and
+// key are read relative to the configuration file. The rest of the file, such
+// as the authtoken and the agent's own settings, is ignored. Tunnel settings
+// that the SDK doesn't support, such as subdomain and host_header, are
+// reported as errors rather than being dropped.
+func TunnelsFromConfigFile(path string) ([]config.Tunnel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tunnels, err := parseAgentConfig(data, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return tunnels, nil
+}
+
+// The parts of an agent configuration file that describe tunnels.
+type agentConfig struct {
+	Version string               `yaml:"version"`
+	Tunnels map[string]yaml.Node `yaml:"tunnels"`
+}
+
+// A tunnel definition from an agent configuration file. Unknown settings are
+// rejected when decoding.
+type agentTunnel struct {
+	Proto      string   `yaml:"proto"`
+	Addr       string   `yaml:"addr"`
+	Labels     []string `yaml:"labels"`
+	Metadata   string   `yaml:"metadata"`
+	Inspect    *bool    `yaml:"inspect"`
+	ProxyProto string   `yaml:"proxy_proto"`
+
+	// HTTP and TLS.
+	Hostname     string `yaml:"hostname"`
+	Domain       string `yaml:"domain"`
+	Subdomain    string `yaml:"subdomain"`
+	MutualTLSCAs string `yaml:"mutual_tls_cas"`
+
+	// HTTP.
+	Schemes               []string      `yaml:"schemes"`
+	BindTLS               any           `yaml:"bind_tls"`
+	HostHeader            string        `yaml:"host_header"`
+	BasicAuth             []string      `yaml:"basic_auth"`
+	Compression           bool          `yaml:"compression"`
+	CircuitBreaker        float64       `yaml:"circuit_breaker"`
+	WebsocketTCPConverter bool          `yaml:"websocket_tcp_converter"`
+	RequestHeader         agentHeaders  `yaml:"request_header"`
+	ResponseHeader        agentHeaders  `yaml:"response_header"`
+	OAuth                 *agentOAuth   `yaml:"oauth"`
+	OIDC                  *agentOIDC    `yaml:"oidc"`
+	VerifyWebhook         *agentWebhook `yaml:"verify_webhook"`
+
+	// TCP.
+	RemoteAddr string `yaml:"remote_addr"`
+
+	// TLS.
+	Crt string `yaml:"crt"`
+	Key string `yaml:"key"`
+
+	IPRestriction struct {
+		AllowCIDRs []string `yaml:"allow_cidrs"`
+		DenyCIDRs  []string `yaml:"deny_cidrs"`
+	} `yaml:"ip_restriction"`
+	TrafficPolicy map[string]any `yaml:"traffic_policy"`
+}
+
+type agentHeaders struct {
+	Add    []string `yaml:"add"`
+	Remove []string `yaml:"remove"`
+}
+
+type agentOAuth struct {
+	Provider     string   `yaml:"provider"`
+	AllowEmails  []string `yaml:"allow_emails"`
+	AllowDomains []string `yaml:"allow_domains"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+type agentOIDC struct {
+	IssuerURL    string   `yaml:"issuer_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	AllowEmails  []string `yaml:"allow_emails"`
+	AllowDomains []string `yaml:"allow_domains"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+type agentWebhook struct {
+	Provider string `yaml:"provider"`
+	Secret   string `yaml:"secret"`
+}
+
+// Parses the tunnels of an agent configuration file, reading the files that
+// they name relative to dir.
+func parseAgentConfig(data []byte, dir string) ([]config.Tunnel, error) {
+	var file agentConfig
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if file.Version != "2" && file.Version != "3" {
+		return nil, fmt.Errorf("unsupported configuration version %q, expected \"2\" or \"3\"", file.Version)
+	}
+
+	names := make([]string, 0, len(file.Tunnels))
+	for name := range file.Tunnels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var tunnels []config.Tunnel
+	for _, name := range names {
+		node := file.Tunnels[name]
+		def, err := decodeAgentTunnel(&node)
+		if err != nil {
+			return nil, fmt.Errorf("tunnel %q: %w", name, err)
+		}
+		cfgs, err := def.tunnels(file.Version, dir)
+		if err != nil {
+			return nil, fmt.Errorf("tunnel %q: %w", name, err)
+		}
+		tunnels = append(tunnels, cfgs...)
+	}
+	return tunnels, nil
+}
+
+// Decodes a tunnel definition, rejecting settings that aren't known.
+func decodeAgentTunnel(node *yaml.Node) (*agentTunnel, error) {
+	raw, err := yaml.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+	var def agentTunnel
+	if err := dec.Decode(&def); err != nil {
+		return nil, err
+	}
+	return &def, nil
+}
+
+// The options that every kind of tunnel supports.
+type agentCommonOption interface {
+	config.HTTPEndpointOption
+	config.TCPEndpointOption
+	config.TLSEndpointOption
+	config.LabeledTunnelOption
+}
+
+// Returns the configurations of the tunnels that the agent would start for
+// the definition.
+func (def *agentTunnel) tunnels(version, dir string) ([]config.Tunnel, error) {
+	if def.Addr == "" {
+		return nil, errors.New("addr is required")
+	}
+	if def.Subdomain != "" {
+		return nil, errors.New("subdomain isn't supported, use domain instead")
+	}
+	if def.HostHeader != "" && def.HostHeader != "preserve" {
+		return nil, errors.New("host_header isn't supported")
+	}
+	domain := def.Domain
+	if domain == "" {
+		domain = def.Hostname
+	}
+
+	common := []agentCommonOption{config.WithForwardsTo(agentForwardsTo(def.Addr))}
+	if def.Metadata != "" {
+		common = append(common, config.WithMetadata(def.Metadata))
+	}
+
+	if len(def.Labels) > 0 {
+		if def.Proto != "" {
+			return nil, errors.New("labeled tunnels can't also have a proto")
+		}
+		if def.TrafficPolicy != nil {
+			return nil, errors.New("labeled tunnels take their traffic policy from their edge")
+		}
+		opts := make([]config.LabeledTunnelOption, 0, len(common)+len(def.Labels))
+		for _, opt := range common {
+			opts = append(opts, opt)
+		}
+		for _, label := range def.Labels {
+			name, value, ok := strings.Cut(label, "=")
+			if !ok {
+				return nil, fmt.Errorf("label %q isn't of the form name=value", label)
+			}
+			opts = append(opts, config.WithLabel(name, value))
+		}
+		return []config.Tunnel{config.LabeledTunnel(opts...)}, nil
+	}
+
+	var network []interface {
+		config.HTTPEndpointOption
+		config.TCPEndpointOption
+		config.TLSEndpointOption
+	}
+	if def.ProxyProto != "" {
+		switch def.ProxyProto {
+		case "1":
+			network = append(network, config.WithProxyProto(config.ProxyProtoV1))
+		case "2":
+			network = append(network, config.WithProxyProto(config.ProxyProtoV2))
+		default:
+			return nil, fmt.Errorf("unsupported proxy_proto %q", def.ProxyProto)
+		}
+	}
+	if len(def.IPRestriction.AllowCIDRs) > 0 {
+		network = append(network, config.WithAllowCIDRString(def.IPRestriction.AllowCIDRs...))
+	}
+	if len(def.IPRestriction.DenyCIDRs) > 0 {
+		network = append(network, config.WithDenyCIDRString(def.IPRestriction.DenyCIDRs...))
+	}
+	if def.TrafficPolicy != nil {
+		policy, err := yaml.Marshal(def.TrafficPolicy)
+		if err != nil {
+			return nil, err
+		}
+		network = append(network, config.WithTrafficPolicy(string(policy)))
+	}
+
+	switch def.Proto {
+	case "http":
+		opts, err := def.httpOptions(dir, domain)
+		if err != nil {
+			return nil, err
+		}
+		for _, opt := range common {
+			opts = append(opts, opt)
+		}
+		for _, opt := range network {
+			opts = append(opts, opt)
+		}
+		schemes, err := def.httpSchemes(version)
+		if err != nil {
+			return nil, err
+		}
+		tunnels := make([]config.Tunnel, 0, len(schemes))
+		for _, scheme := range schemes {
+			tunnels = append(tunnels, config.HTTPEndpoint(append(opts, config.WithScheme(scheme))...))
+		}
+		return tunnels, nil
+	case "tcp":
+		var opts []config.TCPEndpointOption
+		for _, opt := range common {
+			opts = append(opts, opt)
+		}
+		for _, opt := range network {
+			opts = append(opts, opt)
+		}
+		if def.RemoteAddr != "" {
+			opts = append(opts, config.WithRemoteAddr(def.RemoteAddr))
+		}
+		return []config.Tunnel{config.TCPEndpoint(opts...)}, nil
+	case "tls":
+		opts, err := def.tlsOptions(dir, domain)
+		if err != nil {
+			return nil, err
+		}
+		for _, opt := range common {
+			opts = append(opts, opt)
+		}
+		for _, opt := range network {
+			opts = append(opts, opt)
+		}
+		return []config.Tunnel{config.TLSEndpoint(opts...)}, nil
+	case "":
+		return nil, errors.New("proto is required for tunnels without labels")
+	default:
+		return nil, fmt.Errorf("unsupported proto %q", def.Proto)
+	}
+}
+
+// Returns the schemes of the HTTP tunnels that the agent would start. Version
+// 2 files start both by default, and version 3 files only HTTPS.
+func (def *agentTunnel) httpSchemes(version string) ([]config.Scheme, error) {
+	var schemes []string
+	switch {
+	case len(def.Schemes) > 0:
+		schemes = def.Schemes
+	case def.BindTLS != nil:
+		switch fmt.Sprint(def.BindTLS) {
+		case "true":
+			schemes = []string{"https"}
+		case "false":
+			schemes = []string{"http"}
+		case "both":
+			schemes = []string{"https", "http"}
+		default:
+			return nil, fmt.Errorf("unsupported bind_tls %v", def.BindTLS)
+		}
+	case version == "2":
+		schemes = []string{"https", "http"}
+	default:
+		schemes = []string{"https"}
+	}
+
+	parsed := make([]config.Scheme, 0, len(schemes))
+	for _, scheme := range schemes {
+		switch scheme {
+		case "http":
+			parsed = append(parsed, config.SchemeHTTP)
+		case "https":
+			parsed = append(parsed, config.SchemeHTTPS)
+		default:
+			return nil, fmt.Errorf("unsupported scheme %q", scheme)
+		}
+	}
+	return parsed, nil
+}
+
+func (def *agentTunnel) httpOptions(dir, domain string) ([]config.HTTPEndpointOption, error) {
+	var opts []config.HTTPEndpointOption
+	if domain != "" {
+		opts = append(opts, config.WithDomain(domain))
+	}
+	if def.MutualTLSCAs != "" {
+		cas, err := readAgentCAs(dir, def.MutualTLSCAs)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, config.WithMutualTLSCA(cas...))
+	}
+	for _, credentials := range def.BasicAuth {
+		username, password, ok := strings.Cut(credentials, ":")
+		if !ok {
+			return nil, errors.New("basic_auth credentials aren't of the form username:password")
+		}
+		opts = append(opts, config.WithBasicAuth(username, password))
+	}
+	if def.Compression {
+		opts = append(opts, config.WithCompression())
+	}
+	if def.CircuitBreaker != 0 {
+		opts = append(opts, config.WithCircuitBreaker(def.CircuitBreaker))
+	}
+	if def.WebsocketTCPConverter {
+		opts = append(opts, config.WithWebsocketTCPConversion())
+	}
+
+	for _, header := range def.RequestHeader.Add {
+		name, value, err := splitAgentHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, config.WithRequestHeader(name, value))
+	}
+	for _, name := range def.RequestHeader.Remove {
+		opts = append(opts, config.WithRemoveRequestHeader(name))
+	}
+	for _, header := range def.ResponseHeader.Add {
+		name, value, err := splitAgentHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, config.WithResponseHeader(name, value))
+	}
+	for _, name := range def.ResponseHeader.Remove {
+		opts = append(opts, config.WithRemoveResponseHeader(name))
+	}
+
+	if oauth := def.OAuth; oauth != nil {
+		var oauthOpts []config.OAuthOption
+		if len(oauth.AllowEmails) > 0 {
+			oauthOpts = append(oauthOpts, config.WithAllowOAuthEmail(oauth.AllowEmails...))
+		}
+		if len(oauth.AllowDomains) > 0 {
+			oauthOpts = append(oauthOpts, config.WithAllowOAuthDomain(oauth.AllowDomains...))
+		}
+		if len(oauth.Scopes) > 0 {
+			oauthOpts = append(oauthOpts, config.WithOAuthScope(oauth.Scopes...))
+		}
+		opts = append(opts, config.WithOAuth(oauth.Provider, oauthOpts...))
+	}
+	if oidc := def.OIDC; oidc != nil {
+		var oidcOpts []config.OIDCOption
+		if len(oidc.AllowEmails) > 0 {
+			oidcOpts = append(oidcOpts, config.WithAllowOIDCEmail(oidc.AllowEmails...))
+		}
+		if len(oidc.AllowDomains) > 0 {
+			oidcOpts = append(oidcOpts, config.WithAllowOIDCDomain(oidc.AllowDomains...))
+		}
+		if len(oidc.Scopes) > 0 {
+			oidcOpts = append(oidcOpts, config.WithOIDCScope(oidc.Scopes...))
+		}
+		opts = append(opts, config.WithOIDC(oidc.IssuerURL, oidc.ClientID, oidc.ClientSecret, oidcOpts...))
+	}
+	if webhook := def.VerifyWebhook; webhook != nil {
+		opts = append(opts, config.WithWebhookVerification(webhook.Provider, webhook.Secret))
+	}
+	return opts, nil
+}
+
+func (def *agentTunnel) tlsOptions(dir, domain string) ([]config.TLSEndpointOption, error) {
+	var opts []config.TLSEndpointOption
+	if domain != "" {
+		opts = append(opts, config.WithDomain(domain))
+	}
+	if def.MutualTLSCAs != "" {
+		cas, err := readAgentCAs(dir, def.MutualTLSCAs)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, config.WithMutualTLSCA(cas...))
+	}
+	if (def.Crt == "") != (def.Key == "") {
+		return nil, errors.New("crt and key must be set together")
+	}
+	if def.Crt != "" {
+		cert, err := os.ReadFile(resolveAgentPath(dir, def.Crt))
+		if err != nil {
+			return nil, err
+		}
+		key, err := os.ReadFile(resolveAgentPath(dir, def.Key))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, config.WithTermination(cert, key))
+	}
+	return opts, nil
+}
+
+// Returns the address that the agent would forward to for addr, which may be
+// just a port on localhost.
+func agentForwardsTo(addr string) string {
+	if strings.Trim(addr, "0123456789") == "" {
+		return "localhost:" + addr
+	}
+	return addr
+}
+
+func resolveAgentPath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// Reads the PEM-encoded certificates in the file.
+func readAgentCAs(dir, path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(resolveAgentPath(dir, path))
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return certs, nil
+}
+
+// Splits a header from an agent configuration file, of the form
+// "name: value".
+func splitAgentHeader(header string) (string, string, error) {
+	name, value, ok := strings.Cut(header, ":")
+	if !ok {
+		return "", "", fmt.Errorf("header %q isn't of the form \"name: value\"", header)
+	}
+	return strings.TrimSpace(name), strings.TrimSpace(value), nil
+}
@@ -0,0 +1,160 @@
+package ngrok
+
+import (
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+// The parts of a config.Tunnel that the SDK sends to the ngrok service.
+type boundTunnel interface {
+	config.Tunnel
+	Proto() string
+	Opts() any
+	Labels() map[string]string
+	ForwardsTo() string
+	Extra() proto.BindExtra
+}
+
+func TestTunnelsFromConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ngrok.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+version: 2
+authtoken: not-used
+region: eu
+tunnels:
+  web:
+    proto: http
+    addr: 8080
+    hostname: app.example.com
+    schemes: [https]
+    basic_auth: ["user:secret"]
+    inspect: false
+    request_header:
+      add: ["X-Env: prod"]
+      remove: ["X-Debug"]
+    ip_restriction:
+      allow_cidrs: [10.0.0.0/8]
+  db:
+    proto: tcp
+    addr: localhost:5432
+    remote_addr: 1.tcp.ngrok.io:12345
+    proxy_proto: 2
+  edge:
+    labels: [edge=edghts_123]
+    addr: http://localhost:9000
+    metadata: blue
+`), 0o600))
+
+	tunnels, err := TunnelsFromConfigFile(path)
+	require.NoError(t, err)
+	require.Len(t, tunnels, 3)
+
+	db := tunnels[0].(boundTunnel)
+	require.Equal(t, "tcp", db.Proto())
+	require.Equal(t, "localhost:5432", db.ForwardsTo())
+	tcpOpts := db.Opts().(*proto.TCPEndpoint)
+	require.Equal(t, "1.tcp.ngrok.io:12345", tcpOpts.Addr)
+	require.Equal(t, proto.ProxyProto(2), tcpOpts.ProxyProto)
+
+	edge := tunnels[1].(boundTunnel)
+	require.Equal(t, map[string]string{"edge": "edghts_123"}, edge.Labels())
+	require.Equal(t, "http://localhost:9000", edge.ForwardsTo())
+	require.Equal(t, "blue", edge.Extra().Metadata)
+
+	web := tunnels[2].(boundTunnel)
+	require.Equal(t, "https", web.Proto())
+	require.Equal(t, "localhost:8080", web.ForwardsTo())
+	httpOpts := web.Opts().(*proto.HTTPEndpoint)
+	require.Equal(t, "app.example.com", httpOpts.Domain)
+	require.NotNil(t, httpOpts.BasicAuth)
+	require.Equal(t, []string{"X-Env:prod"}, httpOpts.RequestHeaders.Add)
+	require.Equal(t, []string{"X-Debug"}, httpOpts.RequestHeaders.Remove)
+	require.Equal(t, []string{"10.0.0.0/8"}, httpOpts.IPRestriction.AllowCidrs)
+}
+
+func TestTunnelsFromConfigSchemes(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+		protos []string
+	}{
+		{"v2 default", "version: \"2\"\ntunnels:\n  web: {proto: http, addr: 80}\n", []string{"https", "http"}},
+		{"v3 default", "version: 3\ntunnels:\n  web: {proto: http, addr: 80}\n", []string{"https"}},
+		{"bind_tls", "version: 2\ntunnels:\n  web: {proto: http, addr: 80, bind_tls: false}\n", []string{"http"}},
+		{"schemes", "version: 3\ntunnels:\n  web: {proto: http, addr: 80, schemes: [http, https]}\n", []string{"http", "https"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tunnels, err := parseAgentConfig([]byte(tc.config), "")
+			require.NoError(t, err)
+			var protos []string
+			for _, tun := range tunnels {
+				protos = append(protos, tun.(boundTunnel).Proto())
+			}
+			require.Equal(t, tc.protos, protos)
+		})
+	}
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+}
+
+func TestTunnelsFromConfigTLS(t *testing.T) {
+	dir := t.TempDir()
+	cert := testCertificate(t, "tls.example.com")
+	writePEM(t, filepath.Join(dir, "tls.crt"), "CERTIFICATE", cert.Certificate[0])
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tls.key"), []byte("key"), 0o600))
+
+	tunnels, err := parseAgentConfig([]byte(`
+version: 3
+tunnels:
+  tls:
+    proto: tls
+    addr: 443
+    domain: tls.example.com
+    crt: tls.crt
+    key: tls.key
+    mutual_tls_cas: tls.crt
+    traffic_policy:
+      on_tcp_connect:
+        - actions: [{type: deny}]
+`), dir)
+	require.NoError(t, err)
+	require.Len(t, tunnels, 1)
+	tlsOpts := tunnels[0].(boundTunnel).Opts().(*proto.TLSEndpoint)
+	require.Equal(t, "tls.example.com", tlsOpts.Domain)
+	require.NotNil(t, tlsOpts.TLSTermination)
+	require.Equal(t, []byte("key"), tlsOpts.TLSTermination.Key)
+	require.NotNil(t, tlsOpts.MutualTLSAtEdge)
+	require.Contains(t, tlsOpts.TrafficPolicy, "on_tcp_connect")
+}
+
+func TestTunnelsFromConfigErrors(t *testing.T) {
+	for name, cfg := range map[string]string{
+		"no version":      "tunnels:\n  web: {proto: http, addr: 80}\n",
+		"no addr":         "version: 2\ntunnels:\n  web: {proto: http}\n",
+		"no proto":        "version: 2\ntunnels:\n  web: {addr: 80}\n",
+		"unknown proto":   "version: 2\ntunnels:\n  web: {proto: ssh, addr: 22}\n",
+		"unknown setting": "version: 2\ntunnels:\n  web: {proto: http, addr: 80, surprise: true}\n",
+		"subdomain":       "version: 2\ntunnels:\n  web: {proto: http, addr: 80, subdomain: app}\n",
+		"host_header":     "version: 2\ntunnels:\n  web: {proto: http, addr: 80, host_header: rewrite}\n",
+		"bad label":       "version: 2\ntunnels:\n  edge: {labels: [edge], addr: 80}\n",
+		"missing file":    "version: 2\ntunnels:\n  web: {proto: tls, addr: 80, crt: missing.crt, key: missing.key}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseAgentConfig([]byte(cfg), t.TempDir())
+			require.Error(t, err)
+		})
+	}
+
+	_, err := TunnelsFromConfigFile(filepath.Join(t.TempDir(), "missing.yml"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
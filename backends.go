@@ -0,0 +1,248 @@
+package ngrok
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+// BalanceAlgorithm is the way that [ServeBackends] chooses the backend for
+// each request, as configured by [WithBalancing].
+type BalanceAlgorithm int
+
+const (
+	// Each request goes to the next backend in turn.
+	BalanceRoundRobin BalanceAlgorithm = iota
+	// Each request goes to the backend with the fewest requests in flight.
+	BalanceLeastConn
+	// Requests with the same key go to the same backend, for backends that
+	// keep per-client state. See [WithStickySessions].
+	BalanceSticky
+)
+
+// WithBalancing sets the algorithm that [ServeBackends] uses to choose the
+// backend for each request.
+//
+// Defaults to [BalanceRoundRobin].
+func WithBalancing(algorithm BalanceAlgorithm) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.Balancing = algorithm
+	}
+}
+
+// WithStickySessions makes [ServeBackends] send requests with the same key to
+// the same backend, using [BalanceSticky]. Requests for which key returns the
+// empty string, such as those without the cookie used by [StickyCookie], are
+// balanced round-robin instead.
+//
+// Keys are assigned to backends by rendezvous hashing, so that adding or
+// removing a backend only moves the keys that it gains or loses. If key is
+// nil, [StickyClientIP] is used.
+func WithStickySessions(key func(*http.Request) string) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.Balancing = BalanceSticky
+		cfg.StickyKey = key
+	}
+}
+
+// StickyClientIP is a key for [WithStickySessions] that keeps each client on
+// the same backend, by the IP address that it connected to the ngrok edge
+// from.
+func StickyClientIP(req *http.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// StickyCookie returns a key for [WithStickySessions] that keeps the requests
+// carrying the same value of the named cookie, such as a session ID, on the
+// same backend.
+func StickyCookie(name string) func(*http.Request) string {
+	return func(req *http.Request) string {
+		cookie, err := req.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+}
+
+// Backends is the set of URLs that [ServeBackends] proxies requests to. It's
+// safe to add and remove backends while they're being served.
+type Backends struct {
+	mu       sync.RWMutex
+	backends []*backend
+	// The next backend for round-robin balancing. Accessed atomically.
+	next uint32
+}
+
+// A target of ServeBackends.
+type backend struct {
+	// The number of requests in flight. Accessed atomically. Kept first to
+	// guarantee 64-bit alignment.
+	active int64
+
+	url *url.URL
+	// The hash of url, for rendezvous hashing.
+	hash uint64
+}
+
+// NewBackends returns the set of the provided backends.
+func NewBackends(targets ...*url.URL) *Backends {
+	b := &Backends{}
+	for _, target := range targets {
+		b.Add(target)
+	}
+	return b
+}
+
+// Add adds a backend to the set, if it isn't there already.
+func (b *Backends) Add(target *url.URL) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.indexLocked(target) >= 0 {
+		return
+	}
+	b.backends = append(b.backends, &backend{
+		url:  target,
+		hash: hashString(target.String()),
+	})
+}
+
+// Remove removes a backend from the set, reporting whether it was there. No
+// more requests are sent to it, but those that are in flight complete.
+func (b *Backends) Remove(target *url.URL) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := b.indexLocked(target)
+	if i < 0 {
+		return false
+	}
+	// Copied rather than modified in place, since selections may be
+	// iterating over the old slice.
+	backends := make([]*backend, 0, len(b.backends)-1)
+	backends = append(backends, b.backends[:i]...)
+	b.backends = append(backends, b.backends[i+1:]...)
+	return true
+}
+
+// URLs returns the backends in the set.
+func (b *Backends) URLs() []*url.URL {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	urls := make([]*url.URL, 0, len(b.backends))
+	for _, be := range b.backends {
+		urls = append(urls, be.url)
+	}
+	return urls
+}
+
+func (b *Backends) indexLocked(target *url.URL) int {
+	for i, be := range b.backends {
+		if be.url.String() == target.String() {
+			return i
+		}
+	}
+	return -1
+}
+
+// Chooses the backend for a request, or returns nil if there are none.
+func (b *Backends) choose(cfg *serveConfig, req *http.Request) *backend {
+	b.mu.RLock()
+	backends := b.backends
+	b.mu.RUnlock()
+	if len(backends) == 0 {
+		return nil
+	}
+
+	start := int((atomic.AddUint32(&b.next, 1) - 1) % uint32(len(backends)))
+	switch cfg.Balancing {
+	case BalanceLeastConn:
+		var best *backend
+		for i := range backends {
+			be := backends[(start+i)%len(backends)]
+			if best == nil || atomic.LoadInt64(&be.active) < atomic.LoadInt64(&best.active) {
+				best = be
+			}
+		}
+		return best
+	case BalanceSticky:
+		keyFunc := cfg.StickyKey
+		if keyFunc == nil {
+			keyFunc = StickyClientIP
+		}
+		key := keyFunc(req)
+		if key == "" {
+			break
+		}
+		// Rendezvous hashing: the backend with the highest score for the
+		// key wins.
+		keyHash := hashString(key)
+		var (
+			best      *backend
+			bestScore uint64
+		)
+		for _, be := range backends {
+			if score := mixHash(keyHash ^ be.hash); best == nil || score > bestScore {
+				best, bestScore = be, score
+			}
+		}
+		return best
+	}
+	return backends[start]
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// Spreads the bits of a hash, so that the scores of similar keys aren't
+// correlated. This is the finalizer of SplitMix64.
+func mixHash(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// ServeBackends is like [ServeReverseProxy], but balances requests across a
+// set of backends, which may change while they're being served. Backends are
+// chosen as configured by [WithBalancing] or [WithStickySessions], and
+// requests are answered with 502 Bad Gateway while there are none.
+//
+// With [WithAccessLog], the URL of the backend that served each request is
+// logged at the end of its line.
+func ServeBackends(ctx context.Context, tun Tunnel, backends *Backends, opts ...ServeOption) error {
+	cfg := serveConfig{}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	srv := cfg.proxyServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		be := backends.choose(&cfg, req)
+		if be == nil {
+			http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		setAccessLogBackend(req, be.url)
+
+		atomic.AddInt64(&be.active, 1)
+		defer atomic.AddInt64(&be.active, -1)
+		// Proxies are cheap to create, and share the default transport's
+		// connection pool.
+		cfg.reverseProxy(be.url).ServeHTTP(rw, req)
+	}))
+
+	return serve(ctx, srv, func() error {
+		return srv.Serve(tun)
+	})
+}
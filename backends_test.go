@@ -0,0 +1,174 @@
+package ngrok
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Starts backends that respond with their own names, and returns their URLs.
+func startBackends(t *testing.T, names ...string) map[string]*url.URL {
+	urls := map[string]*url.URL{}
+	for _, name := range names {
+		name := name
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_, _ = io.WriteString(rw, name)
+		}))
+		t.Cleanup(srv.Close)
+		u, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+		urls[name] = u
+	}
+	return urls
+}
+
+func serveBackends(t *testing.T, backends *Backends, opts ...ServeOption) string {
+	tun, addr := fakeTunnel(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		_ = ServeBackends(ctx, tun, backends, opts...)
+	}()
+	return addr
+}
+
+// Makes a request with the session cookie, if any, and returns which
+// backend served it.
+func backendFor(t *testing.T, addr, session string) string {
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr, nil)
+	require.NoError(t, err)
+	if session != "" {
+		req.AddCookie(&http.Cookie{Name: "session", Value: session})
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	return string(body)
+}
+
+func TestServeBackendsRoundRobin(t *testing.T) {
+	urls := startBackends(t, "a", "b", "c")
+	addr := serveBackends(t, NewBackends(urls["a"], urls["b"], urls["c"]))
+
+	counts := map[string]int{}
+	for i := 0; i < 6; i++ {
+		counts[backendFor(t, addr, "")]++
+	}
+	require.Equal(t, map[string]int{"a": 2, "b": 2, "c": 2}, counts)
+}
+
+func TestBackendsRoundRobinWraps(t *testing.T) {
+	backends := NewBackends(&url.URL{Host: "a"}, &url.URL{Host: "b"}, &url.URL{Host: "c"})
+	// Past where the counter would be negative as an int on 32-bit
+	// platforms.
+	backends.next = math.MaxUint32 - 1
+	var hosts []string
+	for i := 0; i < 3; i++ {
+		hosts = append(hosts, backends.choose(&serveConfig{}, nil).url.Host)
+	}
+	require.Equal(t, []string{"c", "a", "a"}, hosts)
+}
+
+func TestServeBackendsLeastConn(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		started <- struct{}{}
+		<-release
+		_, _ = io.WriteString(rw, "slow")
+	}))
+	defer slow.Close()
+	defer close(release)
+	slowURL, err := url.Parse(slow.URL)
+	require.NoError(t, err)
+	urls := startBackends(t, "fast")
+
+	addr := serveBackends(t, NewBackends(slowURL, urls["fast"]), WithBalancing(BalanceLeastConn))
+
+	go func() {
+		resp, err := http.Get("http://" + addr)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	<-started
+
+	// The slow backend is busy, so everything else goes to the fast one.
+	for i := 0; i < 4; i++ {
+		require.Equal(t, "fast", backendFor(t, addr, ""))
+	}
+}
+
+func TestServeBackendsSticky(t *testing.T) {
+	urls := startBackends(t, "a", "b", "c")
+	backends := NewBackends(urls["a"], urls["b"], urls["c"])
+	addr := serveBackends(t, backends, WithStickySessions(StickyCookie("session")))
+
+	assigned := map[string]string{}
+	for i := 0; i < 20; i++ {
+		session := fmt.Sprint("session-", i)
+		assigned[session] = backendFor(t, addr, session)
+		for j := 0; j < 3; j++ {
+			require.Equal(t, assigned[session], backendFor(t, addr, session), "sessions stick to their backend")
+		}
+	}
+	used := map[string]bool{}
+	for _, backend := range assigned {
+		used[backend] = true
+	}
+	require.Len(t, used, 3, "sessions are spread across the backends")
+
+	// Only the sessions on a removed backend move.
+	require.True(t, backends.Remove(urls["b"]))
+	require.False(t, backends.Remove(urls["b"]))
+	for session, backend := range assigned {
+		got := backendFor(t, addr, session)
+		if backend == "b" {
+			require.NotEqual(t, "b", got)
+		} else {
+			require.Equal(t, backend, got, "sessions on other backends stay put")
+		}
+	}
+
+	// Requests without a key are still served.
+	require.Contains(t, []string{"a", "c"}, backendFor(t, addr, ""))
+}
+
+func TestServeBackendsEmpty(t *testing.T) {
+	urls := startBackends(t, "a")
+	backends := NewBackends()
+	addr := serveBackends(t, backends)
+
+	resp, err := http.Get("http://" + addr)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+	backends.Add(urls["a"])
+	backends.Add(urls["a"])
+	require.Equal(t, []*url.URL{urls["a"]}, backends.URLs())
+	require.Equal(t, "a", backendFor(t, addr, ""))
+}
+
+func TestServeBackendsAccessLog(t *testing.T) {
+	urls := startBackends(t, "a")
+	var log syncBuffer
+	addr := serveBackends(t, NewBackends(urls["a"]), WithAccessLog(&log, AccessLogCommon))
+
+	require.Equal(t, "a", backendFor(t, addr, ""))
+	require.Eventually(t, func() bool {
+		return strings.HasSuffix(log.Lines()[0], fmt.Sprintf(" %q", urls["a"].String()))
+	}, time.Second, time.Millisecond, "the backend ends the line")
+}
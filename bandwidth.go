@@ -0,0 +1,340 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// A token bucket limiting the rate of reads or writes in one direction of a
+// connection, or of connections arriving. The bucket holds up to one second's
+// worth of tokens, or one token if that's fewer. It's unlimited while its rate
+// is zero.
+type tokenBucket struct {
+	clock  clock
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(clock clock, perSec float64) *tokenBucket {
+	b := &tokenBucket{clock: clock}
+	b.setRate(perSec)
+	return b
+}
+
+// Changes the rate of the bucket. Tokens that have built up are kept, up to
+// the new burst size, except that a bucket starts full when it stops being
+// unlimited.
+func (b *tokenBucket) setRate(perSec float64) {
+	if perSec < 0 {
+		perSec = 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.clock.Now()
+	if b.rate > 0 {
+		b.refill(now)
+	}
+	wasUnlimited := b.rate == 0
+	b.rate = perSec
+	b.burst = perSec
+	if b.burst < 1 {
+		b.burst = 1
+	}
+	if wasUnlimited || b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Adds the tokens earned since the last refill. The lock must be held.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+func (b *tokenBucket) refund(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += float64(n)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// Reports whether the bucket has a rate, rather than being unlimited.
+func (b *tokenBucket) limited() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate > 0
+}
+
+// Takes a single token if one is available, without waiting.
+func (b *tokenBucket) tryTake() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate == 0 {
+		return true
+	}
+	b.refill(b.clock.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// A deadline for the reads or the writes of a connection, which may be changed
+// while they wait on a limiter. Its zero value is a zero deadline, which never
+// passes. A nil deadline is the same, but can't be changed.
+type limitDeadline struct {
+	mu sync.Mutex
+	t  time.Time
+	// Closed when the deadline changes, then replaced.
+	changed chan struct{}
+}
+
+// Returns the deadline, and a channel that's closed when it changes.
+func (d *limitDeadline) get() (time.Time, <-chan struct{}) {
+	if d == nil {
+		return time.Time{}, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.changed == nil {
+		d.changed = make(chan struct{})
+	}
+	return d.t, d.changed
+}
+
+// Changes the deadline, waking whatever is waiting on the old one.
+func (d *limitDeadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.t = t
+	if d.changed != nil {
+		close(d.changed)
+		d.changed = nil
+	}
+}
+
+// Waits until at least one token is available, then takes up to max of them.
+// Returns the number of tokens taken.
+//
+// Fails with os.ErrDeadlineExceeded if the deadline passes first, or
+// net.ErrClosed if the done channel is closed. The deadline may be changed
+// while it waits.
+func (b *tokenBucket) take(max int, d *limitDeadline, done <-chan struct{}) (int, error) {
+	for {
+		deadline, changed := d.get()
+		b.mu.Lock()
+		if b.rate == 0 {
+			b.mu.Unlock()
+			return max, nil
+		}
+		b.refill(b.clock.Now())
+
+		if b.tokens >= 1 {
+			n := max
+			if float64(n) > b.tokens {
+				n = int(b.tokens)
+			}
+			b.tokens -= float64(n)
+			b.mu.Unlock()
+			return n, nil
+		}
+
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		if !deadline.IsZero() {
+			if until := deadline.Sub(b.clock.Now()); until <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			} else if until < wait {
+				wait = until
+			}
+		}
+
+		timer := b.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-changed:
+			timer.Stop()
+		case <-done:
+			timer.Stop()
+			return 0, net.ErrClosed
+		}
+	}
+}
+
+// Takes up to max tokens from every bucket, so that the same number is taken
+// from each. Returns that number.
+func takeAll(buckets []*tokenBucket, max int, deadline *limitDeadline, done <-chan struct{}) (int, error) {
+	n := max
+	for i, b := range buckets {
+		took, err := b.take(n, deadline, done)
+		if err != nil {
+			refundAll(buckets[:i], n)
+			return 0, err
+		}
+		// The buckets before this one gave more than it could.
+		refundAll(buckets[:i], n-took)
+		n = took
+	}
+	return n, nil
+}
+
+func refundAll(buckets []*tokenBucket, n int) {
+	if n <= 0 {
+		return
+	}
+	for _, b := range buckets {
+		b.refund(n)
+	}
+}
+
+// The rates shared by all of the connections accepted from a tunnel, or from
+// all of a session's tunnels. They can be changed while connections are open,
+// but the bandwidth limit only applies to connections that were accepted while
+// it was set, so that connections accepted without one don't pay for it.
+type trafficLimit struct {
+	read  *tokenBucket
+	write *tokenBucket
+	conns *tokenBucket
+}
+
+func newTrafficLimit(clock clock, bytesPerSec int64, connsPerSec float64) *trafficLimit {
+	return &trafficLimit{
+		read:  newTokenBucket(clock, float64(bytesPerSec)),
+		write: newTokenBucket(clock, float64(bytesPerSec)),
+		conns: newTokenBucket(clock, connsPerSec),
+	}
+}
+
+func (l *trafficLimit) setBytesPerSecond(bytesPerSec int64) {
+	l.read.setRate(float64(bytesPerSec))
+	l.write.setRate(float64(bytesPerSec))
+}
+
+// Reports whether the bytes that connections read and write are limited.
+// Both directions always share a rate.
+func (l *trafficLimit) limitsBytes() bool {
+	return l != nil && l.read.limited()
+}
+
+func (l *trafficLimit) setConnsPerSecond(connsPerSec float64) {
+	l.conns.setRate(connsPerSec)
+}
+
+// Reports whether another connection may be accepted under every one of the
+// limits, taking a token from each if so.
+func allowConn(limits ...*trafficLimit) bool {
+	for i, l := range limits {
+		if l == nil {
+			continue
+		}
+		if !l.conns.tryTake() {
+			for _, prev := range limits[:i] {
+				if prev != nil {
+					prev.conns.refund(1)
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// Rate limits the reads and writes of a connection, by its own limit and by
+// those that it shares with the other connections from its tunnel and
+// session.
+type bandwidthLimiter struct {
+	read  []*tokenBucket
+	write []*tokenBucket
+
+	readDeadline  limitDeadline
+	writeDeadline limitDeadline
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Returns a limiter for a connection with its own limit of bytesPerSec, or
+// none if that's zero, and those of the shared limits that limit bytes at the
+// moment. Returns nil if there are no limits.
+func newBandwidthLimiter(clock clock, bytesPerSec int64, shared ...*trafficLimit) *bandwidthLimiter {
+	limited := bytesPerSec > 0
+	for _, limit := range shared {
+		limited = limited || limit.limitsBytes()
+	}
+	// Checked first, since connections without limits are the common case,
+	// and shouldn't pay for allocating any of this.
+	if !limited {
+		return nil
+	}
+
+	l := &bandwidthLimiter{closed: make(chan struct{})}
+	if bytesPerSec > 0 {
+		l.read = append(l.read, newTokenBucket(clock, float64(bytesPerSec)))
+		l.write = append(l.write, newTokenBucket(clock, float64(bytesPerSec)))
+	}
+	for _, limit := range shared {
+		if limit.limitsBytes() {
+			l.read = append(l.read, limit.read)
+			l.write = append(l.write, limit.write)
+		}
+	}
+	return l
+}
+
+func (l *bandwidthLimiter) Read(r io.Reader, p []byte) (int, error) {
+	if len(p) == 0 {
+		return r.Read(p)
+	}
+	max, err := takeAll(l.read, len(p), &l.readDeadline, l.closed)
+	if err != nil {
+		return 0, err
+	}
+	n, err := r.Read(p[:max])
+	// Reads may come up short, in which case the unused tokens are returned.
+	refundAll(l.read, max-n)
+	return n, err
+}
+
+func (l *bandwidthLimiter) Write(w io.Writer, p []byte) (int, error) {
+	var written int
+	for written < len(p) {
+		max, err := takeAll(l.write, len(p)-written, &l.writeDeadline, l.closed)
+		if err != nil {
+			return written, err
+		}
+		n, err := w.Write(p[written : written+max])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (l *bandwidthLimiter) setReadDeadline(t time.Time) {
+	l.readDeadline.set(t)
+}
+
+func (l *bandwidthLimiter) setWriteDeadline(t time.Time) {
+	l.writeDeadline.set(t)
+}
+
+func (l *bandwidthLimiter) close() {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+}
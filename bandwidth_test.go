@@ -0,0 +1,269 @@
+package ngrok
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(systemClock, 1000)
+
+	n, err := bucket.take(5000, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1000, n, "takes are limited to the burst size")
+
+	start := time.Now()
+	n, err = bucket.take(100, nil, nil)
+	require.NoError(t, err)
+	require.Positive(t, n)
+	require.GreaterOrEqual(t, time.Since(start), time.Millisecond, "empty buckets wait to refill")
+}
+
+func TestTokenBucketDeadline(t *testing.T) {
+	bucket := newTokenBucket(systemClock, 1)
+	_, err := bucket.take(1, nil, nil)
+	require.NoError(t, err)
+
+	start := time.Now()
+	var deadline limitDeadline
+	deadline.set(time.Now().Add(50 * time.Millisecond))
+	_, err = bucket.take(1, &deadline, nil)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Less(t, time.Since(start), 500*time.Millisecond)
+
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr))
+	require.True(t, netErr.Timeout())
+}
+
+func TestTokenBucketDone(t *testing.T) {
+	bucket := newTokenBucket(systemClock, 1)
+	_, err := bucket.take(1, nil, nil)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(done)
+	}()
+	_, err = bucket.take(1, nil, done)
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestConnBandwidthLimit(t *testing.T) {
+	const limit = 100_000
+
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).bandwidthLimit = limit
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+
+	received := make(chan int)
+	go func() {
+		n, _ := io.Copy(io.Discard, client)
+		received <- int(n)
+	}()
+
+	// The first second's worth goes out in a burst, and the rest at the
+	// limit.
+	payload := make([]byte, limit*3/2)
+	start := time.Now()
+	n, err := conn.Write(payload)
+	require.NoError(t, err)
+	require.Equal(t, len(payload), n)
+	require.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+	require.NoError(t, conn.Close())
+	require.Equal(t, len(payload), <-received)
+}
+
+func TestConnBandwidthLimitReadDeadline(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).bandwidthLimit = 1
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = client.Write([]byte("ab"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 2)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 1, n, "reads are limited to the available tokens")
+
+	// Reads blocked on the limiter respect the deadline.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	_, err = conn.Read(buf)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestConnBandwidthLimitDeadlineChanged(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).bandwidthLimit = 1
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = client.Write([]byte("ab"))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 2))
+	require.NoError(t, err)
+
+	// Reads already blocked on the limiter see deadlines set after they began.
+	read := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	require.NoError(t, conn.SetReadDeadline(time.Now()))
+	require.ErrorIs(t, <-read, os.ErrDeadlineExceeded)
+	require.Less(t, time.Since(start), 500*time.Millisecond, "the read should wake before the bucket refills")
+}
+
+func TestTokenBucketSetRate(t *testing.T) {
+	clock := newFakeClock()
+	bucket := newTokenBucket(clock, 0)
+
+	n, err := bucket.take(1<<20, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1<<20, n, "unlimited buckets take everything")
+
+	bucket.setRate(10)
+	n, err = bucket.take(100, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 10, n, "limited buckets start full")
+
+	// Lowering the rate doesn't give back what was already taken.
+	bucket.setRate(2)
+	require.False(t, bucket.tryTake())
+	clock.Advance(500 * time.Millisecond)
+	require.True(t, bucket.tryTake())
+	require.False(t, bucket.tryTake())
+}
+
+func TestTakeAll(t *testing.T) {
+	small := newTokenBucket(systemClock, 10)
+	large := newTokenBucket(systemClock, 100)
+
+	n, err := takeAll([]*tokenBucket{large, small}, 50, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 10, n, "the smallest bucket decides")
+
+	// The large bucket got back what the small one couldn't match.
+	n, err = large.take(1000, nil, nil)
+	require.NoError(t, err)
+	require.InDelta(t, 90, n, 1)
+}
+
+func TestBandwidthLimiterUnlimited(t *testing.T) {
+	unlimited := newTrafficLimit(systemClock, 0, 0)
+	require.Nil(t, newBandwidthLimiter(systemClock, 0, unlimited, nil),
+		"connections without a rate shouldn't get a limiter")
+
+	limited := newTrafficLimit(systemClock, 100, 0)
+	l := newBandwidthLimiter(systemClock, 0, unlimited, limited)
+	require.NotNil(t, l)
+	require.Equal(t, []*tokenBucket{limited.read}, l.read)
+	require.Equal(t, []*tokenBucket{limited.write}, l.write)
+}
+
+func TestMaxConnsPerSecond(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.sessionLimits = newTrafficLimit(systemClock, 0, 0)
+	tun.SetMaxConnsPerSecond(1)
+
+	events := make(chan AcceptEvent, 2)
+	tun.OnAccept(func(event AcceptEvent) {
+		events <- event
+	})
+
+	first, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer first.Close()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, (<-events).Rejected)
+
+	second, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer second.Close()
+	go func() {
+		_, _ = tun.Accept()
+	}()
+	require.ErrorIs(t, (<-events).Rejected, ErrRateLimited)
+
+	// Lifting the session's limit doesn't lift the tunnel's.
+	(&sessionImpl{limits: impl.sessionLimits}).SetMaxConnsPerSecond(0)
+	require.False(t, allowConn(impl.limits, impl.sessionLimits))
+	tun.SetMaxConnsPerSecond(0)
+	require.True(t, allowConn(impl.limits, impl.sessionLimits))
+}
+
+func TestMaxBytesPerSecond(t *testing.T) {
+	const limit = 100_000
+
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.sessionLimits = newTrafficLimit(systemClock, limit, 0)
+
+	accept := func() (net.Conn, net.Conn) {
+		client, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = client.Close() })
+		conn, err := tun.Accept()
+		require.NoError(t, err)
+		go func() {
+			_, _ = io.Copy(io.Discard, client)
+		}()
+		return client, conn
+	}
+	_, a := accept()
+	_, b := accept()
+
+	// The connections share the limit, so between them they take longer
+	// than either would on its own.
+	start := time.Now()
+	errs := make(chan error, 2)
+	for _, conn := range []net.Conn{a, b} {
+		go func(conn net.Conn) {
+			_, err := conn.Write(make([]byte, limit))
+			errs <- err
+		}(conn)
+	}
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+	require.GreaterOrEqual(t, time.Since(start), 800*time.Millisecond)
+
+	// Removing the limit applies to open connections.
+	impl.sessionLimits.setBytesPerSecond(0)
+	start = time.Now()
+	_, err := a.Write(make([]byte, 10*limit))
+	require.NoError(t, err)
+	require.Less(t, time.Since(start), 800*time.Millisecond)
+}
@@ -0,0 +1,225 @@
+package ngrok
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+func (t *tunnelImpl) Bridge(localAddr string) (io.Closer, error) {
+	l, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bridge{
+		local:   l,
+		tun:     t,
+		buffers: copyBufferPool(0),
+		conns:   map[net.Conn]struct{}{},
+	}
+	go func() {
+		_ = b.run()
+	}()
+	return b, nil
+}
+
+// Pairs connections accepted from a local listener with connections accepted
+// from a Tunnel.
+type bridge struct {
+	local   net.Listener
+	tun     Tunnel
+	buffers *bufferPool
+
+	mu     sync.Mutex
+	closed bool
+	conns  map[net.Conn]struct{}
+}
+
+func (b *bridge) Addr() net.Addr {
+	return b.local.Addr()
+}
+
+// Pairs connections until accepting from either the listener or the tunnel
+// fails, and returns that error.
+func (b *bridge) run() error {
+	for {
+		local, err := b.local.Accept()
+		if err != nil {
+			return err
+		}
+		remote, err := b.tun.Accept()
+		if err != nil {
+			_ = local.Close()
+			return err
+		}
+		if !b.track(local, remote) {
+			return net.ErrClosed
+		}
+		go func() {
+			join(local, remote, b.buffers, nil)
+			b.untrack(local, remote)
+		}()
+	}
+}
+
+// Records a pair of connections so that they can be closed along with the
+// bridge. If the bridge is already closed, closes them and returns false.
+func (b *bridge) track(conns ...net.Conn) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		for _, c := range conns {
+			_ = c.Close()
+		}
+		return false
+	}
+	for _, c := range conns {
+		b.conns[c] = struct{}{}
+	}
+	return true
+}
+
+func (b *bridge) untrack(conns ...net.Conn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range conns {
+		delete(b.conns, c)
+	}
+}
+
+func (b *bridge) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	conns := b.conns
+	b.conns = map[net.Conn]struct{}{}
+	b.mu.Unlock()
+
+	for c := range conns {
+		_ = c.Close()
+	}
+
+	err := b.local.Close()
+	if tunErr := b.tun.Close(); err == nil {
+		err = tunErr
+	}
+	return err
+}
+
+// Copies data between two connections until both directions are finished,
+// then closes them. When one side stops sending, the other is half-closed if
+// it supports it, so that protocols relying on EOF keep working.
+//
+// A side that stops sending, whether by closing or failing, is the normal end
+// of a direction. Failures to write to a side aren't, and are passed to
+// onWriteErr if it's non-nil.
+func join(a, b net.Conn, buffers *bufferPool, onWriteErr func(error)) {
+	joinWith(a, b, func(dst io.Writer, src io.Reader) (int64, error) {
+		return copyConn(dst, src, buffers)
+	}, onWriteErr)
+}
+
+// Like join, but copies each direction with the function, which behaves as
+// copyConn does.
+func joinWith(a, b net.Conn, copy func(dst io.Writer, src io.Reader) (int64, error), onWriteErr func(error)) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	pipe := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, err := copy(dst, src)
+		if err != nil && onWriteErr != nil && !errors.Is(err, net.ErrClosed) {
+			onWriteErr(err)
+		}
+		if cw, ok := dst.(interface{ CloseWrite() error }); !ok || cw.CloseWrite() != nil {
+			_ = dst.Close()
+		}
+	}
+	go pipe(a, b)
+	go pipe(b, a)
+	wg.Wait()
+	_ = a.Close()
+	_ = b.Close()
+}
+
+// The default size of the buffers used to copy between joined connections.
+const defaultCopyBufferSize = 32 * 1024
+
+// A pool of equally sized buffers, shared by every copy that uses that size so
+// that connections don't each allocate their own. It satisfies
+// httputil.BufferPool.
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// The pools for each buffer size in use, as a map of int to *bufferPool.
+var bufferPools sync.Map
+
+// Returns the shared pool of buffers of the size, or of the default size if
+// it's not positive.
+func copyBufferPool(size int) *bufferPool {
+	if size <= 0 {
+		size = defaultCopyBufferSize
+	}
+	if p, ok := bufferPools.Load(size); ok {
+		return p.(*bufferPool)
+	}
+	p, _ := bufferPools.LoadOrStore(size, &bufferPool{size: size})
+	return p.(*bufferPool)
+}
+
+func (p *bufferPool) Get() []byte {
+	if buf, ok := p.pool.Get().(*[]byte); ok {
+		return *buf
+	}
+	return make([]byte, p.size)
+}
+
+func (p *bufferPool) Put(buf []byte) {
+	if cap(buf) < p.size {
+		return
+	}
+	buf = buf[:p.size]
+	p.pool.Put(&buf)
+}
+
+// Copies from src to dst with a buffer from the pool until src stops sending.
+// Unlike io.Copy, it returns nil for any error reading from src, so that the
+// only errors returned are those writing to dst.
+func copyConn(dst io.Writer, src io.Reader, buffers *bufferPool) (int64, error) {
+	buf := buffers.Get()
+	defer buffers.Put(buf)
+
+	var written int64
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			w, err := writeFull(dst, buf[:n])
+			written += int64(w)
+			if err != nil {
+				return written, err
+			}
+		}
+		if readErr != nil {
+			return written, nil
+		}
+	}
+}
+
+// Writes all of p, retrying the remainder after short writes as long as the
+// writer makes progress.
+func writeFull(w io.Writer, p []byte) (int, error) {
+	var written int
+	for written < len(p) {
+		n, err := w.Write(p[written:])
+		written += n
+		if err != nil && !errors.Is(err, io.ErrShortWrite) {
+			return written, err
+		}
+		if n == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,196 @@
+package ngrok
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridge(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	closer, err := tun.Bridge("127.0.0.1:0")
+	require.NoError(t, err)
+	localAddr := closer.(interface{ Addr() net.Addr }).Addr().String()
+
+	// The legacy server, which can only dial a local address.
+	served := make(chan string, 1)
+	go func() {
+		conn, err := net.Dial("tcp", localAddr)
+		if err != nil {
+			served <- err.Error()
+			return
+		}
+		defer conn.Close()
+		req, _ := io.ReadAll(conn)
+		served <- string(req)
+		_, _ = io.WriteString(conn, "response")
+	}()
+
+	// A client connecting from the ngrok edge.
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = io.WriteString(client, "request")
+	require.NoError(t, err)
+	require.NoError(t, client.(*net.TCPConn).CloseWrite())
+
+	require.Equal(t, "request", <-served)
+	resp, err := io.ReadAll(client)
+	require.NoError(t, err)
+	require.Equal(t, "response", string(resp), "half-closes don't cut off responses")
+
+	require.NoError(t, closer.Close())
+
+	_, err = net.Dial("tcp", localAddr)
+	require.Error(t, err, "the local listener is closed")
+	_, err = tun.Accept()
+	require.ErrorIs(t, err, net.ErrClosed, "the tunnel is closed")
+}
+
+// A writer that accepts at most a few bytes per call, reporting the rest as
+// a short write.
+type shortWriter struct {
+	bytes.Buffer
+	max int
+	// Report short writes without an error, as some writers do.
+	silent bool
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) <= w.max {
+		return w.Buffer.Write(p)
+	}
+	n, _ := w.Buffer.Write(p[:w.max])
+	if w.silent {
+		return n, nil
+	}
+	return n, io.ErrShortWrite
+}
+
+// A writer that never makes progress.
+type stuckWriter struct{}
+
+func (stuckWriter) Write([]byte) (int, error) {
+	return 0, nil
+}
+
+func TestCopyConnShortWrites(t *testing.T) {
+	payload := strings.Repeat("forwarded ", 10000)
+
+	for _, silent := range []bool{false, true} {
+		dst := &shortWriter{max: 7, silent: silent}
+		n, err := copyConn(dst, strings.NewReader(payload), copyBufferPool(0))
+		require.NoError(t, err, "short writes are retried")
+		require.Equal(t, int64(len(payload)), n)
+		require.Equal(t, payload, dst.String())
+	}
+
+	_, err := copyConn(stuckWriter{}, strings.NewReader(payload), copyBufferPool(0))
+	require.ErrorIs(t, err, io.ErrShortWrite, "writers that make no progress are given up on")
+}
+
+func TestCopyConnErrors(t *testing.T) {
+	// Errors reading mean that the source has stopped sending.
+	src := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("reset")))
+	var dst bytes.Buffer
+	n, err := copyConn(&dst, src, copyBufferPool(0))
+	require.NoError(t, err)
+	require.Equal(t, int64(len("partial")), n)
+
+	// Errors writing are returned.
+	local, remote := net.Pipe()
+	require.NoError(t, remote.Close())
+	_, err = copyConn(local, strings.NewReader("lost"), copyBufferPool(0))
+	require.Error(t, err)
+}
+
+// A connection that can't be written to.
+type unwritableConn struct {
+	net.Conn
+}
+
+func (unwritableConn) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestJoinWriteErrors(t *testing.T) {
+	join := func(a, b net.Conn) []error {
+		var errs []error
+		join(a, b, copyBufferPool(0), func(err error) { errs = append(errs, err) })
+		return errs
+	}
+
+	// Failing to write to the upstream is reported.
+	client, clientPeer := net.Pipe()
+	_, upstreamPeer := net.Pipe()
+	go func() { _, _ = client.Write([]byte("hello")) }()
+	errs := join(clientPeer, unwritableConn{upstreamPeer})
+	require.Len(t, errs, 1)
+	require.EqualError(t, errs[0], "broken pipe")
+
+	// Either side closing is not.
+	client, clientPeer = net.Pipe()
+	upstream, upstreamPeer := net.Pipe()
+	require.NoError(t, client.Close())
+	require.NoError(t, upstream.Close())
+	require.Empty(t, join(clientPeer, upstreamPeer))
+}
+
+func TestCopyBufferPool(t *testing.T) {
+	require.Same(t, copyBufferPool(0), copyBufferPool(defaultCopyBufferSize))
+	require.NotSame(t, copyBufferPool(0), copyBufferPool(1024))
+
+	pool := copyBufferPool(1024)
+	buf := pool.Get()
+	require.Len(t, buf, 1024)
+	pool.Put(buf[:10])
+	require.Len(t, pool.Get(), 1024, "buffers are returned at full size")
+	pool.Put(make([]byte, 10))
+	require.Len(t, pool.Get(), 1024, "undersized buffers are discarded")
+
+	payload := strings.Repeat("x", 10000)
+	var dst bytes.Buffer
+	n, err := copyConn(&dst, strings.NewReader(payload), copyBufferPool(7))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(payload)), n)
+	require.Equal(t, payload, dst.String())
+}
+
+// Hides the io.WriterTo and io.ReaderFrom fast paths, as connections do.
+type plainReader struct{ io.Reader }
+type plainWriter struct{ io.Writer }
+
+func BenchmarkCopyConn(b *testing.B) {
+	payload := bytes.Repeat([]byte("x"), 64*1024)
+	for _, bc := range []struct {
+		name string
+		copy func(dst io.Writer, src io.Reader) error
+	}{
+		{"io.Copy", func(dst io.Writer, src io.Reader) error {
+			_, err := io.Copy(dst, src)
+			return err
+		}},
+		{"pooled", func(dst io.Writer, src io.Reader) error {
+			_, err := copyConn(dst, src, copyBufferPool(0))
+			return err
+		}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				src := plainReader{bytes.NewReader(payload)}
+				if err := bc.copy(plainWriter{io.Discard}, src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package ngrok
+
+import "time"
+
+// The source of time for the SDK's timers and timestamps, so that tests can
+// control it rather than sleeping. The system clock is used unless a session
+// is configured with withClock, which covers its tunnels and connections,
+// their health checks, the pools they're in, and the session's reconnect
+// backoff. Waits for a context's deadline stay on the system clock, since
+// that's what the context's own timer uses.
+type clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine once d has elapsed, as with
+	// time.AfterFunc. The returned timer's C is nil.
+	AfterFunc(d time.Duration, f func()) clockTimer
+	// NewTimer returns a timer that sends the time on its C once d has
+	// elapsed, as with time.NewTimer.
+	NewTimer(d time.Duration) clockTimer
+}
+
+// A timer created by a clock, with the methods of time.Timer.
+type clockTimer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// The clock used when none is configured.
+var systemClock clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) clockTimer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+func (realClock) NewTimer(d time.Duration) clockTimer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// Returns the clock, or the system clock if it's nil, so that the zero values
+// of the types that hold one are usable.
+func clockOrSystem(c clock) clock {
+	if c == nil {
+		return systemClock
+	}
+	return c
+}
+
+// withClock configures the clock used by the session and its tunnels and
+// connections, in place of the system clock. It's for tests of the
+// timing-based options, which can then advance time as they see fit.
+func withClock(c clock) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.Clock = c
+	}
+}
@@ -0,0 +1,258 @@
+package ngrok
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A clock that only moves when it's advanced, for testing timing-based
+// behavior without sleeping. Timers fire synchronously from Advance.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2022, 10, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+type fakeTimer struct {
+	clock  *fakeClock
+	when   time.Time
+	active bool
+	f      func()
+	c      chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) clockTimer {
+	return c.newTimer(d, &fakeTimer{f: f})
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) clockTimer {
+	return c.newTimer(d, &fakeTimer{c: make(chan time.Time, 1)})
+}
+
+func (c *fakeClock) newTimer(d time.Duration, t *fakeTimer) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.clock = c
+	t.when = c.now.Add(d)
+	t.active = true
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves the clock forward, firing the timers that come due in the
+// order that they do.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for {
+		var next *fakeTimer
+		for _, t := range c.timers {
+			if t.active && !t.when.After(target) && (next == nil || t.when.Before(next.when)) {
+				next = t
+			}
+		}
+		if next == nil {
+			break
+		}
+		next.active = false
+		c.now = next.when
+		c.mu.Unlock()
+		if next.f != nil {
+			next.f()
+		} else {
+			select {
+			case next.c <- next.when:
+			default:
+			}
+		}
+		c.mu.Lock()
+	}
+	c.now = target
+	c.mu.Unlock()
+}
+
+// Returns the number of timers that have yet to fire.
+func (c *fakeClock) activeTimers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, t := range c.timers {
+		if t.active {
+			n++
+		}
+	}
+	return n
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	wasActive := t.active
+	t.when = t.clock.now.Add(d)
+	t.active = true
+	return wasActive
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+
+	var fired []string
+	clock.AfterFunc(2*time.Second, func() { fired = append(fired, "second") })
+	clock.AfterFunc(time.Second, func() { fired = append(fired, "first") })
+	stopped := clock.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+	require.True(t, stopped.Stop())
+	timer := clock.NewTimer(3 * time.Second)
+
+	clock.Advance(2 * time.Second)
+	require.Equal(t, []string{"first", "second"}, fired)
+	require.Equal(t, start.Add(2*time.Second), clock.Now())
+	require.Equal(t, 1, clock.activeTimers())
+
+	clock.Advance(time.Second)
+	require.Equal(t, start.Add(3*time.Second), <-timer.C())
+	require.False(t, timer.Stop())
+}
+
+func TestIdleTrackerClock(t *testing.T) {
+	clock := newFakeClock()
+	fired := false
+	tracker := newIdleTracker(clock, time.Minute, func() { fired = true })
+
+	tracker.acquire()
+	clock.Advance(time.Hour)
+	require.False(t, fired, "tracker fired while active")
+
+	tracker.release()
+	clock.Advance(time.Minute - time.Nanosecond)
+	require.False(t, fired, "tracker fired early")
+	clock.Advance(time.Nanosecond)
+	require.True(t, fired, "tracker never fired after becoming idle")
+}
+
+func TestConnIdleTimeoutClock(t *testing.T) {
+	clock := newFakeClock()
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.clock = clock
+	impl.connIdleTimeout = time.Minute
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	clock.Advance(30 * time.Second)
+	conn.(interface{ Touch() }).Touch()
+	clock.Advance(59 * time.Second)
+	_, err = conn.Write([]byte("still here"))
+	require.NoError(t, err, "touched connections aren't closed")
+
+	// Writing counts as activity too.
+	clock.Advance(59 * time.Second)
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	buf := make([]byte, len("still here"))
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	_, err = client.Read(make([]byte, 1))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded, "active connections aren't closed")
+
+	clock.Advance(time.Second)
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+	rest, err := io.ReadAll(client)
+	require.NoError(t, err, "idle connections are closed")
+	require.Empty(t, rest)
+}
+
+func TestHandshakeTimeoutClock(t *testing.T) {
+	clock := newFakeClock()
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.clock = clock
+	impl.handshakeTimeout = 10 * time.Second
+
+	stalled, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer stalled.Close()
+	_, err = tun.Accept()
+	require.NoError(t, err)
+
+	clock.Advance(10 * time.Second)
+	require.NoError(t, stalled.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = stalled.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF, "clients that never send anything are disconnected")
+}
+
+func TestTokenBucketClock(t *testing.T) {
+	clock := newFakeClock()
+	bucket := newTokenBucket(clock, 10)
+	n, err := bucket.take(10, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, 10, n)
+
+	taken := make(chan int, 1)
+	go func() {
+		n, _ := bucket.take(10, nil, nil)
+		taken <- n
+	}()
+	require.Eventually(t, func() bool { return clock.activeTimers() == 1 }, time.Second, time.Millisecond)
+	require.Empty(t, taken, "empty buckets wait to refill")
+
+	clock.Advance(500 * time.Millisecond)
+	require.Equal(t, 5, <-taken, "half a second refills half the bucket")
+}
+
+func TestWriteBufferClock(t *testing.T) {
+	clock := newFakeClock()
+	rec := &recordingWriter{}
+	buf := newWriteBuffer(clock, rec, 1024, 10*time.Millisecond)
+
+	_, err := buf.Write([]byte("hello"))
+	require.NoError(t, err)
+	clock.Advance(9 * time.Millisecond)
+	require.Empty(t, rec.Writes())
+
+	clock.Advance(time.Millisecond)
+	require.Equal(t, [][]byte{[]byte("hello")}, rec.Writes(), "buffered writes are flushed after the interval")
+}
+
+func TestWithClock(t *testing.T) {
+	clock := newFakeClock()
+	cfg := connectConfig{}
+	withClock(clock)(&cfg)
+	clock.Advance(time.Hour)
+
+	info := newTransportInfo(&cfg, "", tls.ConnectionState{})
+	require.Equal(t, clock.Now(), info.ConnectedAt)
+}
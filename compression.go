@@ -0,0 +1,230 @@
+package ngrok
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The content types compressed by WithResponseCompression when none are
+// given.
+var defaultCompressibleTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// WithResponseCompression configures [Serve] and [ServeTLS] to gzip responses
+// for clients that send "Accept-Encoding: gzip". This reduces bandwidth for
+// text-heavy responses where the ngrok edge isn't compressing them already.
+//
+// Only responses of at least minSize bytes are compressed, unless the handler
+// flushes them before reaching that size. Responses are also only compressed
+// if their Content-Type matches one of the provided types, where a type
+// ending in "/", such as "text/", matches every subtype. If none are
+// provided, text, JSON, JavaScript, XML, and SVG responses are compressed.
+//
+// Responses that already have a Content-Encoding, such as those compressed by
+// the handler itself, are left alone, as are responses to HEAD and range
+// requests.
+func WithResponseCompression(minSize int, contentTypes ...string) ServeOption {
+	return func(cfg *serveConfig) {
+		if len(contentTypes) == 0 {
+			contentTypes = defaultCompressibleTypes
+		}
+		cfg.Compression = &compressionConfig{
+			MinSize:      minSize,
+			ContentTypes: contentTypes,
+		}
+	}
+}
+
+// Options for compressing responses, set by WithResponseCompression.
+type compressionConfig struct {
+	// The smallest response body that's compressed, in bytes.
+	MinSize int
+	// The media types, or prefixes of them ending in "/", that are
+	// compressed.
+	ContentTypes []string
+}
+
+func (cfg *compressionConfig) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range cfg.ContentTypes {
+		if strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed) || mediaType == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Wraps a handler so that its responses are compressed according to cfg.
+func compressionHandler(handler http.Handler, cfg *compressionConfig) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead || req.Header.Get("Range") != "" || !acceptsGzip(req) {
+			handler.ServeHTTP(rw, req)
+			return
+		}
+		cw := &compressResponseWriter{ResponseWriter: rw, cfg: cfg}
+		defer cw.close()
+		handler.ServeHTTP(cw, req)
+	})
+}
+
+// Reports whether the request's Accept-Encoding allows gzip.
+func acceptsGzip(req *http.Request) bool {
+	for _, header := range req.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "gzip" && name != "*" {
+				continue
+			}
+			key, value, _ := strings.Cut(params, "=")
+			if strings.TrimSpace(key) == "q" {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// An http.ResponseWriter that buffers the start of the response until it
+// knows whether to compress it.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	cfg *compressionConfig
+
+	// The status code passed to WriteHeader, or 0 if it hasn't been called.
+	status int
+	// The start of the body, until decided is set.
+	buf []byte
+	// Set once the header has been sent, and gz chosen.
+	decided bool
+	// Non-nil if the body is being compressed.
+	gz *gzip.Writer
+}
+
+// Unwrap allows http.ResponseController to reach the methods of the
+// underlying http.ResponseWriter that aren't wrapped here.
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	if code < 200 {
+		// Informational responses are sent straight away.
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *compressResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.decided {
+		if w.gz != nil {
+			return w.gz.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.cfg.MinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *compressResponseWriter) Flush() {
+	if !w.decided && w.status != 0 {
+		_ = w.decide(true)
+	}
+	if w.gz != nil {
+		_ = w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+	return hj.Hijack()
+}
+
+// Sends the header, compressing the body if it's eligible and large enough,
+// followed by whatever of the body has been buffered.
+func (w *compressResponseWriter) decide(largeEnough bool) error {
+	w.decided = true
+
+	header := w.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		// The http.Server would sniff the compressed bytes otherwise.
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	eligible := w.status != http.StatusNoContent &&
+		w.status != http.StatusNotModified &&
+		header.Get("Content-Encoding") == "" &&
+		w.cfg.compressible(header.Get("Content-Type"))
+	if eligible {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if eligible && largeEnough {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Sends anything still buffered once the handler has returned.
+func (w *compressResponseWriter) close() {
+	if !w.decided {
+		if w.status == 0 {
+			// Nothing was written, so leave the response to the
+			// http.Server.
+			return
+		}
+		_ = w.decide(false)
+	}
+	if w.gz != nil {
+		_ = w.gz.Close()
+	}
+}
@@ -0,0 +1,115 @@
+package ngrok
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCompression(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	large := strings.Repeat("compress me ", 100)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/large":
+			rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = io.WriteString(rw, large)
+		case "/small":
+			rw.Header().Set("Content-Type", "text/plain")
+			_, _ = io.WriteString(rw, "tiny")
+		case "/binary":
+			rw.Header().Set("Content-Type", "image/png")
+			_, _ = io.WriteString(rw, large)
+		case "/encoded":
+			rw.Header().Set("Content-Type", "text/plain")
+			rw.Header().Set("Content-Encoding", "br")
+			_, _ = io.WriteString(rw, large)
+		case "/created":
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusCreated)
+			_, _ = io.WriteString(rw, large)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = Serve(ctx, tun, handler, WithResponseCompression(64))
+	}()
+
+	// The transport transparently decompresses unless asked not to.
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	get := func(path, acceptEncoding string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr+path, nil)
+		require.NoError(t, err)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var body io.Reader = resp.Body
+		if resp.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(resp.Body)
+			require.NoError(t, err)
+			body = gz
+		}
+		content, err := io.ReadAll(body)
+		require.NoError(t, err)
+		return resp, string(content)
+	}
+
+	resp, body := get("/large", "gzip, deflate")
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	require.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	require.Equal(t, large, body)
+
+	resp, body = get("/created", "gzip")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	require.Equal(t, large, body)
+
+	resp, body = get("/large", "")
+	require.Empty(t, resp.Header.Get("Content-Encoding"), "clients must ask for compression")
+	require.Equal(t, large, body)
+
+	resp, _ = get("/large", "gzip;q=0")
+	require.Empty(t, resp.Header.Get("Content-Encoding"), "clients may refuse compression")
+
+	resp, body = get("/small", "gzip")
+	require.Empty(t, resp.Header.Get("Content-Encoding"), "small responses aren't compressed")
+	require.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	require.Equal(t, "tiny", body)
+
+	resp, _ = get("/binary", "gzip")
+	require.Empty(t, resp.Header.Get("Content-Encoding"), "only allowed content types are compressed")
+
+	resp, _ = get("/encoded", "gzip")
+	require.Equal(t, "br", resp.Header.Get("Content-Encoding"), "already encoded responses are left alone")
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for encoding, accepted := range map[string]bool{
+		"":                 false,
+		"gzip":             true,
+		"deflate, gzip":    true,
+		"GZIP;q=0.5":       true,
+		"gzip;q=0":         false,
+		"gzip; q=0.000":    false,
+		"*":                true,
+		"br, identity;q=1": false,
+	} {
+		req := &http.Request{Header: http.Header{}}
+		if encoding != "" {
+			req.Header.Set("Accept-Encoding", encoding)
+		}
+		require.Equal(t, accepted, acceptsGzip(req), encoding)
+	}
+}
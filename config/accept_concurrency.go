@@ -0,0 +1,46 @@
+package config
+
+// WithAcceptConcurrency accepts connections from the tunnel using n goroutines
+// in parallel, so that the per-connection work done while accepting them, such
+// as configuring buffering, throttling, and tracing, doesn't limit the rate at
+// which connections can be accepted.
+//
+// Connections are handed out by Accept in whichever order the goroutines
+// finish with them, which may differ from the order that they arrived in.
+//
+// Defaults to 1, in which case connections are accepted directly by Accept.
+func WithAcceptConcurrency(n int) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+	LabeledTunnelOption
+} {
+	return acceptConcurrencyOption(n)
+}
+
+type acceptConcurrencyOption int
+
+func (n acceptConcurrencyOption) ApplyHTTP(cfg *httpOptions) {
+	cfg.AcceptWorkers = int(n)
+}
+
+func (n acceptConcurrencyOption) ApplyTCP(cfg *tcpOptions) {
+	cfg.AcceptWorkers = int(n)
+}
+
+func (n acceptConcurrencyOption) ApplyTLS(cfg *tlsOptions) {
+	cfg.AcceptWorkers = int(n)
+}
+
+func (n acceptConcurrencyOption) ApplyLabeled(cfg *labeledOptions) {
+	cfg.AcceptWorkers = int(n)
+}
+
+// AcceptConcurrency returns the number of goroutines to accept connections
+// from the tunnel with. It's always at least 1.
+func (cfg commonOpts) AcceptConcurrency() int {
+	if cfg.AcceptWorkers < 1 {
+		return 1
+	}
+	return cfg.AcceptWorkers
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"testing"
+)
+
+type acceptConcurrencyGetter interface {
+	AcceptConcurrency() int
+}
+
+func testAcceptConcurrency[T tunnelConfigPrivate, OT any](t *testing.T,
+	makeOpts func(...OT) Tunnel,
+) {
+	optsFunc := func(opts ...any) Tunnel {
+		return makeOpts(assertSlice[OT](opts)...)
+	}
+
+	cases := testCases[T, any]{
+		{
+			name:         "absent",
+			opts:         optsFunc(),
+			expectGetter: getterReturns(acceptConcurrencyGetter.AcceptConcurrency, 1),
+		},
+		{
+			name:         "with concurrency",
+			opts:         optsFunc(WithAcceptConcurrency(4)),
+			expectGetter: getterReturns(acceptConcurrencyGetter.AcceptConcurrency, 4),
+		},
+		{
+			name:         "negative concurrency",
+			opts:         optsFunc(WithAcceptConcurrency(-1)),
+			expectGetter: getterReturns(acceptConcurrencyGetter.AcceptConcurrency, 1),
+		},
+	}
+
+	cases.runAll(t)
+}
+
+func TestAcceptConcurrency(t *testing.T) {
+	testAcceptConcurrency[httpOptions](t, HTTPEndpoint)
+	testAcceptConcurrency[tlsOptions](t, TLSEndpoint)
+	testAcceptConcurrency[tcpOptions](t, TCPEndpoint)
+	testAcceptConcurrency[labeledOptions](t, LabeledTunnel)
+}
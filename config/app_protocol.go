@@ -0,0 +1,22 @@
+package config
+
+// The protocol that the ngrok edge speaks to the application.
+type AppProtocol string
+
+const (
+	// HTTP/1.1, which the edge speaks by default.
+	AppProtocolHTTP1 = AppProtocol("http1")
+	// HTTP/2 without TLS, known as h2c, as needed to serve gRPC.
+	AppProtocolHTTP2 = AppProtocol("http2")
+)
+
+// WithAppProtocol sets the protocol that the ngrok edge speaks when it sends
+// requests over this tunnel. Use [AppProtocolHTTP2] to serve gRPC, or other
+// applications that rely on HTTP/2 features such as trailers and
+// bidirectional streaming, with ngrok.ServeGRPC or the ngrok.WithH2C serve
+// option.
+func WithAppProtocol(proto AppProtocol) HTTPEndpointOption {
+	return httpOptionFunc(func(cfg *httpOptions) {
+		cfg.AppProtocol = proto
+	})
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+func TestAppProtocol(t *testing.T) {
+	cases := testCases[httpOptions, proto.HTTPEndpoint]{
+		{
+			name: "default",
+			opts: HTTPEndpoint(),
+			expectOpts: func(t *testing.T, opts *proto.HTTPEndpoint) {
+				require.Empty(t, opts.AppProtocol)
+			},
+		},
+		{
+			name: "http2",
+			opts: HTTPEndpoint(WithAppProtocol(AppProtocolHTTP2)),
+			expectOpts: func(t *testing.T, opts *proto.HTTPEndpoint) {
+				require.Equal(t, "http2", opts.AppProtocol)
+			},
+		},
+	}
+
+	cases.runAll(t)
+}
@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 type commonOpts struct {
 	// Restrictions placed on the origin of incoming connections to the edge.
 	CIDRRestrictions *cidrRestrictions
@@ -12,6 +14,42 @@ type commonOpts struct {
 	// bearing on tunnel behavior.
 	// If not set, defaults to a URI in the format `app://hostname/path/to/executable?pid=12345`
 	ForwardsTo string
+	// The size of the buffer to wrap writes to accepted connections with.
+	// Zero if writes are unbuffered.
+	ConnWriteBufferSize int
+	// How often buffered connection writes are flushed.
+	ConnFlushInterval time.Duration
+	// How long accepted connections may go without activity before they're
+	// closed. Disabled when 0.
+	ConnIdleTimeout time.Duration
+	// How long accepted connections may stay open before they're closed.
+	// Unlimited when 0.
+	ConnMaxLifetime time.Duration
+	// The bytes per second that accepted connections may read or write.
+	// Unlimited when 0.
+	ConnBandwidthLimit int64
+	// The bytes per second that the accepted connections may read or write
+	// between them. Unlimited when 0.
+	MaxBytesPerSecond int64
+	// The connections per second that may be accepted. Unlimited when 0.
+	MaxConnsPerSecond float64
+	// The number of goroutines accepting connections from the tunnel.
+	// Connections are accepted directly when 0 or 1.
+	AcceptWorkers int
+	// How long accepted connections may wait for their first bytes from the
+	// client before they're closed. Disabled when 0.
+	ConnHandshakeTimeout time.Duration
+	// The URL that the tunnel must be assigned, or empty if any is accepted.
+	ExpectedURL string
+	// The Traffic Policy document for the edge to apply, as JSON or YAML.
+	// No policy when empty.
+	TrafficPolicy string
+	// Decides whether to accept each connection arriving at the tunnel. All
+	// are accepted when nil.
+	ConnCallback ConnectionCallback
+	// Whether accepted connections are WebSocket connections whose framing
+	// is removed by the SDK.
+	WebsocketUnwrap bool
 }
 
 func (cfg *commonOpts) getForwardsTo() string {
@@ -65,6 +65,20 @@ type testCase[T tunnelConfigPrivate, O any] struct {
 	expectHTTPHandler *http.Handler
 	expectOpts        func(t *testing.T, opts *O)
 	expectNilOpts     bool
+	// Checks what one of the opts' getter methods returns, as built by
+	// getterReturns.
+	expectGetter func(t *testing.T, opts Tunnel)
+}
+
+// Builds a check for testCase.expectGetter that the opts implement G, and
+// that get returns expect for them. get is usually a method expression, such
+// as maxLifetimeGetter.MaxLifetime.
+func getterReturns[G, V any](get func(G) V, expect V) func(t *testing.T, opts Tunnel) {
+	return func(t *testing.T, opts Tunnel) {
+		getter, ok := opts.(G)
+		require.Truef(t, ok, "opts should implement %v", reflect.TypeOf((*G)(nil)).Elem())
+		require.Equal(t, expect, get(getter))
+	}
 }
 
 type testCases[T tunnelConfigPrivate, O any] []testCase[T, O]
@@ -132,6 +146,10 @@ func (tc testCase[T, O]) Run(t *testing.T) {
 				}
 			}
 		}
+
+		if tc.expectGetter != nil {
+			tc.expectGetter(t, tc.opts)
+		}
 	})
 }
 
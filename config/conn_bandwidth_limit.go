@@ -0,0 +1,45 @@
+package config
+
+// WithConnBandwidthLimit limits each connection accepted from the tunnel to
+// reading and writing bytesPerSec bytes per second, independently in each
+// direction. Short bursts of up to one second's worth of data are allowed.
+//
+// This is useful for sharing bandwidth fairly between connections, or for
+// testing how an application behaves under constrained bandwidth.
+//
+// Disabled when 0, the default.
+func WithConnBandwidthLimit(bytesPerSec int64) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+	LabeledTunnelOption
+} {
+	return connBandwidthLimitOption(bytesPerSec)
+}
+
+type connBandwidthLimitOption int64
+
+func (limit connBandwidthLimitOption) ApplyHTTP(cfg *httpOptions) {
+	cfg.ConnBandwidthLimit = int64(limit)
+}
+
+func (limit connBandwidthLimitOption) ApplyTCP(cfg *tcpOptions) {
+	cfg.ConnBandwidthLimit = int64(limit)
+}
+
+func (limit connBandwidthLimitOption) ApplyTLS(cfg *tlsOptions) {
+	cfg.ConnBandwidthLimit = int64(limit)
+}
+
+func (limit connBandwidthLimitOption) ApplyLabeled(cfg *labeledOptions) {
+	cfg.ConnBandwidthLimit = int64(limit)
+}
+
+// BandwidthLimit returns the number of bytes per second that each connection
+// accepted from the tunnel may read or write, or zero if unlimited.
+func (cfg commonOpts) BandwidthLimit() int64 {
+	if cfg.ConnBandwidthLimit < 0 {
+		return 0
+	}
+	return cfg.ConnBandwidthLimit
+}
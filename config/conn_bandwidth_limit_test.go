@@ -0,0 +1,44 @@
+package config
+
+import (
+	"testing"
+)
+
+type bandwidthLimitGetter interface {
+	BandwidthLimit() int64
+}
+
+func testConnBandwidthLimit[T tunnelConfigPrivate, OT any](t *testing.T,
+	makeOpts func(...OT) Tunnel,
+) {
+	optsFunc := func(opts ...any) Tunnel {
+		return makeOpts(assertSlice[OT](opts)...)
+	}
+
+	cases := testCases[T, any]{
+		{
+			name:         "absent",
+			opts:         optsFunc(),
+			expectGetter: getterReturns(bandwidthLimitGetter.BandwidthLimit, 0),
+		},
+		{
+			name:         "with limit",
+			opts:         optsFunc(WithConnBandwidthLimit(1024)),
+			expectGetter: getterReturns(bandwidthLimitGetter.BandwidthLimit, 1024),
+		},
+		{
+			name:         "negative limit",
+			opts:         optsFunc(WithConnBandwidthLimit(-1024)),
+			expectGetter: getterReturns(bandwidthLimitGetter.BandwidthLimit, 0),
+		},
+	}
+
+	cases.runAll(t)
+}
+
+func TestConnBandwidthLimit(t *testing.T) {
+	testConnBandwidthLimit[httpOptions](t, HTTPEndpoint)
+	testConnBandwidthLimit[tlsOptions](t, TLSEndpoint)
+	testConnBandwidthLimit[tcpOptions](t, TCPEndpoint)
+	testConnBandwidthLimit[labeledOptions](t, LabeledTunnel)
+}
@@ -0,0 +1,71 @@
+package config
+
+import "context"
+
+// ConnInfo describes a connection that has arrived at a tunnel, as passed to
+// the callback configured with [WithConnectionCallback].
+type ConnInfo struct {
+	// The ID of the tunnel that the connection arrived at.
+	TunnelID string
+	// The address of the client that connected to the ngrok edge.
+	ClientAddr string
+	// The protocol of the connection, such as "http", "https", "tcp", or
+	// "tls".
+	Proto string
+	// The type of the edge that the connection arrived through. Empty for
+	// endpoints that aren't attached to an edge.
+	EdgeType string
+	// The server name that the client sent in its TLS ClientHello, if the
+	// tunnel checks it with [WithRequiredSNI].
+	ServerName string
+}
+
+// ConnectionCallback decides whether to accept a connection that has arrived
+// at a tunnel. See [WithConnectionCallback].
+type ConnectionCallback func(ctx context.Context, info ConnInfo) error
+
+// WithConnectionCallback configures a function to be called with each
+// connection that arrives at the tunnel, before it's returned from Accept or
+// forwarded. This lets connections be rejected based on the client's address,
+// rate limits, or the state of the application.
+//
+// A connection is accepted if the callback returns nil. Otherwise, it's
+// rejected with a response suited to its protocol: HTTP connections are sent a
+// 403 Forbidden response, and the rest are reset. Rejections are reported to
+// the tunnel's OnAccept function with the callback's error.
+//
+// The callback is called on the goroutine accepting from the tunnel, so slow
+// callbacks delay the connections behind them. Use
+// [WithAcceptConcurrency] to make decisions in parallel.
+func WithConnectionCallback(fn ConnectionCallback) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+	LabeledTunnelOption
+} {
+	return connCallbackOption(fn)
+}
+
+type connCallbackOption ConnectionCallback
+
+func (fn connCallbackOption) ApplyHTTP(cfg *httpOptions) {
+	cfg.ConnCallback = ConnectionCallback(fn)
+}
+
+func (fn connCallbackOption) ApplyTCP(cfg *tcpOptions) {
+	cfg.ConnCallback = ConnectionCallback(fn)
+}
+
+func (fn connCallbackOption) ApplyTLS(cfg *tlsOptions) {
+	cfg.ConnCallback = ConnectionCallback(fn)
+}
+
+func (fn connCallbackOption) ApplyLabeled(cfg *labeledOptions) {
+	cfg.ConnCallback = ConnectionCallback(fn)
+}
+
+// ConnectionCallback returns the function that decides whether to accept each
+// connection arriving at the tunnel, or nil if they're all accepted.
+func (cfg commonOpts) ConnectionCallback() ConnectionCallback {
+	return cfg.ConnCallback
+}
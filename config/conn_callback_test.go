@@ -0,0 +1,57 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type connectionCallbackGetter interface {
+	ConnectionCallback() ConnectionCallback
+}
+
+// Returns whether the options have a connection callback.
+func hasConnectionCallback(g connectionCallbackGetter) bool {
+	return g.ConnectionCallback() != nil
+}
+
+// Returns what the options' connection callback returns for an empty
+// ConnInfo.
+func callConnectionCallback(g connectionCallbackGetter) error {
+	return g.ConnectionCallback()(context.Background(), ConnInfo{})
+}
+
+func testConnectionCallback[T tunnelConfigPrivate, OT any](t *testing.T,
+	makeOpts func(...OT) Tunnel,
+) {
+	optsFunc := func(opts ...any) Tunnel {
+		return makeOpts(assertSlice[OT](opts)...)
+	}
+
+	errRejected := errors.New("rejected")
+	callback := func(ctx context.Context, info ConnInfo) error {
+		return errRejected
+	}
+
+	cases := testCases[T, any]{
+		{
+			name:         "absent",
+			opts:         optsFunc(),
+			expectGetter: getterReturns(hasConnectionCallback, false),
+		},
+		{
+			name:         "with callback",
+			opts:         optsFunc(WithConnectionCallback(callback)),
+			expectGetter: getterReturns(callConnectionCallback, errRejected),
+		},
+	}
+
+	cases.runAll(t)
+}
+
+func TestConnectionCallback(t *testing.T) {
+	testConnectionCallback[httpOptions](t, HTTPEndpoint)
+	testConnectionCallback[tlsOptions](t, TLSEndpoint)
+	testConnectionCallback[tcpOptions](t, TCPEndpoint)
+	testConnectionCallback[labeledOptions](t, LabeledTunnel)
+}
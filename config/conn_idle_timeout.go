@@ -0,0 +1,47 @@
+package config
+
+import "time"
+
+// WithConnIdleTimeout closes connections accepted from the tunnel once they've
+// gone the provided duration without reading or writing any data.
+//
+// Protocols whose liveness isn't visible in the byte stream can extend a
+// connection's life explicitly by asserting it to interface{ Touch() } and
+// calling Touch.
+//
+// Disabled by default.
+func WithConnIdleTimeout(timeout time.Duration) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+	LabeledTunnelOption
+} {
+	return connIdleTimeoutOption(timeout)
+}
+
+type connIdleTimeoutOption time.Duration
+
+func (timeout connIdleTimeoutOption) ApplyHTTP(cfg *httpOptions) {
+	cfg.ConnIdleTimeout = time.Duration(timeout)
+}
+
+func (timeout connIdleTimeoutOption) ApplyTCP(cfg *tcpOptions) {
+	cfg.ConnIdleTimeout = time.Duration(timeout)
+}
+
+func (timeout connIdleTimeoutOption) ApplyTLS(cfg *tlsOptions) {
+	cfg.ConnIdleTimeout = time.Duration(timeout)
+}
+
+func (timeout connIdleTimeoutOption) ApplyLabeled(cfg *labeledOptions) {
+	cfg.ConnIdleTimeout = time.Duration(timeout)
+}
+
+// IdleTimeout returns the duration after which idle connections accepted
+// from the tunnel are closed, or zero if they're never closed for being idle.
+func (cfg commonOpts) IdleTimeout() time.Duration {
+	if cfg.ConnIdleTimeout < 0 {
+		return 0
+	}
+	return cfg.ConnIdleTimeout
+}
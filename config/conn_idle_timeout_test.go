@@ -0,0 +1,45 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+type idleTimeoutGetter interface {
+	IdleTimeout() time.Duration
+}
+
+func testConnIdleTimeout[T tunnelConfigPrivate, OT any](t *testing.T,
+	makeOpts func(...OT) Tunnel,
+) {
+	optsFunc := func(opts ...any) Tunnel {
+		return makeOpts(assertSlice[OT](opts)...)
+	}
+
+	cases := testCases[T, any]{
+		{
+			name:         "absent",
+			opts:         optsFunc(),
+			expectGetter: getterReturns(idleTimeoutGetter.IdleTimeout, 0),
+		},
+		{
+			name:         "with timeout",
+			opts:         optsFunc(WithConnIdleTimeout(time.Minute)),
+			expectGetter: getterReturns(idleTimeoutGetter.IdleTimeout, time.Minute),
+		},
+		{
+			name:         "negative timeout",
+			opts:         optsFunc(WithConnIdleTimeout(-time.Minute)),
+			expectGetter: getterReturns(idleTimeoutGetter.IdleTimeout, 0),
+		},
+	}
+
+	cases.runAll(t)
+}
+
+func TestConnIdleTimeout(t *testing.T) {
+	testConnIdleTimeout[httpOptions](t, HTTPEndpoint)
+	testConnIdleTimeout[tlsOptions](t, TLSEndpoint)
+	testConnIdleTimeout[tcpOptions](t, TCPEndpoint)
+	testConnIdleTimeout[labeledOptions](t, LabeledTunnel)
+}
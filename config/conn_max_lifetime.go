@@ -0,0 +1,49 @@
+package config
+
+import "time"
+
+// WithConnMaxLifetime closes connections accepted from the tunnel once they've
+// been open for the provided duration, whether or not they're active. This
+// bounds how long any one client can hold on to a connection, such as one
+// that keeps sending just enough to defeat WithConnIdleTimeout.
+//
+// Connections forwarded with Forward are closed along with their upstream
+// connections. Connections closed this way are counted in the tunnel's
+// TunnelInfo.ConnTimeouts.
+//
+// Disabled by default.
+func WithConnMaxLifetime(lifetime time.Duration) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+	LabeledTunnelOption
+} {
+	return connMaxLifetimeOption(lifetime)
+}
+
+type connMaxLifetimeOption time.Duration
+
+func (lifetime connMaxLifetimeOption) ApplyHTTP(cfg *httpOptions) {
+	cfg.ConnMaxLifetime = time.Duration(lifetime)
+}
+
+func (lifetime connMaxLifetimeOption) ApplyTCP(cfg *tcpOptions) {
+	cfg.ConnMaxLifetime = time.Duration(lifetime)
+}
+
+func (lifetime connMaxLifetimeOption) ApplyTLS(cfg *tlsOptions) {
+	cfg.ConnMaxLifetime = time.Duration(lifetime)
+}
+
+func (lifetime connMaxLifetimeOption) ApplyLabeled(cfg *labeledOptions) {
+	cfg.ConnMaxLifetime = time.Duration(lifetime)
+}
+
+// MaxLifetime returns how long connections accepted from the tunnel may stay
+// open before they're closed, or zero if they may stay open indefinitely.
+func (cfg commonOpts) MaxLifetime() time.Duration {
+	if cfg.ConnMaxLifetime < 0 {
+		return 0
+	}
+	return cfg.ConnMaxLifetime
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+type maxLifetimeGetter interface {
+	MaxLifetime() time.Duration
+}
+
+func testConnMaxLifetime[T tunnelConfigPrivate, OT any](t *testing.T,
+	makeOpts func(...OT) Tunnel,
+) {
+	optsFunc := func(opts ...any) Tunnel {
+		return makeOpts(assertSlice[OT](opts)...)
+	}
+
+	cases := testCases[T, any]{
+		{
+			name:         "absent",
+			opts:         optsFunc(),
+			expectGetter: getterReturns(maxLifetimeGetter.MaxLifetime, 0),
+		},
+		{
+			name:         "with lifetime",
+			opts:         optsFunc(WithConnMaxLifetime(time.Minute)),
+			expectGetter: getterReturns(maxLifetimeGetter.MaxLifetime, time.Minute),
+		},
+		{
+			name:         "negative lifetime",
+			opts:         optsFunc(WithConnMaxLifetime(-time.Minute)),
+			expectGetter: getterReturns(maxLifetimeGetter.MaxLifetime, 0),
+		},
+	}
+
+	cases.runAll(t)
+}
+
+func TestConnMaxLifetime(t *testing.T) {
+	testConnMaxLifetime[httpOptions](t, HTTPEndpoint)
+	testConnMaxLifetime[tlsOptions](t, TLSEndpoint)
+	testConnMaxLifetime[tcpOptions](t, TCPEndpoint)
+	testConnMaxLifetime[labeledOptions](t, LabeledTunnel)
+}
@@ -0,0 +1,94 @@
+package config
+
+import "time"
+
+// The interval at which buffered connection writes are flushed if
+// WithConnFlushInterval isn't used.
+const defaultConnFlushInterval = 10 * time.Millisecond
+
+// WithConnWriteBuffer buffers up to size bytes of writes to each connection
+// accepted from the tunnel before sending them to the ngrok edge. This reduces
+// overhead for protocols that make many small writes.
+//
+// Buffered data is sent when the buffer fills, when the connection is closed,
+// when its Flush method is called, and periodically as configured by
+// WithConnFlushInterval. Connections accepted from the tunnel can be asserted
+// to interface{ Flush() error } to flush explicitly.
+//
+// A size of zero, the default, disables buffering.
+func WithConnWriteBuffer(size int) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+	LabeledTunnelOption
+} {
+	return connWriteBufferOption(size)
+}
+
+type connWriteBufferOption int
+
+func (size connWriteBufferOption) ApplyHTTP(cfg *httpOptions) {
+	cfg.ConnWriteBufferSize = int(size)
+}
+
+func (size connWriteBufferOption) ApplyTCP(cfg *tcpOptions) {
+	cfg.ConnWriteBufferSize = int(size)
+}
+
+func (size connWriteBufferOption) ApplyTLS(cfg *tlsOptions) {
+	cfg.ConnWriteBufferSize = int(size)
+}
+
+func (size connWriteBufferOption) ApplyLabeled(cfg *labeledOptions) {
+	cfg.ConnWriteBufferSize = int(size)
+}
+
+// WithConnFlushInterval sets the longest that a write will sit in a
+// connection's buffer before being sent, bounding the latency that buffering
+// adds for interactive protocols. It has no effect unless WithConnWriteBuffer
+// is also used.
+//
+// Defaults to 10ms. A negative interval disables periodic flushing.
+func WithConnFlushInterval(interval time.Duration) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+	LabeledTunnelOption
+} {
+	return connFlushIntervalOption(interval)
+}
+
+type connFlushIntervalOption time.Duration
+
+func (interval connFlushIntervalOption) ApplyHTTP(cfg *httpOptions) {
+	cfg.ConnFlushInterval = time.Duration(interval)
+}
+
+func (interval connFlushIntervalOption) ApplyTCP(cfg *tcpOptions) {
+	cfg.ConnFlushInterval = time.Duration(interval)
+}
+
+func (interval connFlushIntervalOption) ApplyTLS(cfg *tlsOptions) {
+	cfg.ConnFlushInterval = time.Duration(interval)
+}
+
+func (interval connFlushIntervalOption) ApplyLabeled(cfg *labeledOptions) {
+	cfg.ConnFlushInterval = time.Duration(interval)
+}
+
+// ConnWriteBuffer returns the size of the write buffer to wrap accepted
+// connections with, and the interval at which to flush it. A size of zero
+// means that connections are unbuffered, and an interval of zero means that
+// they're never flushed periodically.
+func (cfg commonOpts) ConnWriteBuffer() (size int, flushInterval time.Duration) {
+	if cfg.ConnWriteBufferSize <= 0 {
+		return 0, 0
+	}
+	switch {
+	case cfg.ConnFlushInterval == 0:
+		flushInterval = defaultConnFlushInterval
+	case cfg.ConnFlushInterval > 0:
+		flushInterval = cfg.ConnFlushInterval
+	}
+	return cfg.ConnWriteBufferSize, flushInterval
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+type connWriteBuffer struct {
+	size     int
+	interval time.Duration
+}
+
+type connWriteBufferGetter interface {
+	ConnWriteBuffer() (int, time.Duration)
+}
+
+func getConnWriteBuffer(g connWriteBufferGetter) connWriteBuffer {
+	size, interval := g.ConnWriteBuffer()
+	return connWriteBuffer{size, interval}
+}
+
+func testConnWriteBuffer[T tunnelConfigPrivate, OT any](t *testing.T,
+	makeOpts func(...OT) Tunnel,
+) {
+	optsFunc := func(opts ...any) Tunnel {
+		return makeOpts(assertSlice[OT](opts)...)
+	}
+
+	cases := testCases[T, any]{
+		{
+			name:         "absent",
+			opts:         optsFunc(),
+			expectGetter: getterReturns(getConnWriteBuffer, connWriteBuffer{}),
+		},
+		{
+			name:         "default interval",
+			opts:         optsFunc(WithConnWriteBuffer(4096)),
+			expectGetter: getterReturns(getConnWriteBuffer, connWriteBuffer{4096, defaultConnFlushInterval}),
+		},
+		{
+			name:         "custom interval",
+			opts:         optsFunc(WithConnWriteBuffer(4096), WithConnFlushInterval(time.Second)),
+			expectGetter: getterReturns(getConnWriteBuffer, connWriteBuffer{4096, time.Second}),
+		},
+		{
+			name:         "periodic flushing disabled",
+			opts:         optsFunc(WithConnWriteBuffer(4096), WithConnFlushInterval(-1)),
+			expectGetter: getterReturns(getConnWriteBuffer, connWriteBuffer{4096, 0}),
+		},
+		{
+			name:         "interval without buffer",
+			opts:         optsFunc(WithConnFlushInterval(time.Second)),
+			expectGetter: getterReturns(getConnWriteBuffer, connWriteBuffer{}),
+		},
+	}
+
+	cases.runAll(t)
+}
+
+func TestConnWriteBuffer(t *testing.T) {
+	testConnWriteBuffer[httpOptions](t, HTTPEndpoint)
+	testConnWriteBuffer[tlsOptions](t, TLSEndpoint)
+	testConnWriteBuffer[tcpOptions](t, TCPEndpoint)
+	testConnWriteBuffer[labeledOptions](t, LabeledTunnel)
+}
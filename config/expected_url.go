@@ -0,0 +1,38 @@
+package config
+
+// WithExpectedURL makes starting the tunnel fail if the URL that the ngrok
+// edge assigns to it isn't the provided one, such as when a reserved domain
+// is unavailable and an ephemeral one is assigned instead. This is for
+// automation that registers the URL elsewhere, e.g. as a webhook endpoint,
+// and mustn't proceed with the wrong one.
+//
+// The URL may be given with or without its scheme. Without one, only the
+// host and port are compared. Hostnames are compared case-insensitively, and
+// a trailing "/" is ignored.
+func WithExpectedURL(url string) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+} {
+	return expectedURLOption(url)
+}
+
+type expectedURLOption string
+
+func (url expectedURLOption) ApplyHTTP(cfg *httpOptions) {
+	cfg.ExpectedURL = string(url)
+}
+
+func (url expectedURLOption) ApplyTCP(cfg *tcpOptions) {
+	cfg.ExpectedURL = string(url)
+}
+
+func (url expectedURLOption) ApplyTLS(cfg *tlsOptions) {
+	cfg.ExpectedURL = string(url)
+}
+
+// RequiredURL returns the URL that the tunnel must be assigned for it to
+// start, or the empty string if any URL is accepted.
+func (cfg commonOpts) RequiredURL() string {
+	return cfg.ExpectedURL
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+)
+
+type requiredURLGetter interface {
+	RequiredURL() string
+}
+
+func testExpectedURL[T tunnelConfigPrivate, OT any](t *testing.T,
+	makeOpts func(...OT) Tunnel,
+) {
+	optsFunc := func(opts ...any) Tunnel {
+		return makeOpts(assertSlice[OT](opts)...)
+	}
+
+	cases := testCases[T, any]{
+		{
+			name:         "absent",
+			opts:         optsFunc(),
+			expectGetter: getterReturns(requiredURLGetter.RequiredURL, ""),
+		},
+		{
+			name:         "with url",
+			opts:         optsFunc(WithExpectedURL("https://app.example.com")),
+			expectGetter: getterReturns(requiredURLGetter.RequiredURL, "https://app.example.com"),
+		},
+	}
+
+	cases.runAll(t)
+}
+
+func TestExpectedURL(t *testing.T) {
+	testExpectedURL[httpOptions](t, HTTPEndpoint)
+	testExpectedURL[tlsOptions](t, TLSEndpoint)
+	testExpectedURL[tcpOptions](t, TCPEndpoint)
+}
@@ -7,7 +7,13 @@ import (
 )
 
 // WithForwardsTo sets the ForwardsTo string for this tunnel.
-// This can be veiwed via the API or dashboard.
+// This can be viewed via the API or dashboard.
+//
+// The value is purely informational: it's sent to the ngrok service when the
+// tunnel is started, but has no bearing on where connections are actually
+// sent. Use it to show a meaningful service name rather than the default,
+// which is derived from the hostname, executable, and process ID. The value is
+// also returned by the resulting tunnel's ForwardsTo method.
 func WithForwardsTo(meta string) interface {
 	HTTPEndpointOption
 	LabeledTunnelOption
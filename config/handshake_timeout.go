@@ -0,0 +1,49 @@
+package config
+
+import "time"
+
+// WithHandshakeTimeout closes connections accepted from the tunnel if the
+// client hasn't sent any data, such as a TLS ClientHello or an HTTP request
+// line, within the provided duration of the connection being accepted. This
+// protects against clients that open connections and stall in order to
+// exhaust the server's resources.
+//
+// Only use this for protocols where the client speaks first.
+//
+// Disabled by default.
+func WithHandshakeTimeout(timeout time.Duration) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+	LabeledTunnelOption
+} {
+	return handshakeTimeoutOption(timeout)
+}
+
+type handshakeTimeoutOption time.Duration
+
+func (timeout handshakeTimeoutOption) ApplyHTTP(cfg *httpOptions) {
+	cfg.ConnHandshakeTimeout = time.Duration(timeout)
+}
+
+func (timeout handshakeTimeoutOption) ApplyTCP(cfg *tcpOptions) {
+	cfg.ConnHandshakeTimeout = time.Duration(timeout)
+}
+
+func (timeout handshakeTimeoutOption) ApplyTLS(cfg *tlsOptions) {
+	cfg.ConnHandshakeTimeout = time.Duration(timeout)
+}
+
+func (timeout handshakeTimeoutOption) ApplyLabeled(cfg *labeledOptions) {
+	cfg.ConnHandshakeTimeout = time.Duration(timeout)
+}
+
+// HandshakeTimeout returns how long connections accepted from the tunnel may
+// wait for their first bytes from the client, or zero if they may wait
+// forever.
+func (cfg commonOpts) HandshakeTimeout() time.Duration {
+	if cfg.ConnHandshakeTimeout < 0 {
+		return 0
+	}
+	return cfg.ConnHandshakeTimeout
+}
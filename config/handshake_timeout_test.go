@@ -0,0 +1,45 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+type handshakeTimeoutGetter interface {
+	HandshakeTimeout() time.Duration
+}
+
+func testHandshakeTimeout[T tunnelConfigPrivate, OT any](t *testing.T,
+	makeOpts func(...OT) Tunnel,
+) {
+	optsFunc := func(opts ...any) Tunnel {
+		return makeOpts(assertSlice[OT](opts)...)
+	}
+
+	cases := testCases[T, any]{
+		{
+			name:         "absent",
+			opts:         optsFunc(),
+			expectGetter: getterReturns(handshakeTimeoutGetter.HandshakeTimeout, 0),
+		},
+		{
+			name:         "with timeout",
+			opts:         optsFunc(WithHandshakeTimeout(time.Minute)),
+			expectGetter: getterReturns(handshakeTimeoutGetter.HandshakeTimeout, time.Minute),
+		},
+		{
+			name:         "negative timeout",
+			opts:         optsFunc(WithHandshakeTimeout(-time.Minute)),
+			expectGetter: getterReturns(handshakeTimeoutGetter.HandshakeTimeout, 0),
+		},
+	}
+
+	cases.runAll(t)
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	testHandshakeTimeout[httpOptions](t, HTTPEndpoint)
+	testHandshakeTimeout[tlsOptions](t, TLSEndpoint)
+	testHandshakeTimeout[tcpOptions](t, TCPEndpoint)
+	testHandshakeTimeout[labeledOptions](t, LabeledTunnel)
+}
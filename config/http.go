@@ -38,6 +38,10 @@ type httpOptions struct {
 	// The domain to request for this edge
 	Domain string
 
+	// The protocol that the edge speaks to the application.
+	// Defaults to [AppProtocolHTTP1].
+	AppProtocol AppProtocol
+
 	// If non-nil, start a goroutine which runs this http server
 	// accepting connections from the http tunnel
 	httpServer *http.Server
@@ -73,7 +77,8 @@ type httpOptions struct {
 
 func (cfg *httpOptions) toProtoConfig() *proto.HTTPEndpoint {
 	opts := &proto.HTTPEndpoint{
-		Domain: cfg.Domain,
+		Domain:      cfg.Domain,
+		AppProtocol: string(cfg.AppProtocol),
 	}
 
 	if cfg.Compression {
@@ -106,6 +111,7 @@ func (cfg *httpOptions) toProtoConfig() *proto.HTTPEndpoint {
 	opts.OIDC = cfg.OIDC.toProtoConfig()
 	opts.WebhookVerification = cfg.WebhookVerification.toProtoConfig()
 	opts.IPRestriction = cfg.commonOpts.CIDRRestrictions.toProtoConfig()
+	opts.TrafficPolicy = cfg.commonOpts.trafficPolicyJSON()
 
 	return opts
 }
@@ -0,0 +1,90 @@
+package config
+
+// WithMaxBytesPerSecond limits all of the connections accepted from the
+// tunnel, together, to reading and writing bytesPerSec bytes per second,
+// independently in each direction. Short bursts of up to one second's worth
+// of data are allowed. Unlike [WithConnBandwidthLimit], which limits each
+// connection on its own, this bounds the bandwidth that the tunnel uses on the
+// host's uplink however many connections it has.
+//
+// The limit can be changed on the running tunnel with its
+// SetMaxBytesPerSecond method.
+//
+// Disabled when 0, the default.
+func WithMaxBytesPerSecond(bytesPerSec int64) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+	LabeledTunnelOption
+} {
+	return maxBytesPerSecondOption(bytesPerSec)
+}
+
+type maxBytesPerSecondOption int64
+
+func (limit maxBytesPerSecondOption) ApplyHTTP(cfg *httpOptions) {
+	cfg.MaxBytesPerSecond = int64(limit)
+}
+
+func (limit maxBytesPerSecondOption) ApplyTCP(cfg *tcpOptions) {
+	cfg.MaxBytesPerSecond = int64(limit)
+}
+
+func (limit maxBytesPerSecondOption) ApplyTLS(cfg *tlsOptions) {
+	cfg.MaxBytesPerSecond = int64(limit)
+}
+
+func (limit maxBytesPerSecondOption) ApplyLabeled(cfg *labeledOptions) {
+	cfg.MaxBytesPerSecond = int64(limit)
+}
+
+// WithMaxConnsPerSecond limits the rate at which connections are accepted
+// from the tunnel to connsPerSec connections per second, with bursts of up to
+// one second's worth. Connections that arrive faster are rejected: HTTP
+// clients are sent a 429 Too Many Requests response, and the rest are reset.
+// Fractional rates, such as 0.5 for one connection every two seconds, are
+// allowed.
+//
+// The limit can be changed on the running tunnel with its
+// SetMaxConnsPerSecond method.
+//
+// Disabled when 0, the default.
+func WithMaxConnsPerSecond(connsPerSec float64) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+	LabeledTunnelOption
+} {
+	return maxConnsPerSecondOption(connsPerSec)
+}
+
+type maxConnsPerSecondOption float64
+
+func (limit maxConnsPerSecondOption) ApplyHTTP(cfg *httpOptions) {
+	cfg.MaxConnsPerSecond = float64(limit)
+}
+
+func (limit maxConnsPerSecondOption) ApplyTCP(cfg *tcpOptions) {
+	cfg.MaxConnsPerSecond = float64(limit)
+}
+
+func (limit maxConnsPerSecondOption) ApplyTLS(cfg *tlsOptions) {
+	cfg.MaxConnsPerSecond = float64(limit)
+}
+
+func (limit maxConnsPerSecondOption) ApplyLabeled(cfg *labeledOptions) {
+	cfg.MaxConnsPerSecond = float64(limit)
+}
+
+// RateLimits returns the bytes per second that the connections accepted from
+// the tunnel may read or write between them, and the connections per second
+// that may be accepted. Each is zero if it's unlimited.
+func (cfg commonOpts) RateLimits() (bytesPerSec int64, connsPerSec float64) {
+	if cfg.MaxBytesPerSecond > 0 {
+		bytesPerSec = cfg.MaxBytesPerSecond
+	}
+	if cfg.MaxConnsPerSecond > 0 {
+		connsPerSec = cfg.MaxConnsPerSecond
+	}
+	return bytesPerSec, connsPerSec
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"testing"
+)
+
+type rateLimits struct {
+	bytesPerSec int64
+	connsPerSec float64
+}
+
+type rateLimitsGetter interface {
+	RateLimits() (int64, float64)
+}
+
+func getRateLimits(g rateLimitsGetter) rateLimits {
+	bytesPerSec, connsPerSec := g.RateLimits()
+	return rateLimits{bytesPerSec, connsPerSec}
+}
+
+func testRateLimits[T tunnelConfigPrivate, OT any](t *testing.T,
+	makeOpts func(...OT) Tunnel,
+) {
+	optsFunc := func(opts ...any) Tunnel {
+		return makeOpts(assertSlice[OT](opts)...)
+	}
+
+	cases := testCases[T, any]{
+		{
+			name:         "absent",
+			opts:         optsFunc(),
+			expectGetter: getterReturns(getRateLimits, rateLimits{}),
+		},
+		{
+			name:         "with limits",
+			opts:         optsFunc(WithMaxBytesPerSecond(1<<20), WithMaxConnsPerSecond(0.5)),
+			expectGetter: getterReturns(getRateLimits, rateLimits{1 << 20, 0.5}),
+		},
+		{
+			name:         "negative limits",
+			opts:         optsFunc(WithMaxBytesPerSecond(-1), WithMaxConnsPerSecond(-1)),
+			expectGetter: getterReturns(getRateLimits, rateLimits{}),
+		},
+	}
+
+	cases.runAll(t)
+}
+
+func TestRateLimits(t *testing.T) {
+	testRateLimits[httpOptions](t, HTTPEndpoint)
+	testRateLimits[tlsOptions](t, TLSEndpoint)
+	testRateLimits[tcpOptions](t, TCPEndpoint)
+	testRateLimits[labeledOptions](t, LabeledTunnel)
+}
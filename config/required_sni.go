@@ -0,0 +1,31 @@
+package config
+
+import "strings"
+
+// WithRequiredSNI closes connections accepted from the tunnel unless the
+// server name that the client requested via SNI in its TLS ClientHello matches
+// one of the provided patterns. This ensures that only the expected hostnames
+// reach the backend, in addition to whatever the edge enforces.
+//
+// Patterns are matched case-insensitively, and may start with a "*." wildcard,
+// which matches exactly one label: "*.example.com" matches "a.example.com",
+// but not "example.com" or "a.b.example.com". Clients that don't send SNI are
+// rejected.
+//
+// The ClientHello is read while accepting the connection, so a client that's
+// slow to send one holds up the connections behind it, for up to the timeout
+// set by WithHandshakeTimeout, or 10 seconds without one. Combine this with
+// WithAcceptConcurrency to check several connections at once.
+func WithRequiredSNI(patterns ...string) TLSEndpointOption {
+	return tlsOptionFunc(func(cfg *tlsOptions) {
+		for _, pattern := range patterns {
+			cfg.RequiredSNI = append(cfg.RequiredSNI, strings.ToLower(pattern))
+		}
+	})
+}
+
+// RequiredServerNames returns the patterns that the SNI of connections accepted
+// from the tunnel must match, or nil if every connection is accepted.
+func (cfg tlsOptions) RequiredServerNames() []string {
+	return cfg.RequiredSNI
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequiredSNI(t *testing.T) {
+	opts := TLSEndpoint()
+	require.Nil(t, opts.(tlsOptions).RequiredServerNames())
+
+	opts = TLSEndpoint(
+		WithRequiredSNI("App.Example.com", "*.api.example.com"),
+		WithRequiredSNI("other.example.com"),
+	)
+	require.Equal(t, []string{
+		"app.example.com",
+		"*.api.example.com",
+		"other.example.com",
+	}, opts.(tlsOptions).RequiredServerNames())
+}
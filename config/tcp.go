@@ -47,6 +47,7 @@ func (cfg *tcpOptions) toProtoConfig() *proto.TCPEndpoint {
 		Addr:          cfg.RemoteAddr,
 		IPRestriction: cfg.commonOpts.CIDRRestrictions.toProtoConfig(),
 		ProxyProto:    proto.ProxyProto(cfg.commonOpts.ProxyProto),
+		TrafficPolicy: cfg.commonOpts.trafficPolicyJSON(),
 	}
 }
 
@@ -44,6 +44,9 @@ type tlsOptions struct {
 	// format.
 	CertPEM []byte
 
+	// The patterns that the SNI of accepted connections must match.
+	RequiredSNI []string
+
 	// An HTTP Server to run traffic on
 	httpServer *http.Server
 }
@@ -55,6 +58,7 @@ func (cfg *tlsOptions) toProtoConfig() *proto.TLSEndpoint {
 	}
 
 	opts.IPRestriction = cfg.commonOpts.CIDRRestrictions.toProtoConfig()
+	opts.TrafficPolicy = cfg.commonOpts.trafficPolicyJSON()
 
 	opts.MutualTLSAtEdge = mutualTLSEndpointOption(cfg.MutualTLSCA).toProtoConfig()
 
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// WithTrafficPolicy sets the Traffic Policy for the ngrok edge to apply to the
+// tunnel's traffic, such as rate limiting, header mutation, JWT validation, or
+// redirects. The policy is a document in either JSON or YAML, in the format
+// described by ngrok's Traffic Policy documentation. Use
+// [WithTrafficPolicyRules] to build one in Go instead.
+//
+// Starting the tunnel fails without contacting the ngrok service if the
+// policy isn't a well-formed JSON or YAML object. Its rules are validated by
+// the ngrok service when the tunnel starts.
+func WithTrafficPolicy(policy string) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+} {
+	return trafficPolicyOption(policy)
+}
+
+// WithTrafficPolicyRules is like [WithTrafficPolicy], but takes the policy as
+// a [TrafficPolicy], built from rules and the constructors for the common
+// actions.
+func WithTrafficPolicyRules(policy TrafficPolicy) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+} {
+	return trafficPolicyOption(policy.String())
+}
+
+type trafficPolicyOption string
+
+func (policy trafficPolicyOption) ApplyHTTP(cfg *httpOptions) {
+	cfg.TrafficPolicy = string(policy)
+}
+
+func (policy trafficPolicyOption) ApplyTCP(cfg *tcpOptions) {
+	cfg.TrafficPolicy = string(policy)
+}
+
+func (policy trafficPolicyOption) ApplyTLS(cfg *tlsOptions) {
+	cfg.TrafficPolicy = string(policy)
+}
+
+// ValidateTrafficPolicy returns an error if the tunnel's Traffic Policy isn't
+// a well-formed JSON or YAML object.
+func (cfg commonOpts) ValidateTrafficPolicy() error {
+	_, err := normalizeTrafficPolicy(cfg.TrafficPolicy)
+	return err
+}
+
+// Returns the Traffic Policy as JSON, for sending to the ngrok service. A
+// policy that can't be converted is sent as-is, though ValidateTrafficPolicy
+// keeps it from getting that far.
+func (cfg commonOpts) trafficPolicyJSON() string {
+	policy, err := normalizeTrafficPolicy(cfg.TrafficPolicy)
+	if err != nil {
+		return cfg.TrafficPolicy
+	}
+	return policy
+}
+
+// Converts a policy in JSON or YAML to JSON.
+func normalizeTrafficPolicy(policy string) (string, error) {
+	if strings.TrimSpace(policy) == "" {
+		return "", nil
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(policy), &doc); err == nil {
+		return policy, nil
+	}
+	// YAML is a superset of JSON, so malformed JSON is reported as YAML.
+	if err := yaml.Unmarshal([]byte(policy), &doc); err != nil {
+		return "", fmt.Errorf("invalid traffic policy: %w", err)
+	}
+	if doc == nil {
+		return "", errors.New("invalid traffic policy: not an object")
+	}
+	normalized, err := json.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("invalid traffic policy: %w", err)
+	}
+	return string(normalized), nil
+}
+
+// TrafficPolicy is a Traffic Policy document, for [WithTrafficPolicyRules].
+// Each phase's rules are applied by the ngrok edge in order.
+type TrafficPolicy struct {
+	// Rules applied to HTTP requests, before they're sent to the tunnel.
+	OnHTTPRequest []PolicyRule `json:"on_http_request,omitempty"`
+	// Rules applied to HTTP responses, before they're sent to the client.
+	OnHTTPResponse []PolicyRule `json:"on_http_response,omitempty"`
+	// Rules applied to TCP connections, as they're accepted.
+	OnTCPConnect []PolicyRule `json:"on_tcp_connect,omitempty"`
+}
+
+// String returns the policy as a JSON document.
+func (p TrafficPolicy) String() string {
+	doc, _ := json.Marshal(p)
+	return string(doc)
+}
+
+// PolicyRule is a rule of a [TrafficPolicy]: a set of actions, taken for the
+// traffic that matches every one of its expressions.
+type PolicyRule struct {
+	// A name for the rule, for identifying it in logs.
+	Name string `json:"name,omitempty"`
+	// CEL expressions which the traffic must all match for the actions to
+	// be taken. The rule applies to all traffic when there are none.
+	Expressions []string `json:"expressions,omitempty"`
+	// The actions to take, in order.
+	Actions []PolicyAction `json:"actions"`
+}
+
+// PolicyAction is an action taken by a [PolicyRule].
+type PolicyAction struct {
+	// The type of the action, such as "rate-limit".
+	Type string `json:"type"`
+	// The action's configuration, which depends on its type.
+	Config map[string]any `json:"config,omitempty"`
+}
+
+// PolicyActionOf returns an action of any type, for those that don't have a
+// constructor of their own.
+func PolicyActionOf(actionType string, config map[string]any) PolicyAction {
+	return PolicyAction{Type: actionType, Config: config}
+}
+
+// RateLimitAction rejects requests beyond capacity in each window of the
+// given duration, counted separately for each value of the bucket keys, which
+// are CEL expressions such as "conn.client_ip". All requests share a bucket
+// when no keys are given.
+func RateLimitAction(capacity int, window time.Duration, bucketKeys ...string) PolicyAction {
+	config := map[string]any{
+		"algorithm": "sliding_window",
+		"capacity":  capacity,
+		"rate":      fmt.Sprintf("%gs", window.Seconds()),
+	}
+	if len(bucketKeys) > 0 {
+		config["bucket_key"] = bucketKeys
+	}
+	return PolicyAction{Type: "rate-limit", Config: config}
+}
+
+// AddHeadersAction adds the headers to the request or response, depending on
+// the phase of its rule.
+func AddHeadersAction(headers map[string]string) PolicyAction {
+	return PolicyAction{Type: "add-headers", Config: map[string]any{"headers": headers}}
+}
+
+// RemoveHeadersAction removes the named headers from the request or response,
+// depending on the phase of its rule.
+func RemoveHeadersAction(names ...string) PolicyAction {
+	return PolicyAction{Type: "remove-headers", Config: map[string]any{"headers": names}}
+}
+
+// RedirectAction redirects requests whose URL matches the regular expression
+// from, to the URL to, which may refer to its capture groups, with the status
+// code. The edge chooses the status code if it's 0.
+func RedirectAction(from, to string, statusCode int) PolicyAction {
+	config := map[string]any{"from": from, "to": to}
+	if statusCode != 0 {
+		config["status_code"] = statusCode
+	}
+	return PolicyAction{Type: "redirect", Config: config}
+}
+
+// DenyAction rejects requests with the status code, or connections, depending
+// on the phase of its rule. The edge chooses the status code if it's 0.
+func DenyAction(statusCode int) PolicyAction {
+	var config map[string]any
+	if statusCode != 0 {
+		config = map[string]any{"status_code": statusCode}
+	}
+	return PolicyAction{Type: "deny", Config: config}
+}
+
+// JWTValidationAction rejects requests that don't carry a JWT bearer token in
+// their Authorization header, signed by a key from the JSON Web Key Set at
+// jwksURL, and issued by the issuer for the audience.
+func JWTValidationAction(issuer, audience, jwksURL string) PolicyAction {
+	return PolicyAction{Type: "jwt-validation", Config: map[string]any{
+		"issuer": map[string]any{
+			"allow_list": []map[string]any{{"value": issuer}},
+		},
+		"audience": map[string]any{
+			"allow_list": []map[string]any{{"value": audience}},
+		},
+		"http": map[string]any{
+			"tokens": []map[string]any{{
+				"type":   "jwt",
+				"method": "header",
+				"name":   "Authorization",
+				"prefix": "Bearer ",
+			}},
+		},
+		"jws": map[string]any{
+			"allowed_algorithms": []string{"RS256", "ES256"},
+			"keys": map[string]any{
+				"sources": map[string]any{
+					"additional_jkus": []string{jwksURL},
+				},
+			},
+		},
+	}}
+}
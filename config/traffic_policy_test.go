@@ -0,0 +1,120 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+func testTrafficPolicy[T tunnelConfigPrivate, O any, OT any](t *testing.T,
+	makeOpts func(...OT) Tunnel,
+	getTrafficPolicy func(*O) string,
+) {
+	optsFunc := func(opts ...any) Tunnel {
+		return makeOpts(assertSlice[OT](opts)...)
+	}
+
+	cases := testCases[T, O]{
+		{
+			name: "absent",
+			opts: optsFunc(),
+			expectOpts: func(t *testing.T, opts *O) {
+				require.Empty(t, getTrafficPolicy(opts))
+			},
+		},
+		{
+			name: "json",
+			opts: optsFunc(WithTrafficPolicy(`{"on_http_request":[{"actions":[{"type":"deny"}]}]}`)),
+			expectOpts: func(t *testing.T, opts *O) {
+				require.JSONEq(t, `{"on_http_request":[{"actions":[{"type":"deny"}]}]}`, getTrafficPolicy(opts))
+			},
+		},
+		{
+			name: "yaml",
+			opts: optsFunc(WithTrafficPolicy("on_http_request:\n  - actions:\n      - type: deny\n")),
+			expectOpts: func(t *testing.T, opts *O) {
+				require.JSONEq(t, `{"on_http_request":[{"actions":[{"type":"deny"}]}]}`, getTrafficPolicy(opts))
+			},
+		},
+	}
+
+	cases.runAll(t)
+
+	for _, invalid := range []string{"[1, 2]", "key: [unterminated", "plain text"} {
+		policyCfg := optsFunc(WithTrafficPolicy(invalid)).(interface {
+			ValidateTrafficPolicy() error
+		})
+		require.Error(t, policyCfg.ValidateTrafficPolicy(), invalid)
+	}
+	require.NoError(t, optsFunc().(interface {
+		ValidateTrafficPolicy() error
+	}).ValidateTrafficPolicy())
+}
+
+func TestTrafficPolicy(t *testing.T) {
+	testTrafficPolicy[httpOptions](t, HTTPEndpoint, func(opts *proto.HTTPEndpoint) string {
+		return opts.TrafficPolicy
+	})
+	testTrafficPolicy[tlsOptions](t, TLSEndpoint, func(opts *proto.TLSEndpoint) string {
+		return opts.TrafficPolicy
+	})
+	testTrafficPolicy[tcpOptions](t, TCPEndpoint, func(opts *proto.TCPEndpoint) string {
+		return opts.TrafficPolicy
+	})
+}
+
+func TestTrafficPolicyRules(t *testing.T) {
+	policy := TrafficPolicy{
+		OnHTTPRequest: []PolicyRule{
+			{
+				Name:    "limit",
+				Actions: []PolicyAction{RateLimitAction(100, time.Minute, "conn.client_ip")},
+			},
+			{
+				Expressions: []string{"req.url.path.startsWith('/old')"},
+				Actions:     []PolicyAction{RedirectAction("/old/(.*)", "/new/$1", 301)},
+			},
+		},
+		OnHTTPResponse: []PolicyRule{{
+			Actions: []PolicyAction{
+				AddHeadersAction(map[string]string{"x-served-by": "ngrok"}),
+				RemoveHeadersAction("server"),
+			},
+		}},
+		OnTCPConnect: []PolicyRule{{
+			Actions: []PolicyAction{DenyAction(0)},
+		}},
+	}
+
+	opts := HTTPEndpoint(WithTrafficPolicyRules(policy)).(httpOptions)
+	require.JSONEq(t, `{
+		"on_http_request": [
+			{
+				"name": "limit",
+				"actions": [{"type": "rate-limit", "config": {
+					"algorithm": "sliding_window", "capacity": 100, "rate": "60s", "bucket_key": ["conn.client_ip"]
+				}}]
+			},
+			{
+				"expressions": ["req.url.path.startsWith('/old')"],
+				"actions": [{"type": "redirect", "config": {"from": "/old/(.*)", "to": "/new/$1", "status_code": 301}}]
+			}
+		],
+		"on_http_response": [
+			{"actions": [
+				{"type": "add-headers", "config": {"headers": {"x-served-by": "ngrok"}}},
+				{"type": "remove-headers", "config": {"headers": ["server"]}}
+			]}
+		],
+		"on_tcp_connect": [
+			{"actions": [{"type": "deny"}]}
+		]
+	}`, opts.toProtoConfig().TrafficPolicy)
+
+	jwt := JWTValidationAction("https://issuer.example.com", "api", "https://issuer.example.com/.well-known/jwks.json")
+	require.Equal(t, "jwt-validation", jwt.Type)
+	require.Equal(t, PolicyAction{Type: "custom", Config: map[string]any{"a": 1}}, PolicyActionOf("custom", map[string]any{"a": 1}))
+}
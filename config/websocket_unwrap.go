@@ -0,0 +1,43 @@
+package config
+
+// WithWebsocketUnwrapping makes the SDK accept every connection from the tunnel
+// as a WebSocket, so that a raw TCP service can be reached by browser WebSocket
+// clients. It's the counterpart to [WithWebsocketTCPConversion], which does
+// the same at the ngrok edge: the SDK answers the client's WebSocket
+// handshake, and the connection returned from Accept, or forwarded, carries
+// the payloads of the client's messages, without their framing. Writes to the
+// connection are sent to the client as binary messages.
+//
+// Connections that don't open with a WebSocket handshake are answered with
+// 400 Bad Request and rejected. The handshake is read while accepting the
+// connection, so a client that's slow to send one holds up the connections
+// behind it, for up to the timeout set by WithHandshakeTimeout, or 10 seconds
+// without one. Combine this with WithAcceptConcurrency to accept several
+// connections at once.
+func WithWebsocketUnwrapping() interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	LabeledTunnelOption
+} {
+	return websocketUnwrapOption{}
+}
+
+type websocketUnwrapOption struct{}
+
+func (websocketUnwrapOption) ApplyHTTP(cfg *httpOptions) {
+	cfg.WebsocketUnwrap = true
+}
+
+func (websocketUnwrapOption) ApplyTCP(cfg *tcpOptions) {
+	cfg.WebsocketUnwrap = true
+}
+
+func (websocketUnwrapOption) ApplyLabeled(cfg *labeledOptions) {
+	cfg.WebsocketUnwrap = true
+}
+
+// UnwrapsWebsockets returns whether the SDK answers the WebSocket handshake of
+// each connection accepted from the tunnel, and removes its framing.
+func (cfg commonOpts) UnwrapsWebsockets() bool {
+	return cfg.WebsocketUnwrap
+}
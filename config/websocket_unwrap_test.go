@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+)
+
+type unwrapsWebsocketsGetter interface {
+	UnwrapsWebsockets() bool
+}
+
+func testWebsocketUnwrapping[T tunnelConfigPrivate, OT any](t *testing.T,
+	makeOpts func(...OT) Tunnel,
+) {
+	optsFunc := func(opts ...any) Tunnel {
+		return makeOpts(assertSlice[OT](opts)...)
+	}
+
+	cases := testCases[T, any]{
+		{
+			name:         "absent",
+			opts:         optsFunc(),
+			expectGetter: getterReturns(unwrapsWebsocketsGetter.UnwrapsWebsockets, false),
+		},
+		{
+			name:         "enabled",
+			opts:         optsFunc(WithWebsocketUnwrapping()),
+			expectGetter: getterReturns(unwrapsWebsocketsGetter.UnwrapsWebsockets, true),
+		},
+	}
+
+	cases.runAll(t)
+}
+
+func TestWebsocketUnwrapping(t *testing.T) {
+	testWebsocketUnwrapping[httpOptions](t, HTTPEndpoint)
+	testWebsocketUnwrapping[tcpOptions](t, TCPEndpoint)
+	testWebsocketUnwrapping[labeledOptions](t, LabeledTunnel)
+}
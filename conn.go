@@ -0,0 +1,135 @@
+package ngrok
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"net/netip"
+)
+
+// Conn is implemented by the connections accepted from a [Tunnel], which are
+// returned as a [net.Conn] so that a Tunnel can be used as a [net.Listener].
+// Type-assert them to Conn to reach what the ngrok edge and the SDK know
+// about each connection. HTTP handlers passed to [Serve] and friends can use
+// [ConnFromContext] instead:
+//
+//	if conn, ok := ngrok.ConnFromContext(req.Context()); ok {
+//		clientIP := conn.EdgeProxyInfo().ClientIP
+//	}
+type Conn interface {
+	net.Conn
+
+	// EdgeProxyInfo returns what the ngrok edge reported about the
+	// connection, such as the address of the client it came from.
+	EdgeProxyInfo() EdgeProxyInfo
+	// EdgeRoute returns how an edge routed the connection to a labeled
+	// tunnel.
+	EdgeRoute() EdgeRouteInfo
+	// RawProxyHeader returns a copy of the header that the edge sent ahead
+	// of the connection's payload.
+	RawProxyHeader() []byte
+	// Geo returns the location of the client, as resolved by the lookup
+	// configured with WithGeoIP.
+	Geo() (GeoInfo, error)
+	// ServerName returns the server name that the client requested via
+	// SNI, as checked for config.WithRequiredSNI.
+	ServerName() string
+	// PeerCertificates returns the certificates presented by the client
+	// when ServeTLS terminated TLS for the connection.
+	PeerCertificates() []*x509.Certificate
+	// FirstRequestHost returns the Host of the first HTTP request served
+	// over the connection.
+	FirstRequestHost() string
+	// ConnID returns an identifier for the connection, unique among those
+	// that arrived at the same Tunnel.
+	ConnID() uint64
+	// Context returns the context created for the connection by the
+	// session's Tracer.
+	Context() context.Context
+	// Annotate attaches an application-level key/value pair to the
+	// connection.
+	Annotate(key, value string)
+	// Annotations returns a copy of the annotations added with Annotate.
+	Annotations() map[string]string
+	// Touch marks the connection as active, postponing its closure by
+	// config.WithConnIdleTimeout.
+	Touch()
+	// Flush sends any writes buffered due to config.WithConnWriteBuffer.
+	Flush() error
+	// PendingWrites returns the number of bytes written to the connection
+	// that haven't yet been sent.
+	PendingWrites() int64
+	// CloseWrite shuts down the writing side of the connection.
+	CloseWrite() error
+}
+
+var _ Conn = (*connImpl)(nil)
+
+// ConnFromContext returns the connection that an HTTP request arrived over,
+// from the request's context, for requests served by [Serve], [ServeTLS], and
+// the other functions of this package that serve HTTP from a [Tunnel].
+func ConnFromContext(ctx context.Context) (Conn, bool) {
+	conn, ok := ctx.Value(tunnelConnKey{}).(*connImpl)
+	if !ok {
+		return nil, false
+	}
+	return conn, true
+}
+
+// EdgeProxyInfo describes a connection accepted from a [Tunnel], as returned
+// by [Conn].EdgeProxyInfo. It's everything that [ParseProxyHeader] reads from
+// the header that the ngrok edge sends ahead of the connection, along with
+// what the SDK learned about it since.
+type EdgeProxyInfo struct {
+	ProxyInfo
+	// The region of the ngrok edge that the Session is connected to, and so
+	// which the connection arrived through. Empty if the Tunnel isn't from
+	// a Session.
+	Region string
+	// Whether the edge terminated TLS for the connection, leaving it
+	// decrypted by the time it arrives, as for HTTPS and TLS endpoints that
+	// don't pass it through.
+	EdgeTerminatedTLS bool
+	// The application protocol negotiated via ALPN when [ServeTLS]
+	// terminated TLS for the connection. Empty otherwise, or if the client
+	// didn't offer any.
+	NegotiatedProtocol string
+}
+
+// EdgeProxyInfo returns what the ngrok edge reported about the connection, so
+// that handlers can act on the client's real address and how it connected
+// without parsing the proxy header themselves. The ServerName is only known
+// for tunnels with config.WithRequiredSNI, which reads it from the
+// ClientHello.
+func (c *connImpl) EdgeProxyInfo() EdgeProxyInfo {
+	var info EdgeProxyInfo
+	if c.Proxy != nil {
+		header := c.Proxy.Header
+		info.ProxyInfo = ProxyInfo{
+			BindID:         header.ID,
+			ClientAddr:     header.ClientAddr,
+			Proto:          header.Proto,
+			EdgeType:       header.EdgeType,
+			PassthroughTLS: header.PassthroughTLS,
+		}
+		if addrPort, err := netip.ParseAddrPort(header.ClientAddr); err == nil {
+			info.ClientIP = addrPort.Addr()
+		}
+		// The raw header is framed by a 64-bit length; see RawProxyHeader.
+		if raw := c.Proxy.RawHeader; len(raw) > 8 {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(raw[8:], &fields); err == nil {
+				info.Extra = extraProxyHeaderFields(fields)
+			}
+		}
+	}
+	info.ServerName = c.serverName
+	info.EdgeTerminatedTLS = !info.PassthroughTLS && (info.Proto == "https" || info.Proto == "tls")
+	info.NegotiatedProtocol, _ = c.negotiatedProto.Load().(string)
+
+	if c.Tun != nil {
+		info.Region = c.Tun.Session().Region()
+	}
+	return info
+}
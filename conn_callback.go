@@ -0,0 +1,63 @@
+package ngrok
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+// How long a rejected connection may take to receive its response before it's
+// closed regardless.
+const rejectWriteTimeout = 5 * time.Second
+
+// Closes a connection that was rejected before being accepted, such as by the
+// callback configured with config.WithConnectionCallback, in the way suited to
+// its protocol: HTTP clients are sent a response with the status, and the rest
+// are reset.
+func rejectConn(conn *tunnel_client.ProxyConn, status int) {
+	respondConn(conn, status, nil, nil)
+}
+
+// Like rejectConn, but sends HTTP clients the headers and body, which default
+// to plain text with the status text.
+func respondConn(conn *tunnel_client.ProxyConn, status int, header http.Header, body []byte) {
+	switch conn.Header.Proto {
+	case "http", "https":
+		writeResponse(conn.Conn, status, header, body)
+	default:
+		if linger, ok := conn.Conn.(interface{ SetLinger(int) error }); ok {
+			_ = linger.SetLinger(0)
+		}
+	}
+	_ = conn.Conn.Close()
+}
+
+// Writes an HTTP response with the status, headers, and body to a connection
+// that's about to be closed, giving up after rejectWriteTimeout. The body
+// defaults to plain text with the status text.
+func writeResponse(conn net.Conn, status int, header http.Header, body []byte) {
+	if header == nil {
+		header = http.Header{}
+	}
+	if body == nil {
+		body = []byte(http.StatusText(status) + "\n")
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Set("Connection", "close")
+
+	var resp bytes.Buffer
+	resp.WriteString("HTTP/1.1 " + strconv.Itoa(status) + " " + http.StatusText(status) + "\r\n")
+	_ = header.Write(&resp)
+	resp.WriteString("\r\n")
+	resp.Write(body)
+
+	_ = conn.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+	_, _ = conn.Write(resp.Bytes())
+}
@@ -0,0 +1,118 @@
+package ngrok
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+func TestConnectionCallback(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.Tunnel.(*fakeClientTunnel).header.Proto = "tcp"
+
+	errBlocked := errors.New("blocked")
+	infos := make(chan config.ConnInfo, 2)
+	allow := make(chan bool, 2)
+	impl.connCallback = func(ctx context.Context, info config.ConnInfo) error {
+		infos <- info
+		if <-allow {
+			return nil
+		}
+		return errBlocked
+	}
+	events := make(chan AcceptEvent, 2)
+	tun.OnAccept(func(event AcceptEvent) {
+		events <- event
+	})
+
+	allow <- false
+	allow <- true
+	rejected, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer rejected.Close()
+	accepted, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer accepted.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	info := <-infos
+	require.Equal(t, config.ConnInfo{TunnelID: "fake", ClientAddr: "127.0.0.1:1234", Proto: "tcp"}, info)
+	require.ErrorIs(t, (<-events).Rejected, errBlocked)
+	require.NoError(t, (<-events).Rejected)
+
+	// Rejected TCP connections are reset.
+	require.NoError(t, rejected.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = rejected.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, io.EOF)
+}
+
+func TestConnectionCallbackHTTP(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.Tunnel.(*fakeClientTunnel).header.Proto = "https"
+	impl.connCallback = func(ctx context.Context, info config.ConnInfo) error {
+		return errors.New("rate limited")
+	}
+	go func() {
+		_, _ = tun.Accept()
+	}()
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.SetDeadline(time.Now().Add(5*time.Second)))
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	require.NoError(t, err)
+	require.NoError(t, req.Write(client))
+	resp, err := http.ReadResponse(bufio.NewReader(client), req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestConnectionCallbackPanic(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	panics := make(chan bool, 2)
+	impl.connCallback = func(ctx context.Context, info config.ConnInfo) error {
+		if <-panics {
+			panic("oops")
+		}
+		return nil
+	}
+	events := make(chan AcceptEvent, 2)
+	tun.OnAccept(func(event AcceptEvent) {
+		events <- event
+	})
+
+	panics <- true
+	panics <- false
+	rejected, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer rejected.Close()
+	accepted, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer accepted.Close()
+
+	// A panic rejects the connection, like returning an error does.
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	require.ErrorIs(t, (<-events).Rejected, errCallbackPanic{})
+	require.NoError(t, (<-events).Rejected)
+}
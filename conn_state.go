@@ -0,0 +1,100 @@
+package ngrok
+
+import (
+	"context"
+	"sync"
+)
+
+// ConnState describes whether a [Tunnel]'s [Session] is currently connected to
+// the ngrok service, as returned by [Tunnel].ConnectionState.
+type ConnState int
+
+const (
+	// The session is connected, and the tunnel can receive connections.
+	ConnStateConnected ConnState = iota
+	// The session lost its connection and is reconnecting. The tunnel stays
+	// open, and receives connections again once reconnected.
+	ConnStateReconnecting
+	// The tunnel or its session has been closed, and won't reconnect.
+	ConnStateClosed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnStateConnected:
+		return "connected"
+	case ConnStateReconnecting:
+		return "reconnecting"
+	default:
+		return "closed"
+	}
+}
+
+// Tracks a session's ConnState as its reconnect loop progresses. The zero
+// value is connected.
+type sessionState struct {
+	mu    sync.Mutex
+	state ConnState
+	// Closed and replaced each time the state changes. Created lazily.
+	changed chan struct{}
+}
+
+func (s *sessionState) get() (ConnState, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+	return s.state, s.changed
+}
+
+func (s *sessionState) set(state ConnState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Closed sessions never come back.
+	if s.state == state || s.state == ConnStateClosed {
+		return
+	}
+	s.state = state
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+}
+
+func (t *tunnelImpl) ConnectionState() ConnState {
+	state, _ := t.connState()
+	return state
+}
+
+// Returns the tunnel's ConnState, along with a channel that's closed when it
+// may have changed.
+func (t *tunnelImpl) connState() (ConnState, <-chan struct{}) {
+	select {
+	case <-t.Done():
+		return ConnStateClosed, nil
+	default:
+	}
+	if sess, ok := t.Sess.(*sessionImpl); ok {
+		return sess.state.get()
+	}
+	return ConnStateConnected, nil
+}
+
+func (t *tunnelImpl) WaitConnected(ctx context.Context) error {
+	for {
+		state, changed := t.connState()
+		switch state {
+		case ConnStateConnected:
+			return nil
+		case ConnStateClosed:
+			return ErrTunnelClosed
+		}
+		select {
+		case <-changed:
+		case <-t.Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
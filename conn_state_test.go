@@ -0,0 +1,51 @@
+package ngrok
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionState(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+	sess := &sessionImpl{}
+	tun.(*tunnelImpl).Sess = sess
+
+	require.Equal(t, ConnStateConnected, tun.ConnectionState())
+	require.NoError(t, tun.WaitConnected(context.Background()))
+
+	sess.state.set(ConnStateReconnecting)
+	require.Equal(t, ConnStateReconnecting, tun.ConnectionState())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, tun.WaitConnected(ctx), context.DeadlineExceeded)
+
+	waited := make(chan error)
+	go func() {
+		waited <- tun.WaitConnected(context.Background())
+	}()
+	time.Sleep(10 * time.Millisecond)
+	sess.state.set(ConnStateConnected)
+	require.NoError(t, <-waited, "WaitConnected returns once reconnected")
+	require.Equal(t, ConnStateConnected, tun.ConnectionState())
+
+	sess.state.set(ConnStateReconnecting)
+	go func() {
+		waited <- tun.WaitConnected(context.Background())
+	}()
+	require.NoError(t, tun.Close())
+	require.ErrorIs(t, <-waited, ErrTunnelClosed)
+	require.Equal(t, ConnStateClosed, tun.ConnectionState())
+}
+
+func TestSessionStateClosed(t *testing.T) {
+	var state sessionState
+	state.set(ConnStateClosed)
+	state.set(ConnStateConnected)
+	current, _ := state.get()
+	require.Equal(t, ConnStateClosed, current, "closed sessions stay closed")
+	require.Equal(t, "closed", current.String())
+}
@@ -0,0 +1,113 @@
+package ngrok
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+// A Session that reports the region it's connected to.
+type regionSession struct {
+	noSession
+}
+
+func (regionSession) Region() string {
+	return "eu"
+}
+
+func TestEdgeProxyInfo(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.Sess = regionSession{}
+	fake := impl.Tunnel.(*fakeClientTunnel)
+	fake.header = proto.ProxyHeader{
+		ID:         "bind_123",
+		ClientAddr: "203.0.113.5:4444",
+		Proto:      "https",
+		EdgeType:   "https",
+	}
+	fake.rawHeader = proxyHeader(`{"Id":"bind_123","ClientAddr":"203.0.113.5:4444","Proto":"https","EdgeType":"https","RouteId":"edghtsrt_456"}`)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	accepted, err := tun.Accept()
+	require.NoError(t, err)
+	defer accepted.Close()
+
+	conn, ok := accepted.(Conn)
+	require.True(t, ok, "accepted connections implement Conn")
+	require.Equal(t, EdgeProxyInfo{
+		ProxyInfo: ProxyInfo{
+			BindID:     "bind_123",
+			ClientAddr: "203.0.113.5:4444",
+			ClientIP:   netip.MustParseAddr("203.0.113.5"),
+			Proto:      "https",
+			EdgeType:   "https",
+			Extra:      map[string]json.RawMessage{"RouteId": json.RawMessage(`"edghtsrt_456"`)},
+		},
+		Region:            "eu",
+		EdgeTerminatedTLS: true,
+	}, conn.EdgeProxyInfo())
+
+	fake.header.PassthroughTLS = true
+	fake.rawHeader = nil
+	passthrough, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer passthrough.Close()
+	accepted, err = tun.Accept()
+	require.NoError(t, err)
+	defer accepted.Close()
+
+	info := accepted.(Conn).EdgeProxyInfo()
+	require.True(t, info.PassthroughTLS)
+	require.False(t, info.EdgeTerminatedTLS)
+	require.Nil(t, info.Extra)
+}
+
+func TestConnFromContext(t *testing.T) {
+	_, ok := ConnFromContext(context.Background())
+	require.False(t, ok)
+
+	tun, addr := fakeTunnel(t)
+	infos := make(chan EdgeProxyInfo, 1)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var info EdgeProxyInfo
+		if conn, ok := ConnFromContext(req.Context()); ok {
+			info = conn.EdgeProxyInfo()
+		}
+		infos <- info
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = ServeTLS(ctx, tun, handler, WithTLSCertificates(testCertificate(t, "example.com")))
+	}()
+
+	client, err := tls.Dial("tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"http/1.1"},
+	})
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = io.WriteString(client, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	info := <-infos
+	require.Equal(t, "http/1.1", info.NegotiatedProtocol)
+	require.Equal(t, "127.0.0.1:1234", info.ClientAddr)
+}
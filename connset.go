@@ -0,0 +1,144 @@
+package ngrok
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// ConnSet is the live set of connections accepted from a [Tunnel] that haven't
+// yet been closed, as returned by [Tunnel].ConnSet. It's maintained by the
+// Tunnel itself, so that servers which broadcast to, or enumerate, their
+// clients don't need a registry of their own. It's safe for concurrent use.
+type ConnSet struct {
+	mu    sync.Mutex
+	conns map[*connImpl]struct{}
+	// Closed whenever the set becomes empty.
+	drained chan struct{}
+	subs    map[chan ConnEvent]struct{}
+}
+
+// ConnEvent describes a connection being added to or removed from a
+// [ConnSet].
+type ConnEvent struct {
+	// The connection that was accepted or closed.
+	Conn net.Conn
+	// True if the connection was accepted, false if it was closed.
+	Opened bool
+}
+
+// Len returns the number of open connections.
+func (s *ConnSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Conns returns a snapshot of the open connections, in no particular order.
+func (s *ConnSet) Conns() []net.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := make([]net.Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Like Conns, but without converting the connections.
+func (s *ConnSet) snapshot() []*connImpl {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns := make([]*connImpl, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// Range calls fn for each open connection until it returns false. It iterates
+// over a snapshot, so fn may safely write to or close the connections.
+func (s *ConnSet) Range(fn func(conn net.Conn) bool) {
+	for _, c := range s.Conns() {
+		if !fn(c) {
+			return
+		}
+	}
+}
+
+// Subscribe returns a channel that receives an event each time a connection is
+// added to or removed from the set, and a function that ends the subscription
+// and closes the channel. The channel has room for buffer events; if the
+// subscriber falls further behind than that, events are dropped rather than
+// holding up the Tunnel.
+func (s *ConnSet) Subscribe(buffer int) (<-chan ConnEvent, func()) {
+	ch := make(chan ConnEvent, buffer)
+
+	s.mu.Lock()
+	if s.subs == nil {
+		s.subs = make(map[chan ConnEvent]struct{})
+	}
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			delete(s.subs, ch)
+			close(ch)
+		})
+	}
+}
+
+func (s *ConnSet) add(c *connImpl) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[*connImpl]struct{})
+	}
+	if len(s.conns) == 0 {
+		s.drained = make(chan struct{})
+	}
+	s.conns[c] = struct{}{}
+	s.notify(ConnEvent{Conn: c, Opened: true})
+}
+
+func (s *ConnSet) remove(c *connImpl) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+	if len(s.conns) == 0 {
+		close(s.drained)
+	}
+	s.notify(ConnEvent{Conn: c})
+}
+
+// Must be called with the lock held.
+func (s *ConnSet) notify(event ConnEvent) {
+	for ch := range s.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Blocks until the set is empty, or the context is done.
+func (s *ConnSet) wait(ctx context.Context) error {
+	s.mu.Lock()
+	if len(s.conns) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	drained := s.drained
+	s.mu.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,62 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnSet(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	set := tun.ConnSet()
+	require.Zero(t, set.Len())
+
+	events, unsubscribe := set.Subscribe(4)
+
+	var clients, conns []net.Conn
+	for i := 0; i < 2; i++ {
+		client, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer client.Close()
+		clients = append(clients, client)
+
+		conn, err := tun.Accept()
+		require.NoError(t, err)
+		conns = append(conns, conn)
+
+		event := <-events
+		require.True(t, event.Opened)
+		require.Equal(t, conn, event.Conn)
+	}
+	require.Equal(t, 2, set.Len())
+	require.ElementsMatch(t, conns, set.Conns())
+
+	// Broadcast to everyone.
+	set.Range(func(conn net.Conn) bool {
+		_, err := io.WriteString(conn, "hi")
+		require.NoError(t, err)
+		return true
+	})
+	for _, client := range clients {
+		buf := make([]byte, 2)
+		_, err := io.ReadFull(client, buf)
+		require.NoError(t, err)
+		require.Equal(t, "hi", string(buf))
+	}
+
+	require.NoError(t, conns[0].Close())
+	event := <-events
+	require.False(t, event.Opened)
+	require.Equal(t, conns[0], event.Conn)
+	require.Equal(t, []net.Conn{conns[1]}, set.Conns())
+
+	unsubscribe()
+	_, ok := <-events
+	require.False(t, ok, "unsubscribing closes the channel")
+	unsubscribe()
+
+	require.NoError(t, conns[1].Close(), "closing after unsubscribing doesn't notify")
+	require.Zero(t, set.Len())
+}
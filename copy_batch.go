@@ -0,0 +1,78 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+)
+
+// Copies from src to dst like copyConn, but reads on a separate goroutine, so
+// that reading continues while a write is in progress. The chunks that queue
+// up meanwhile, up to depth of them, are then sent together with a single
+// vectored write, using writev for the destinations that support it, such as
+// TCP and Unix connections. This saves system calls when dst is slower than
+// src, as with bulk transfers to a busy upstream.
+//
+// If writing fails, the reading goroutine exits once its current read
+// returns, which it does when src is closed.
+func batchCopyConn(dst io.Writer, src io.Reader, buffers *bufferPool, depth int) (int64, error) {
+	if depth < 1 {
+		depth = 1
+	}
+	chunks := make(chan []byte, depth)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		defer close(chunks)
+		for {
+			buf := buffers.Get()
+			n, err := src.Read(buf)
+			if n > 0 {
+				select {
+				case chunks <- buf[:n]:
+				case <-stop:
+					buffers.Put(buf)
+					return
+				}
+			} else {
+				buffers.Put(buf)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var (
+		written int64
+		batch   = make([][]byte, 0, depth)
+	)
+	for chunk := range chunks {
+		batch = append(batch[:0], chunk)
+	gather:
+		for len(batch) < depth {
+			select {
+			case next, ok := <-chunks:
+				if !ok {
+					break gather
+				}
+				batch = append(batch, next)
+			default:
+				break gather
+			}
+		}
+
+		// WriteTo consumes the buffers it's given, so they're returned to
+		// the pool from the batch instead.
+		bufs := net.Buffers(append([][]byte(nil), batch...))
+		n, err := bufs.WriteTo(dst)
+		written += n
+		for _, chunk := range batch {
+			buffers.Put(chunk)
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,50 @@
+package ngrok
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A writer that takes a while with each write, so that reads queue up.
+type slowWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestBatchCopyConn(t *testing.T) {
+	payload := strings.Repeat("0123456789", 1000)
+	var dst slowWriter
+	n, err := batchCopyConn(&dst, strings.NewReader(payload), copyBufferPool(100), 4)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(payload)), n)
+	require.Equal(t, payload, dst.String())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestBatchCopyConnWriteError(t *testing.T) {
+	src, srcW := io.Pipe()
+	defer src.Close()
+	go func() {
+		_, _ = srcW.Write([]byte("ping"))
+	}()
+
+	_, err := batchCopyConn(failingWriter{}, src, copyBufferPool(0), 4)
+	require.EqualError(t, err, "write failed")
+}
@@ -1,8 +1,14 @@
 package ngrok
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
 )
 
 // Errors arising from authentication failure.
@@ -31,16 +37,48 @@ func (e errAuthFailed) Unwrap() error {
 
 func (e errAuthFailed) Is(target error) bool {
 	_, ok := target.(errAuthFailed)
-	return ok
+	return ok || (e.Remote && target == ErrAuthFailed)
+}
+
+// ErrAuthExpired is matched by the errors passed to the
+// [WithDisconnectHandler] callback when the ngrok service rejects the
+// [Session]'s authtoken while reconnecting, such as after it has expired or
+// been revoked. Unless [WithTokenRefresh] supplies a new one, the Session then
+// gives up, and the errors that its tunnels' Accept fails with match it too.
+var ErrAuthExpired = errors.New("authentication expired")
+
+// Errors arising from the ngrok service rejecting the credentials of a session
+// that had previously authenticated.
+type errAuthExpired struct {
+	// The underlying authentication failure.
+	Inner error
+}
+
+func (e errAuthExpired) Error() string {
+	return fmt.Sprintf("session credentials are no longer valid: %v", e.Inner)
+}
+
+func (e errAuthExpired) Unwrap() error {
+	return e.Inner
+}
+
+func (e errAuthExpired) Is(target error) bool {
+	_, ok := target.(errAuthExpired)
+	return ok || target == ErrAuthExpired
 }
 
 // The error returned by [Tunnel]'s [net.Listener.Accept] method.
 type errAcceptFailed struct {
 	// The underlying error.
 	Inner error
+	// Why the tunnel's session ended, if it has.
+	Reason error
 }
 
 func (e errAcceptFailed) Error() string {
+	if e.Reason != nil {
+		return fmt.Sprintf("failed to accept connection: %v: %v", e.Reason, e.Inner)
+	}
 	return fmt.Sprintf("failed to accept connection: %v", e.Inner)
 }
 
@@ -49,8 +87,50 @@ func (e errAcceptFailed) Unwrap() error {
 }
 
 func (e errAcceptFailed) Is(target error) bool {
-	_, ok := target.(errAcceptFailed)
-	return ok
+	if _, ok := target.(errAcceptFailed); ok {
+		return true
+	}
+	return e.Reason != nil && errors.Is(e.Reason, target)
+}
+
+// The reasons that a [Session] may end, which are matched by the error that
+// its tunnels terminate with. See [Tunnel].Err.
+var (
+	// ErrSessionClosed is matched once the Session has been closed by the
+	// application, with Close, CloseWithContext, or Shutdown, or by its idle
+	// timeout.
+	ErrSessionClosed = errors.New("session closed")
+	// ErrStoppedByService is matched once the ngrok service has told the
+	// Session to stop or restart, e.g. from the dashboard, and its handler
+	// agreed. See WithStopHandler and WithRestartHandler.
+	ErrStoppedByService = errors.New("session stopped by the ngrok service")
+	// ErrSessionLost is matched once the Session has lost its connection to
+	// the ngrok service and given up reconnecting, e.g. because its
+	// ReconnectPolicy ran out of attempts, or its authtoken was rejected
+	// without WithTokenRefresh to replace it. The error also wraps the one
+	// that made it give up.
+	ErrSessionLost = errors.New("session lost")
+)
+
+// Error arising from a session giving up on reconnecting.
+type errSessionLost struct {
+	// The error that the session gave up with.
+	Inner error
+}
+
+func (e errSessionLost) Error() string {
+	return fmt.Sprintf("session lost: %v", e.Inner)
+}
+
+func (e errSessionLost) Unwrap() error {
+	return e.Inner
+}
+
+func (e errSessionLost) Is(target error) bool {
+	if _, ok := target.(errSessionLost); ok {
+		return true
+	}
+	return target == ErrSessionLost
 }
 
 // Errors arising from a failure to start a tunnel.
@@ -72,15 +152,181 @@ func (e errListen) Is(target error) bool {
 	return ok
 }
 
+// Error arising from one of the tunnels passed to [StartTunnels] failing to
+// start.
+type errStartTunnels struct {
+	// The index of the configuration that failed.
+	Index int
+	// The underlying error.
+	Inner error
+}
+
+func (e errStartTunnels) Error() string {
+	return fmt.Sprintf("failed to start tunnel %d of group: %v", e.Index, e.Inner)
+}
+
+func (e errStartTunnels) Unwrap() error {
+	return e.Inner
+}
+
+func (e errStartTunnels) Is(target error) bool {
+	_, ok := target.(errStartTunnels)
+	return ok
+}
+
+// ErrNotLabeled is matched by the error returned by [Tunnel].SetLabels for
+// tunnels that weren't started with a labeled tunnel configuration.
+var ErrNotLabeled = tunnel_client.ErrNotLabeled
+
+// ErrSessionMetadataNotSupported is returned by [Session].SetMetadata. The
+// ngrok service only receives a session's metadata when the session
+// authenticates, and has no way to update it while the session is connected.
+var ErrSessionMetadataNotSupported = errors.New("the ngrok service doesn't support updating the metadata of a connected session")
+
+// Error arising from a failure to replace a tunnel's labels.
+type errSetLabels struct {
+	// The underlying error.
+	Inner error
+}
+
+func (e errSetLabels) Error() string {
+	return fmt.Sprintf("failed to set tunnel labels: %v", e.Inner)
+}
+
+func (e errSetLabels) Unwrap() error {
+	return e.Inner
+}
+
+func (e errSetLabels) Is(target error) bool {
+	_, ok := target.(errSetLabels)
+	return ok
+}
+
+// Error arising from a failure to replace a tunnel's metadata.
+type errSetMetadata struct {
+	// The underlying error.
+	Inner error
+}
+
+func (e errSetMetadata) Error() string {
+	return fmt.Sprintf("failed to set tunnel metadata: %v", e.Inner)
+}
+
+func (e errSetMetadata) Unwrap() error {
+	return e.Inner
+}
+
+func (e errSetMetadata) Is(target error) bool {
+	_, ok := target.(errSetMetadata)
+	return ok
+}
+
+// Error is implemented by the errors that the ngrok service reports with one
+// of its documented error codes, such as when it rejects the Traffic Policy of
+// a tunnel being started. Use [errors.As] to find one in the errors returned
+// by [Connect] and [Session].Listen:
+//
+//	var ngrokErr ngrok.Error
+//	if errors.As(err, &ngrokErr) && ngrokErr.ErrorCode() == "ERR_NGROK_2201" {
+//		...
+//	}
+//
+// The common failures also match one of [ErrAuthFailed], [ErrAccountLimit],
+// or [ErrDomainTaken] with [errors.Is].
+type Error interface {
+	error
+	// ErrorCode returns the ngrok error code, e.g. "ERR_NGROK_2201".
+	ErrorCode() string
+	// Msg returns the error message without the code.
+	Msg() string
+}
+
+// ErrAuthFailed is matched by the errors returned when the ngrok service
+// rejects a [Session]'s credentials, such as an invalid or revoked authtoken.
+// It's not matched when the authentication request couldn't be sent at all.
+var ErrAuthFailed = errors.New("authentication failed")
+
+// ErrAccountLimit is matched by the errors returned when an operation would
+// exceed a limit of the ngrok account, such as its number of simultaneous
+// sessions or endpoints.
+var ErrAccountLimit = errors.New("account limit exceeded")
+
+// ErrDomainTaken is matched by the errors returned by [Session].Listen when
+// the tunnel's domain or address is already in use by another endpoint.
+var ErrDomainTaken = errors.New("domain already in use")
+
+// The sentinels matched by the errors with each code.
+var ngrokErrorSentinels = map[string]error{
+	"ERR_NGROK_105":  ErrAuthFailed,
+	"ERR_NGROK_106":  ErrAuthFailed,
+	"ERR_NGROK_107":  ErrAuthFailed,
+	"ERR_NGROK_4018": ErrAuthFailed,
+	"ERR_NGROK_108":  ErrAccountLimit,
+	"ERR_NGROK_324":  ErrAccountLimit,
+	"ERR_NGROK_334":  ErrDomainTaken,
+}
+
+// The pattern of the error codes in messages from the ngrok service.
+var ngrokErrorCode = regexp.MustCompile(`ERR_NGROK_\d+`)
+
+// An error reported by the ngrok service with an error code.
+type ngrokError struct {
+	// The ngrok error code.
+	Code string
+	// The message, without the code.
+	Message string
+}
+
+func (e ngrokError) Error() string {
+	if e.Message == "" {
+		return e.Code
+	}
+	return fmt.Sprintf("%s (%s)", e.Message, e.Code)
+}
+
+func (e ngrokError) ErrorCode() string {
+	return e.Code
+}
+
+func (e ngrokError) Msg() string {
+	return e.Message
+}
+
+func (e ngrokError) Is(target error) bool {
+	if _, ok := target.(ngrokError); ok {
+		return true
+	}
+	sentinel, ok := ngrokErrorSentinels[e.Code]
+	return ok && target == sentinel
+}
+
+// Converts an error reported by the ngrok service into an [Error] if its
+// message carries an error code, and returns others unchanged.
+func remoteError(err error) error {
+	msg := err.Error()
+	code := ngrokErrorCode.FindString(msg)
+	if code == "" {
+		return err
+	}
+	return ngrokError{
+		Code:    code,
+		Message: strings.TrimSpace(strings.Replace(msg, code, "", 1)),
+	}
+}
+
 // Errors arising from a failure to construct a [golang.org/x/net/proxy.Dialer] from a [url.URL].
 type errProxyInit struct {
-	// The provided proxy URL.
+	// The provided proxy URL. Nil if the proxy named by the environment
+	// couldn't be parsed.
 	URL *url.URL
 	// The underlying error.
 	Inner error
 }
 
 func (e errProxyInit) Error() string {
+	if e.URL == nil {
+		return fmt.Sprintf("failed to construct proxy dialer from the environment: %v", e.Inner)
+	}
 	return fmt.Sprintf("failed to construct proxy dialer from \"%s\": %v", e.URL.String(), e.Inner)
 }
 
@@ -113,3 +359,142 @@ func (e errSessionDial) Is(target error) bool {
 	_, ok := target.(errSessionDial)
 	return ok
 }
+
+// Error arising from a panic in a user-provided callback.
+type errCallbackPanic struct {
+	// The name of the callback that panicked.
+	Callback string
+	// The value that was passed to panic.
+	Value any
+}
+
+func (e errCallbackPanic) Error() string {
+	return fmt.Sprintf("panic in %s callback: %v", e.Callback, e.Value)
+}
+
+func (e errCallbackPanic) Is(target error) bool {
+	_, ok := target.(errCallbackPanic)
+	return ok
+}
+
+// Error reported to the disconnect handler when a session closes itself after
+// being idle.
+type errSessionIdle struct {
+	// The configured idle timeout.
+	Timeout time.Duration
+}
+
+func (e errSessionIdle) Error() string {
+	return fmt.Sprintf("session idle for %v, closing", e.Timeout)
+}
+
+func (e errSessionIdle) Is(target error) bool {
+	_, ok := target.(errSessionIdle)
+	return ok
+}
+
+// Error arising from an invalid or unusable [WithForwardLocalAddr].
+type errForwardLocalAddr struct {
+	// The provided local address.
+	Addr string
+	// The underlying error.
+	Inner error
+}
+
+func (e errForwardLocalAddr) Error() string {
+	return fmt.Sprintf("failed to bind forwarding local address \"%s\": %v", e.Addr, e.Inner)
+}
+
+func (e errForwardLocalAddr) Unwrap() error {
+	return e.Inner
+}
+
+func (e errForwardLocalAddr) Is(target error) bool {
+	_, ok := target.(errForwardLocalAddr)
+	return ok
+}
+
+// Error arising from an upstream passed to [Forward] that can't be dialed.
+type errForwardUpstream struct {
+	// The provided upstream.
+	Upstream string
+	// The underlying error.
+	Inner error
+}
+
+func (e errForwardUpstream) Error() string {
+	return fmt.Sprintf("invalid forwarding upstream \"%s\": %v", e.Upstream, e.Inner)
+}
+
+func (e errForwardUpstream) Unwrap() error {
+	return e.Inner
+}
+
+func (e errForwardUpstream) Is(target error) bool {
+	_, ok := target.(errForwardUpstream)
+	return ok
+}
+
+// The error returned by [Serve], [ServeTLS], and [Forward] when they stop for
+// an expected reason.
+type errServe struct {
+	// Why the server stopped.
+	Result ServeResult
+	// The underlying error.
+	Inner error
+}
+
+func (e errServe) Error() string {
+	return fmt.Sprintf("serve stopped (%s): %v", e.Result, e.Inner)
+}
+
+func (e errServe) Unwrap() error {
+	return e.Inner
+}
+
+func (e errServe) Is(target error) bool {
+	switch target {
+	case ErrServeShutdown:
+		return e.Result == ServeResultShutdown
+	case ErrTunnelClosed:
+		return e.Result == ServeResultTunnelClosed
+	}
+	_, ok := target.(errServe)
+	return ok
+}
+
+// The error returned by [Session].Listen when the session already has as
+// many tunnels open as allowed by [WithMaxTunnels].
+type errTooManyTunnels struct {
+	// The configured limit.
+	Max int
+}
+
+func (e errTooManyTunnels) Error() string {
+	return fmt.Sprintf("failed to start tunnel: session already has the maximum of %d tunnels open", e.Max)
+}
+
+func (e errTooManyTunnels) Is(target error) bool {
+	_, ok := target.(errTooManyTunnels)
+	return ok
+}
+
+// ErrUnexpectedURL is returned by [Session].Listen when the tunnel was
+// configured with config.WithExpectedURL, but the ngrok edge assigned it a
+// different URL. The tunnel is closed before the error is returned. Use
+// [errors.As] to retrieve both URLs.
+type ErrUnexpectedURL struct {
+	// The URL passed to config.WithExpectedURL.
+	Expected string
+	// The URL that the edge assigned to the tunnel.
+	Actual string
+}
+
+func (e ErrUnexpectedURL) Error() string {
+	return fmt.Sprintf("failed to start tunnel: assigned URL %q, but expected %q", e.Actual, e.Expected)
+}
+
+func (e ErrUnexpectedURL) Is(target error) bool {
+	_, ok := target.(ErrUnexpectedURL)
+	return ok
+}
@@ -5,6 +5,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
 )
 
 var testError = errors.New("testing, 1 2 3!")
@@ -28,3 +30,92 @@ func TestErrorWrapping(t *testing.T) {
 
 	require.True(t, downcastAuth.Remote)
 }
+
+func TestAuthError(t *testing.T) {
+	rejected := proto.AuthResp{Error: "The authtoken you specified has been revoked.\n\nERR_NGROK_107"}
+
+	// Failures to send the request are never expiry.
+	err := authError(proto.AuthResp{}, testError, true)
+	require.ErrorIs(t, err, testError)
+	require.NotErrorIs(t, err, ErrAuthExpired)
+
+	// Rejections on the initial connect are misconfiguration.
+	err = authError(rejected, testError, false)
+	require.ErrorIs(t, err, errAuthFailed{})
+	require.NotErrorIs(t, err, ErrAuthExpired)
+
+	// Rejections after having authenticated are expiry.
+	err = authError(rejected, testError, true)
+	require.ErrorIs(t, err, ErrAuthExpired)
+	require.ErrorIs(t, err, errAuthFailed{})
+	require.Contains(t, err.Error(), "has been revoked")
+
+	var failed errAuthFailed
+	require.True(t, errors.As(err, &failed))
+	require.True(t, failed.Remote)
+
+	// Only rejections of the credentials are expiry, not those for other
+	// reasons, or without a code.
+	for _, other := range []string{
+		"Your account is limited to 1 simultaneous ngrok agent session.\n\nERR_NGROK_108",
+		"The authtoken you specified has been revoked.",
+	} {
+		err = authError(proto.AuthResp{Error: other}, nil, true)
+		require.ErrorIs(t, err, errAuthFailed{})
+		require.NotErrorIs(t, err, ErrAuthExpired, other)
+	}
+}
+
+func TestRemoteError(t *testing.T) {
+	err := remoteError(errors.New("invalid traffic policy: unknown action \"nope\"\n\nERR_NGROK_2201"))
+
+	var ngrokErr Error
+	require.True(t, errors.As(err, &ngrokErr))
+	require.Equal(t, "ERR_NGROK_2201", ngrokErr.ErrorCode())
+	require.Equal(t, "invalid traffic policy: unknown action \"nope\"", ngrokErr.Msg())
+	require.Equal(t, "invalid traffic policy: unknown action \"nope\" (ERR_NGROK_2201)", err.Error())
+
+	// Errors without a code are returned as they are.
+	require.Equal(t, testError, remoteError(testError))
+}
+
+func TestErrorSentinels(t *testing.T) {
+	cases := []struct {
+		msg      string
+		sentinel error
+	}{
+		{"The authtoken you specified does not look like a proper ngrok tunnel authtoken.\nERR_NGROK_105", ErrAuthFailed},
+		{"Your account is limited to 1 simultaneous ngrok agent session.\nERR_NGROK_108", ErrAccountLimit},
+		{"The endpoint 'https://example.ngrok.app' is already online.\nERR_NGROK_334", ErrDomainTaken},
+	}
+	sentinels := []error{ErrAuthFailed, ErrAccountLimit, ErrDomainTaken}
+
+	for _, tc := range cases {
+		err := errListen{remoteError(errors.New(tc.msg))}
+		for _, sentinel := range sentinels {
+			if sentinel == tc.sentinel {
+				require.ErrorIs(t, err, sentinel, tc.msg)
+			} else {
+				require.NotErrorIs(t, err, sentinel, tc.msg)
+			}
+		}
+	}
+
+	// Unknown codes are still an Error, but match no sentinel.
+	err := remoteError(errors.New("something went wrong ERR_NGROK_9999"))
+	var ngrokErr Error
+	require.True(t, errors.As(err, &ngrokErr))
+	for _, sentinel := range sentinels {
+		require.NotErrorIs(t, err, sentinel)
+	}
+
+	// Authentication rejections match ErrAuthFailed whatever their code, but
+	// failures to send the request don't.
+	require.ErrorIs(t, authError(proto.AuthResp{Error: "ERR_NGROK_107"}, nil, false), ErrAuthFailed)
+	require.ErrorIs(t, authError(proto.AuthResp{Error: "bad token"}, nil, true), ErrAuthFailed)
+	require.NotErrorIs(t, authError(proto.AuthResp{}, testError, false), ErrAuthFailed)
+
+	err = authError(proto.AuthResp{Error: "The authtoken has been revoked.\n\nERR_NGROK_107"}, nil, false)
+	require.True(t, errors.As(err, &ngrokErr))
+	require.Equal(t, "ERR_NGROK_107", ngrokErr.ErrorCode())
+}
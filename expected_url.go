@@ -0,0 +1,31 @@
+package ngrok
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Reports whether the URL assigned to a tunnel is the one that it was
+// expected to have. If the expected URL doesn't have a scheme, only the hosts
+// are compared.
+func matchesExpectedURL(expected, actual string) bool {
+	if !strings.Contains(expected, "://") {
+		u, err := url.Parse(actual)
+		if err != nil {
+			return false
+		}
+		return strings.EqualFold(strings.TrimSuffix(expected, "/"), u.Host)
+	}
+
+	e, err := url.Parse(expected)
+	if err != nil {
+		return false
+	}
+	a, err := url.Parse(actual)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(e.Scheme, a.Scheme) &&
+		strings.EqualFold(e.Host, a.Host) &&
+		strings.TrimSuffix(e.Path, "/") == strings.TrimSuffix(a.Path, "/")
+}
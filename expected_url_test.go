@@ -0,0 +1,40 @@
+package ngrok
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchesExpectedURL(t *testing.T) {
+	cases := []struct {
+		expected, actual string
+		matches          bool
+	}{
+		{"https://app.example.com", "https://app.example.com", true},
+		{"https://App.Example.com/", "https://app.example.com", true},
+		{"app.example.com", "https://app.example.com", true},
+		{"1.tcp.ngrok.io:12345", "tcp://1.tcp.ngrok.io:12345", true},
+		{"https://app.example.com", "http://app.example.com", false},
+		{"https://app.example.com", "https://3f2a-203-0-113-5.ngrok-free.app", false},
+		{"app.example.com", "https://3f2a-203-0-113-5.ngrok-free.app", false},
+		{"1.tcp.ngrok.io:12345", "tcp://1.tcp.ngrok.io:23456", false},
+	}
+	for _, tc := range cases {
+		require.Equal(t, tc.matches, matchesExpectedURL(tc.expected, tc.actual), "%s vs %s", tc.expected, tc.actual)
+	}
+}
+
+func TestErrUnexpectedURL(t *testing.T) {
+	var err error = ErrUnexpectedURL{
+		Expected: "https://app.example.com",
+		Actual:   "https://3f2a-203-0-113-5.ngrok-free.app",
+	}
+	require.ErrorIs(t, err, ErrUnexpectedURL{})
+
+	var unexpected ErrUnexpectedURL
+	require.True(t, errors.As(err, &unexpected))
+	require.Equal(t, "https://app.example.com", unexpected.Expected)
+	require.Equal(t, "https://3f2a-203-0-113-5.ngrok-free.app", unexpected.Actual)
+}
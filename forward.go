@@ -0,0 +1,460 @@
+package ngrok
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+// ForwardOption customizes how [Forward] connects to its upstream.
+type ForwardOption func(*forwardConfig)
+
+// Options to use when forwarding a [Tunnel] to an upstream address.
+type forwardConfig struct {
+	// The local address that connections to the upstream are made from.
+	// If nil, the operating system chooses one.
+	LocalAddr *net.TCPAddr
+	// The size of the buffers used to copy the data of forwarded
+	// connections.
+	// Defaults to 32KiB when 0.
+	CopyBufferSize int
+	// Called with the errors writing to either side of a forwarded
+	// connection.
+	ErrorHandler func(error)
+	// The version of the PROXY protocol header sent to the upstream ahead of
+	// each connection.
+	// Disabled when config.ProxyProtoNone.
+	ProxyProto config.ProxyProtoVersion
+	// The configuration for connecting to the upstream over TLS.
+	// Plain TCP is used when nil.
+	TLS *tls.Config
+	// Makes the connections to the upstream in place of the default dialer.
+	Dialer ForwardDialer
+	// The most chunks of data that are queued up to be written together in
+	// each direction of a forwarded connection.
+	// Data is written as soon as it's read when 0.
+	WriteBatch int
+	// How the upstream's health is checked while it's forwarded to.
+	// Unchecked when nil.
+	HealthCheck *HealthCheck
+	// Set by an invalid option, and returned by Forward before it starts.
+	Err error
+}
+
+// WithForwardLocalAddr configures [Forward] to connect to its upstream from the
+// provided local IP address, optionally with a port, e.g. "10.0.0.5" or
+// "[fd00::5]:0". This binds the upstream connections to the network interface
+// that the address is assigned to, for multi-homed hosts where the upstream
+// has to be reached over a particular interface.
+//
+// Forward returns an error without accepting any connections if the address
+// is invalid or can't be bound, or if the upstream isn't a TCP address.
+func WithForwardLocalAddr(addr string) ForwardOption {
+	return func(cfg *forwardConfig) {
+		local, err := parseForwardLocalAddr(addr)
+		if err != nil {
+			cfg.Err = errForwardLocalAddr{addr, err}
+			return
+		}
+		cfg.LocalAddr = local
+	}
+}
+
+// WithForwardErrorHandler configures a function which is called with each
+// error that [Forward] encounters connecting to the upstream, or writing to
+// either the client or the upstream, such as when one side resets its
+// connection. Either side closing its connection is the normal end of
+// forwarding, and isn't reported.
+func WithForwardErrorHandler(handler func(error)) ForwardOption {
+	return func(cfg *forwardConfig) {
+		cfg.ErrorHandler = handler
+	}
+}
+
+// WithCopyBufferSize configures the size of the buffers that [Forward] and
+// [ForwardListener] use to copy data between connections, in bytes. Buffers are
+// drawn from a pool shared by every copy of the same size, rather than being
+// allocated for each connection. Small buffers suit many interactive
+// connections, while large ones suit bulk transfers.
+//
+// If unset, 32KiB buffers are used, as they are for [Tunnel].Bridge and
+// [ServeReverseProxy].
+func WithCopyBufferSize(size int) ForwardOption {
+	return func(cfg *forwardConfig) {
+		cfg.CopyBufferSize = size
+	}
+}
+
+// WithWriteBatching configures [Forward] and [Tunnel].Forward to keep reading
+// from each side of a forwarded connection while writing to the other, and to
+// write up to depth of the chunks that have queued up meanwhile at once, as a
+// single vectored write. For TCP and Unix socket upstreams this is a single
+// writev system call, which reduces the overhead of bulk transfers to
+// upstreams that can't keep up with the data arriving. Each chunk is up to
+// the size set by [WithCopyBufferSize].
+//
+// Batching costs a goroutine for each direction of each connection, and only
+// helps when writes are slower than reads, so it's off by default.
+func WithWriteBatching(depth int) ForwardOption {
+	return func(cfg *forwardConfig) {
+		cfg.WriteBatch = depth
+	}
+}
+
+func parseForwardLocalAddr(addr string) (*net.TCPAddr, error) {
+	if ip := net.ParseIP(addr); ip != nil {
+		return &net.TCPAddr{IP: ip}, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("%q is not an IP address", host)
+	}
+	return net.ResolveTCPAddr("tcp", net.JoinHostPort(host, port))
+}
+
+// Checks that the local address can actually be bound, so that a
+// misconfiguration is reported up front rather than on every connection.
+func checkForwardLocalAddr(local *net.TCPAddr) error {
+	probe := *local
+	probe.Port = 0
+	l, err := net.ListenTCP("tcp", &probe)
+	if err != nil {
+		return err
+	}
+	return l.Close()
+}
+
+// Parses the upstream passed to Forward into the network and address to dial.
+// The upstream is either a URL whose scheme names the network, or a bare TCP
+// host and port. Schemes other than those supported by the default dialer are
+// only accepted when the dialer has been replaced.
+func parseForwardUpstream(upstream string, customDialer bool) (string, string, error) {
+	if isNamedPipe(upstream) {
+		return "pipe", upstream, nil
+	}
+	if !strings.Contains(upstream, "://") {
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			return "", "", errForwardUpstream{upstream, err}
+		}
+		return "tcp", upstream, nil
+	}
+
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return "", "", errForwardUpstream{upstream, err}
+	}
+	if u.RawQuery != "" {
+		return "", "", errForwardUpstream{upstream, errors.New("a query may not be given")}
+	}
+	switch u.Scheme {
+	case "tcp":
+		if u.Path != "" {
+			return "", "", errForwardUpstream{upstream, errors.New("only a host and port may be given")}
+		}
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return "", "", errForwardUpstream{upstream, err}
+		}
+		return "tcp", u.Host, nil
+	case "unix":
+		path := u.Host + u.Path
+		if path == "" {
+			return "", "", errForwardUpstream{upstream, errors.New("a socket path must be given")}
+		}
+		return "unix", path, nil
+	case "pipe":
+		name := u.Host + u.Path
+		if name == "" {
+			return "", "", errForwardUpstream{upstream, errors.New("a pipe name must be given")}
+		}
+		return "pipe", `\\.\pipe\` + strings.ReplaceAll(name, "/", `\`), nil
+	}
+	if !customDialer {
+		return "", "", errForwardUpstream{upstream, fmt.Errorf("unsupported scheme %q", u.Scheme)}
+	}
+	return u.Scheme, u.Host + u.Path, nil
+}
+
+// An upstream that connections are forwarded to, as validated by forwarder.
+type forwardUpstream struct {
+	// The upstream as it was passed to Forward.
+	upstream  string
+	cfg       *forwardConfig
+	network   string
+	addr      string
+	dialer    ForwardDialer
+	tlsConfig *tls.Config
+	buffers   *bufferPool
+}
+
+// Validates the options and the upstream, and returns the upstream that
+// connections are forwarded to.
+func (cfg *forwardConfig) forwarder(upstream string) (*forwardUpstream, error) {
+	if cfg.Err != nil {
+		return nil, cfg.Err
+	}
+	network, addr, err := parseForwardUpstream(upstream, cfg.Dialer != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	up := &forwardUpstream{
+		upstream: upstream,
+		cfg:      cfg,
+		network:  network,
+		addr:     addr,
+		dialer:   cfg.Dialer,
+		buffers:  copyBufferPool(cfg.CopyBufferSize),
+	}
+	if cfg.TLS != nil {
+		up.tlsConfig = upstreamTLSConfig(cfg.TLS, addr)
+	}
+	if up.dialer == nil {
+		netDialer := &net.Dialer{}
+		if cfg.LocalAddr != nil {
+			if network != "tcp" {
+				return nil, errForwardUpstream{upstream, errors.New("a local address can only be used with TCP upstreams")}
+			}
+			if err := checkForwardLocalAddr(cfg.LocalAddr); err != nil {
+				return nil, errForwardLocalAddr{cfg.LocalAddr.String(), err}
+			}
+			netDialer.LocalAddr = cfg.LocalAddr
+		}
+		up.dialer = netDialer
+		if network == "pipe" {
+			if err := checkNamedPipes(); err != nil {
+				return nil, errForwardUpstream{upstream, err}
+			}
+			up.dialer = pipeDialer{}
+		}
+	}
+	return up, nil
+}
+
+// Connects to the upstream on behalf of a client at src, sending the PROXY
+// header and making the TLS handshake if configured to. If src is nil, the
+// connection's own local address is sent as the client's.
+func (up *forwardUpstream) dial(ctx context.Context, src net.Addr) (net.Conn, error) {
+	upstreamConn, err := up.dialer.DialContext(ctx, up.network, up.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to upstream %s: %w", up.addr, err)
+	}
+
+	if up.cfg.ProxyProto != config.ProxyProtoNone {
+		if src == nil {
+			src = upstreamConn.LocalAddr()
+		}
+		header := proxyProtoHeader(up.cfg.ProxyProto, src, upstreamConn.RemoteAddr())
+		if _, err := upstreamConn.Write(header); err != nil {
+			_ = upstreamConn.Close()
+			return nil, fmt.Errorf("failed to send PROXY header to upstream %s: %w", up.addr, err)
+		}
+	}
+
+	if up.tlsConfig != nil {
+		tlsConn := tls.Client(upstreamConn, up.tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = upstreamConn.Close()
+			return nil, fmt.Errorf("failed TLS handshake with upstream %s: %w", up.addr, err)
+		}
+		upstreamConn = tlsConn
+	}
+	return upstreamConn, nil
+}
+
+// Forwards a connection to the upstream, until either side closes or abort
+// is closed.
+func (up *forwardUpstream) forward(ctx context.Context, conn net.Conn, abort <-chan struct{}) {
+	upstreamConn, err := up.dial(ctx, conn.RemoteAddr())
+	if err != nil {
+		_ = conn.Close()
+		if up.cfg.ErrorHandler != nil {
+			up.cfg.ErrorHandler(err)
+		}
+		return
+	}
+
+	joined := make(chan struct{})
+	defer close(joined)
+	go func() {
+		select {
+		case <-abort:
+			_ = conn.Close()
+			_ = upstreamConn.Close()
+		case <-joined:
+		}
+	}()
+	if up.cfg.WriteBatch > 0 {
+		joinWith(conn, upstreamConn, func(dst io.Writer, src io.Reader) (int64, error) {
+			return batchCopyConn(dst, src, up.buffers, up.cfg.WriteBatch)
+		}, up.cfg.ErrorHandler)
+		return
+	}
+	join(conn, upstreamConn, up.buffers, up.cfg.ErrorHandler)
+}
+
+// Forward accepts connections from the [Tunnel] and forwards each of them to
+// the upstream address, copying data in both directions until either side
+// closes. The upstream is one of:
+//
+//   - a TCP host and port, such as "localhost:8080", or the same as a "tcp://"
+//     URL
+//   - a Unix domain socket, such as "unix:///var/run/app.sock"
+//   - a Windows named pipe, such as `\\.\pipe\app` or "pipe://app"
+//
+// Use [WithForwardDialer] to forward to other kinds of upstream, such as one
+// in the same process. Connections are dropped if the upstream can't be
+// reached; use [WithHealthCheck] to stop them from being routed to an
+// upstream that's down. Forward blocks until the [Tunnel] is closed or the context is
+// cancelled.
+//
+// As with [Serve], the returned error can be classified with [ServeResultOf],
+// and the [Tunnel] is closed when Forward returns. Connections that are still
+// being forwarded when it returns are left open; use [Tunnel].Forward to wait
+// for them instead.
+func Forward(ctx context.Context, tun Tunnel, upstreamAddr string, opts ...ForwardOption) error {
+	defer tun.Close()
+
+	cfg := forwardConfig{}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	up, err := cfg.forwarder(upstreamAddr)
+	if err != nil {
+		return err
+	}
+	if cfg.HealthCheck != nil {
+		stop := up.checkHealth(tun, *cfg.HealthCheck)
+		defer stop()
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = tun.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		conn, err := tun.Accept()
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return errServe{Result: ServeResultShutdown, Inner: ctxErr}
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return errServe{Result: ServeResultTunnelClosed, Inner: err}
+			}
+			return err
+		}
+
+		go up.forward(ctx, conn, nil)
+	}
+}
+
+func (t *tunnelImpl) Forward(ctx context.Context, upstream string, opts ...ForwardOption) error {
+	cfg := forwardConfig{}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	up, err := cfg.forwarder(upstream)
+	if err != nil {
+		_ = t.Close()
+		return err
+	}
+	if cfg.HealthCheck != nil {
+		stop := up.checkHealth(t, *cfg.HealthCheck)
+		defer stop()
+	}
+
+	// Serve closes the connections that are still open at the context's
+	// deadline, but join would only notice once the upstream next sent
+	// something, so the upstream connections are closed along with them.
+	abort := make(chan struct{})
+	served := make(chan struct{})
+	defer close(served)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-served:
+			return
+		}
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return
+		}
+		// On the system clock, like the context's deadline.
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			close(abort)
+		case <-served:
+		}
+	}()
+
+	return t.Serve(ctx, func(conn net.Conn) {
+		up.forward(ctx, conn, abort)
+	})
+}
+
+// ForwardListener exposes a local listener through the [Tunnel], for servers
+// that have already created their own [net.Listener] and would rather not
+// accept from the Tunnel directly. Each connection accepted from the listener
+// is paired with the next connection accepted from the Tunnel, and data is
+// copied between them in both directions, as with [Tunnel].Bridge.
+//
+// ForwardListener blocks until the context is cancelled or either the
+// listener or the [Tunnel] is closed. Closing one closes the other, along with
+// every paired connection. As with [Forward], the returned error can be
+// classified with [ServeResultOf].
+//
+// Of the [ForwardOption]s, only [WithCopyBufferSize] applies to
+// ForwardListener.
+func ForwardListener(ctx context.Context, tun Tunnel, l net.Listener, opts ...ForwardOption) error {
+	cfg := forwardConfig{}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	b := &bridge{
+		local:   l,
+		tun:     tun,
+		buffers: copyBufferPool(cfg.CopyBufferSize),
+		conns:   map[net.Conn]struct{}{},
+	}
+	defer b.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-tun.Done():
+		case <-done:
+			return
+		}
+		_ = b.Close()
+	}()
+
+	err := b.run()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return errServe{Result: ServeResultShutdown, Inner: ctxErr}
+	}
+	if errors.Is(err, net.ErrClosed) {
+		return errServe{Result: ServeResultTunnelClosed, Inner: err}
+	}
+	return err
+}
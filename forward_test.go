@@ -0,0 +1,407 @@
+package ngrok
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Starts an upstream that echoes everything back, and reports the remote
+// address of each connection made to it.
+func startEchoUpstream(t *testing.T) (string, <-chan net.Addr) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	remotes := make(chan net.Addr, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			remotes <- conn.RemoteAddr()
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String(), remotes
+}
+
+func TestForward(t *testing.T) {
+	upstream, remotes := startEchoUpstream(t)
+	tun, addr := fakeTunnel(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan error)
+	go func() {
+		exited <- Forward(ctx, tun, upstream, WithForwardLocalAddr("127.0.0.1"))
+	}()
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = io.WriteString(client, "ping")
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	remote := (<-remotes).(*net.TCPAddr)
+	require.Equal(t, "127.0.0.1", remote.IP.String())
+
+	cancel()
+	require.Equal(t, ServeResultShutdown, ServeResultOf(<-exited))
+}
+
+func TestForwardLocalAddrErrors(t *testing.T) {
+	for _, addr := range []string{
+		"not an address",
+		"localhost:0",
+		"127.0.0.1:port",
+		// Reserved for documentation, so never assigned to an interface.
+		"192.0.2.1",
+	} {
+		t.Run(addr, func(t *testing.T) {
+			tun, _ := fakeTunnel(t)
+			err := Forward(context.Background(), tun, "127.0.0.1:1", WithForwardLocalAddr(addr))
+			require.ErrorIs(t, err, errForwardLocalAddr{})
+			require.Contains(t, err.Error(), addr)
+		})
+	}
+}
+
+func TestForwardUpstreamURL(t *testing.T) {
+	for _, tc := range []struct {
+		upstream, network, addr string
+	}{
+		{"tcp://127.0.0.1:1", "tcp", "127.0.0.1:1"},
+		{"127.0.0.1:1", "tcp", "127.0.0.1:1"},
+		{"tcp://[::1]:1", "tcp", "[::1]:1"},
+		{"unix:///var/run/app.sock", "unix", "/var/run/app.sock"},
+		{"unix://app.sock", "unix", "app.sock"},
+		{`\\.\pipe\app`, "pipe", `\\.\pipe\app`},
+		{"pipe://app/v1", "pipe", `\\.\pipe\app\v1`},
+	} {
+		network, addr, err := parseForwardUpstream(tc.upstream, false)
+		require.NoError(t, err, tc.upstream)
+		require.Equal(t, tc.network, network, tc.upstream)
+		require.Equal(t, tc.addr, addr, tc.upstream)
+	}
+
+	// Other schemes are left to custom dialers.
+	network, addr, err := parseForwardUpstream("memory://app", true)
+	require.NoError(t, err)
+	require.Equal(t, "memory", network)
+	require.Equal(t, "app", addr)
+
+	for _, upstream := range []string{
+		"localhost",
+		"http://localhost:8080",
+		"tcp://localhost",
+		"tcp://localhost:8080/path",
+		"tcp://%zz",
+		"unix://",
+		"memory://app",
+	} {
+		t.Run(upstream, func(t *testing.T) {
+			tun, _ := fakeTunnel(t)
+			err := tun.Forward(context.Background(), upstream)
+			require.ErrorIs(t, err, errForwardUpstream{})
+			require.Contains(t, err.Error(), upstream)
+		})
+	}
+}
+
+func TestTunnelForward(t *testing.T) {
+	upstream, _ := startEchoUpstream(t)
+	tun, addr := fakeTunnel(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- tun.Forward(ctx, "tcp://"+upstream)
+	}()
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = io.WriteString(client, "ping")
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	// Connections are still forwarded until the context's deadline.
+	cancel()
+	_, err = io.WriteString(client, "pong")
+	require.NoError(t, err)
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	require.Equal(t, "pong", string(buf))
+
+	// And then closed, along with the upstream connection, which would
+	// otherwise wait for more data forever.
+	select {
+	case err := <-exited:
+		require.ErrorIs(t, err, ErrServeShutdown)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(time.Second):
+		require.FailNow(t, "Forward didn't return at the deadline")
+	}
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = client.Read(buf)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestForwardDialErrors(t *testing.T) {
+	// Nothing listens on a port once its listener is closed.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	upstream := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	tun, addr := fakeTunnel(t)
+	errs := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = tun.Forward(ctx, upstream, WithForwardErrorHandler(func(err error) {
+			errs <- err
+		}))
+	}()
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	select {
+	case err := <-errs:
+		require.Contains(t, err.Error(), upstream)
+	case <-time.After(time.Second):
+		require.FailNow(t, "dial error wasn't reported")
+	}
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = client.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF, "connections are dropped if the upstream can't be reached")
+}
+
+func TestForwardListener(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- ForwardListener(context.Background(), tun, l)
+	}()
+
+	// The local side, which dials the listener.
+	local, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer local.Close()
+
+	// The remote side, arriving at the tunnel.
+	remote, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer remote.Close()
+
+	_, err = io.WriteString(remote, "ping")
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(local, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	_, err = io.WriteString(local, "pong")
+	require.NoError(t, err)
+	_, err = io.ReadFull(remote, buf)
+	require.NoError(t, err)
+	require.Equal(t, "pong", string(buf))
+
+	// Closing the listener closes the tunnel and the paired connections.
+	require.NoError(t, l.Close())
+	require.Equal(t, ServeResultTunnelClosed, ServeResultOf(<-exited))
+	_, err = tun.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+	_, err = io.ReadAll(remote)
+	require.NoError(t, err)
+}
+
+func TestForwardListenerTunnelClosed(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- ForwardListener(context.Background(), tun, l)
+	}()
+
+	// Closing the tunnel closes the listener, even while it's waiting for a
+	// local connection.
+	require.NoError(t, tun.Close())
+	require.Equal(t, ServeResultTunnelClosed, ServeResultOf(<-exited))
+	_, err = l.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestForwardListenerCancel(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan error, 1)
+	go func() {
+		exited <- ForwardListener(ctx, tun, l)
+	}()
+
+	cancel()
+	require.ErrorIs(t, <-exited, ErrServeShutdown)
+}
+
+// Checks that a connection to the tunnel is forwarded to an upstream that
+// echoes.
+func requireForwardsEcho(t *testing.T, addr string) {
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = io.WriteString(client, "ping")
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+}
+
+func serveEcho(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+	}
+}
+
+func TestForwardUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	defer l.Close()
+	go serveEcho(l)
+
+	tun, addr := fakeTunnel(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = tun.Forward(ctx, "unix://"+path)
+	}()
+	requireForwardsEcho(t, addr)
+}
+
+func TestForwardInMemory(t *testing.T) {
+	l := NewInMemoryListener()
+	go serveEcho(l)
+
+	tun, addr := fakeTunnel(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = tun.Forward(ctx, "memory://app", WithForwardDialer(l))
+	}()
+	requireForwardsEcho(t, addr)
+
+	// Dials fail once the listener is closed.
+	require.NoError(t, l.Close())
+	_, err := l.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+	_, err = l.DialContext(ctx, "memory", "app")
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestForwardLocalAddrUnix(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+	err := tun.Forward(context.Background(), "unix:///tmp/app.sock", WithForwardLocalAddr("127.0.0.1"))
+	require.ErrorIs(t, err, errForwardUpstream{})
+}
+
+func TestForwardWriteBatching(t *testing.T) {
+	upstream, _ := startEchoUpstream(t)
+	tun, addr := fakeTunnel(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = tun.Forward(ctx, upstream, WithWriteBatching(8), WithCopyBufferSize(16))
+	}()
+	requireForwardsEcho(t, addr)
+}
+
+func BenchmarkForwardThroughput(b *testing.B) {
+	payload := bytes.Repeat([]byte("x"), 256*1024)
+	for _, bc := range []struct {
+		name string
+		opts []ForwardOption
+	}{
+		{"default", nil},
+		{"batched", []ForwardOption{WithWriteBatching(8)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			// An upstream that discards everything it's sent.
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(b, err)
+			defer l.Close()
+			go func() {
+				for {
+					conn, err := l.Accept()
+					if err != nil {
+						return
+					}
+					go func() {
+						defer conn.Close()
+						_, _ = io.Copy(io.Discard, conn)
+					}()
+				}
+			}()
+
+			tun, addr := fakeTunnel(&testing.T{})
+			defer tun.Close()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				_ = tun.Forward(ctx, l.Addr().String(), bc.opts...)
+			}()
+
+			client, err := net.Dial("tcp", addr)
+			require.NoError(b, err)
+			defer client.Close()
+
+			b.SetBytes(int64(len(payload)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := client.Write(payload); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package ngrok
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+)
+
+// GeoInfo describes where a client connecting to a [Tunnel] is located, as
+// determined by the lookup function configured with [WithGeoIP].
+type GeoInfo struct {
+	// The ISO 3166-1 alpha-2 code of the client's country, e.g. "US".
+	Country string
+	// The client's region within its country, such as a state or province.
+	Region string
+	// The client's city.
+	City string
+}
+
+// GeoIPLookup resolves a client IP address to its location. It's supplied by
+// the application, typically backed by a GeoIP database, so that the SDK
+// doesn't need to ship one.
+type GeoIPLookup func(addr netip.Addr) (GeoInfo, error)
+
+// WithGeoIP configures a function which resolves the location of the clients
+// connecting to the session's tunnels. The location of each connection is
+// available from its Geo method and is included in the [ConnStats] passed to
+// a [Tracer].
+//
+// Lookups are done lazily, the first time a connection's location is needed,
+// and successful results are cached by IP address for the lifetime of the
+// session.
+func WithGeoIP(lookup GeoIPLookup) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.GeoIP = lookup
+	}
+}
+
+// The most addresses that a geoResolver will remember.
+const geoCacheSize = 4096
+
+// Caches the results of a session's GeoIPLookup.
+type geoResolver struct {
+	lookup GeoIPLookup
+
+	mu    sync.Mutex
+	cache map[netip.Addr]GeoInfo
+}
+
+func newGeoResolver(lookup GeoIPLookup) *geoResolver {
+	if lookup == nil {
+		return nil
+	}
+	return &geoResolver{
+		lookup: lookup,
+		cache:  make(map[netip.Addr]GeoInfo),
+	}
+}
+
+// Resolves the location of a client address, which may include a port.
+func (r *geoResolver) resolve(clientAddr string) (GeoInfo, error) {
+	addr, err := parseClientIP(clientAddr)
+	if err != nil {
+		return GeoInfo{}, err
+	}
+
+	r.mu.Lock()
+	info, ok := r.cache[addr]
+	r.mu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	// Errors aren't cached, since they may well be transient.
+	info, err = r.lookup(addr)
+	if err != nil {
+		return GeoInfo{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.cache) >= geoCacheSize {
+		// Rather than track recency, start over. Busy clients will quickly
+		// be cached again.
+		r.cache = make(map[netip.Addr]GeoInfo)
+	}
+	r.cache[addr] = info
+	return info, nil
+}
+
+func parseClientIP(clientAddr string) (netip.Addr, error) {
+	if addrPort, err := netip.ParseAddrPort(clientAddr); err == nil {
+		return addrPort.Addr().Unmap(), nil
+	}
+	addr, err := netip.ParseAddr(clientAddr)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid client address %q: %w", clientAddr, err)
+	}
+	return addr.Unmap(), nil
+}
+
+// The location of a single connection, resolved at most once.
+type connGeo struct {
+	resolver   *geoResolver
+	clientAddr string
+
+	once sync.Once
+	info GeoInfo
+	err  error
+}
+
+func (g *connGeo) get(c *connImpl) (GeoInfo, error) {
+	if g == nil {
+		return GeoInfo{}, nil
+	}
+	g.once.Do(func() {
+		g.err = c.Tun.guarded("GeoIP", func() error {
+			var err error
+			g.info, err = g.resolver.resolve(g.clientAddr)
+			return err
+		})
+		if errors.Is(g.err, errCallbackPanic{}) {
+			// Only the transport is closed, since this may be called
+			// while the connection is being closed.
+			_ = c.Conn.Close()
+		}
+	})
+	return g.info, g.err
+}
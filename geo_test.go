@@ -0,0 +1,111 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoResolver(t *testing.T) {
+	var lookups int
+	resolver := newGeoResolver(func(addr netip.Addr) (GeoInfo, error) {
+		lookups++
+		if addr == netip.MustParseAddr("192.0.2.1") {
+			return GeoInfo{}, testError
+		}
+		return GeoInfo{Country: "US", Region: "CA", City: "San Francisco"}, nil
+	})
+
+	info, err := resolver.resolve("203.0.113.7:4321")
+	require.NoError(t, err)
+	require.Equal(t, "US", info.Country)
+
+	_, err = resolver.resolve("203.0.113.7:1234")
+	require.NoError(t, err)
+	_, err = resolver.resolve("[::ffff:203.0.113.7]:1234")
+	require.NoError(t, err)
+	require.Equal(t, 1, lookups, "results are cached by IP")
+
+	_, err = resolver.resolve("192.0.2.1")
+	require.ErrorIs(t, err, testError)
+	_, err = resolver.resolve("192.0.2.1")
+	require.ErrorIs(t, err, testError)
+	require.Equal(t, 3, lookups, "errors aren't cached")
+
+	_, err = resolver.resolve("not an address")
+	require.Error(t, err)
+	require.Equal(t, 3, lookups, "invalid addresses are never looked up")
+}
+
+func TestConnGeo(t *testing.T) {
+	tracer := &testTracer{
+		started: make(chan ConnAttributes, 1),
+		ended:   make(chan ConnStats, 1),
+	}
+
+	var lookups []netip.Addr
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.tracer = tracer
+	impl.geo = newGeoResolver(func(addr netip.Addr) (GeoInfo, error) {
+		lookups = append(lookups, addr)
+		return GeoInfo{Country: "NZ"}, nil
+	})
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	require.Empty(t, lookups, "lookups are done lazily")
+
+	info, err := conn.(interface{ Geo() (GeoInfo, error) }).Geo()
+	require.NoError(t, err)
+	require.Equal(t, "NZ", info.Country)
+
+	require.NoError(t, conn.Close())
+	stats := <-tracer.ended
+	require.Equal(t, "NZ", stats.Geo.Country)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("127.0.0.1")}, lookups)
+}
+
+func TestConnGeoPanic(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).geo = newGeoResolver(func(addr netip.Addr) (GeoInfo, error) {
+		panic("oops")
+	})
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The connection whose lookup panicked is closed.
+	_, err = conn.(interface{ Geo() (GeoInfo, error) }).Geo()
+	require.ErrorIs(t, err, errCallbackPanic{})
+	_, err = client.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestNoGeoIP(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	info, err := conn.(interface{ Geo() (GeoInfo, error) }).Geo()
+	require.NoError(t, err)
+	require.Equal(t, GeoInfo{}, info)
+}
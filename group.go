@@ -0,0 +1,41 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func (t *tunnelImpl) ServeWithGroup(ctx context.Context, g *errgroup.Group, handle func(context.Context, net.Conn) error) {
+	g.Go(func() error {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = t.Close()
+			case <-done:
+			}
+		}()
+
+		for {
+			conn, err := t.Accept()
+			if err != nil {
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return errServe{Result: ServeResultShutdown, Inner: ctxErr}
+				}
+				if errors.Is(err, net.ErrClosed) {
+					return errServe{Result: ServeResultTunnelClosed, Inner: err}
+				}
+				return err
+			}
+
+			g.Go(func() error {
+				defer conn.Close()
+				return handle(ctx, conn)
+			})
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestServeWithGroup(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	handlerErr := errors.New("bad client")
+	g, ctx := errgroup.WithContext(context.Background())
+	tun.ServeWithGroup(ctx, g, func(ctx context.Context, conn net.Conn) error {
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return err
+		}
+		if string(buf) == "fail" {
+			return handlerErr
+		}
+		_, err := conn.Write(buf)
+		return err
+	})
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = io.WriteString(client, "ping")
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	failing, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer failing.Close()
+	_, err = io.WriteString(failing, "fail")
+	require.NoError(t, err)
+
+	require.ErrorIs(t, g.Wait(), handlerErr, "the first handler error is the group's")
+	_, err = tun.Accept()
+	require.ErrorIs(t, err, net.ErrClosed, "the tunnel is closed once the group is cancelled")
+}
+
+func TestServeWithGroupCancel(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g, ctx := errgroup.WithContext(ctx)
+	tun.ServeWithGroup(ctx, g, func(context.Context, net.Conn) error {
+		return nil
+	})
+
+	cancel()
+	err := g.Wait()
+	require.ErrorIs(t, err, ErrServeShutdown)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestServeWithGroupTunnelClosed(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+
+	g, ctx := errgroup.WithContext(context.Background())
+	tun.ServeWithGroup(ctx, g, func(context.Context, net.Conn) error {
+		return nil
+	})
+
+	require.NoError(t, tun.(*tunnelImpl).Tunnel.Close())
+	require.ErrorIs(t, g.Wait(), ErrTunnelClosed)
+}
@@ -0,0 +1,49 @@
+package ngrok
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// WithH2C configures [Serve] to accept HTTP/2 without TLS, known as h2c, along
+// with HTTP/1.1. The ngrok edge terminates TLS for HTTP tunnels, so this is how
+// HTTP/2 reaches the application when the tunnel is configured with
+// config.WithAppProtocol(config.AppProtocolHTTP2). Both connections that start
+// with the HTTP/2 preface and HTTP/1.1 requests to upgrade are accepted.
+//
+// [ServeTLS] negotiates HTTP/2 with ALPN already, and doesn't need this.
+func WithH2C() ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.H2C = true
+	}
+}
+
+// ServeGRPC serves a gRPC server, or any other [http.Handler] that relies on
+// HTTP/2, over an HTTP [Tunnel], as [Serve] does with [WithH2C]. A
+// *grpc.Server is served through its ServeHTTP method:
+//
+//	tun, err := sess.Listen(ctx, config.HTTPEndpoint(
+//		config.WithAppProtocol(config.AppProtocolHTTP2),
+//	))
+//	...
+//	srv := grpc.NewServer()
+//	pb.RegisterGreeterServer(srv, &greeter{})
+//	err = ngrok.ServeGRPC(ctx, tun, srv)
+//
+// The tunnel must be configured with config.WithAppProtocol as above, or else
+// the edge sends requests over HTTP/1.1, which gRPC can't be served over. For
+// labeled tunnels, the app protocol is configured on the edge's backend
+// instead.
+func ServeGRPC(ctx context.Context, tun Tunnel, srv http.Handler, opts ...ServeOption) error {
+	return Serve(ctx, tun, srv, append(opts, WithH2C())...)
+}
+
+// Serves h2c alongside HTTP/1.1, as configured with WithH2C.
+func h2cHandler(handler http.Handler, cfg *serveConfig) http.Handler {
+	return h2c.NewHandler(handler, &http2.Server{
+		IdleTimeout: cfg.IdleTimeout,
+	})
+}
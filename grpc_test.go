@@ -0,0 +1,63 @@
+package ngrok
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+func TestServeGRPC(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	// Stands in for a *grpc.Server, which needs HTTP/2 for its trailers.
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/grpc" {
+			return
+		}
+		require.Equal(t, 2, r.ProtoMajor)
+		rw.Header().Set("Trailer", "Grpc-Status")
+		_, _ = io.Copy(rw, r.Body)
+		rw.Header().Set("Grpc-Status", "0")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan error, 1)
+	go func() {
+		exited <- ServeGRPC(ctx, tun, handler)
+	}()
+
+	client := &http.Client{
+		Transport: &http2.Transport{
+			// Speak h2c with prior knowledge, as the edge does.
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+"/greeter.Greeter/SayHello", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, 2, resp.ProtoMajor)
+	require.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+
+	// HTTP/1.1 is still served alongside.
+	resp, err = http.Get("http://" + addr)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, 1, resp.ProtoMajor)
+
+	cancel()
+	require.ErrorIs(t, <-exited, ErrServeShutdown)
+}
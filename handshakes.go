@@ -0,0 +1,155 @@
+package ngrok
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+// The most connections whose handshakes are checked at once, or that have
+// passed and are waiting for Accept. Once there are this many, more are left
+// waiting at the edge, as they are while nobody calls Accept.
+const maxPendingHandshakes = 64
+
+// Checks the handshakes of the connections that arrive at a tunnel, such as
+// their SNI or WebSocket upgrade, on a goroutine per connection, so that a
+// client that's slow to send its handshake holds up only its own connection,
+// rather than Accept.
+type handshakeQueue struct {
+	// The connections that passed, in the order that they finished.
+	ready chan handshakeResult
+	// Holds a token for each pending connection.
+	pending chan struct{}
+	// Closed once the tunnel stops handing out connections, after which err
+	// is why.
+	failed chan struct{}
+	err    error
+}
+
+type handshakeResult struct {
+	conn       *tunnel_client.ProxyConn
+	serverName string
+}
+
+// Reports whether connections have a handshake to check before they're
+// accepted.
+func (t *tunnelImpl) checksHandshakes() bool {
+	return t.requiredSNI != nil || t.unwrapWebsockets
+}
+
+// Returns the next connection that arrives at the tunnel and passes its
+// handshake, with the server name that it requested.
+func (t *tunnelImpl) nextConn() (*tunnel_client.ProxyConn, string, error) {
+	if !t.checksHandshakes() {
+		conn, err := t.arrive()
+		return conn, "", err
+	}
+
+	t.handshakesOnce.Do(func() {
+		t.handshakes = &handshakeQueue{
+			ready:   make(chan handshakeResult),
+			pending: make(chan struct{}, maxPendingHandshakes),
+			failed:  make(chan struct{}),
+		}
+		go t.runHandshakes(t.handshakes)
+	})
+	q := t.handshakes
+	select {
+	case res := <-q.ready:
+		return res.conn, res.serverName, nil
+	case <-q.failed:
+		return nil, "", q.err
+	}
+}
+
+// Returns the next connection that arrives at the tunnel and isn't turned
+// away because it's paused, draining, in maintenance, or rate limited.
+func (t *tunnelImpl) arrive() (*tunnel_client.ProxyConn, error) {
+	for {
+		t.waitResumed()
+		conn, err := t.Tunnel.Accept()
+		if err != nil {
+			return nil, err
+		}
+		// A connection may have arrived just as the tunnel was paused.
+		if !t.waitResumed() {
+			_ = conn.Conn.Close()
+			return nil, net.ErrClosed
+		}
+		if atomic.LoadInt32(&t.draining) != 0 {
+			_ = conn.Conn.Close()
+			t.notifyAccept(atomic.AddUint64(&t.lastConnID, 1), conn.Header.ClientAddr, ErrDraining)
+			continue
+		}
+		if page := t.maintenancePage(); page != nil {
+			page.serve(conn)
+			t.notifyAccept(atomic.AddUint64(&t.lastConnID, 1), conn.Header.ClientAddr, ErrMaintenance)
+			continue
+		}
+		if !allowConn(t.limits, t.sessionLimits) {
+			rejectConn(conn, http.StatusTooManyRequests)
+			t.notifyAccept(atomic.AddUint64(&t.lastConnID, 1), conn.Header.ClientAddr, ErrRateLimited)
+			continue
+		}
+		return conn, nil
+	}
+}
+
+// Hands each connection that arrives to a goroutine of its own, which checks
+// its handshake and queues it for Accept if it passes, until the tunnel stops
+// handing out connections.
+func (t *tunnelImpl) runHandshakes(q *handshakeQueue) {
+	for {
+		q.pending <- struct{}{}
+		conn, err := t.arrive()
+		if err != nil {
+			q.err = err
+			close(q.failed)
+			return
+		}
+		go func() {
+			defer func() { <-q.pending }()
+			serverName, ok := t.checkHandshake(conn)
+			if !ok {
+				return
+			}
+			select {
+			case q.ready <- handshakeResult{conn, serverName}:
+			case <-q.failed:
+				_ = conn.Conn.Close()
+			case <-t.Done():
+				_ = conn.Conn.Close()
+			}
+		}()
+	}
+}
+
+// Checks the handshake of a connection, replacing its Conn with one that
+// replays what was read, or unwraps it. Connections that fail are closed, and
+// reported as rejected.
+func (t *tunnelImpl) checkHandshake(conn *tunnel_client.ProxyConn) (string, bool) {
+	var serverName string
+	if t.requiredSNI != nil {
+		checked, name, err := t.checkSNI(conn.Conn)
+		if err != nil {
+			_ = conn.Conn.Close()
+			atomic.AddUint64(&t.sniRejections, 1)
+			t.notifyAccept(atomic.AddUint64(&t.lastConnID, 1), conn.Header.ClientAddr, err)
+			return "", false
+		}
+		conn.Conn = checked
+		serverName = name
+	}
+	if t.unwrapWebsockets {
+		unwrapped, err := t.acceptWebsocket(conn.Conn)
+		if err != nil {
+			_ = conn.Conn.Close()
+			t.notifyAccept(atomic.AddUint64(&t.lastConnID, 1), conn.Header.ClientAddr, err)
+			return "", false
+		}
+		conn.Conn = unwrapped
+	}
+	return serverName, true
+}
@@ -0,0 +1,260 @@
+package ngrok
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The defaults for the zero fields of a HealthCheck.
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 5 * time.Second
+	defaultHealthFailures      = 3
+)
+
+// HealthAction is what a [Tunnel] does with new connections while the
+// upstream that it's forwarded to is unhealthy, as configured by
+// [HealthCheck].Action.
+type HealthAction int
+
+const (
+	// Connections keep being forwarded to the upstream, and the changes in
+	// its health are only reported.
+	HealthActionNone HealthAction = iota
+	// The tunnel is paused with [Tunnel].Pause, so that new connections wait
+	// at the ngrok edge until the upstream recovers, or until they time out.
+	HealthActionPause
+	// New connections are turned away without reaching the upstream: HTTP
+	// clients are sent 503 Service Unavailable, and the rest are reset. This
+	// uses [Tunnel].SetMaintenance, replacing any maintenance page that was
+	// set, and clearing it once the upstream recovers.
+	HealthActionReject
+)
+
+// HealthCheck configures how [Forward] and [Tunnel].Forward check the health
+// of their upstream. See [WithHealthCheck].
+type HealthCheck struct {
+	// The path of an HTTP GET request sent to the upstream by each check,
+	// such as "/healthz". If empty, checks only connect to the upstream.
+	HTTPPath string
+	// The status that HTTP checks expect the upstream to respond with.
+	// Defaults to any 2xx status when 0.
+	ExpectStatus int
+	// How often the upstream is checked.
+	// Defaults to 10 seconds when 0.
+	Interval time.Duration
+	// How long each check may take before it fails.
+	// Defaults to 5 seconds, or the Interval if that's shorter, when 0.
+	Timeout time.Duration
+	// The number of checks in a row that must fail for the upstream to be
+	// considered unhealthy.
+	// Defaults to 3 when 0.
+	FailureThreshold int
+	// The number of checks in a row that must pass for an unhealthy upstream
+	// to be considered healthy again.
+	// Defaults to 1 when 0.
+	SuccessThreshold int
+	// What the tunnel does while the upstream is unhealthy.
+	Action HealthAction
+	// Called on its own goroutine each time the upstream's health changes.
+	OnChange func(HealthEvent)
+}
+
+// HealthEvent reports a change in the health of an upstream, as passed to
+// [HealthCheck].OnChange.
+type HealthEvent struct {
+	// The upstream that was checked, as passed to Forward.
+	Upstream string
+	// The upstream's new health.
+	Healthy bool
+	// Why the last check failed, if the upstream became unhealthy.
+	Err error
+	// When the check that changed its health was made.
+	Time time.Time
+}
+
+// WithHealthCheck configures [Forward] and [Tunnel].Forward to check the
+// health of their upstream periodically while they're forwarding to it, for
+// as long as they run. Upstreams start out healthy, become unhealthy once
+// enough checks in a row fail, and recover once enough pass, with each change
+// logged and reported to the check's OnChange function. Its Action stops the
+// edge from routing connections to an upstream that is down.
+func WithHealthCheck(check HealthCheck) ForwardOption {
+	return func(cfg *forwardConfig) {
+		cfg.HealthCheck = &check
+	}
+}
+
+// Fills in the defaults of the zero fields.
+func (check HealthCheck) withDefaults() HealthCheck {
+	if check.Interval <= 0 {
+		check.Interval = defaultHealthCheckInterval
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = defaultHealthCheckTimeout
+		if check.Interval < check.Timeout {
+			check.Timeout = check.Interval
+		}
+	}
+	if check.FailureThreshold <= 0 {
+		check.FailureThreshold = defaultHealthFailures
+	}
+	if check.SuccessThreshold <= 0 {
+		check.SuccessThreshold = 1
+	}
+	return check
+}
+
+// Checks the upstream's health on its own goroutine, and applies its action
+// to the tunnel while it's unhealthy. Returns a function that stops checking
+// and undoes the action.
+func (up *forwardUpstream) checkHealth(tun Tunnel, check HealthCheck) func() {
+	check = check.withDefaults()
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		up.runHealthChecks(ctx, tun, check)
+	}()
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func (up *forwardUpstream) runHealthChecks(ctx context.Context, tun Tunnel, check HealthCheck) {
+	clock := systemClock
+	if t, ok := tun.(*tunnelImpl); ok {
+		clock = clockOrSystem(t.clock)
+	}
+	var (
+		healthy   = true
+		failures  int
+		successes int
+		timer     = clock.NewTimer(check.Interval)
+	)
+	defer timer.Stop()
+	defer func() {
+		if !healthy {
+			applyHealthAction(tun, check.Action, true)
+		}
+	}()
+
+	for {
+		err := up.probe(ctx, check)
+		if ctx.Err() != nil {
+			return
+		}
+
+		changed := false
+		if err != nil {
+			successes = 0
+			failures++
+			if healthy && failures >= check.FailureThreshold {
+				healthy, changed = false, true
+			}
+		} else {
+			failures = 0
+			successes++
+			if !healthy && successes >= check.SuccessThreshold {
+				healthy, changed = true, true
+			}
+		}
+
+		if changed {
+			applyHealthAction(tun, check.Action, healthy)
+			if t, ok := tun.(*tunnelImpl); ok && t.logger != nil {
+				if healthy {
+					t.log().Info("upstream healthy", "upstream", up.upstream)
+				} else {
+					t.log().Warn("upstream unhealthy", "upstream", up.upstream, "err", err)
+				}
+			}
+			if check.OnChange != nil {
+				event := HealthEvent{Upstream: up.upstream, Healthy: healthy, Time: clock.Now()}
+				if !healthy {
+					event.Err = err
+				}
+				go check.OnChange(event)
+			}
+		}
+
+		select {
+		case <-timer.C():
+			timer.Reset(check.Interval)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Pauses or rejects the tunnel's new connections while its upstream is
+// unhealthy, and undoes that once it's healthy.
+func applyHealthAction(tun Tunnel, action HealthAction, healthy bool) {
+	switch action {
+	case HealthActionPause:
+		if healthy {
+			tun.Resume()
+		} else {
+			tun.Pause()
+		}
+	case HealthActionReject:
+		if healthy {
+			tun.SetMaintenance(nil)
+		} else {
+			tun.SetMaintenance(&MaintenancePage{StatusCode: http.StatusServiceUnavailable})
+		}
+	}
+}
+
+// Runs a single check of the upstream, returning why it failed, if it did.
+func (up *forwardUpstream) probe(ctx context.Context, check HealthCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+
+	if check.HTTPPath == "" {
+		conn, err := up.dial(ctx, nil)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return up.dial(ctx, nil)
+		},
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+
+	host := up.addr
+	if up.network != "tcp" {
+		host = "localhost"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+check.HTTPPath, nil)
+	if err != nil {
+		return fmt.Errorf("invalid health check path %q: %w", check.HTTPPath, err)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return fmt.Errorf("health check request to upstream %s failed: %w", up.addr, err)
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	_ = resp.Body.Close()
+
+	ok := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if check.ExpectStatus != 0 {
+		ok = resp.StatusCode == check.ExpectStatus
+	}
+	if !ok {
+		return fmt.Errorf("health check of upstream %s got status %d", up.addr, resp.StatusCode)
+	}
+	return nil
+}
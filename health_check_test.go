@@ -0,0 +1,138 @@
+package ngrok
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requireHealthEvent(t *testing.T, events <-chan HealthEvent, healthy bool) HealthEvent {
+	select {
+	case event := <-events:
+		require.Equal(t, healthy, event.Healthy)
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no health event with healthy=%v", healthy)
+		return HealthEvent{}
+	}
+}
+
+func TestHealthCheckHTTPReject(t *testing.T) {
+	var status int32 = http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/healthz", r.URL.Path)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+	upstream := srv.Listener.Addr().String()
+
+	tun, _ := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	events := make(chan HealthEvent, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = tun.Forward(ctx, upstream, WithHealthCheck(HealthCheck{
+			HTTPPath:         "/healthz",
+			Interval:         10 * time.Millisecond,
+			FailureThreshold: 2,
+			Action:           HealthActionReject,
+			OnChange:         func(event HealthEvent) { events <- event },
+		}))
+	}()
+
+	atomic.StoreInt32(&status, http.StatusInternalServerError)
+	event := requireHealthEvent(t, events, false)
+	require.Equal(t, upstream, event.Upstream)
+	require.ErrorContains(t, event.Err, "got status 500")
+	require.NotNil(t, impl.maintenancePage())
+	require.Equal(t, http.StatusServiceUnavailable, impl.maintenancePage().StatusCode)
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	event = requireHealthEvent(t, events, true)
+	require.NoError(t, event.Err)
+	require.Nil(t, impl.maintenancePage())
+}
+
+func TestHealthCheckTCPPause(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go serveEcho(l)
+
+	tun, _ := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	events := make(chan HealthEvent, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		_ = tun.Forward(ctx, l.Addr().String(), WithHealthCheck(HealthCheck{
+			Interval:         10 * time.Millisecond,
+			FailureThreshold: 1,
+			Action:           HealthActionPause,
+			OnChange:         func(event HealthEvent) { events <- event },
+		}))
+	}()
+
+	require.NoError(t, l.Close())
+	requireHealthEvent(t, events, false)
+	require.True(t, impl.paused())
+
+	// The tunnel is resumed once forwarding stops.
+	cancel()
+	<-forwarded
+	require.False(t, impl.paused())
+}
+
+func TestHealthCheckExpectStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	cfg := forwardConfig{}
+	up, err := cfg.forwarder(srv.Listener.Addr().String())
+	require.NoError(t, err)
+
+	check := HealthCheck{HTTPPath: "/"}.withDefaults()
+	require.NoError(t, up.probe(context.Background(), check))
+
+	check.ExpectStatus = http.StatusOK
+	require.ErrorContains(t, up.probe(context.Background(), check), "got status 204")
+}
+
+func TestHealthCheckClock(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	upstream := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	clock := newFakeClock()
+	tun, _ := fakeTunnel(t)
+	tun.(*tunnelImpl).clock = clock
+	events := make(chan HealthEvent, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = tun.Forward(ctx, upstream, WithHealthCheck(HealthCheck{
+			Interval:         time.Hour,
+			FailureThreshold: 2,
+			OnChange:         func(event HealthEvent) { events <- event },
+		}))
+	}()
+
+	// The checks after the first wait on the tunnel's clock.
+	require.Eventually(t, func() bool {
+		return clock.activeTimers() == 1
+	}, 5*time.Second, time.Millisecond)
+	require.Empty(t, events)
+	clock.Advance(time.Hour)
+	event := requireHealthEvent(t, events, false)
+	require.Equal(t, clock.Now(), event.Time)
+}
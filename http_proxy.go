@@ -0,0 +1,155 @@
+package ngrok
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// WithProxyFromEnvironment configures whether the session uses the proxy named
+// by the HTTPS_PROXY environment variable, or its lowercase equivalent, to
+// connect to the ngrok service when no proxy is configured with
+// [WithProxyURL]. NO_PROXY is honored as it is by [http.ProxyFromEnvironment].
+//
+// The environment is used by default. This option has no effect if the dialer
+// is overridden with [WithDialer].
+func WithProxyFromEnvironment(enabled bool) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.IgnoreProxyEnv = !enabled
+	}
+}
+
+// Returns the proxy that the environment says to use for connecting to the
+// ngrok server, or nil if there isn't one.
+func proxyFromEnvironment(serverAddr string) (*url.URL, error) {
+	return httpproxy.FromEnvironment().ProxyFunc()(&url.URL{
+		Scheme: "https",
+		Host:   serverAddr,
+	})
+}
+
+// Returns a Dialer that connects through the proxy. HTTP and HTTPS proxies are
+// tunneled through with CONNECT, and the rest are left to
+// golang.org/x/net/proxy, which supports SOCKS5.
+func newProxyDialer(proxyURL *url.URL, forward *net.Dialer) (Dialer, error) {
+	switch proxyURL.Scheme {
+	case "http", "https":
+		return &httpConnectDialer{proxyURL: proxyURL, forward: forward}, nil
+	}
+	proxied, err := proxy.FromURL(proxyURL, forward)
+	if err != nil {
+		return nil, err
+	}
+	dialer, ok := proxied.(Dialer)
+	if !ok {
+		return nil, fmt.Errorf("proxy scheme %q doesn't support dialing with a context", proxyURL.Scheme)
+	}
+	return dialer, nil
+}
+
+// A Dialer that tunnels connections through an HTTP proxy with the CONNECT
+// method.
+type httpConnectDialer struct {
+	proxyURL *url.URL
+	forward  *net.Dialer
+}
+
+func (d *httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *httpConnectDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	proxyAddr := d.proxyURL.Host
+	if d.proxyURL.Port() == "" {
+		port := "80"
+		if d.proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(d.proxyURL.Hostname(), port)
+	}
+
+	conn, err := d.forward.DialContext(ctx, network, proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if d.proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: d.proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	// Bound the CONNECT exchange by the context, as the dial was, by
+	// interrupting it with a deadline in the past if the context is done
+	// first.
+	finished := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Unix(1, 0))
+		case <-finished:
+		}
+	}()
+	tunneled, err := d.connect(conn, address)
+	close(finished)
+	<-stopped
+
+	if ctx.Err() != nil {
+		_ = conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tunneled, nil
+}
+
+// Asks the proxy to tunnel the connection to the address, returning the
+// tunneled connection.
+func (d *httpConnectDialer) connect(conn net.Conn, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if user := d.proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy refused to connect to %s: %s", address, resp.Status)
+	}
+
+	if br.Buffered() > 0 {
+		// The server spoke first, and some of what it said was read along
+		// with the response.
+		return &peekConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
@@ -0,0 +1,134 @@
+package ngrok
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Starts an HTTP proxy that tunnels CONNECT requests carrying the expected
+// Proxy-Authorization, and refuses the rest. Returns its URL.
+func startConnectProxy(t *testing.T, wantAuth string) *url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				if req.Method != http.MethodConnect || req.Header.Get("Proxy-Authorization") != wantAuth {
+					_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer upstream.Close()
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go func() {
+					_, _ = io.Copy(upstream, conn)
+				}()
+				_, _ = io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	return &url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func TestHTTPConnectDialer(t *testing.T) {
+	upstream, _ := startEchoUpstream(t)
+	proxyURL := startConnectProxy(t, "Basic dXNlcjpodW50ZXIy")
+	proxyURL.User = url.UserPassword("user", "hunter2")
+
+	dialer, err := newProxyDialer(proxyURL, &net.Dialer{})
+	require.NoError(t, err)
+	conn, err := dialer.DialContext(context.Background(), "tcp", upstream)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = io.WriteString(conn, "ping")
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	// Without the credentials, the proxy refuses.
+	proxyURL.User = nil
+	dialer, err = newProxyDialer(proxyURL, &net.Dialer{})
+	require.NoError(t, err)
+	_, err = dialer.Dial("tcp", upstream)
+	require.ErrorContains(t, err, "407")
+}
+
+func TestHTTPConnectDialerContext(t *testing.T) {
+	// A proxy that never responds.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer conn.Close()
+			_, _ = io.Copy(io.Discard, conn)
+		}
+	}()
+
+	dialer, err := newProxyDialer(&url.URL{Scheme: "http", Host: l.Addr().String()}, &net.Dialer{})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = dialer.DialContext(ctx, "tcp", "tunnel.example.com:443")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestNewProxyDialer(t *testing.T) {
+	_, err := newProxyDialer(&url.URL{Scheme: "socks5", Host: "127.0.0.1:1080", User: url.UserPassword("user", "pass")}, &net.Dialer{})
+	require.NoError(t, err)
+
+	_, err = newProxyDialer(&url.URL{Scheme: "notarealscheme", Host: "example.com"}, &net.Dialer{})
+	require.Error(t, err)
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy"} {
+		t.Setenv(name, "")
+	}
+
+	proxyURL, err := proxyFromEnvironment("tunnel.example.com:443")
+	require.NoError(t, err)
+	require.Nil(t, proxyURL)
+
+	t.Setenv("HTTPS_PROXY", "http://proxy.corp.example:3128")
+	proxyURL, err = proxyFromEnvironment("tunnel.example.com:443")
+	require.NoError(t, err)
+	require.Equal(t, "http://proxy.corp.example:3128", proxyURL.String())
+
+	t.Setenv("NO_PROXY", ".example.com")
+	proxyURL, err = proxyFromEnvironment("tunnel.example.com:443")
+	require.NoError(t, err)
+	require.Nil(t, proxyURL, "NO_PROXY is honored")
+
+	var cfg connectConfig
+	WithProxyFromEnvironment(false)(&cfg)
+	require.True(t, cfg.IgnoreProxyEnv)
+}
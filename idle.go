@@ -0,0 +1,220 @@
+package ngrok
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Tracks the number of open tunnels and connections on a session, and calls
+// onIdle once there have been none for the configured timeout.
+//
+// All methods are safe to call on a nil tracker, which never fires.
+type idleTracker struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	active  int
+	timer   clockTimer
+	stopped bool
+	onIdle  func()
+}
+
+// Creates a new tracker, which starts out idle. Returns nil if the timeout is
+// zero.
+func newIdleTracker(clock clock, timeout time.Duration, onIdle func()) *idleTracker {
+	if timeout == 0 {
+		return nil
+	}
+	t := &idleTracker{
+		timeout: timeout,
+		onIdle:  onIdle,
+	}
+	t.timer = clock.AfterFunc(timeout, t.fire)
+	return t
+}
+
+// acquire records the start of some activity, such as a tunnel being opened
+// or a connection being accepted.
+func (t *idleTracker) acquire() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active++
+	t.timer.Stop()
+}
+
+// release records the end of some activity previously recorded with acquire.
+func (t *idleTracker) release() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	if t.active == 0 && !t.stopped {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+// stop prevents the tracker from ever firing.
+func (t *idleTracker) stop() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	t.timer.Stop()
+}
+
+func (t *idleTracker) fire() {
+	t.mu.Lock()
+	// Activity may have started after the timer fired, but before we got
+	// the lock.
+	if t.active > 0 || t.stopped {
+		t.mu.Unlock()
+		return
+	}
+	t.stopped = true
+	t.mu.Unlock()
+
+	t.onIdle()
+}
+
+// Closes a connection once it has had no activity for the configured timeout.
+//
+// Rather than resetting a timer on every read and write, activity is recorded
+// as a timestamp, and the timer re-arms itself for the remaining time when it
+// finds that the connection has been active since it was set.
+//
+// All methods are safe to call on a nil connIdleTimer, which never fires.
+type connIdleTimer struct {
+	// Unix nanoseconds, accessed atomically. Kept first to guarantee 64-bit
+	// alignment.
+	lastActive int64
+
+	clock   clock
+	timeout time.Duration
+	timer   clockTimer
+	onIdle  func()
+}
+
+// Creates a timer that doesn't run until start is called, so that onIdle may
+// refer to state that's set up after the timer is created.
+func newConnIdleTimer(clock clock, timeout time.Duration, onIdle func()) *connIdleTimer {
+	t := &connIdleTimer{
+		clock:   clock,
+		timeout: timeout,
+		onIdle:  onIdle,
+	}
+	t.timer = clock.AfterFunc(timeout, t.check)
+	t.timer.Stop()
+	return t
+}
+
+func (t *connIdleTimer) start() {
+	if t == nil {
+		return
+	}
+	atomic.StoreInt64(&t.lastActive, t.clock.Now().UnixNano())
+	t.timer.Reset(t.timeout)
+}
+
+// touch records activity on the connection.
+func (t *connIdleTimer) touch() {
+	if t == nil {
+		return
+	}
+	atomic.StoreInt64(&t.lastActive, t.clock.Now().UnixNano())
+}
+
+func (t *connIdleTimer) stop() {
+	if t == nil {
+		return
+	}
+	t.timer.Stop()
+}
+
+func (t *connIdleTimer) check() {
+	idle := t.clock.Now().Sub(time.Unix(0, atomic.LoadInt64(&t.lastActive)))
+	if idle < t.timeout {
+		t.timer.Reset(t.timeout - idle)
+		return
+	}
+	t.onIdle()
+}
+
+// Closes a connection unless it reads its first bytes within the timeout.
+//
+// All methods are safe to call on a nil handshakeTimer, which never fires.
+type handshakeTimer struct {
+	// Non-zero once the timer has either fired or been disarmed. Accessed
+	// atomically.
+	done    int32
+	timeout time.Duration
+	timer   clockTimer
+}
+
+// Creates a timer that doesn't run until start is called, so that onTimeout
+// may refer to state that's set up after the timer is created.
+func newHandshakeTimer(clock clock, timeout time.Duration, onTimeout func()) *handshakeTimer {
+	t := &handshakeTimer{timeout: timeout}
+	t.timer = clock.AfterFunc(timeout, func() {
+		if atomic.CompareAndSwapInt32(&t.done, 0, 1) {
+			onTimeout()
+		}
+	})
+	t.timer.Stop()
+	return t
+}
+
+func (t *handshakeTimer) start() {
+	if t == nil {
+		return
+	}
+	t.timer.Reset(t.timeout)
+}
+
+// received disarms the timer, once data has been read from the connection or
+// it has been closed.
+func (t *handshakeTimer) received() {
+	if t == nil {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&t.done, 0, 1) {
+		t.timer.Stop()
+	}
+}
+
+// Closes a connection once it has been open for the configured lifetime.
+//
+// All methods are safe to call on a nil lifetimeTimer, which never fires.
+type lifetimeTimer struct {
+	lifetime time.Duration
+	timer    clockTimer
+}
+
+// Creates a timer that doesn't run until start is called, so that onExpire
+// may refer to state that's set up after the timer is created.
+func newLifetimeTimer(clock clock, lifetime time.Duration, onExpire func()) *lifetimeTimer {
+	t := &lifetimeTimer{lifetime: lifetime}
+	t.timer = clock.AfterFunc(lifetime, onExpire)
+	t.timer.Stop()
+	return t
+}
+
+func (t *lifetimeTimer) start() {
+	if t == nil {
+		return
+	}
+	t.timer.Reset(t.lifetime)
+}
+
+func (t *lifetimeTimer) stop() {
+	if t == nil {
+		return
+	}
+	t.timer.Stop()
+}
@@ -0,0 +1,185 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testIdleTimeout = 50 * time.Millisecond
+
+func requireFired(t *testing.T, fired <-chan struct{}, msg string) {
+	select {
+	case <-fired:
+	case <-time.After(10 * testIdleTimeout):
+		require.FailNow(t, msg)
+	}
+}
+
+func requireNotFired(t *testing.T, fired <-chan struct{}, msg string) {
+	select {
+	case <-fired:
+		require.FailNow(t, msg)
+	case <-time.After(3 * testIdleTimeout):
+	}
+}
+
+func TestIdleTracker(t *testing.T) {
+	fired := make(chan struct{}, 1)
+	tracker := newIdleTracker(systemClock, testIdleTimeout, func() {
+		fired <- struct{}{}
+	})
+
+	tracker.acquire()
+	requireNotFired(t, fired, "tracker fired while active")
+
+	tracker.release()
+	requireFired(t, fired, "tracker never fired after becoming idle")
+}
+
+func TestIdleTrackerStop(t *testing.T) {
+	fired := make(chan struct{}, 1)
+	tracker := newIdleTracker(systemClock, testIdleTimeout, func() {
+		fired <- struct{}{}
+	})
+
+	tracker.stop()
+	requireNotFired(t, fired, "tracker fired after being stopped")
+}
+
+func TestIdleTrackerDisabled(t *testing.T) {
+	tracker := newIdleTracker(systemClock, 0, func() {
+		panic("disabled trackers should never fire")
+	})
+	require.Nil(t, tracker)
+
+	require.NotPanics(t, func() {
+		tracker.acquire()
+		tracker.release()
+		tracker.stop()
+	})
+}
+
+func TestIdleTrackerTunnel(t *testing.T) {
+	fired := make(chan struct{}, 1)
+	tracker := newIdleTracker(systemClock, testIdleTimeout, func() {
+		fired <- struct{}{}
+	})
+
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.idle = tracker
+	tracker.acquire()
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+
+	require.NoError(t, tun.Close())
+	requireNotFired(t, fired, "tracker fired with an open connection")
+
+	require.NoError(t, conn.Close())
+	_ = conn.Close() // closing twice doesn't double-count
+	requireFired(t, fired, "tracker never fired after the last connection closed")
+}
+
+func TestConnIdleTimeout(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).connIdleTimeout = testIdleTimeout
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Keep the connection alive past the timeout without sending any data.
+	deadline := time.Now().Add(3 * testIdleTimeout)
+	for time.Now().Before(deadline) {
+		conn.(interface{ Touch() }).Touch()
+		time.Sleep(testIdleTimeout / 5)
+	}
+
+	_, err = conn.Write([]byte("still here"))
+	require.NoError(t, err, "touched connections aren't closed")
+
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(10*testIdleTimeout)))
+	rest, err := io.ReadAll(client)
+	require.NoError(t, err, "idle connections are closed")
+	require.Equal(t, "still here", string(rest))
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).handshakeTimeout = testIdleTimeout
+
+	stalled, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer stalled.Close()
+	_, err = tun.Accept()
+	require.NoError(t, err)
+
+	talkative, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer talkative.Close()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = io.WriteString(talkative, "GET / HTTP/1.1\r\n")
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	require.NoError(t, err)
+
+	// Clients that never send anything are disconnected.
+	require.NoError(t, stalled.SetReadDeadline(time.Now().Add(10*testIdleTimeout)))
+	_, err = stalled.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	// Clients that did are left alone.
+	time.Sleep(3 * testIdleTimeout)
+	_, err = conn.Write([]byte("still here"))
+	require.NoError(t, err)
+	require.NoError(t, talkative.SetReadDeadline(time.Now().Add(10*testIdleTimeout)))
+	buf := make([]byte, len("still here"))
+	_, err = io.ReadFull(talkative, buf)
+	require.NoError(t, err)
+	require.Equal(t, "still here", string(buf))
+	require.Equal(t, uint64(1), tun.Describe().ConnTimeouts)
+}
+
+func TestConnMaxLifetime(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).connMaxLifetime = testIdleTimeout
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Activity doesn't keep the connection open past its lifetime.
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(10*testIdleTimeout)))
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Write([]byte("x")); err != nil {
+			break
+		}
+		_, err := client.Read(buf)
+		require.NoError(t, err)
+		time.Sleep(testIdleTimeout / 10)
+	}
+	_, err = io.ReadAll(client)
+	require.NoError(t, err, "connections are closed at the end of their lifetime")
+	require.Equal(t, uint64(1), tun.Describe().ConnTimeouts)
+}
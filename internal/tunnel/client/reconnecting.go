@@ -2,6 +2,7 @@ package client
 
 import (
 	"errors"
+	"fmt"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -15,6 +16,63 @@ import (
 
 var ErrSessionNotReady = errors.New("an ngrok tunnel session has not yet been established")
 
+// ErrReconnectAttempts is matched by the error published when a reconnecting
+// session gives up after its policy's MaxAttempts.
+var ErrReconnectAttempts = errors.New("too many failed attempts to reconnect")
+
+// The error published when a reconnecting session gives up after its policy's
+// MaxAttempts. It matches both ErrReconnectAttempts and the error that the last
+// attempt failed with.
+type errGaveUp struct {
+	attempts int
+	last     error
+}
+
+func (e errGaveUp) Error() string {
+	return fmt.Sprintf("%v: giving up after %d, the last with: %v", ErrReconnectAttempts, e.attempts, e.last)
+}
+
+func (e errGaveUp) Unwrap() error {
+	return e.last
+}
+
+func (e errGaveUp) Is(target error) bool {
+	return target == ErrReconnectAttempts
+}
+
+// StopReconnecting wraps an error returned by a ReconnectCallback, to make the
+// session give up with it rather than trying again.
+func StopReconnecting(err error) error {
+	return stopReconnecting{err}
+}
+
+type stopReconnecting struct {
+	error
+}
+
+func (e stopReconnecting) Unwrap() error {
+	return e.error
+}
+
+// ReconnectPolicy configures how a reconnecting session waits between attempts
+// to reconnect, and when it gives up.
+type ReconnectPolicy struct {
+	// The wait after the first failed attempt, which doubles after each
+	// subsequent one.
+	// Defaults to 500ms when 0.
+	MinBackoff time.Duration
+	// The longest wait between attempts.
+	// Defaults to 30s when 0.
+	MaxBackoff time.Duration
+	// The most consecutive attempts that may fail before the session gives
+	// up.
+	// Unlimited when 0.
+	MaxAttempts int
+	// Waits between attempts, so that tests can control the passage of time.
+	// Defaults to time.Sleep when nil.
+	Sleep func(time.Duration)
+}
+
 // Wraps a RawSession so that it can be safely swapped out
 type swapRaw struct {
 	raw unsafe.Pointer
@@ -95,10 +153,12 @@ func (s *swapRaw) Accept() (netx.LoggedConn, error) {
 
 type reconnectingSession struct {
 	closed       int32
+	failure      atomic.Value // failure, once the session has given up
 	dialer       RawSessionDialer
 	stateChanges chan<- error
 	clientID     string
 	cb           ReconnectCallback
+	policy       ReconnectPolicy
 	swapper      *swapRaw
 	*session
 }
@@ -120,14 +180,20 @@ type ReconnectCallback func(s Session) error
 // It is unsafe to call any functions except Close() on the returned session until
 // you receive the first callback.
 //
+// The session waits between attempts to reconnect according to the policy. If
+// it gives up, it publishes an error matching ErrReconnectAttempts before
+// closing the stateChanges channel. It also gives up when the callback returns
+// an error wrapped with StopReconnecting, publishing the wrapped error.
+//
 // If the stateChanges channel is not serviced by the caller, the
 // ReconnectingSession will hang.
-func NewReconnectingSession(logger log.Logger, dialer RawSessionDialer, stateChanges chan<- error, cb ReconnectCallback) Session {
+func NewReconnectingSession(logger log.Logger, dialer RawSessionDialer, stateChanges chan<- error, cb ReconnectCallback, policy ReconnectPolicy) Session {
 	swapper := new(swapRaw)
 	s := &reconnectingSession{
 		dialer:       dialer,
 		stateChanges: stateChanges,
 		cb:           cb,
+		policy:       policy,
 		swapper:      swapper,
 		session: &session{
 			tunnels: make(map[string]*tunnel),
@@ -148,6 +214,18 @@ func NewReconnectingSession(logger log.Logger, dialer RawSessionDialer, stateCha
 	return s
 }
 
+// The error that a reconnecting session gave up with.
+type failure struct {
+	err error
+}
+
+// Err returns the error that the session gave up reconnecting with, or nil if
+// it hasn't.
+func (s *reconnectingSession) Err() error {
+	f, _ := s.failure.Load().(failure)
+	return f.err
+}
+
 func (s *reconnectingSession) Close() error {
 	atomic.StoreInt32(&s.closed, 1)
 	return s.session.Close()
@@ -201,8 +279,24 @@ func (s *reconnectingSession) connect(acceptErr error) error {
 		Factor: 2,
 		Jitter: false,
 	}
+	if s.policy.MinBackoff != 0 {
+		boff.Min = s.policy.MinBackoff
+	}
+	if s.policy.MaxBackoff != 0 {
+		boff.Max = s.policy.MaxBackoff
+	}
+	attempts := 0
+
+	failPermanent := func(err error) error {
+		// Recorded before the tunnels are closed, so that they can tell why.
+		s.failure.Store(failure{err})
+		s.stateChanges <- err
+		close(s.stateChanges)
+		return err
+	}
 
-	failTemp := func(err error, raw RawSession) {
+	// returns a non-nil error if the session has given up
+	failTemp := func(err error, raw RawSession) error {
 		s.Error("failed to reconnect session", "err", err)
 		s.stateChanges <- err
 
@@ -211,59 +305,19 @@ func (s *reconnectingSession) connect(acceptErr error) error {
 			raw.Close()
 		}
 
+		attempts++
+		if s.policy.MaxAttempts > 0 && attempts >= s.policy.MaxAttempts {
+			return failPermanent(errGaveUp{attempts, err})
+		}
+
 		// session failed, wait before reconnecting
 		wait := boff.Duration()
 		s.Debug("sleep before reconnect", "secs", int(wait.Seconds()))
-		time.Sleep(wait)
-	}
-
-	failPermanent := func(err error) error {
-		s.stateChanges <- err
-		close(s.stateChanges)
-		return err
-	}
-
-	restartBinds := func(raw RawSession) (err error) {
-		s.Lock()
-		defer s.Unlock()
-
-		// reconnected tunnels, which may have different IDs
-		newTunnels := make(map[string]*tunnel, len(s.tunnels))
-		for oldID, t := range s.tunnels {
-			// set the returned token for reconnection
-			tCfg := t.RemoteBindConfig()
-			t.bindExtra.Token = tCfg.Token
-
-			var respErr string
-			if tCfg.Labels != nil {
-				resp, err := raw.ListenLabel(tCfg.Labels, tCfg.Metadata, t.ForwardsTo())
-				if err != nil {
-					return err
-				}
-				respErr = resp.Error
-				if resp.ID != "" {
-					t.id.Store(resp.ID)
-					newTunnels[resp.ID] = t
-				} else {
-					// Otherwise save the old tunnel I guess? Maybe next reconnect gets it?
-					// This doesn't seem quite right though...
-					newTunnels[oldID] = t
-				}
-			} else {
-				resp, err := raw.Listen(tCfg.ConfigProto, tCfg.Opts, t.bindExtra, t.ID(), t.ForwardsTo())
-				if err != nil {
-					return err
-				}
-				respErr = resp.Error
-				// same ID, no need to change
-				newTunnels[oldID] = t
-			}
-
-			if respErr != "" {
-				return errors.New(respErr)
-			}
+		sleep := s.policy.Sleep
+		if sleep == nil {
+			sleep = time.Sleep
 		}
-		s.tunnels = newTunnels
+		sleep(wait)
 		return nil
 	}
 
@@ -286,7 +340,9 @@ func (s *reconnectingSession) connect(acceptErr error) error {
 		// dial the tunnel server
 		raw, err := s.dialer()
 		if err != nil {
-			failTemp(err, raw)
+			if gaveUp := failTemp(err, raw); gaveUp != nil {
+				return gaveUp
+			}
 			continue
 		}
 
@@ -295,14 +351,23 @@ func (s *reconnectingSession) connect(acceptErr error) error {
 
 		// callback for authentication
 		if err := s.cb(s); err != nil {
-			failTemp(err, raw)
+			var stop stopReconnecting
+			if errors.As(err, &stop) {
+				raw.Close()
+				return failPermanent(stop.error)
+			}
+			if gaveUp := failTemp(err, raw); gaveUp != nil {
+				return gaveUp
+			}
 			continue
 		}
 
 		// re-establish binds
-		err = restartBinds(raw)
+		err = s.restartBinds(raw)
 		if err != nil {
-			failTemp(err, raw)
+			if gaveUp := failTemp(err, raw); gaveUp != nil {
+				return gaveUp
+			}
 			continue
 		}
 
@@ -314,3 +379,61 @@ func (s *reconnectingSession) connect(acceptErr error) error {
 		return nil
 	}
 }
+
+// Binds the session's tunnels again on a new raw session, which may give them
+// new IDs.
+func (s *reconnectingSession) restartBinds(raw RawSession) error {
+	// Rebinds that are under way finish first, so that none lists its tunnel
+	// under an ID from the old raw session once this is done.
+	unlock := s.lockTunnels()
+	defer unlock()
+
+	// reconnected tunnels, which may have different IDs
+	newTunnels := make(map[string]*tunnel, len(s.tunnels))
+	rebound := make(map[*tunnel]bool, len(s.tunnels))
+	for oldID, t := range s.tunnels {
+		// a tunnel is bound once, even if it's listed under more than one
+		// ID
+		if rebound[t] {
+			continue
+		}
+		rebound[t] = true
+
+		// set the returned token for reconnection
+		tCfg := t.RemoteBindConfig()
+		t.bindExtra.Token = tCfg.Token
+
+		var respErr string
+		if tCfg.Labels != nil {
+			resp, err := raw.ListenLabel(tCfg.Labels, tCfg.Metadata, t.ForwardsTo())
+			if err != nil {
+				return err
+			}
+			respErr = resp.Error
+			if resp.ID != "" {
+				t.id.Store(resp.ID)
+				newTunnels[resp.ID] = t
+			} else {
+				// Otherwise save the old tunnel I guess? Maybe next reconnect gets it?
+				// This doesn't seem quite right though...
+				newTunnels[oldID] = t
+			}
+		} else {
+			resp, err := raw.Listen(tCfg.ConfigProto, tCfg.Opts, t.bindExtra, t.ID(), t.ForwardsTo())
+			if err != nil {
+				return err
+			}
+			respErr = resp.Error
+			// same ID, no need to change
+			newTunnels[oldID] = t
+		}
+
+		if respErr != "" {
+			return errors.New(respErr)
+		}
+	}
+	s.tunnels = newTunnels
+	// the previous bindings of rebound tunnels went with the old session
+	s.retiring = nil
+	return nil
+}
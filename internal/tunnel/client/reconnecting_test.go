@@ -0,0 +1,90 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconnectMaxAttempts(t *testing.T) {
+	dialErr := errors.New("connection refused")
+	dials := 0
+	dialer := func() (RawSession, error) {
+		dials++
+		return nil, dialErr
+	}
+
+	var waits []time.Duration
+	stateChanges := make(chan error, 32)
+	sess := NewReconnectingSession(log15.New(), dialer, stateChanges, nil, ReconnectPolicy{
+		MinBackoff:  time.Second,
+		MaxBackoff:  3 * time.Second,
+		MaxAttempts: 3,
+		Sleep: func(d time.Duration) {
+			waits = append(waits, d)
+		},
+	})
+	defer sess.Close()
+
+	var errs []error
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case err, ok := <-stateChanges:
+			if ok {
+				errs = append(errs, err)
+				continue
+			}
+		case <-timeout:
+			require.FailNow(t, "session never gave up")
+		}
+		break
+	}
+
+	require.Len(t, errs, 4)
+	for _, err := range errs[:3] {
+		require.ErrorIs(t, err, dialErr)
+	}
+	require.ErrorIs(t, errs[3], ErrReconnectAttempts)
+	require.ErrorIs(t, errs[3], dialErr, "the last attempt's error is kept")
+	require.Contains(t, errs[3].Error(), dialErr.Error())
+	require.Equal(t, 3, dials)
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second}, waits, "the backoff waits on the policy's Sleep")
+	require.ErrorIs(t, sess.(*reconnectingSession).Err(), ErrReconnectAttempts, "the session records why it gave up")
+}
+
+func TestReconnectStopped(t *testing.T) {
+	authErr := errors.New("authtoken revoked")
+	calls := 0
+	cb := func(Session) error {
+		calls++
+		return StopReconnecting(authErr)
+	}
+	dialer := func() (RawSession, error) {
+		return closeRawSession{}, nil
+	}
+
+	stateChanges := make(chan error, 32)
+	sess := NewReconnectingSession(log15.New(), dialer, stateChanges, cb, ReconnectPolicy{
+		Sleep: func(time.Duration) {},
+	})
+	defer sess.Close()
+
+	var errs []error
+	for err := range stateChanges {
+		errs = append(errs, err)
+	}
+	require.Equal(t, []error{authErr}, errs, "the session gives up with the callback's error")
+	require.Equal(t, 1, calls)
+	require.ErrorIs(t, sess.(*reconnectingSession).Err(), authErr)
+}
+
+// A RawSession that can only be closed.
+type closeRawSession struct {
+	RawSession
+}
+
+func (closeRawSession) Close() error { return nil }
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"strconv"
@@ -77,6 +78,12 @@ type session struct {
 	sync.RWMutex
 	log.Logger
 	tunnels map[string]*tunnel
+	// previous IDs of rebound tunnels, whose connections are still delivered
+	// until they're unbound
+	retiring map[string]*tunnel
+	// read locked while tunnels are being rebound, so that connections for
+	// their new IDs that arrive before the binds return can wait for them
+	rebinding sync.RWMutex
 }
 
 // NewSession starts a new go-tunnel client session running over the given
@@ -210,14 +217,14 @@ func (s *session) handleProxy(proxy netx.LoggedConn) {
 
 	// read out the proxy header
 	var proxyHdr proto.ProxyHeader
-	err := ReadProxyHeader(proxy, &proxyHdr)
+	raw, err := readProxyHeader(proxy, &proxyHdr, true)
 	if err != nil {
 		proxyError("error reading proxy header", "err", err)
 		return
 	}
 
 	// find tunnel
-	tunnel, ok := s.getTunnel(proxyHdr.ID)
+	tunnel, ok := s.findTunnel(proxyHdr.ID)
 	if !ok {
 		proxyError("no tunnel found for proxy", "id", proxyHdr.ID)
 		return
@@ -226,19 +233,68 @@ func (s *session) handleProxy(proxy netx.LoggedConn) {
 	tunnel.shut.RLock()
 	defer tunnel.shut.RUnlock()
 	// deliver proxy connection + wrap it so it has a proper RemoteAddr()
-	tunnel.handleConn(newProxyConn(proxy, proxyHdr))
+	pconn := newProxyConn(proxy, proxyHdr)
+	pconn.RawHeader = raw
+	tunnel.handleConn(pconn)
+}
+
+// The largest proxy header that we're willing to read. Real headers are a
+// couple hundred bytes.
+const maxProxyHeaderSize = 64 * 1024
+
+// Buffers for reading proxy headers, which are read once per connection.
+// Buffers grown beyond proxyHeaderPoolCap aren't returned to the pool.
+const proxyHeaderPoolCap = 4 * 1024
+
+var proxyHeaderBufs = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 512)
+		return &buf
+	},
 }
 
 // Public so we can use it in lib/tunnel/server/functional_test.go
 func ReadProxyHeader(proxy netx.LoggedConn, header *proto.ProxyHeader) error {
-	var sz int64
-	err := binary.Read(proxy, binary.LittleEndian, &sz)
-	if err != nil {
-		return err
+	_, err := readProxyHeader(proxy, header, false)
+	return err
+}
+
+// Reads and decodes a proxy header. If keepRaw is set, a copy of the header
+// exactly as it was read, length prefix included, is also returned.
+func readProxyHeader(proxy netx.LoggedConn, header *proto.ProxyHeader, keepRaw bool) ([]byte, error) {
+	bufp := proxyHeaderBufs.Get().(*[]byte)
+	defer func() {
+		if cap(*bufp) <= proxyHeaderPoolCap {
+			proxyHeaderBufs.Put(bufp)
+		}
+	}()
+
+	szBuf := (*bufp)[:8]
+	if _, err := io.ReadFull(proxy, szBuf); err != nil {
+		return nil, err
+	}
+	sz := int64(binary.LittleEndian.Uint64(szBuf))
+	if sz < 0 || sz > maxProxyHeaderSize {
+		return nil, fmt.Errorf("invalid proxy header size: %d", sz)
+	}
+
+	if int64(cap(*bufp)) < 8+sz {
+		grown := make([]byte, 8+sz)
+		copy(grown, szBuf)
+		*bufp = grown
+	}
+	buf := (*bufp)[:8+sz]
+	if _, err := io.ReadFull(proxy, buf[8:]); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(buf[8:], header); err != nil {
+		return nil, err
 	}
 
-	dec := json.NewDecoder(io.LimitReader(proxy, sz))
-	return dec.Decode(&header)
+	if !keepRaw {
+		return nil, nil
+	}
+	return append([]byte(nil), buf...), nil
 }
 
 func (s *session) unlisten(bindID string) error {
@@ -260,10 +316,104 @@ func (s *session) unlisten(bindID string) error {
 	return nil
 }
 
+// Binds the labeled tunnel again with the new labels and metadata, and then
+// removes its previous binding. Connections for both are delivered to the
+// tunnel until the previous one is gone. Callers hold the tunnel's rebindMu.
+func (s *session) rebind(t *tunnel, labels map[string]string, metadata string) error {
+	s.rebinding.RLock()
+	resp, err := s.raw.ListenLabel(labels, metadata, t.forwardsTo)
+	if err != nil {
+		s.rebinding.RUnlock()
+		return err
+	}
+	if resp.Error != "" {
+		s.rebinding.RUnlock()
+		return errors.New(resp.Error)
+	}
+
+	// The tunnel is only ever listed under one ID, so that reconnecting binds
+	// it once.
+	oldID := t.ID()
+	s.Lock()
+	t.id.Store(resp.ID)
+	t.labels.Store(labels)
+	t.metadata.Store(metadata)
+	delete(s.tunnels, oldID)
+	s.tunnels[resp.ID] = t
+	if s.retiring == nil {
+		s.retiring = make(map[string]*tunnel)
+	}
+	s.retiring[oldID] = t
+	s.Unlock()
+	s.rebinding.RUnlock()
+
+	unbindResp, err := s.raw.Unlisten(oldID)
+	s.Lock()
+	delete(s.retiring, oldID)
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+	if unbindResp.Error != "" {
+		err = errors.New(unbindResp.Error)
+		s.Error("server failed to unlisten previous labels", "err", err)
+		return err
+	}
+	return nil
+}
+
+// Locks the rebindMu of every labeled tunnel, and then the session, so that
+// none of the tunnels is halfway through a rebind. The rebindMus are taken
+// without the session locked, since rebinds lock it while holding theirs.
+// Returns a function that unlocks them all.
+func (s *session) lockTunnels() (unlock func()) {
+	held := make(map[*tunnel]bool)
+	for {
+		s.Lock()
+		var unheld []*tunnel
+		for _, t := range s.tunnels {
+			if t.rebind != nil && !held[t] {
+				unheld = append(unheld, t)
+			}
+		}
+		if len(unheld) == 0 {
+			return func() {
+				s.Unlock()
+				for t := range held {
+					t.rebindMu.Unlock()
+				}
+			}
+		}
+		// Tunnels may be added while the session is unlocked, so it's
+		// checked again.
+		s.Unlock()
+		for _, t := range unheld {
+			t.rebindMu.Lock()
+			held[t] = true
+		}
+	}
+}
+
+// Finds the tunnel that a proxy connection is for. If there's none with its
+// ID, it may be for a binding that the server has established, but whose
+// response hasn't been handled yet, so it waits for any rebinds to finish and
+// looks again.
+func (s *session) findTunnel(id string) (*tunnel, bool) {
+	if t, ok := s.getTunnel(id); ok {
+		return t, ok
+	}
+	s.rebinding.Lock()
+	defer s.rebinding.Unlock()
+	return s.getTunnel(id)
+}
+
 func (s *session) getTunnel(id string) (t *tunnel, ok bool) {
 	s.RLock()
 	defer s.RUnlock()
 	t, ok = s.tunnels[id]
+	if !ok {
+		t, ok = s.retiring[id]
+	}
 	return
 }
 
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/inconshreveable/log15/v3"
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/netx"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+// A net.Conn that reads from a buffer.
+type bufferConn struct {
+	net.Conn
+	*bytes.Reader
+}
+
+func (c *bufferConn) Read(p []byte) (int, error) {
+	return c.Reader.Read(p)
+}
+
+func encodeProxyHeader(t testing.TB, hdr proto.ProxyHeader) []byte {
+	buf, err := json.Marshal(hdr)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, binary.Write(&out, binary.LittleEndian, int64(len(buf))))
+	out.Write(buf)
+	return out.Bytes()
+}
+
+func proxyHeaderConn(raw []byte) netx.LoggedConn {
+	return netx.NewLoggedConn(log15.New(), &bufferConn{Reader: bytes.NewReader(raw)})
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	long := proto.ProxyHeader{
+		ID:             "tun_123",
+		ClientAddr:     "203.0.113.7:54321",
+		Proto:          "https",
+		EdgeType:       "https",
+		PassthroughTLS: true,
+	}
+	short := proto.ProxyHeader{
+		ID:    "tun_456",
+		Proto: "tcp",
+	}
+
+	// Read a long header followed by a short one, so that a reused buffer
+	// would leak the tail of the first into the second.
+	for _, expected := range []proto.ProxyHeader{long, short} {
+		var actual proto.ProxyHeader
+		require.NoError(t, ReadProxyHeader(proxyHeaderConn(encodeProxyHeader(t, expected)), &actual))
+		require.Equal(t, expected, actual)
+	}
+}
+
+func TestReadProxyHeaderRaw(t *testing.T) {
+	raw := encodeProxyHeader(t, proto.ProxyHeader{ID: "tun_123", ClientAddr: "203.0.113.7:54321"})
+
+	var hdr proto.ProxyHeader
+	actual, err := readProxyHeader(proxyHeaderConn(raw), &hdr, true)
+	require.NoError(t, err)
+	require.Equal(t, raw, actual)
+
+	actual, err = readProxyHeader(proxyHeaderConn(raw), &hdr, false)
+	require.NoError(t, err)
+	require.Nil(t, actual)
+}
+
+func TestReadProxyHeaderConsumesHeader(t *testing.T) {
+	raw := append(encodeProxyHeader(t, proto.ProxyHeader{ID: "tun_123"}), "payload"...)
+	conn := proxyHeaderConn(raw)
+
+	var hdr proto.ProxyHeader
+	require.NoError(t, ReadProxyHeader(conn, &hdr))
+
+	rest := make([]byte, len("payload"))
+	_, err := conn.Read(rest)
+	require.NoError(t, err)
+	require.Equal(t, "payload", string(rest), "only the header is consumed")
+}
+
+func TestReadProxyHeaderInvalidSize(t *testing.T) {
+	for _, sz := range []int64{-1, maxProxyHeaderSize + 1} {
+		var raw bytes.Buffer
+		require.NoError(t, binary.Write(&raw, binary.LittleEndian, sz))
+
+		var hdr proto.ProxyHeader
+		require.Error(t, ReadProxyHeader(proxyHeaderConn(raw.Bytes()), &hdr))
+	}
+}
+
+func BenchmarkReadProxyHeader(b *testing.B) {
+	raw := encodeProxyHeader(b, proto.ProxyHeader{
+		ID:         "tun_123",
+		ClientAddr: "203.0.113.7:54321",
+		Proto:      "https",
+		EdgeType:   "https",
+	})
+	reader := bytes.NewReader(raw)
+	conn := netx.NewLoggedConn(log15.New(), &bufferConn{Reader: reader})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader.Reset(raw)
+		var hdr proto.ProxyHeader
+		if err := ReadProxyHeader(conn, &hdr); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package client
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
 	"net/url"
+	"sync"
 	"sync/atomic"
 
 	"golang.ngrok.com/ngrok/internal/tunnel/proto"
@@ -13,14 +16,27 @@ type Tunnel interface {
 	Accept() (*ProxyConn, error)
 	Addr() net.Addr
 	Close() error
+	// CloseWithContext closes the Tunnel, giving up on waiting for the
+	// remote machine to deallocate its listener once the context is done.
+	CloseWithContext(ctx context.Context) error
 	RemoteBindConfig() *RemoteBindConfig
 	ID() string
 	ForwardsTo() string
+	// SetLabels replaces the labels of a labeled tunnel without closing it.
+	SetLabels(labels map[string]string) error
+	// SetMetadata replaces the metadata of a labeled tunnel without closing
+	// it.
+	SetMetadata(metadata string) error
 }
 
+var ErrNotLabeled = errors.New("only labeled tunnels have labels to set")
+
 type ProxyConn struct {
 	Header proto.ProxyHeader
 	Conn   net.Conn
+	// The header exactly as it was sent by the server, including its length
+	// prefix. May be empty.
+	RawHeader []byte
 }
 
 // A Tunnel is a net.Listener that Accept()'s connections from a
@@ -32,11 +48,17 @@ type tunnel struct {
 	opts        any
 	token       string
 	bindExtra   proto.BindExtra
-	labels      map[string]string
+	labels      atomic.Value // map[string]string, for labeled tunnels
+	metadata    atomic.Value // string, replaced when labeled tunnels are rebound
 	forwardsTo  string
 
 	accept   chan *ProxyConn // new connections come on this channel
 	unlisten func() error    // call this function to close the tunnel
+	// call this function to rebind a labeled tunnel. nil for other tunnels.
+	rebind func(labels map[string]string, metadata string) error
+	// held while a labeled tunnel is rebound or closed, so that they happen
+	// one at a time, and each starts from the binding the last one left
+	rebindMu sync.Mutex
 
 	shut shutdown // for clean shutdowns
 }
@@ -44,7 +66,7 @@ type tunnel struct {
 func newTunnel(resp proto.BindResp, extra proto.BindExtra, s *session, forwardsTo string) *tunnel {
 	id := atomic.Value{}
 	id.Store(resp.ClientID)
-	return &tunnel{
+	t := &tunnel{
 		id:          id,
 		configProto: resp.Proto,
 		url:         resp.URL,
@@ -55,21 +77,29 @@ func newTunnel(resp proto.BindResp, extra proto.BindExtra, s *session, forwardsT
 		unlisten:    func() error { return s.unlisten(resp.ClientID) },
 		forwardsTo:  forwardsTo,
 	}
+	t.metadata.Store(extra.Metadata)
+	return t
 }
 
 func newTunnelLabel(resp proto.StartTunnelWithLabelResp, metadata string, labels map[string]string, s *session, forwardsTo string) *tunnel {
-	id := atomic.Value{}
-	id.Store(resp.ID)
-	return &tunnel{
-		id: id,
+	t := &tunnel{
 		bindExtra: proto.BindExtra{
 			Metadata: metadata,
 		}, // this makes the reconnecting session a little easier
-		labels:     labels,
 		accept:     make(chan *ProxyConn),
-		unlisten:   func() error { return s.unlisten(resp.ID) },
 		forwardsTo: forwardsTo,
 	}
+	t.id.Store(resp.ID)
+	t.labels.Store(labels)
+	t.metadata.Store(metadata)
+	// the ID changes when the tunnel is rebound with new labels
+	t.unlisten = func() error {
+		t.rebindMu.Lock()
+		defer t.rebindMu.Unlock()
+		return s.unlisten(t.ID())
+	}
+	t.rebind = func(labels map[string]string, metadata string) error { return s.rebind(t, labels, metadata) }
+	return t
 }
 
 func (t *tunnel) handleConn(r *ProxyConn) {
@@ -79,20 +109,36 @@ func (t *tunnel) handleConn(r *ProxyConn) {
 }
 
 // Accept returns the next available connection from a remote machine, or an
-// error if the tunnel closes.
+// error if the tunnel closes. The error returned after the tunnel closes wraps
+// net.ErrClosed, just like a net.Listener's.
 func (t *tunnel) Accept() (*ProxyConn, error) {
 	conn, ok := <-t.accept
 	if !ok {
-		return nil, errors.New("Tunnel closed")
+		return nil, fmt.Errorf("Tunnel closed: %w", net.ErrClosed)
 	}
 	return conn, nil
 }
 
 // Closes the Tunnel by asking the remote machine to deallocate its listener, or
 // an error if the request failed.
-func (t *tunnel) Close() (err error) {
+func (t *tunnel) Close() error {
+	return t.CloseWithContext(context.Background())
+}
+
+// Closes the Tunnel like Close, but stops waiting for the remote machine's
+// response once the context is done, returning the context's error. The
+// Tunnel stops accepting connections either way.
+func (t *tunnel) CloseWithContext(ctx context.Context) (err error) {
 	t.shut.Shut(func() {
-		err = t.unlisten()
+		unlistened := make(chan error, 1)
+		go func() {
+			unlistened <- t.unlisten()
+		}()
+		select {
+		case err = <-unlistened:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
 		close(t.accept)
 	})
 	return
@@ -114,6 +160,40 @@ func (t *tunnel) ID() string {
 	return t.id.Load().(string)
 }
 
+func (t *tunnel) getLabels() map[string]string {
+	labels, _ := t.labels.Load().(map[string]string)
+	return labels
+}
+
+// SetLabels rebinds a labeled tunnel with new labels. The new binding is
+// established before the old one is removed, so that connections keep
+// arriving throughout.
+func (t *tunnel) SetLabels(labels map[string]string) error {
+	if t.rebind == nil {
+		return ErrNotLabeled
+	}
+	t.rebindMu.Lock()
+	defer t.rebindMu.Unlock()
+	return t.rebind(labels, t.getMetadata())
+}
+
+func (t *tunnel) getMetadata() string {
+	metadata, _ := t.metadata.Load().(string)
+	return metadata
+}
+
+// SetMetadata rebinds a labeled tunnel with new metadata, as SetLabels does
+// with new labels. The metadata of other tunnels is only sent when they're
+// first bound, so it can't be changed.
+func (t *tunnel) SetMetadata(metadata string) error {
+	if t.rebind == nil {
+		return ErrNotLabeled
+	}
+	t.rebindMu.Lock()
+	defer t.rebindMu.Unlock()
+	return t.rebind(t.getLabels(), metadata)
+}
+
 // RemoteBindConfig returns more detailed information about the public endpoint of the
 // tunnel listener on the remote machine.
 func (t *tunnel) RemoteBindConfig() *RemoteBindConfig {
@@ -122,8 +202,8 @@ func (t *tunnel) RemoteBindConfig() *RemoteBindConfig {
 		ConfigProto: t.configProto,
 		Opts:        t.opts,
 		Token:       t.token,
-		Metadata:    t.bindExtra.Metadata,
-		Labels:      t.labels,
+		Metadata:    t.getMetadata(),
+		Labels:      t.getLabels(),
 	}
 }
 
@@ -143,7 +223,9 @@ func (a *RemoteBindConfig) Network() string {
 func (a *RemoteBindConfig) String() string {
 	u, err := url.Parse(a.URL)
 	if err != nil {
-		panic(err)
+		// Addr() is called by plenty of code that isn't expecting it to
+		// fail, so fall back to the raw URL rather than panicking.
+		return a.URL
 	}
 	return u.Host
 }
@@ -0,0 +1,277 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+func TestTunnelAcceptAfterClose(t *testing.T) {
+	tun := &tunnel{
+		accept:   make(chan *ProxyConn),
+		unlisten: func() error { return nil },
+	}
+
+	require.NoError(t, tun.Close())
+
+	_, err := tun.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestTunnelCloseWithContext(t *testing.T) {
+	stuck := make(chan struct{})
+	defer close(stuck)
+	tun := &tunnel{
+		accept: make(chan *ProxyConn),
+		unlisten: func() error {
+			<-stuck
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, tun.CloseWithContext(ctx), context.Canceled)
+
+	// The tunnel is closed despite the remote never replying.
+	_, err := tun.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestRemoteBindConfigString(t *testing.T) {
+	cfg := &RemoteBindConfig{URL: "https://example.ngrok.io"}
+	require.Equal(t, "example.ngrok.io", cfg.String())
+
+	cfg = &RemoteBindConfig{}
+	require.Equal(t, "", cfg.String(), "labeled tunnels have no URL")
+
+	cfg = &RemoteBindConfig{URL: "://not a url"}
+	require.NotPanics(t, func() {
+		_ = cfg.String()
+	})
+}
+
+func TestTunnelForwardsTo(t *testing.T) {
+	tun := newTunnel(proto.BindResp{}, proto.BindExtra{}, nil, "my-service")
+	require.Equal(t, "my-service", tun.ForwardsTo())
+
+	tun = newTunnelLabel(proto.StartTunnelWithLabelResp{}, "", map[string]string{"edge": "edghts_123"}, nil, "my-service")
+	require.Equal(t, "my-service", tun.ForwardsTo())
+}
+
+// A RawSession that binds labeled tunnels with sequential IDs, and records the
+// IDs that it's asked to unbind.
+type labelRawSession struct {
+	RawSession
+	mu        sync.Mutex
+	binds     int
+	unlistens []string
+	metadata  []string
+}
+
+func (s *labelRawSession) ListenLabel(_ map[string]string, metadata string, _ string) (proto.StartTunnelWithLabelResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.binds++
+	s.metadata = append(s.metadata, metadata)
+	return proto.StartTunnelWithLabelResp{ID: fmt.Sprintf("tun_%d", s.binds)}, nil
+}
+
+func (s *labelRawSession) Unlisten(id string) (proto.UnbindResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unlistens = append(s.unlistens, id)
+	return proto.UnbindResp{}, nil
+}
+
+func TestTunnelSetLabels(t *testing.T) {
+	raw := &labelRawSession{}
+	sess := &session{
+		raw:     raw,
+		Logger:  log15.New(),
+		tunnels: make(map[string]*tunnel),
+	}
+
+	tun, err := sess.ListenLabel(map[string]string{"deployment": "blue"}, "", "")
+	require.NoError(t, err)
+	require.Equal(t, "tun_1", tun.ID())
+
+	require.NoError(t, tun.SetLabels(map[string]string{"deployment": "green"}))
+	require.Equal(t, "tun_2", tun.ID())
+	require.Equal(t, map[string]string{"deployment": "green"}, tun.RemoteBindConfig().Labels)
+	require.Equal(t, []string{"tun_1"}, raw.unlistens, "the old binding is removed")
+
+	_, ok := sess.getTunnel("tun_1")
+	require.False(t, ok)
+	found, ok := sess.getTunnel("tun_2")
+	require.True(t, ok)
+	require.Same(t, tun, found, "connections for the new binding reach the same tunnel")
+
+	require.NoError(t, tun.Close())
+	require.Equal(t, []string{"tun_1", "tun_2"}, raw.unlistens, "closing unbinds the current ID")
+
+	unlabeled := &tunnel{}
+	require.ErrorIs(t, unlabeled.SetLabels(map[string]string{"a": "b"}), ErrNotLabeled)
+}
+
+func TestTunnelSetLabelsConcurrent(t *testing.T) {
+	raw := &labelRawSession{}
+	sess := &session{
+		raw:     raw,
+		Logger:  log15.New(),
+		tunnels: make(map[string]*tunnel),
+	}
+
+	tun, err := sess.ListenLabel(map[string]string{"deployment": "blue"}, "", "")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, tun.SetLabels(map[string]string{"deployment": fmt.Sprint(i)}))
+		}(i)
+	}
+	wg.Wait()
+
+	// Each rebind removes the binding that the one before it left.
+	want := make([]string, 10)
+	for i := range want {
+		want[i] = fmt.Sprintf("tun_%d", i+1)
+	}
+	require.Equal(t, want, raw.unlistens)
+	require.Equal(t, "tun_11", tun.ID())
+	require.Equal(t, map[string]*tunnel{"tun_11": tun.(*tunnel)}, sess.tunnels)
+	require.Empty(t, sess.retiring)
+}
+
+// A labelRawSession whose binds don't return until release is closed.
+type slowLabelRawSession struct {
+	*labelRawSession
+	bound   chan struct{}
+	release chan struct{}
+}
+
+func (s *slowLabelRawSession) ListenLabel(labels map[string]string, metadata string, forwardsTo string) (proto.StartTunnelWithLabelResp, error) {
+	resp, err := s.labelRawSession.ListenLabel(labels, metadata, forwardsTo)
+	s.bound <- struct{}{}
+	<-s.release
+	return resp, err
+}
+
+func TestTunnelSetLabelsEarlyConn(t *testing.T) {
+	raw := &labelRawSession{}
+	sess := &session{
+		raw:     raw,
+		Logger:  log15.New(),
+		tunnels: make(map[string]*tunnel),
+	}
+	tun, err := sess.ListenLabel(map[string]string{"deployment": "blue"}, "", "")
+	require.NoError(t, err)
+
+	slow := &slowLabelRawSession{raw, make(chan struct{}), make(chan struct{})}
+	sess.raw = slow
+	done := make(chan error, 1)
+	go func() {
+		done <- tun.SetLabels(map[string]string{"deployment": "green"})
+	}()
+	<-slow.bound
+
+	// Connections for the new binding that arrive before the bind returns
+	// wait for it, rather than being dropped.
+	found := make(chan *tunnel, 1)
+	go func() {
+		t, _ := sess.findTunnel("tun_2")
+		found <- t
+	}()
+	select {
+	case <-found:
+		require.FailNow(t, "found before the bind returned")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(slow.release)
+	require.Same(t, tun, <-found)
+	require.NoError(t, <-done)
+
+	_, ok := sess.findTunnel("tun_3")
+	require.False(t, ok)
+}
+
+func TestTunnelSetMetadata(t *testing.T) {
+	raw := &labelRawSession{}
+	sess := &session{
+		raw:     raw,
+		Logger:  log15.New(),
+		tunnels: make(map[string]*tunnel),
+	}
+
+	tun, err := sess.ListenLabel(map[string]string{"deployment": "blue"}, "v1", "")
+	require.NoError(t, err)
+	require.Equal(t, "v1", tun.RemoteBindConfig().Metadata)
+
+	require.NoError(t, tun.SetMetadata("v2"))
+	require.Equal(t, "tun_2", tun.ID())
+	require.Equal(t, "v2", tun.RemoteBindConfig().Metadata)
+	require.Equal(t, map[string]string{"deployment": "blue"}, tun.RemoteBindConfig().Labels, "the labels are kept")
+	require.Equal(t, []string{"tun_1"}, raw.unlistens, "the old binding is removed")
+
+	// Relabeling keeps the new metadata.
+	require.NoError(t, tun.SetLabels(map[string]string{"deployment": "green"}))
+	require.Equal(t, []string{"v1", "v2", "v2"}, raw.metadata)
+
+	unlabeled := newTunnel(proto.BindResp{}, proto.BindExtra{Metadata: "v1"}, nil, "")
+	require.ErrorIs(t, unlabeled.SetMetadata("v2"), ErrNotLabeled)
+	require.Equal(t, "v1", unlabeled.RemoteBindConfig().Metadata)
+}
+
+func TestTunnelSetLabelsDuringReconnect(t *testing.T) {
+	raw := &labelRawSession{}
+	sess := &reconnectingSession{
+		session: &session{
+			raw:     raw,
+			Logger:  log15.New(),
+			tunnels: make(map[string]*tunnel),
+		},
+	}
+	tun, err := sess.ListenLabel(map[string]string{"deployment": "blue"}, "", "")
+	require.NoError(t, err)
+
+	slow := &slowLabelRawSession{raw, make(chan struct{}), make(chan struct{})}
+	sess.raw = slow
+	done := make(chan error, 1)
+	go func() {
+		done <- tun.SetLabels(map[string]string{"deployment": "green"})
+	}()
+	<-slow.bound
+
+	// Reconnecting waits for the rebind, rather than having it list the
+	// tunnel under an ID from the old raw session afterwards.
+	reconnected := &labelRawSession{binds: 100}
+	restarted := make(chan error, 1)
+	go func() {
+		restarted <- sess.restartBinds(reconnected)
+	}()
+	select {
+	case <-restarted:
+		require.FailNow(t, "restarted before the rebind returned")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(slow.release)
+	require.NoError(t, <-done)
+	require.NoError(t, <-restarted)
+
+	require.Equal(t, "tun_101", tun.ID())
+	require.Equal(t, map[string]*tunnel{"tun_101": tun.(*tunnel)}, sess.tunnels)
+	require.Empty(t, sess.retiring)
+	require.Equal(t, map[string]string{"deployment": "green"}, tun.RemoteBindConfig().Labels, "the new session binds the new labels")
+}
@@ -203,6 +203,7 @@ type HTTPEndpoint struct {
 	Auth              string
 	HostHeaderRewrite bool   // true if the request's host header is being rewritten
 	LocalURLScheme    string // scheme of the local forward
+	AppProtocol       string `json:",omitempty"` // protocol spoken to the local forward, "http1" or "http2"
 	ProxyProto
 
 	// middleware
@@ -217,6 +218,9 @@ type HTTPEndpoint struct {
 	RequestHeaders        *pb.MiddlewareConfiguration_Headers
 	ResponseHeaders       *pb.MiddlewareConfiguration_Headers
 	WebsocketTCPConverter *pb.MiddlewareConfiguration_WebsocketTCPConverter
+
+	// Traffic Policy document, as JSON
+	TrafficPolicy string `json:",omitempty"`
 }
 
 type TCPEndpoint struct {
@@ -225,6 +229,9 @@ type TCPEndpoint struct {
 
 	// middleware
 	IPRestriction *pb.MiddlewareConfiguration_IPRestriction
+
+	// Traffic Policy document, as JSON
+	TrafficPolicy string `json:",omitempty"`
 }
 
 type TLSEndpoint struct {
@@ -238,6 +245,9 @@ type TLSEndpoint struct {
 	MutualTLSAtEdge *pb.MiddlewareConfiguration_MutualTLS
 	TLSTermination  *pb.MiddlewareConfiguration_TLSTermination
 	IPRestriction   *pb.MiddlewareConfiguration_IPRestriction
+
+	// Traffic Policy document, as JSON
+	TrafficPolicy string `json:",omitempty"`
 }
 
 type SSHOptions struct {
@@ -0,0 +1,200 @@
+package ngrok
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+// These tests verify that a Tunnel can be used anywhere the standard library
+// (and friends) expect a net.Listener.
+
+func TestInteropHTTPServe(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	exited := make(chan error)
+	go func() {
+		exited <- http.Serve(tun, helloHandler)
+	}()
+
+	resp, err := http.Get("http://" + addr)
+	require.NoError(t, err, "GET tunnel")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, "Hello, world!\n", string(body))
+
+	require.NoError(t, tun.Close())
+	err = <-exited
+	require.ErrorIs(t, err, net.ErrClosed, "http.Serve should stop once the tunnel is closed")
+}
+
+func TestInteropHTTP2ServeConn(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	srv := &http2.Server{}
+	protos := make(chan int, 1)
+	go func() {
+		for {
+			conn, err := tun.Accept()
+			if err != nil {
+				return
+			}
+			go srv.ServeConn(conn, &http2.ServeConnOpts{
+				Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+					protos <- r.ProtoMajor
+					helloHandler(rw, r)
+				}),
+			})
+		}
+	}()
+
+	client := &http.Client{
+		Transport: &http2.Transport{
+			// Speak h2c with prior knowledge
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+	}
+
+	resp, err := client.Get("http://" + addr)
+	require.NoError(t, err, "GET tunnel over h2c")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, "Hello, world!\n", string(body))
+	require.Equal(t, 2, resp.ProtoMajor)
+	require.Equal(t, 2, <-protos, "the handler should see the request as HTTP/2")
+}
+
+func TestInteropFCGIServe(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	exited := make(chan error)
+	go func() {
+		exited <- fcgi.Serve(tun, helloHandler)
+	}()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err, "dial tunnel")
+	defer conn.Close()
+
+	stdout, err := fcgiGet(conn, "/")
+	require.NoError(t, err, "FastCGI request")
+	require.Contains(t, string(stdout), "Hello, world!\n")
+
+	require.NoError(t, tun.Close())
+	require.ErrorIs(t, <-exited, net.ErrClosed, "fcgi.Serve should stop once the tunnel is closed")
+}
+
+func TestInteropServeContext(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan error)
+	go func() {
+		exited <- Serve(ctx, tun, helloHandler)
+	}()
+
+	cancel()
+	require.ErrorIs(t, <-exited, context.Canceled)
+
+	_, err := tun.Accept()
+	require.ErrorIs(t, err, net.ErrClosed, "Serve closes the tunnel when it returns")
+}
+
+// The minimal FastCGI record types needed to issue a request.
+const (
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+)
+
+func fcgiWriteRecord(w io.Writer, recType uint8, content []byte) error {
+	header := []byte{1, recType, 0, 1, 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(header[4:], uint16(len(content)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(content)
+	return err
+}
+
+// Issues a single FastCGI GET request over the connection and returns the
+// contents of the responder's stdout.
+func fcgiGet(conn net.Conn, path string) ([]byte, error) {
+	var params bytes.Buffer
+	for _, kv := range [][2]string{
+		{"REQUEST_METHOD", "GET"},
+		{"REQUEST_URI", path},
+		{"SERVER_PROTOCOL", "HTTP/1.1"},
+		{"HTTP_HOST", "example.com"},
+	} {
+		params.WriteByte(byte(len(kv[0])))
+		params.WriteByte(byte(len(kv[1])))
+		params.WriteString(kv[0])
+		params.WriteString(kv[1])
+	}
+
+	for _, rec := range []struct {
+		recType uint8
+		content []byte
+	}{
+		// Role: responder, no flags
+		{fcgiBeginRequest, []byte{0, 1, 0, 0, 0, 0, 0, 0}},
+		{fcgiParams, params.Bytes()},
+		{fcgiParams, nil},
+		{fcgiStdin, nil},
+	} {
+		if err := fcgiWriteRecord(conn, rec.recType, rec.content); err != nil {
+			return nil, err
+		}
+	}
+
+	var stdout bytes.Buffer
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return nil, err
+		}
+		length := binary.BigEndian.Uint16(header[4:])
+		content := make([]byte, int(length)+int(header[6]))
+		if _, err := io.ReadFull(conn, content); err != nil {
+			return nil, err
+		}
+		switch header[1] {
+		case fcgiStdout:
+			stdout.Write(content[:length])
+		case fcgiEndRequest:
+			return stdout.Bytes(), nil
+		}
+	}
+}
+
+func TestInteropAcceptError(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+	require.NoError(t, tun.Close())
+
+	_, err := tun.Accept()
+	require.Error(t, err)
+	require.True(t, errors.Is(err, net.ErrClosed))
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		require.False(t, netErr.Timeout(), "closed tunnels shouldn't look like timeouts")
+	}
+}
@@ -0,0 +1,278 @@
+package ngrok
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/label"
+)
+
+// LabelSet is the set of labels attached to a labeled [Tunnel], as returned
+// by [Tunnel].LabelSet.
+type LabelSet map[string]string
+
+// Get returns the value of the label, or the empty string if the label isn't
+// set.
+func (ls LabelSet) Get(key string) string {
+	return ls[key]
+}
+
+// Has reports whether the label is set.
+func (ls LabelSet) Has(key string) bool {
+	_, ok := ls[key]
+	return ok
+}
+
+// Match reports whether the labels satisfy every requirement of the
+// [Selector].
+func (ls LabelSet) Match(selector Selector) bool {
+	for _, req := range selector.requirements {
+		if !req.matches(ls) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks that every label has a well-formed key. Keys must be
+// non-empty and consist of alphanumerics, '-', '_', '.', or '/'.
+func (ls LabelSet) Validate() error {
+	for k := range ls {
+		if err := validateLabelKey(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String formats the labels as a sorted, comma-separated list of key=value
+// pairs.
+func (ls LabelSet) String() string {
+	return label.ToString(label.Labels(ls))
+}
+
+// Selector is a parsed label selector, used to match a [LabelSet]. Construct
+// one with [ParseSelector].
+type Selector struct {
+	requirements []requirement
+}
+
+// String returns the selector in its canonical, parseable form.
+func (s Selector) String() string {
+	reqs := make([]string, 0, len(s.requirements))
+	for _, req := range s.requirements {
+		reqs = append(reqs, req.String())
+	}
+	return strings.Join(reqs, ",")
+}
+
+// ParseSelector parses a Kubernetes-style label selector. A selector is a
+// comma-separated list of requirements, all of which must be satisfied for a
+// [LabelSet] to match. The supported requirements are:
+//
+//	key               the label is set
+//	!key              the label is not set
+//	key=value         the label is set to value (key==value is equivalent)
+//	key!=value        the label is not set to value, or is not set at all
+//	key in (a,b)      the label is set to one of the values
+//	key notin (a,b)   the label is not set to any of the values, or is not set
+//
+// The empty selector matches every [LabelSet].
+func ParseSelector(selector string) (Selector, error) {
+	var parsed Selector
+
+	parts, err := splitSelector(selector)
+	if err != nil {
+		return Selector{}, err
+	}
+
+	for _, part := range parts {
+		req, err := parseRequirement(part)
+		if err != nil {
+			return Selector{}, err
+		}
+		parsed.requirements = append(parsed.requirements, req)
+	}
+
+	return parsed, nil
+}
+
+type selectorOp string
+
+const (
+	opExists       selectorOp = "exists"
+	opDoesNotExist selectorOp = "!"
+	opEquals       selectorOp = "="
+	opNotEquals    selectorOp = "!="
+	opIn           selectorOp = "in"
+	opNotIn        selectorOp = "notin"
+)
+
+type requirement struct {
+	key    string
+	op     selectorOp
+	values []string
+}
+
+func (r requirement) matches(ls LabelSet) bool {
+	value, ok := ls[r.key]
+	switch r.op {
+	case opExists:
+		return ok
+	case opDoesNotExist:
+		return !ok
+	case opEquals:
+		return ok && value == r.values[0]
+	case opNotEquals:
+		return !ok || value != r.values[0]
+	case opIn:
+		return ok && contains(r.values, value)
+	case opNotIn:
+		return !ok || !contains(r.values, value)
+	}
+	return false
+}
+
+func (r requirement) String() string {
+	switch r.op {
+	case opExists:
+		return r.key
+	case opDoesNotExist:
+		return "!" + r.key
+	case opEquals, opNotEquals:
+		return r.key + string(r.op) + r.values[0]
+	default:
+		return fmt.Sprintf("%s %s (%s)", r.key, r.op, strings.Join(r.values, ","))
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Splits a selector on the commas separating requirements, ignoring those
+// that appear inside of a set of values.
+func splitSelector(selector string) ([]string, error) {
+	var (
+		parts []string
+		depth int
+		start int
+	)
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+			if depth > 1 {
+				return nil, fmt.Errorf("invalid label selector %q: nested parentheses", selector)
+			}
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("invalid label selector %q: unbalanced parentheses", selector)
+			}
+		case ',':
+			if depth == 0 {
+				parts = append(parts, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("invalid label selector %q: unbalanced parentheses", selector)
+	}
+	parts = append(parts, selector[start:])
+
+	// The empty selector is valid, but empty requirements within a selector
+	// aren't.
+	if len(parts) == 1 && strings.TrimSpace(parts[0]) == "" {
+		return nil, nil
+	}
+
+	return parts, nil
+}
+
+func parseRequirement(raw string) (requirement, error) {
+	s := strings.TrimSpace(raw)
+	if s == "" {
+		return requirement{}, fmt.Errorf("invalid label selector requirement %q: empty requirement", raw)
+	}
+
+	invalid := func(err error) (requirement, error) {
+		return requirement{}, fmt.Errorf("invalid label selector requirement %q: %w", raw, err)
+	}
+
+	// Set-based requirements
+	if open := strings.IndexByte(s, '('); open >= 0 {
+		if !strings.HasSuffix(s, ")") {
+			return invalid(errors.New("expected value set to end with ')'"))
+		}
+		fields := strings.Fields(s[:open])
+		if len(fields) != 2 {
+			return invalid(errors.New("expected 'key in (values)' or 'key notin (values)'"))
+		}
+		key, op := fields[0], selectorOp(fields[1])
+		if op != opIn && op != opNotIn {
+			return invalid(fmt.Errorf("unknown operator %q", op))
+		}
+		if err := validateLabelKey(key); err != nil {
+			return invalid(err)
+		}
+		var values []string
+		for _, v := range strings.Split(s[open+1:len(s)-1], ",") {
+			values = append(values, strings.TrimSpace(v))
+		}
+		return requirement{key: key, op: op, values: values}, nil
+	}
+
+	// Equality-based requirements
+	for _, candidate := range []struct {
+		sep string
+		op  selectorOp
+	}{
+		{"!=", opNotEquals},
+		{"==", opEquals},
+		{"=", opEquals},
+	} {
+		if key, value, ok := strings.Cut(s, candidate.sep); ok {
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if err := validateLabelKey(key); err != nil {
+				return invalid(err)
+			}
+			return requirement{key: key, op: candidate.op, values: []string{value}}, nil
+		}
+	}
+
+	// Existence requirements
+	op := opExists
+	if strings.HasPrefix(s, "!") {
+		op = opDoesNotExist
+		s = strings.TrimSpace(s[1:])
+	}
+	if err := validateLabelKey(s); err != nil {
+		return invalid(err)
+	}
+	return requirement{key: s, op: op}, nil
+}
+
+func validateLabelKey(key string) error {
+	if key == "" {
+		return errors.New("label key must not be empty")
+	}
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z',
+			c >= 'A' && c <= 'Z',
+			c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '/':
+		default:
+			return fmt.Errorf("label key %q contains invalid character %q", key, c)
+		}
+	}
+	return nil
+}
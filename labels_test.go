@@ -0,0 +1,169 @@
+package ngrok
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+func TestParseSelector(t *testing.T) {
+	labels := LabelSet{
+		"app":  "web",
+		"env":  "prod",
+		"tier": "frontend",
+	}
+
+	cases := []struct {
+		selector  string
+		canonical string
+		matches   bool
+	}{
+		{"", "", true},
+		{"app", "app", true},
+		{"!app", "!app", false},
+		{"!missing", "!missing", true},
+		{"app=web", "app=web", true},
+		{"app==web", "app=web", true},
+		{"app = api", "app=api", false},
+		{"app!=api", "app!=api", true},
+		{"missing!=api", "missing!=api", true},
+		{"env in (prod, staging)", "env in (prod,staging)", true},
+		{"env in (dev)", "env in (dev)", false},
+		{"env notin (dev,staging)", "env notin (dev,staging)", true},
+		{"missing notin (dev)", "missing notin (dev)", true},
+		{"app=web,env in (prod,staging),!missing", "app=web,env in (prod,staging),!missing", true},
+		{"app=web,tier=backend", "app=web,tier=backend", false},
+		{"example.com/owner", "example.com/owner", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.selector, func(t *testing.T) {
+			sel, err := ParseSelector(tc.selector)
+			require.NoError(t, err)
+			require.Equal(t, tc.canonical, sel.String())
+			require.Equal(t, tc.matches, labels.Match(sel))
+
+			// The canonical form round-trips.
+			reparsed, err := ParseSelector(sel.String())
+			require.NoError(t, err)
+			require.Equal(t, sel, reparsed)
+		})
+	}
+}
+
+func TestParseSelectorErrors(t *testing.T) {
+	for _, selector := range []string{
+		"app=web,",
+		",app",
+		"env in (prod",
+		"env in prod)",
+		"env in ((prod))",
+		"env within (prod)",
+		"in (prod)",
+		"=web",
+		"!",
+		"app name",
+		"app$=web",
+	} {
+		t.Run(selector, func(t *testing.T) {
+			_, err := ParseSelector(selector)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestLabelSet(t *testing.T) {
+	labels := LabelSet{"b": "2", "a": "1"}
+
+	require.Equal(t, "1", labels.Get("a"))
+	require.Equal(t, "", labels.Get("c"))
+	require.True(t, labels.Has("b"))
+	require.False(t, labels.Has("c"))
+	require.Equal(t, "a=1, b=2", labels.String())
+	require.NoError(t, labels.Validate())
+
+	require.Error(t, LabelSet{"": "empty"}.Validate())
+	require.Error(t, LabelSet{"has space": "x"}.Validate())
+}
+
+func TestTunnelLabelSet(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.Tunnel.(*fakeClientTunnel).labels = map[string]string{"app": "web"}
+
+	labels := tun.LabelSet()
+	require.Equal(t, LabelSet{"app": "web"}, labels)
+
+	labels["app"] = "api"
+	require.Equal(t, "web", tun.Labels()["app"], "LabelSet returns a copy")
+}
+
+func TestTunnelSetLabels(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+	require.ErrorIs(t, tun.SetLabels(map[string]string{"app": "web"}), ErrNotLabeled)
+
+	tun.(*tunnelImpl).Tunnel.(*fakeClientTunnel).labels = map[string]string{"deployment": "blue"}
+	labels := map[string]string{"deployment": "green"}
+	require.NoError(t, tun.SetLabels(labels))
+	require.Equal(t, LabelSet{"deployment": "green"}, tun.LabelSet())
+
+	labels["deployment"] = "red"
+	require.Equal(t, "green", tun.Labels()["deployment"], "SetLabels copies the labels")
+
+	for _, invalid := range []map[string]string{nil, {"has space": "x"}} {
+		err := tun.SetLabels(invalid)
+		require.ErrorIs(t, err, errSetLabels{})
+		require.Equal(t, "green", tun.Labels()["deployment"], "invalid labels aren't set")
+	}
+}
+
+func TestTunnelSetMetadata(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+	err := tun.SetMetadata(context.Background(), "v2")
+	require.ErrorIs(t, err, ErrNotLabeled)
+	require.ErrorIs(t, err, errSetMetadata{})
+
+	tun.(*tunnelImpl).Tunnel.(*fakeClientTunnel).labels = map[string]string{"deployment": "blue"}
+	require.NoError(t, tun.SetMetadata(context.Background(), "v2"))
+	require.Equal(t, "v2", tun.Metadata())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, tun.SetMetadata(ctx, "v3"), context.Canceled)
+	require.Equal(t, "v2", tun.Metadata(), "calls whose context is already done aren't made")
+}
+
+// A client tunnel whose SetMetadata calls block until released.
+type slowMetadataTunnel struct {
+	tunnel_client.Tunnel
+	release chan struct{}
+	set     chan string
+}
+
+func (s *slowMetadataTunnel) SetMetadata(metadata string) error {
+	<-s.release
+	err := s.Tunnel.SetMetadata(metadata)
+	s.set <- metadata
+	return err
+}
+
+func TestTunnelSetMetadataTimeout(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.Tunnel.(*fakeClientTunnel).labels = map[string]string{"deployment": "blue"}
+	slow := &slowMetadataTunnel{impl.Tunnel, make(chan struct{}), make(chan string, 1)}
+	impl.Tunnel = slow
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, tun.SetMetadata(ctx, "v2"), context.DeadlineExceeded)
+
+	// The rebind still finishes in the background.
+	close(slow.release)
+	require.Equal(t, "v2", <-slow.set)
+	require.Equal(t, "v2", tun.Metadata())
+}
@@ -0,0 +1,86 @@
+// Package slog provides a logger that writes to a log/slog.Handler and
+// implements the golang.ngrok.com/ngrok/log.Logger interface.
+package slog
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+type LogLevel = int
+
+// Log level constants matching the ones in golang.ngrok.com/ngrok/log
+const (
+	LogLevelTrace = 6
+	LogLevelDebug = 5
+	LogLevelInfo  = 4
+	LogLevelWarn  = 3
+	LogLevelError = 2
+	LogLevelNone  = 1
+)
+
+// LevelTrace is the slog level that trace messages are logged at, below
+// slog.LevelDebug.
+const LevelTrace = slog.LevelDebug - 4
+
+type Logger struct {
+	handler slog.Handler
+}
+
+// NewLogger creates a Logger that writes to the handler. Messages are only
+// formatted if the handler is enabled for their level, so the levels logged
+// can be chosen with the handler's options, such as
+// slog.HandlerOptions.Level.
+func NewLogger(handler slog.Handler) *Logger {
+	return &Logger{handler: handler}
+}
+
+func (l *Logger) Log(ctx context.Context, level LogLevel, msg string, data map[string]interface{}) {
+	var lvl slog.Level
+	switch level {
+	case LogLevelTrace:
+		lvl = LevelTrace
+	case LogLevelDebug:
+		lvl = slog.LevelDebug
+	case LogLevelInfo:
+		lvl = slog.LevelInfo
+	case LogLevelWarn:
+		lvl = slog.LevelWarn
+	case LogLevelError:
+		lvl = slog.LevelError
+	default:
+		lvl = slog.LevelError
+		data = withInvalidLevel(data, level)
+	}
+
+	if !l.handler.Enabled(ctx, lvl) {
+		return
+	}
+
+	// The messages come from within the SDK, rather than from a caller that
+	// the handler could usefully report as the source.
+	record := slog.NewRecord(time.Now(), lvl, msg, 0)
+
+	// Sorted, so that the attributes come out in the same order every time.
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		record.AddAttrs(slog.Any(k, data[k]))
+	}
+
+	_ = l.handler.Handle(ctx, record)
+}
+
+func withInvalidLevel(data map[string]interface{}, level LogLevel) map[string]interface{} {
+	withLevel := make(map[string]interface{}, len(data)+1)
+	for k, v := range data {
+		withLevel[k] = v
+	}
+	withLevel["INVALID_LOG_LEVEL"] = level
+	return withLevel
+}
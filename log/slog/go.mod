@@ -0,0 +1,3 @@
+module golang.ngrok.com/ngrok/log/slog
+
+go 1.21
@@ -9,6 +9,14 @@ import (
 	"golang.ngrok.com/ngrok/log"
 )
 
+// Logs nothing, for sessions that weren't configured with a logger and for
+// tunnels that aren't part of a session.
+var nopLogger = func() log15.Logger {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	return logger
+}()
+
 type log15Handler struct {
 	log.Logger
 }
@@ -0,0 +1,64 @@
+package ngrok
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/log"
+)
+
+type loggedMessage struct {
+	level log.LogLevel
+	msg   string
+	data  map[string]interface{}
+}
+
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []loggedMessage
+}
+
+func (l *recordingLogger) Log(_ context.Context, level log.LogLevel, msg string, data map[string]interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, loggedMessage{level, msg, data})
+}
+
+func (l *recordingLogger) find(msg string) (loggedMessage, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, m := range l.messages {
+		if m.msg == msg {
+			return m, true
+		}
+	}
+	return loggedMessage{}, false
+}
+
+func TestTunnelLogEvents(t *testing.T) {
+	rec := &recordingLogger{}
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).logger = toLog15(rec).New("tunnel", tun.ID())
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	accepted, ok := rec.find("connection accepted")
+	require.True(t, ok)
+	require.Equal(t, log.LogLevelDebug, accepted.level)
+	require.Equal(t, "fake", accepted.data["tunnel"])
+	require.Equal(t, "127.0.0.1:1234", accepted.data["client_addr"])
+
+	require.NoError(t, tun.Close())
+	closed, ok := rec.find("tunnel closed")
+	require.True(t, ok)
+	require.Equal(t, log.LogLevelInfo, closed.level)
+}
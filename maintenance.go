@@ -0,0 +1,68 @@
+package ngrok
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+// ErrMaintenance is the reason given in the [AcceptEvent] for connections that
+// were answered with the [Tunnel]'s maintenance page. See
+// [Tunnel].SetMaintenance.
+var ErrMaintenance = errors.New("tunnel is in maintenance")
+
+// MaintenancePage is the response that a [Tunnel] in maintenance mode sends
+// to HTTP clients. See [Tunnel].SetMaintenance.
+type MaintenancePage struct {
+	// The response's status code.
+	// Defaults to 503 Service Unavailable when 0.
+	StatusCode int
+	// The response's Content-Type.
+	// Defaults to "text/plain; charset=utf-8" when empty.
+	ContentType string
+	// The response's body.
+	// Defaults to the status text when nil.
+	Body []byte
+	// If positive, sent as the Retry-After header, rounded to seconds, to
+	// tell clients when to try again.
+	RetryAfter time.Duration
+}
+
+// Holds a tunnel's maintenance page, as stored in an atomic.Value.
+type maintenanceState struct {
+	page *MaintenancePage
+}
+
+func (t *tunnelImpl) SetMaintenance(page *MaintenancePage) {
+	if page != nil {
+		copied := *page
+		page = &copied
+	}
+	t.maintenance.Store(maintenanceState{page})
+}
+
+func (t *tunnelImpl) maintenancePage() *MaintenancePage {
+	state, _ := t.maintenance.Load().(maintenanceState)
+	return state.page
+}
+
+// Answers a connection that arrived while the tunnel was in maintenance mode
+// with the page, if it's HTTP, and resets it otherwise.
+func (page *MaintenancePage) serve(conn *tunnel_client.ProxyConn) {
+	status := page.StatusCode
+	if status == 0 {
+		status = http.StatusServiceUnavailable
+	}
+	header := http.Header{}
+	if page.ContentType != "" {
+		header.Set("Content-Type", page.ContentType)
+	}
+	if page.RetryAfter > 0 {
+		seconds := (page.RetryAfter + time.Second - 1) / time.Second
+		header.Set("Retry-After", strconv.FormatInt(int64(seconds), 10))
+	}
+	respondConn(conn, status, header, page.Body)
+}
@@ -0,0 +1,63 @@
+package ngrok
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTunnelMaintenance(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.Tunnel.(*fakeClientTunnel).header.Proto = "https"
+	events := make(chan AcceptEvent, 1)
+	tun.OnAccept(func(event AcceptEvent) {
+		events <- event
+	})
+
+	tun.SetMaintenance(&MaintenancePage{
+		ContentType: "text/html",
+		Body:        []byte("<h1>Back soon</h1>"),
+		RetryAfter:  90 * time.Second,
+	})
+	require.True(t, tun.Describe().Maintenance)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := tun.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.SetDeadline(time.Now().Add(5*time.Second)))
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/", nil)
+	require.NoError(t, err)
+	require.NoError(t, req.Write(client))
+	resp, err := http.ReadResponse(bufio.NewReader(client), req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, "text/html", resp.Header.Get("Content-Type"))
+	require.Equal(t, "90", resp.Header.Get("Retry-After"))
+	require.Equal(t, "<h1>Back soon</h1>", string(body))
+	require.ErrorIs(t, (<-events).Rejected, ErrMaintenance)
+
+	// Connections are accepted again once maintenance ends.
+	tun.SetMaintenance(nil)
+	require.False(t, tun.Describe().Maintenance)
+	next, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer next.Close()
+	conn := <-accepted
+	defer conn.Close()
+	require.NoError(t, (<-events).Rejected)
+}
@@ -0,0 +1,140 @@
+package ngrok
+
+import (
+	"time"
+)
+
+// Meter creates the instruments that a [Session] reports its metrics with.
+// Like [Tracer], it's a deliberately small interface, so that Prometheus
+// registries or OpenTelemetry meters can be adapted to it without the SDK
+// depending on them.
+//
+// Each instrument is created once, when the session is started, with the
+// names of the labels that its values are reported with. The values are then
+// passed to Add or Set in the same order. See the Metric constants for the
+// instruments that are created.
+//
+// Configure one for a [Session] with [WithMeter].
+type Meter interface {
+	// Counter creates an instrument for a monotonically increasing value.
+	Counter(name, help string, labels ...string) MetricCounter
+	// Gauge creates an instrument for a value that can go up and down.
+	Gauge(name, help string, labels ...string) MetricGauge
+}
+
+// MetricCounter is a counter created by a [Meter].
+type MetricCounter interface {
+	// Add increments the counter for the given label values.
+	Add(delta float64, labelValues ...string)
+}
+
+// MetricGauge is a gauge created by a [Meter].
+type MetricGauge interface {
+	// Set replaces the value of the gauge for the given label values.
+	Set(value float64, labelValues ...string)
+}
+
+// The names of the instruments created by a [Session] with its [Meter].
+const (
+	// A gauge of the tunnels currently open on the session.
+	MetricTunnelsActive = "ngrok_session_tunnels_active"
+	// A counter of the connections accepted, labeled by "tunnel_id".
+	MetricConnsAccepted = "ngrok_tunnel_connections_accepted_total"
+	// A counter of the bytes read from accepted connections, labeled by
+	// "tunnel_id".
+	MetricBytesRead = "ngrok_tunnel_bytes_read_total"
+	// A counter of the bytes written to accepted connections, labeled by
+	// "tunnel_id".
+	MetricBytesWritten = "ngrok_tunnel_bytes_written_total"
+	// A counter of the times the session has reconnected to the ngrok
+	// service.
+	MetricReconnects = "ngrok_session_reconnects_total"
+	// A gauge of the latency of the most recent heartbeat, in seconds.
+	MetricHeartbeatLatency = "ngrok_session_heartbeat_latency_seconds"
+)
+
+// WithMeter configures a [Meter] to report metrics about the session, its
+// tunnels, and the connections accepted from them.
+func WithMeter(meter Meter) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.Meter = meter
+	}
+}
+
+// The instruments of a single session.
+//
+// All methods are safe to call on a nil sessionMetrics, which reports
+// nothing.
+type sessionMetrics struct {
+	tunnelsActive    MetricGauge
+	connsAccepted    MetricCounter
+	bytesRead        MetricCounter
+	bytesWritten     MetricCounter
+	reconnects       MetricCounter
+	heartbeatLatency MetricGauge
+}
+
+// Creates the session's instruments. Returns nil if there's no meter.
+func newSessionMetrics(meter Meter) *sessionMetrics {
+	if meter == nil {
+		return nil
+	}
+	return &sessionMetrics{
+		tunnelsActive:    meter.Gauge(MetricTunnelsActive, "The number of tunnels open on the session."),
+		connsAccepted:    meter.Counter(MetricConnsAccepted, "The number of connections accepted from the tunnel.", "tunnel_id"),
+		bytesRead:        meter.Counter(MetricBytesRead, "The number of bytes read from connections accepted from the tunnel.", "tunnel_id"),
+		bytesWritten:     meter.Counter(MetricBytesWritten, "The number of bytes written to connections accepted from the tunnel.", "tunnel_id"),
+		reconnects:       meter.Counter(MetricReconnects, "The number of times the session has reconnected to the ngrok service."),
+		heartbeatLatency: meter.Gauge(MetricHeartbeatLatency, "The latency of the session's most recent heartbeat, in seconds."),
+	}
+}
+
+func (m *sessionMetrics) tunnels(open int) {
+	if m != nil {
+		m.tunnelsActive.Set(float64(open))
+	}
+}
+
+func (m *sessionMetrics) reconnected() {
+	if m != nil {
+		m.reconnects.Add(1)
+	}
+}
+
+func (m *sessionMetrics) heartbeat(latency time.Duration) {
+	if m != nil {
+		m.heartbeatLatency.Set(latency.Seconds())
+	}
+}
+
+// Records a connection being accepted from the tunnel, returning the metrics
+// for its traffic.
+func (m *sessionMetrics) accepted(tunnelID string) *connMetrics {
+	if m == nil {
+		return nil
+	}
+	m.connsAccepted.Add(1, tunnelID)
+	return &connMetrics{session: m, tunnelID: tunnelID}
+}
+
+// The metrics of a single connection.
+//
+// All methods are safe to call on a nil connMetrics, which reports nothing.
+type connMetrics struct {
+	session *sessionMetrics
+	// The ID of the tunnel that the connection was accepted from, as of when
+	// it was accepted.
+	tunnelID string
+}
+
+func (m *connMetrics) read(n int) {
+	if m != nil {
+		m.session.bytesRead.Add(float64(n), m.tunnelID)
+	}
+}
+
+func (m *connMetrics) wrote(n int) {
+	if m != nil {
+		m.session.bytesWritten.Add(float64(n), m.tunnelID)
+	}
+}
@@ -0,0 +1,122 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A Meter that records the latest value of each instrument, keyed by its name
+// and label values.
+type testMeter struct {
+	mu     sync.Mutex
+	values map[string]float64
+	labels map[string][]string
+}
+
+func newTestMeter() *testMeter {
+	return &testMeter{
+		values: map[string]float64{},
+		labels: map[string][]string{},
+	}
+}
+
+type testInstrument struct {
+	meter *testMeter
+	name  string
+}
+
+func (m *testMeter) Counter(name, help string, labels ...string) MetricCounter {
+	m.labels[name] = labels
+	return testInstrument{m, name}
+}
+
+func (m *testMeter) Gauge(name, help string, labels ...string) MetricGauge {
+	m.labels[name] = labels
+	return testInstrument{m, name}
+}
+
+func (i testInstrument) Add(delta float64, labelValues ...string) {
+	i.meter.mu.Lock()
+	defer i.meter.mu.Unlock()
+	i.meter.values[i.key(labelValues)] += delta
+}
+
+func (i testInstrument) Set(value float64, labelValues ...string) {
+	i.meter.mu.Lock()
+	defer i.meter.mu.Unlock()
+	i.meter.values[i.key(labelValues)] = value
+}
+
+func (i testInstrument) key(labelValues []string) string {
+	return strings.Join(append([]string{i.name}, labelValues...), "/")
+}
+
+func (m *testMeter) value(key string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[key]
+}
+
+func TestSessionMetrics(t *testing.T) {
+	meter := newTestMeter()
+	metrics := newSessionMetrics(meter)
+	require.Equal(t, []string{"tunnel_id"}, meter.labels[MetricBytesRead])
+	require.Empty(t, meter.labels[MetricTunnelsActive])
+
+	sess := &sessionImpl{metrics: metrics}
+	require.True(t, sess.acquireTunnel())
+	require.True(t, sess.acquireTunnel())
+	require.Equal(t, 2.0, meter.value(MetricTunnelsActive))
+	sess.releaseTunnel(nil)
+	require.Equal(t, 1.0, meter.value(MetricTunnelsActive))
+
+	metrics.reconnected()
+	metrics.reconnected()
+	require.Equal(t, 2.0, meter.value(MetricReconnects))
+
+	metrics.heartbeat(250 * time.Millisecond)
+	require.Equal(t, 0.25, meter.value(MetricHeartbeatLatency))
+}
+
+func TestConnMetrics(t *testing.T) {
+	meter := newTestMeter()
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).metrics = newSessionMetrics(meter)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, 1.0, meter.value(MetricConnsAccepted+"/fake"))
+
+	_, err = io.WriteString(client, "ping")
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, "pong!")
+	require.NoError(t, err)
+
+	require.Equal(t, 4.0, meter.value(MetricBytesRead+"/fake"))
+	require.Equal(t, 5.0, meter.value(MetricBytesWritten+"/fake"))
+}
+
+func TestNoMetrics(t *testing.T) {
+	var metrics *sessionMetrics
+	require.NotPanics(t, func() {
+		metrics.tunnels(1)
+		metrics.reconnected()
+		metrics.heartbeat(time.Second)
+		conn := metrics.accepted("fake")
+		conn.read(1)
+		conn.wrote(1)
+	})
+}
@@ -0,0 +1,274 @@
+package ngrok
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+)
+
+// The most initial bytes that a Mux peeks at to find a listener for a
+// connection.
+const maxMuxPeek = 1024
+
+// How long a Mux waits for a connection's initial bytes to match a listener.
+const muxPeekTimeout = 10 * time.Second
+
+// The verdict of a matcher on the initial bytes of a connection.
+type matchResult int
+
+const (
+	// The bytes so far could go either way.
+	matchMore matchResult = iota
+	matchYes
+	matchNo
+)
+
+// Mux shares a single [Tunnel] between several [net.Listener]s, dispatching
+// each accepted connection to the first listener whose matcher accepts the
+// initial bytes sent by the client. This makes it possible to, for example,
+// serve both HTTP and a custom TCP protocol over one TCP tunnel.
+//
+// Matchers are given whatever bytes have arrived from the client so far, and
+// are asked again as more arrive if none of them accepts, up to 1 KiB.
+// Connections that don't match any listener by then, or within 10 seconds, are
+// closed. The listeners' connections are [Conn]s whenever the Tunnel's are.
+//
+// The listeners do not receive any connections until [Mux].Serve is called.
+type Mux struct {
+	tun Tunnel
+	// How long to wait for a connection to match. muxPeekTimeout if zero.
+	peekTimeout time.Duration
+
+	mu        sync.RWMutex
+	listeners []*muxListener
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// NewMux creates a new [Mux] that will accept connections from the provided
+// [Tunnel].
+func NewMux(tun Tunnel) *Mux {
+	return &Mux{
+		tun:  tun,
+		done: make(chan struct{}),
+	}
+}
+
+// Match returns a [net.Listener] that accepts the connections whose initial
+// bytes satisfy the provided matcher. Matchers are evaluated in the same order
+// that they were registered. A matcher that returns false may be called again
+// with more bytes, so it should only return true once it's seen enough to be
+// sure.
+func (m *Mux) Match(matcher func([]byte) bool) net.Listener {
+	return m.match(func(initial []byte) matchResult {
+		if matcher(initial) {
+			return matchYes
+		}
+		return matchMore
+	})
+}
+
+func (m *Mux) match(matcher func([]byte) matchResult) net.Listener {
+	l := &muxListener{
+		mux:     m,
+		matcher: matcher,
+		conns:   make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, l)
+
+	return l
+}
+
+// MatchHTTP returns a [net.Listener] that accepts HTTP/1.x connections and
+// HTTP/2 connections with prior knowledge.
+func (m *Mux) MatchHTTP() net.Listener {
+	return m.match(matchHTTP)
+}
+
+// MatchTLS returns a [net.Listener] that accepts TLS connections.
+func (m *Mux) MatchTLS() net.Listener {
+	return m.match(matchTLS)
+}
+
+// Serve accepts connections from the [Tunnel] and dispatches them to the
+// matching listeners. It blocks until accepting from the [Tunnel] fails, e.g.
+// because it was closed, and returns that error. When Serve returns, each of
+// the derived listeners is closed.
+func (m *Mux) Serve() error {
+	defer m.shutdown()
+
+	for {
+		conn, err := m.tun.Accept()
+		if err != nil {
+			return err
+		}
+		go m.dispatch(conn)
+	}
+}
+
+// Close closes the underlying [Tunnel], which will cause [Mux].Serve to
+// return.
+func (m *Mux) Close() error {
+	m.shutdown()
+	return m.tun.Close()
+}
+
+func (m *Mux) shutdown() {
+	m.doneOnce.Do(func() {
+		close(m.done)
+	})
+}
+
+func (m *Mux) dispatch(conn net.Conn) {
+	matched, peek := m.classify(conn)
+
+	if matched != nil {
+		var out net.Conn = peek
+		if c, ok := conn.(Conn); ok {
+			out = &muxConn{Conn: c, peek: peek}
+		}
+		select {
+		case matched.conns <- out:
+			return
+		case <-matched.done:
+		case <-m.done:
+		}
+	}
+
+	_ = conn.Close()
+}
+
+// Peeks at the connection's initial bytes until a listener's matcher accepts
+// them, or every matcher rejects them, or there are too many, or the client
+// takes too long to send them. Returns nil if no listener matched.
+func (m *Mux) classify(conn net.Conn) (*muxListener, *peekConn) {
+	timeout := m.peekTimeout
+	if timeout == 0 {
+		timeout = muxPeekTimeout
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	// Cleared before the connection is handed off.
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+
+	peek := newPeekConnSize(conn, maxMuxPeek)
+	var initial []byte
+	for len(initial) < maxMuxPeek {
+		var err error
+		initial, err = peek.PeekMore(len(initial))
+		if err != nil {
+			return nil, peek
+		}
+
+		undecided := false
+		m.mu.RLock()
+		for _, l := range m.listeners {
+			switch l.matcher(initial) {
+			case matchYes:
+				m.mu.RUnlock()
+				return l, peek
+			case matchMore:
+				undecided = true
+			}
+		}
+		m.mu.RUnlock()
+		if !undecided {
+			break
+		}
+	}
+	return nil, peek
+}
+
+// A connection handed out by a Mux listener. It replays the bytes that were
+// peeked to match it, while keeping the methods of the [Conn] that it was
+// accepted as.
+type muxConn struct {
+	Conn
+	peek *peekConn
+}
+
+func (c *muxConn) Read(b []byte) (int, error) {
+	return c.peek.Read(b)
+}
+
+func (c *muxConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+type muxListener struct {
+	mux     *Mux
+	matcher func([]byte) matchResult
+	conns   chan net.Conn
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+	case <-l.mux.done:
+	}
+	return nil, errAcceptFailed{Inner: net.ErrClosed}
+}
+
+// Close stops this listener from receiving connections. The underlying
+// [Tunnel] and the other listeners derived from it are unaffected.
+func (l *muxListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *muxListener) Addr() net.Addr {
+	return l.mux.tun.Addr()
+}
+
+var httpPrefixes = [][]byte{
+	[]byte("GET "),
+	[]byte("HEAD "),
+	[]byte("POST "),
+	[]byte("PUT "),
+	[]byte("DELETE "),
+	[]byte("CONNECT "),
+	[]byte("OPTIONS "),
+	[]byte("TRACE "),
+	[]byte("PATCH "),
+	// The HTTP/2 connection preface
+	[]byte("PRI * HTTP/2.0"),
+}
+
+func matchHTTP(initial []byte) matchResult {
+	return matchPrefixes(initial, httpPrefixes)
+}
+
+// A TLS handshake record, followed by the major version of the record layer,
+// which has been 3 since SSLv3.
+var tlsPrefixes = [][]byte{{0x16, 0x03}}
+
+func matchTLS(initial []byte) matchResult {
+	return matchPrefixes(initial, tlsPrefixes)
+}
+
+// Matches the bytes that start with one of the prefixes, and waits for more
+// of those that are the start of one.
+func matchPrefixes(initial []byte, prefixes [][]byte) matchResult {
+	result := matchNo
+	for _, prefix := range prefixes {
+		if bytes.HasPrefix(initial, prefix) {
+			return matchYes
+		}
+		if bytes.HasPrefix(prefix, initial) {
+			result = matchMore
+		}
+	}
+	return result
+}
@@ -0,0 +1,166 @@
+package ngrok
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMux(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	mux := NewMux(tun)
+	// Connections that match nothing wait for more bytes until then.
+	mux.peekTimeout = 500 * time.Millisecond
+	httpL := mux.MatchHTTP()
+	tlsL := mux.MatchTLS()
+	pingL := mux.Match(func(initial []byte) bool {
+		return bytes.HasPrefix(initial, []byte("PING"))
+	})
+
+	served := make(chan error, 1)
+	go func() {
+		served <- mux.Serve()
+	}()
+
+	go func() {
+		_ = http.Serve(httpL, helloHandler)
+	}()
+	go func() {
+		_ = http.Serve(tls.NewListener(tlsL, &tls.Config{
+			Certificates: []tls.Certificate{testCertificate(t, "example.com")},
+		}), helloHandler)
+	}()
+	go func() {
+		for {
+			conn, err := pingL.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				if line == "PING\n" {
+					_, _ = io.WriteString(conn, "PONG\n")
+				}
+			}()
+		}
+	}()
+
+	resp, err := http.Get("http://" + addr)
+	require.NoError(t, err, "GET over HTTP")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, "Hello, world!\n", string(body))
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	resp, err = client.Get("https://" + addr)
+	require.NoError(t, err, "GET over HTTPS")
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, "Hello, world!\n", string(body))
+	require.NotNil(t, resp.TLS)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err, "dial custom protocol")
+	_, err = io.WriteString(conn, "PING\n")
+	require.NoError(t, err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "PONG\n", line)
+	_ = conn.Close()
+
+	// Connections that match nothing get closed.
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err, "dial unknown protocol")
+	_, err = io.WriteString(conn, "HELLO\n")
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	_ = conn.Close()
+
+	require.NoError(t, mux.Close())
+	require.Error(t, <-served)
+
+	_, err = httpL.Accept()
+	require.ErrorIs(t, err, net.ErrClosed, "derived listeners close with the mux")
+}
+
+func TestMuxMatchers(t *testing.T) {
+	require.Equal(t, matchYes, matchHTTP([]byte("GET / HTTP/1.1\r\n")))
+	require.Equal(t, matchYes, matchHTTP([]byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")))
+	require.Equal(t, matchMore, matchHTTP([]byte("GE")))
+	require.Equal(t, matchMore, matchHTTP([]byte("PRI * HT")))
+	require.Equal(t, matchNo, matchHTTP([]byte("GETTING")))
+	require.Equal(t, matchNo, matchHTTP([]byte{0x16, 0x03, 0x01}))
+
+	require.Equal(t, matchYes, matchTLS([]byte{0x16, 0x03, 0x01, 0x02, 0x00}))
+	require.Equal(t, matchMore, matchTLS([]byte{0x16}))
+	require.Equal(t, matchNo, matchTLS([]byte("GET / HTTP/1.1\r\n")))
+}
+
+func TestMuxPeeksUntilMatched(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	mux := NewMux(tun)
+	mux.peekTimeout = 500 * time.Millisecond
+	httpL := mux.MatchHTTP()
+	go func() {
+		_ = mux.Serve()
+	}()
+	defer mux.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := httpL.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	// The method is split across writes, so the first read doesn't have
+	// enough to match.
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.(*net.TCPConn).SetNoDelay(true))
+	_, err = io.WriteString(client, "GE")
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	_, err = io.WriteString(client, "T / HTTP/1.1\r\n")
+	require.NoError(t, err)
+	conn := <-accepted
+	defer conn.Close()
+	tunConn, ok := conn.(Conn)
+	require.True(t, ok, "connections keep the methods of the tunnel's Conn")
+	require.Equal(t, uint64(1), tunConn.ConnID())
+
+	// The deadline is cleared once the connection is matched.
+	time.Sleep(mux.peekTimeout)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "GET / HTTP/1.1\r\n", line)
+
+	// Clients that never send enough to match are closed.
+	silent, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer silent.Close()
+	_, err = io.WriteString(silent, "GE")
+	require.NoError(t, err)
+	require.NoError(t, silent.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = silent.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
@@ -0,0 +1,399 @@
+// Package ngroktest provides an in-process fake of the ngrok service, for
+// hermetic tests of code that accepts and serves connections from tunnels.
+//
+// A [Server] speaks enough of the tunnel protocol for sessions to connect to
+// it with [ngrok.Connect] and start tunnels of every kind, and it opens
+// connections to those tunnels as if they had arrived at the ngrok edge.
+// Sessions reach it over an in-memory transport, so tests need neither the
+// network nor an authtoken:
+//
+//	srv := ngroktest.NewServer()
+//	defer srv.Close()
+//
+//	sess, err := srv.Connect(ctx)
+//	...
+//	tun, err := sess.Listen(ctx, config.HTTPEndpoint())
+//	...
+//	go http.Serve(tun, handler)
+//
+//	resp, err := srv.HTTPClient().Get(tun.URL() + "/hello")
+//
+// The fake doesn't implement the edge's features: traffic policies, auth,
+// compression, PROXY protocol headers, and the like are accepted and ignored.
+// Connections to HTTPS and TLS endpoints carry the same bytes that the edge
+// would send the agent, so HTTPS connections are plain HTTP, and TLS
+// connections are the client's own TLS unless they're terminated at the edge.
+package ngroktest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.ngrok.com/ngrok"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+// The address that sessions connect to, which is also the name in the
+// server's certificate.
+const serverAddr = "connect.ngroktest.invalid:443"
+
+// The domain that the URLs of tunnels are under, unless they ask for one.
+const rootDomain = "ngroktest.invalid"
+
+// The first port handed out to TCP endpoints.
+const firstTCPPort = 10000
+
+// How long a session may take to finish its TLS handshake.
+const handshakeTimeout = 10 * time.Second
+
+var (
+	// ErrServerClosed is returned when dialing a [Server] after it has been
+	// closed.
+	ErrServerClosed = errors.New("ngroktest: server closed")
+	// ErrTunnelNotFound is returned by [Server].Dial when no tunnel matches
+	// the target.
+	ErrTunnelNotFound = errors.New("ngroktest: tunnel not found")
+)
+
+// Server is an in-process fake of the ngrok service. Create one with
+// [NewServer], and connect sessions to it with [Server].Connect, or with
+// [ngrok.Connect] given the options from [Server].ConnectOptions.
+type Server struct {
+	authtoken string
+	region    string
+	tlsConfig *tls.Config
+	caPool    *x509.CertPool
+
+	mu       sync.Mutex
+	closed   bool
+	sessions map[*serverSession]struct{}
+	tunnels  map[string]*serverTunnel
+	// Incremented for each tunnel that's bound, to keep them in order.
+	binds   int
+	tcpPort int
+	// Incremented for each connection that's dialed, to make up client
+	// addresses.
+	dials int
+}
+
+// ServerOption configures a [Server].
+type ServerOption func(*Server)
+
+// WithAuthtoken makes the server reject sessions that don't authenticate
+// with the token. By default, any authtoken is accepted, including none.
+func WithAuthtoken(token string) ServerOption {
+	return func(s *Server) {
+		s.authtoken = token
+	}
+}
+
+// WithRegion sets the region that the server reports to sessions.
+// Defaults to "local".
+func WithRegion(region string) ServerOption {
+	return func(s *Server) {
+		s.region = region
+	}
+}
+
+// NewServer starts a fake ngrok service, with a new self-signed certificate
+// for sessions to verify it with. Close it once the test is done.
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{
+		region:   "local",
+		sessions: make(map[*serverSession]struct{}),
+		tunnels:  make(map[string]*serverTunnel),
+		tcpPort:  firstTCPPort,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	cert, err := selfSignedCert()
+	if err != nil {
+		panic(fmt.Sprintf("ngroktest: failed to create a certificate: %v", err))
+	}
+	s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	s.caPool = x509.NewCertPool()
+	s.caPool.AddCert(cert.Leaf)
+	return s
+}
+
+// Creates a certificate for the server's name that signs itself, so that it's
+// also the CA that sessions trust.
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	host, _, _ := net.SplitHostPort(serverAddr)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * 365 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// ConnectOptions returns the options that make [ngrok.Connect] connect to
+// the server. Options passed after them take precedence, except that
+// replacing the server address, dialer, or CA breaks the connection.
+func (s *Server) ConnectOptions() []ngrok.ConnectOption {
+	opts := []ngrok.ConnectOption{
+		ngrok.WithServer(serverAddr),
+		ngrok.WithDialer(ngrok.DialerFunc(s.dialSession)),
+		ngrok.WithCA(s.caPool),
+	}
+	if token := s.getAuthtoken(); token != "" {
+		opts = append(opts, ngrok.WithAuthtoken(token))
+	}
+	return opts
+}
+
+func (s *Server) getAuthtoken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.authtoken
+}
+
+// SetAuthtoken replaces the token that sessions must authenticate with, as
+// configured with [WithAuthtoken], and disconnects the sessions that are
+// connected, as when an authtoken expires or is revoked. Sessions that
+// reconnect with the old token are rejected. An empty token accepts any.
+func (s *Server) SetAuthtoken(token string) {
+	s.mu.Lock()
+	s.authtoken = token
+	sessions := s.sessions
+	s.sessions = make(map[*serverSession]struct{})
+	s.mu.Unlock()
+
+	for sess := range sessions {
+		_ = sess.mux.Close()
+	}
+}
+
+// Connect starts a session with the server, as [ngrok.Connect] does with
+// the ngrok service. The options are applied after those from
+// [Server].ConnectOptions.
+func (s *Server) Connect(ctx context.Context, opts ...ngrok.ConnectOption) (ngrok.Session, error) {
+	return ngrok.Connect(ctx, append(s.ConnectOptions(), opts...)...)
+}
+
+// Connects a session to the server through an in-memory pipe, regardless of
+// the address.
+func (s *Server) dialSession(ctx context.Context, network, address string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return nil, ErrServerClosed
+	}
+
+	client, server := net.Pipe()
+	go s.serve(server)
+	return client, nil
+}
+
+// Close disconnects every session and stops accepting new ones. Sessions
+// that were connected to the server keep trying to reconnect until they're
+// closed.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	sessions := s.sessions
+	s.sessions = make(map[*serverSession]struct{})
+	s.tunnels = make(map[string]*serverTunnel)
+	s.mu.Unlock()
+
+	for sess := range sessions {
+		_ = sess.mux.Close()
+	}
+	return nil
+}
+
+// TunnelInfo describes a tunnel that's been started on the [Server], as
+// returned by [Server].Tunnels.
+type TunnelInfo struct {
+	// The ID of the tunnel, as returned by its ID method.
+	ID string
+	// The ID of the session that started the tunnel.
+	SessionID string
+	// The protocol of the endpoint, one of "http", "https", "tcp", or "tls",
+	// or empty for labeled tunnels.
+	Proto string
+	// The URL of the endpoint, or empty for labeled tunnels.
+	URL string
+	// The labels of a labeled tunnel.
+	Labels map[string]string
+	// What the tunnel forwards to, as configured with
+	// config.WithForwardsTo.
+	ForwardsTo string
+	// The tunnel's opaque metadata.
+	Metadata string
+}
+
+// Tunnels returns the tunnels that are currently started on the server, in
+// the order that they were started.
+func (s *Server) Tunnels() []TunnelInfo {
+	s.mu.Lock()
+	tunnels := make([]*serverTunnel, 0, len(s.tunnels))
+	for _, t := range s.tunnels {
+		tunnels = append(tunnels, t)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].seq < tunnels[j].seq })
+	infos := make([]TunnelInfo, len(tunnels))
+	for i, t := range tunnels {
+		infos[i] = t.info
+	}
+	return infos
+}
+
+// DialOption configures a connection opened by [Server].Dial.
+type DialOption func(*dialConfig)
+
+type dialConfig struct {
+	clientAddr string
+	proto      string
+}
+
+// WithClientAddr sets the address that the connection appears to come from,
+// as reported by its RemoteAddr. Defaults to a made-up address in
+// 192.0.2.0/24.
+func WithClientAddr(addr string) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.clientAddr = addr
+	}
+}
+
+// WithProto sets the protocol that the connection is reported to arrive
+// over, which is otherwise the protocol of the tunnel's endpoint, or "https"
+// for labeled tunnels.
+func WithProto(proto string) DialOption {
+	return func(cfg *dialConfig) {
+		cfg.proto = proto
+	}
+}
+
+// Dial opens a connection to a tunnel, as if a client had connected to its
+// endpoint. The target is the tunnel's URL, or its ID, which is how labeled
+// tunnels are dialed. The connection is delivered to the tunnel's Accept,
+// and carries what the edge would send the agent.
+//
+// Fails with [ErrTunnelNotFound] if no tunnel matches the target.
+func (s *Server) Dial(ctx context.Context, target string, opts ...DialOption) (net.Conn, error) {
+	return s.dialTunnel(ctx, func(t *serverTunnel) bool {
+		return t.info.ID == target || (t.info.URL != "" && t.info.URL == target)
+	}, target, opts...)
+}
+
+// HTTPClient returns a client that sends its requests to the server's HTTP
+// and HTTPS endpoints, by the host of their URL. Requests to hosts that no
+// tunnel serves fail with [ErrTunnelNotFound].
+func (s *Server) HTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:    s.dialHTTP("http"),
+			DialTLSContext: s.dialHTTP("https"),
+		},
+	}
+}
+
+// Returns a function that dials the endpoint of the protocol that serves the
+// host of an address. Connections from the edge to HTTPS endpoints are plain
+// HTTP, which the transport sends over the TLS dialer's connection as is.
+func (s *Server) dialHTTP(scheme string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		return s.dialTunnel(ctx, func(t *serverTunnel) bool {
+			u, err := url.Parse(t.info.URL)
+			return err == nil && t.info.Proto == scheme && u.Hostname() == host
+		}, scheme+"://"+addr)
+	}
+}
+
+// Opens a connection to the first tunnel that matches, which is described
+// by the target in errors.
+func (s *Server) dialTunnel(ctx context.Context, match func(*serverTunnel) bool, target string, opts ...DialOption) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var cfg dialConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrServerClosed
+	}
+	var tun *serverTunnel
+	for _, t := range s.tunnels {
+		if match(t) && (tun == nil || t.seq < tun.seq) {
+			tun = t
+		}
+	}
+	s.dials++
+	dials := s.dials
+	s.mu.Unlock()
+	if tun == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTunnelNotFound, target)
+	}
+
+	if cfg.clientAddr == "" {
+		cfg.clientAddr = net.JoinHostPort(fmt.Sprintf("192.0.2.%d", 1+(dials-1)%254), strconv.Itoa(40000+dials%20000))
+	}
+	if cfg.proto == "" {
+		cfg.proto = tun.info.Proto
+		if cfg.proto == "" {
+			cfg.proto = "https"
+		}
+	}
+	return tun.sess.openProxy(ctx, proto.ProxyHeader{
+		ID:             tun.info.ID,
+		ClientAddr:     cfg.clientAddr,
+		Proto:          cfg.proto,
+		PassthroughTLS: tun.passthroughTLS,
+	})
+}
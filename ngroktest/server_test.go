@@ -0,0 +1,254 @@
+package ngroktest
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok"
+	"golang.ngrok.com/ngrok/config"
+)
+
+func connect(t *testing.T, srv *Server, opts ...ngrok.ConnectOption) ngrok.Session {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	sess, err := srv.Connect(ctx, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sess.Close() })
+	return sess
+}
+
+func listen(t *testing.T, sess ngrok.Session, cfg config.Tunnel) ngrok.Tunnel {
+	tun, err := sess.Listen(context.Background(), cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tun.Close() })
+	return tun
+}
+
+// Echoes every connection accepted from the tunnel.
+func serveEcho(tun ngrok.Tunnel) {
+	go func() {
+		for {
+			conn, err := tun.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+}
+
+func requireEcho(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf))
+}
+
+func TestServerSession(t *testing.T) {
+	srv := NewServer(WithRegion("eu"), WithAuthtoken("secret"))
+	defer srv.Close()
+
+	sess := connect(t, srv)
+	require.NotEmpty(t, sess.ID())
+	require.Equal(t, "eu", sess.Region())
+	require.Equal(t, "eu", sess.Info().Region)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := srv.Connect(ctx, ngrok.WithAuthtoken("wrong"))
+	require.ErrorIs(t, err, ngrok.ErrAuthFailed)
+}
+
+func TestServerTCP(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	sess := connect(t, srv)
+
+	tun := listen(t, sess, config.TCPEndpoint(config.WithForwardsTo("upstream")))
+	require.True(t, strings.HasPrefix(tun.URL(), "tcp://"), tun.URL())
+	serveEcho(tun)
+
+	tunnels := srv.Tunnels()
+	require.Len(t, tunnels, 1)
+	require.Equal(t, TunnelInfo{
+		ID:         tun.ID(),
+		SessionID:  sess.ID(),
+		Proto:      "tcp",
+		URL:        tun.URL(),
+		ForwardsTo: "upstream",
+	}, tunnels[0])
+
+	conn, err := srv.Dial(context.Background(), tun.URL())
+	require.NoError(t, err)
+	requireEcho(t, conn)
+
+	conn, err = srv.Dial(context.Background(), tun.ID())
+	require.NoError(t, err)
+	requireEcho(t, conn)
+
+	_, err = srv.Dial(context.Background(), "tcp://nowhere:1")
+	require.ErrorIs(t, err, ErrTunnelNotFound)
+}
+
+func TestServerClientAddr(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	sess := connect(t, srv)
+	tun := listen(t, sess, config.TCPEndpoint())
+
+	addrs := make(chan string, 1)
+	go func() {
+		conn, err := tun.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		addrs <- conn.RemoteAddr().String()
+	}()
+
+	conn, err := srv.Dial(context.Background(), tun.URL(), WithClientAddr("203.0.113.7:1234"))
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, "203.0.113.7:1234", <-addrs)
+}
+
+func TestServerHTTP(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	sess := connect(t, srv)
+
+	tun := listen(t, sess, config.HTTPEndpoint(config.WithDomain("app.example.com")))
+	require.Equal(t, "https://app.example.com", tun.URL())
+	go func() {
+		_ = http.Serve(tun, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, r.Host+r.URL.Path)
+		}))
+	}()
+
+	resp, err := srv.HTTPClient().Get(tun.URL() + "/hello")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, "app.example.com/hello", string(body))
+
+	_, err = srv.HTTPClient().Get("http://app.example.com/")
+	require.ErrorIs(t, err, ErrTunnelNotFound)
+
+	_, err = sess.Listen(context.Background(), config.HTTPEndpoint(config.WithDomain("app.example.com")))
+	require.ErrorIs(t, err, ngrok.ErrDomainTaken)
+}
+
+func TestServerLabeled(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	sess := connect(t, srv)
+
+	tun := listen(t, sess, config.LabeledTunnel(config.WithLabel("edge", "edghts_1")))
+	serveEcho(tun)
+	require.Equal(t, map[string]string{"edge": "edghts_1"}, srv.Tunnels()[0].Labels)
+
+	conn, err := srv.Dial(context.Background(), tun.ID())
+	require.NoError(t, err)
+	requireEcho(t, conn)
+}
+
+func TestServerUnbind(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	sess := connect(t, srv)
+
+	tun, err := sess.Listen(context.Background(), config.TCPEndpoint())
+	require.NoError(t, err)
+	require.Len(t, srv.Tunnels(), 1)
+
+	require.NoError(t, tun.Close())
+	require.Empty(t, srv.Tunnels())
+	_, err = srv.Dial(context.Background(), tun.URL())
+	require.ErrorIs(t, err, ErrTunnelNotFound)
+}
+
+func TestServerClose(t *testing.T) {
+	srv := NewServer()
+	sess := connect(t, srv)
+	listen(t, sess, config.TCPEndpoint())
+
+	require.NoError(t, srv.Close())
+	require.Empty(t, srv.Tunnels())
+	_, err := srv.Dial(context.Background(), "anything")
+	require.ErrorIs(t, err, ErrServerClosed)
+}
+
+func TestServerAuthtokenRevoked(t *testing.T) {
+	srv := NewServer(WithAuthtoken("secret"))
+	defer srv.Close()
+
+	disconnects := make(chan error, 4)
+	// Reconnecting dials with the context passed to Connect, so it's never
+	// cancelled.
+	sess, err := srv.Connect(context.Background(), ngrok.WithDisconnectHandler(func(_ context.Context, _ ngrok.Session, err error) {
+		disconnects <- err
+	}))
+	require.NoError(t, err)
+	defer sess.Close()
+	tun := listen(t, sess, config.TCPEndpoint())
+
+	srv.SetAuthtoken("rotated")
+	_, err = tun.Accept()
+	require.ErrorIs(t, err, ngrok.ErrAuthExpired, "Accept reports why the session gave up")
+	require.ErrorIs(t, err, ngrok.ErrSessionLost)
+
+	var errs []error
+	for err := range disconnects {
+		if err == nil {
+			break
+		}
+		errs = append(errs, err)
+	}
+	require.NotEmpty(t, errs)
+	require.ErrorIs(t, errs[len(errs)-1], ngrok.ErrAuthExpired)
+}
+
+func TestServerAuthtokenRefreshed(t *testing.T) {
+	srv := NewServer(WithAuthtoken("secret"))
+	defer srv.Close()
+
+	connects := make(chan struct{}, 4)
+	sess, err := srv.Connect(context.Background(),
+		ngrok.WithTokenRefresh(func() (string, error) {
+			return "rotated", nil
+		}),
+		ngrok.WithReconnectBackoff(time.Millisecond, time.Millisecond),
+		ngrok.WithConnectHandler(func(context.Context, ngrok.Session) {
+			connects <- struct{}{}
+		}),
+	)
+	require.NoError(t, err)
+	defer sess.Close()
+	tun := listen(t, sess, config.TCPEndpoint())
+	serveEcho(tun)
+	<-connects
+
+	srv.SetAuthtoken("rotated")
+	select {
+	case <-connects:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "the session should reconnect with the refreshed token")
+	}
+	conn, err := srv.Dial(context.Background(), tun.URL())
+	require.NoError(t, err)
+	requireEcho(t, conn)
+}
@@ -0,0 +1,291 @@
+package ngroktest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.ngrok.com/ngrok/internal/muxado"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+// A session connected to the server.
+type serverSession struct {
+	srv *Server
+	mux muxado.TypedStreamSession
+	// Only accessed by auth, which sessions call before anything else.
+	id string
+}
+
+// A tunnel started by a session.
+type serverTunnel struct {
+	sess *serverSession
+	info TunnelInfo
+	seq  int
+	// Whether the edge passes the client's TLS through to the agent.
+	passthroughTLS bool
+}
+
+// Serves a session over its end of the pipe until the session ends.
+func (s *Server) serve(conn net.Conn) {
+	tlsConn := tls.Server(conn, s.tlsConfig)
+	_ = tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		_ = tlsConn.Close()
+		return
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+
+	// Answers the heartbeats of the session, without sending any of its own.
+	mux := muxado.NewHeartbeat(muxado.NewTypedStreamSession(muxado.Server(tlsConn, &muxado.Config{})), func(time.Duration) {}, muxado.NewHeartbeatConfig())
+	sess := &serverSession{srv: s, mux: mux}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = mux.Close()
+		return
+	}
+	s.sessions[sess] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, sess)
+		for id, t := range s.tunnels {
+			if t.sess == sess {
+				delete(s.tunnels, id)
+			}
+		}
+		s.mu.Unlock()
+		_ = mux.Close()
+	}()
+
+	for {
+		stream, err := mux.AcceptTypedStream()
+		if err != nil {
+			return
+		}
+		go sess.handle(stream)
+	}
+}
+
+// Answers an RPC from the session: a request sent as JSON on a stream of its
+// type, which the response is written back to.
+func (sess *serverSession) handle(stream muxado.TypedStream) {
+	defer stream.Close()
+
+	var resp any
+	dec := json.NewDecoder(stream)
+	switch proto.ReqType(stream.StreamType()) {
+	case proto.AuthReq:
+		var req proto.Auth
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		resp = sess.auth(&req)
+	case proto.BindReq:
+		var req proto.Bind
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		resp = sess.bind(&req)
+	case proto.StartTunnelWithLabelReq:
+		var req proto.StartTunnelWithLabel
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		resp = sess.bindLabels(&req)
+	case proto.UnbindReq:
+		var req proto.Unbind
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		resp = sess.unbind(&req)
+	case proto.SrvInfoReq:
+		var req proto.SrvInfo
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		resp = proto.SrvInfoResp{Region: sess.srv.region}
+	default:
+		return
+	}
+	_ = json.NewEncoder(stream).Encode(resp)
+}
+
+func (sess *serverSession) auth(req *proto.Auth) proto.AuthResp {
+	srv := sess.srv
+	if token := srv.getAuthtoken(); token != "" && req.Extra.Authtoken.PlainText() != token {
+		return proto.AuthResp{
+			Version: proto.Version,
+			Error:   "The authtoken you specified is not valid.\r\n\r\nERR_NGROK_107\r\n",
+		}
+	}
+
+	// Sessions keep their ID when they reconnect.
+	sess.id = req.ClientID
+	if sess.id == "" {
+		sess.id = "ts_" + randomID()
+	}
+	return proto.AuthResp{
+		Version:  proto.Version,
+		ClientID: sess.id,
+		Extra: proto.AuthRespExtra{
+			Version:     "ngroktest",
+			Region:      srv.region,
+			AccountName: "ngroktest",
+		},
+	}
+}
+
+func (sess *serverSession) bind(req *proto.Bind) proto.BindResp {
+	if err := proto.UnpackProtoOpts(req.Proto, req.Opts, req); err != nil {
+		return proto.BindResp{Error: err.Error()}
+	}
+
+	tun := &serverTunnel{
+		sess: sess,
+		info: TunnelInfo{
+			ID:         req.ClientID,
+			Proto:      req.Proto,
+			ForwardsTo: req.ForwardsTo,
+			Metadata:   req.Extra.Metadata,
+		},
+	}
+
+	srv := sess.srv
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	switch opts := req.Opts.(type) {
+	case *proto.HTTPEndpoint:
+		opts.Hostname = hostname(opts.Domain, opts.Hostname, opts.Subdomain)
+		tun.info.URL = req.Proto + "://" + opts.Hostname
+	case *proto.TLSEndpoint:
+		opts.Hostname = hostname(opts.Domain, opts.Hostname, opts.Subdomain)
+		tun.info.URL = "tls://" + opts.Hostname
+		tun.passthroughTLS = opts.TLSTermination == nil
+	case *proto.TCPEndpoint:
+		if opts.Addr == "" {
+			opts.Addr = "1.tcp." + rootDomain + ":" + strconv.Itoa(srv.tcpPort)
+			srv.tcpPort++
+		}
+		tun.info.URL = "tcp://" + opts.Addr
+	default:
+		return proto.BindResp{Error: fmt.Sprintf("ngroktest: unsupported protocol: %s", req.Proto)}
+	}
+
+	for _, t := range srv.tunnels {
+		if t.info.URL == tun.info.URL && t.info.ID != tun.info.ID {
+			return proto.BindResp{Error: fmt.Sprintf("The endpoint '%s' is already online.\r\n\r\nERR_NGROK_334\r\n", tun.info.URL)}
+		}
+	}
+	srv.addTunnel(tun)
+
+	return proto.BindResp{
+		ClientID: tun.info.ID,
+		URL:      tun.info.URL,
+		Proto:    req.Proto,
+		Opts:     req.Opts,
+	}
+}
+
+func (sess *serverSession) bindLabels(req *proto.StartTunnelWithLabel) proto.StartTunnelWithLabelResp {
+	if len(req.Labels) == 0 {
+		return proto.StartTunnelWithLabelResp{Error: "ngroktest: labeled tunnels need at least one label"}
+	}
+	tun := &serverTunnel{
+		sess: sess,
+		info: TunnelInfo{
+			Labels:     req.Labels,
+			ForwardsTo: req.ForwardsTo,
+			Metadata:   req.Metadata,
+		},
+	}
+
+	srv := sess.srv
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.addTunnel(tun)
+	return proto.StartTunnelWithLabelResp{ID: tun.info.ID}
+}
+
+// Records a tunnel, giving it an ID if it has none, and replacing any with
+// the same ID, which a session that's reconnecting rebinds its tunnels with.
+// The server's lock must be held.
+func (s *Server) addTunnel(tun *serverTunnel) {
+	if tun.info.ID == "" {
+		tun.info.ID = "tn_" + randomID()
+	}
+	tun.info.SessionID = tun.sess.id
+	s.binds++
+	tun.seq = s.binds
+	s.tunnels[tun.info.ID] = tun
+}
+
+func (sess *serverSession) unbind(req *proto.Unbind) proto.UnbindResp {
+	srv := sess.srv
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	t, ok := srv.tunnels[req.ClientID]
+	if !ok || t.sess != sess {
+		return proto.UnbindResp{Error: fmt.Sprintf("ngroktest: no tunnel with ID %s", req.ClientID)}
+	}
+	delete(srv.tunnels, req.ClientID)
+	return proto.UnbindResp{}
+}
+
+// Opens a proxy stream to the session, which starts with the length of the
+// header as an 8 byte little-endian integer, followed by the header as JSON.
+func (sess *serverSession) openProxy(ctx context.Context, header proto.ProxyHeader) (net.Conn, error) {
+	stream, err := sess.mux.OpenTypedStream(muxado.StreamType(proto.ProxyReq))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(header)
+	if err != nil {
+		_ = stream.Close()
+		return nil, err
+	}
+	buf := make([]byte, 8, 8+len(raw))
+	binary.LittleEndian.PutUint64(buf, uint64(len(raw)))
+	buf = append(buf, raw...)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetWriteDeadline(deadline)
+		defer func() { _ = stream.SetWriteDeadline(time.Time{}) }()
+	}
+	if _, err := stream.Write(buf); err != nil {
+		_ = stream.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// The hostname of an HTTP or TLS endpoint, which is the domain that it asked
+// for, or a random one under the root domain.
+func hostname(domain, host, subdomain string) string {
+	switch {
+	case domain != "":
+		return domain
+	case host != "":
+		return host
+	case subdomain != "":
+		return subdomain + "." + rootDomain
+	}
+	return randomID() + "." + rootDomain
+}
+
+func randomID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -123,6 +124,24 @@ func TestWithHTTPHandler(t *testing.T) {
 	require.NoError(t, tun.CloseWithContext(ctx))
 }
 
+func TestListenAndServeHTTP(t *testing.T) {
+	onlineTest(t)
+	ctx := context.Background()
+
+	tun, err := ListenAndServeHTTP(ctx, config.HTTPEndpoint(), helloHandler, WithAuthtokenFromEnv())
+	require.NoError(t, err, "ListenAndServeHTTP")
+
+	resp, err := http.Get(tun.URL())
+	require.NoError(t, err, "GET tunnel url")
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err, "Read response body")
+	require.Equal(t, "Hello, world!\n", string(body), "HTTP Body Contents")
+
+	require.NoError(t, tun.CloseWithContext(ctx))
+	<-tun.Done()
+}
+
 func TestHTTPS(t *testing.T) {
 	ctx := context.Background()
 	tun, exited := serveHTTP(ctx, t, nil,
@@ -669,3 +688,38 @@ func TestNonExported(t *testing.T) {
 
 	require.NotEmpty(t, sess.(interface{ Region() string }).Region())
 }
+
+// Counts the connections dialed to the ngrok service.
+type countingDialer struct {
+	net.Dialer
+	dials int32
+}
+
+func (cd *countingDialer) Dial(network, addr string) (net.Conn, error) {
+	return cd.DialContext(context.Background(), network, addr)
+}
+
+func (cd *countingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	atomic.AddInt32(&cd.dials, 1)
+	return cd.Dialer.DialContext(ctx, network, addr)
+}
+
+func TestTunnelsShareSession(t *testing.T) {
+	ctx := context.Background()
+	dialer := &countingDialer{}
+	sess := setupSession(ctx, t, WithDialer(dialer), WithMaxTunnels(3))
+	defer sess.Close()
+
+	var tuns []Tunnel
+	for i := 0; i < 3; i++ {
+		tuns = append(tuns, startTunnel(ctx, t, sess, config.HTTPEndpoint()))
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&dialer.dials), "tunnels share the session's connection")
+
+	_, err := sess.Listen(ctx, config.HTTPEndpoint())
+	require.ErrorIs(t, err, errTooManyTunnels{})
+
+	require.NoError(t, tuns[0].Close())
+	tuns[0] = startTunnel(ctx, t, sess, config.HTTPEndpoint())
+	require.Equal(t, int32(1), atomic.LoadInt32(&dialer.dials))
+}
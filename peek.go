@@ -0,0 +1,68 @@
+package ngrok
+
+import (
+	"bufio"
+	"errors"
+	"net"
+)
+
+// A net.Conn that allows inspecting the first bytes sent by the client
+// without consuming them. Bytes that have been peeked are replayed to
+// subsequent calls to Read.
+type peekConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// The most bytes that a peekConn can peek at, unless it's made with
+// newPeekConnSize. The same as bufio's default.
+const defaultBufSize = 4096
+
+func newPeekConn(conn net.Conn) *peekConn {
+	return newPeekConnSize(conn, defaultBufSize)
+}
+
+// Returns a peekConn that can peek at up to size bytes.
+func newPeekConnSize(conn net.Conn, size int) *peekConn {
+	return &peekConn{
+		Conn: conn,
+		r:    bufio.NewReaderSize(conn, size),
+	}
+}
+
+// Peek blocks until the first bytes arrive from the client and returns
+// whatever is available without waiting for more. The returned slice is only
+// valid until the next call to Read.
+func (c *peekConn) Peek() ([]byte, error) {
+	return c.PeekMore(0)
+}
+
+// PeekMore is like Peek, but blocks until more than the n bytes that have
+// already been peeked have arrived.
+func (c *peekConn) PeekMore(n int) ([]byte, error) {
+	if _, err := c.r.Peek(n + 1); err != nil {
+		return nil, err
+	}
+	return c.r.Peek(c.r.Buffered())
+}
+
+// PeekN blocks until the first n bytes arrive from the client, and returns
+// them without consuming them.
+func (c *peekConn) PeekN(n int) ([]byte, error) {
+	return c.r.Peek(n)
+}
+
+func (c *peekConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *peekConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+func (c *peekConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("connection doesn't support CloseWrite")
+}
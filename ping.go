@@ -0,0 +1,114 @@
+package ngrok
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The path under which requests made by PingRTT are answered by Serve and
+// friends, rather than being passed to the handler.
+const pingPathPrefix = "/.well-known/ngrok-go-ping/"
+
+// Tokens for the pings that a tunnel is waiting on.
+type pingTokens struct {
+	mu     sync.Mutex
+	tokens map[string]struct{}
+}
+
+func (p *pingTokens) add() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tokens == nil {
+		p.tokens = map[string]struct{}{}
+	}
+	p.tokens[token] = struct{}{}
+	return token, nil
+}
+
+func (p *pingTokens) remove(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.tokens, token)
+}
+
+func (p *pingTokens) has(token string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.tokens[token]
+	return ok
+}
+
+func (t *tunnelImpl) PingRTT(ctx context.Context) (time.Duration, error) {
+	u, err := url.Parse(t.URL())
+	if err != nil || (u.Scheme != ProtoHTTP && u.Scheme != ProtoHTTPS) {
+		return 0, ErrNotSupported
+	}
+
+	token, err := t.pings.add()
+	if err != nil {
+		return 0, err
+	}
+	defer t.pings.remove(token)
+
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	target := strings.TrimSuffix(t.URL(), "/") + pingPathPrefix + token
+
+	ping := func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("ping through %s was answered with %s; is the tunnel being served by ngrok.Serve?", t.URL(), resp.Status)
+		}
+		return nil
+	}
+
+	// The first ping pays for DNS and the connection and TLS handshakes,
+	// which would otherwise dwarf the round trip itself.
+	if err := ping(); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if err := ping(); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// Answers the requests made by PingRTT to the tunnel that the connection was
+// accepted from, passing everything else on to the handler.
+func pingHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if token := strings.TrimPrefix(req.URL.Path, pingPathPrefix); token != req.URL.Path {
+			if conn, ok := req.Context().Value(tunnelConnKey{}).(*connImpl); ok && conn.Tun.pings.has(token) {
+				rw.Header().Set("Cache-Control", "no-store")
+				rw.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		handler.ServeHTTP(rw, req)
+	})
+}
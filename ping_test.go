@@ -0,0 +1,56 @@
+package ngrok
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPingRTT(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).Tunnel.(*fakeClientTunnel).url = "http://" + addr
+
+	paths := make(chan string, 10)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		paths <- req.URL.Path
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = Serve(ctx, tun, handler)
+	}()
+
+	rtt, err := tun.PingRTT(ctx)
+	require.NoError(t, err)
+	require.Positive(t, rtt)
+	require.Empty(t, paths, "pings aren't passed to the handler")
+
+	// Only pings that the tunnel is waiting on are answered.
+	resp, err := http.Get("http://" + addr + pingPathPrefix + "guess")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, pingPathPrefix+"guess", <-paths)
+}
+
+func TestPingRTTNotSupported(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+
+	_, err := tun.PingRTT(context.Background())
+	require.ErrorIs(t, err, ErrNotSupported, "TCP tunnels can't be pinged")
+}
+
+func TestPingRTTNotServed(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).Tunnel.(*fakeClientTunnel).url = "http://" + addr
+
+	go func() {
+		_ = http.Serve(tun, helloHandler)
+	}()
+
+	_, err := tun.PingRTT(context.Background())
+	require.Error(t, err, "pings aren't answered without Serve")
+}
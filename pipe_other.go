@@ -0,0 +1,21 @@
+//go:build !windows
+
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+var errNoNamedPipes = errors.New("named pipes are only supported on Windows")
+
+func checkNamedPipes() error {
+	return errNoNamedPipes
+}
+
+type pipeDialer struct{}
+
+func (pipeDialer) DialContext(ctx context.Context, network, name string) (net.Conn, error) {
+	return nil, errNoNamedPipes
+}
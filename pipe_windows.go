@@ -0,0 +1,66 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Windows' ERROR_PIPE_BUSY, returned while every instance of a pipe is in use.
+const errorPipeBusy syscall.Errno = 231
+
+// How often to retry a named pipe whose instances are all in use.
+const pipeBusyRetry = 10 * time.Millisecond
+
+func checkNamedPipes() error {
+	return nil
+}
+
+// Dials Windows named pipes.
+type pipeDialer struct{}
+
+func (pipeDialer) DialContext(ctx context.Context, network, name string) (net.Conn, error) {
+	path, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: pipeAddr(name), Err: err}
+	}
+	for {
+		handle, err := syscall.CreateFile(path,
+			syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_EXISTING, 0, 0)
+		if err == nil {
+			return &pipeConn{File: os.NewFile(uintptr(handle), name), addr: pipeAddr(name)}, nil
+		}
+		if !errors.Is(err, errorPipeBusy) {
+			return nil, &net.OpError{Op: "dial", Net: network, Addr: pipeAddr(name), Err: err}
+		}
+		timer := time.NewTimer(pipeBusyRetry)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// A client connection to a named pipe.
+type pipeConn struct {
+	*os.File
+	addr pipeAddr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+type pipeAddr string
+
+func (pipeAddr) Network() string  { return "pipe" }
+func (a pipeAddr) String() string { return string(a) }
@@ -0,0 +1,502 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+// The interval between health checks if WithPoolHealthCheck isn't used.
+const defaultPoolCheckInterval = 10 * time.Second
+
+// How long a Pool waits to unbind the tunnel of an unhealthy member, or to
+// start it again once it recovers.
+const poolRebindTimeout = 10 * time.Second
+
+// PoolOption customizes a [Pool].
+type PoolOption func(*poolConfig)
+
+// Options for a Pool.
+type poolConfig struct {
+	// How often the members' health is checked.
+	CheckInterval time.Duration
+	// The heartbeat latency above which a member is unhealthy.
+	// Unbounded when 0.
+	MaxLatency time.Duration
+	// The fewest members that are kept serving, even if they're unhealthy.
+	MinServing int
+}
+
+// WithPoolHealthCheck sets how often a [Pool] checks the health of its
+// members, and the heartbeat latency above which a member's session is
+// considered unhealthy. A maxLatency of zero only considers whether the
+// session is connected to the ngrok service and answering heartbeats.
+//
+// Defaults to checking every 10 seconds, with no bound on latency.
+func WithPoolHealthCheck(interval, maxLatency time.Duration) PoolOption {
+	return func(cfg *poolConfig) {
+		cfg.CheckInterval = interval
+		cfg.MaxLatency = maxLatency
+	}
+}
+
+// WithPoolMinServing sets the fewest members that a [Pool] keeps serving. If
+// fewer than this many are healthy, the unhealthy members with the lowest
+// latency keep serving too, so that a problem affecting every session, such as
+// this host's own network, doesn't turn every connection away.
+//
+// Defaults to 1.
+func WithPoolMinServing(n int) PoolOption {
+	return func(cfg *poolConfig) {
+		cfg.MinServing = n
+	}
+}
+
+// Pool accepts connections from tunnels on several sessions at once, such as
+// sessions to different regions, or redundant sessions to the same one, and
+// presents them as a single [net.Listener]. Use [ListenPool] to start a
+// tunnel on each of a set of sessions, or [NewPool] for tunnels that are
+// already started.
+//
+// The pool periodically checks the health of each member's session, by
+// whether it's connected to the ngrok service and by its heartbeat latency,
+// as configured by [WithPoolHealthCheck]. New connections are steered away
+// from unhealthy members by closing their tunnels, so that the ngrok edge
+// stops routing to them, and the tunnels are started again on the same
+// sessions with the same configuration once they recover. The connections
+// that they had already accepted keep working. Tunnels that weren't started
+// by [Session].Listen, and so can't be started again, are put into drain mode
+// instead (see [Tunnel].SetDraining). Members whose tunnels terminate without
+// the pool closing them are dropped from the pool, and the others keep
+// serving.
+//
+// Since a Pool is a net.Listener, it can be passed to [http.Serve] or any
+// other server that accepts from one.
+type Pool struct {
+	cfg     poolConfig
+	clock   clock
+	members []*poolMember
+
+	accepted chan net.Conn
+	// Closed once every member's tunnel has terminated.
+	allDone chan struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	wg        sync.WaitGroup
+}
+
+// A tunnel in a Pool, and the results of its last health check.
+type poolMember struct {
+	sess Session
+	// The configuration to start the tunnel again with once it's been
+	// unbound. Nil if it's unknown, in which case the tunnel is drained
+	// instead.
+	cfg config.Tunnel
+
+	mu  sync.Mutex
+	tun Tunnel
+	// Non-nil while the pool has closed the tunnel to stop the edge from
+	// routing to it, and closed once it's been started again.
+	unbound chan struct{}
+	healthy bool
+	serving bool
+	latency time.Duration
+	err     error
+}
+
+// Returns the member's current tunnel, and whether the pool has unbound it.
+func (m *poolMember) current() (Tunnel, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tun, m.unbound != nil
+}
+
+// Reports whether the member's tunnel has terminated without the pool
+// closing it.
+func (m *poolMember) terminated() bool {
+	tun, unbound := m.current()
+	if unbound {
+		return false
+	}
+	select {
+	case <-tun.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// PoolMember describes the state of one of the tunnels in a [Pool], as of its
+// last health check.
+type PoolMember struct {
+	// The tunnel. Each time the pool starts it again after it's been
+	// unbound, this is a new Tunnel.
+	Tunnel Tunnel
+	// Whether its session passed the last health check.
+	Healthy bool
+	// Whether it's accepting new connections, rather than being unbound or
+	// draining them.
+	Serving bool
+	// The heartbeat latency of its session. Zero if it couldn't be measured.
+	Latency time.Duration
+	// Why it failed the last health check, if it did.
+	Err error
+}
+
+// ListenPool starts a tunnel with the same configuration on each of the
+// sessions, and returns a [Pool] that accepts from all of them. If any of the
+// tunnels can't be started, those that were are closed, and the error is
+// returned. The sessions aren't closed along with the pool.
+func ListenPool(ctx context.Context, tunnelConfig config.Tunnel, sessions []Session, opts ...PoolOption) (*Pool, error) {
+	if len(sessions) == 0 {
+		return nil, errors.New("failed to start pool: no sessions")
+	}
+	tunnels := make([]Tunnel, 0, len(sessions))
+	for _, sess := range sessions {
+		tun, err := sess.Listen(ctx, tunnelConfig)
+		if err != nil {
+			for _, started := range tunnels {
+				_ = started.Close()
+			}
+			return nil, fmt.Errorf("failed to start pool: %w", err)
+		}
+		tunnels = append(tunnels, tun)
+	}
+	return NewPool(tunnels, opts...), nil
+}
+
+// NewPool returns a [Pool] that accepts from the tunnels, which it takes
+// ownership of: they're closed when the pool is. A pool of no tunnels is
+// already done: its Accept returns an error right away.
+func NewPool(tunnels []Tunnel, opts ...PoolOption) *Pool {
+	cfg := poolConfig{
+		CheckInterval: defaultPoolCheckInterval,
+		MinServing:    1,
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	p := &Pool{
+		cfg:      cfg,
+		clock:    systemClock,
+		accepted: make(chan net.Conn),
+		allDone:  make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	var accepting sync.WaitGroup
+	for _, tun := range tunnels {
+		m := &poolMember{sess: tun.Session(), tun: tun, healthy: true, serving: true}
+		if impl, ok := tun.(*tunnelImpl); ok {
+			m.cfg = impl.cfg
+			if impl.clock != nil {
+				p.clock = impl.clock
+			}
+		}
+		p.members = append(p.members, m)
+		accepting.Add(1)
+		go func() {
+			defer accepting.Done()
+			p.acceptFrom(m)
+		}()
+	}
+	go func() {
+		accepting.Wait()
+		close(p.allDone)
+	}()
+
+	if cfg.CheckInterval > 0 && len(p.members) > 0 {
+		p.wg.Add(1)
+		go p.checkHealth()
+	}
+	return p
+}
+
+// Passes the connections from a member's tunnel on to Accept, until it
+// terminates or the pool is closed. While the pool has unbound it, waits for
+// it to be started again.
+func (p *Pool) acceptFrom(m *poolMember) {
+	for {
+		tun, _ := m.current()
+		conn, err := tun.Accept()
+		if err != nil {
+			m.mu.Lock()
+			replaced, unbound := m.tun != tun, m.unbound
+			m.mu.Unlock()
+			if replaced {
+				continue
+			}
+			if unbound == nil {
+				return
+			}
+			select {
+			case <-unbound:
+				continue
+			case <-p.closed:
+				return
+			}
+		}
+		select {
+		case p.accepted <- conn:
+		case <-p.closed:
+			_ = conn.Close()
+			return
+		}
+	}
+}
+
+// Accept returns the next connection from any of the pool's tunnels. Once
+// the pool is closed, or every tunnel has terminated, it returns an error
+// wrapping [net.ErrClosed].
+func (p *Pool) Accept() (net.Conn, error) {
+	select {
+	case conn := <-p.accepted:
+		return conn, nil
+	case <-p.closed:
+		return nil, errAcceptFailed{Inner: net.ErrClosed}
+	case <-p.allDone:
+		// A connection may have been handed off just before the last
+		// tunnel terminated.
+		select {
+		case conn := <-p.accepted:
+			return conn, nil
+		default:
+		}
+		return nil, errAcceptFailed{Inner: fmt.Errorf("every tunnel in the pool has terminated: %w", net.ErrClosed)}
+	}
+}
+
+// Close closes every tunnel in the pool. Connections that were already
+// accepted are left open.
+func (p *Pool) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		for _, m := range p.members {
+			tun, unbound := m.current()
+			if unbound {
+				continue
+			}
+			if closeErr := tun.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+		p.wg.Wait()
+	})
+	return err
+}
+
+// Addr returns the address of the pool's first tunnel, or a placeholder if
+// the pool has none.
+func (p *Pool) Addr() net.Addr {
+	if len(p.members) == 0 {
+		return poolAddr{}
+	}
+	tun, _ := p.members[0].current()
+	return tun.Addr()
+}
+
+type poolAddr struct{}
+
+func (poolAddr) Network() string { return "pool" }
+func (poolAddr) String() string  { return "pool" }
+
+// Members returns the state of each of the pool's tunnels that's still open,
+// as of the last health check.
+func (p *Pool) Members() []PoolMember {
+	var members []PoolMember
+	for _, m := range p.members {
+		if m.terminated() {
+			continue
+		}
+		m.mu.Lock()
+		members = append(members, PoolMember{
+			Tunnel:  m.tun,
+			Healthy: m.healthy,
+			Serving: m.serving,
+			Latency: m.latency,
+			Err:     m.err,
+		})
+		m.mu.Unlock()
+	}
+	return members
+}
+
+// ServePool is like [Serve], but serves the connections accepted from every
+// tunnel in the [Pool]. The pool is closed when ServePool returns, and the
+// returned error matches [ErrTunnelClosed] if every tunnel in it terminated.
+func ServePool(ctx context.Context, pool *Pool, handler http.Handler, opts ...ServeOption) error {
+	cfg := serveConfig{}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	srv := cfg.server(handler)
+
+	return serve(ctx, srv, func() error {
+		return srv.Serve(pool)
+	})
+}
+
+func (p *Pool) checkHealth() {
+	defer p.wg.Done()
+	timer := p.clock.NewTimer(p.cfg.CheckInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C():
+			p.rebalance()
+			timer.Reset(p.cfg.CheckInterval)
+		case <-p.closed:
+			return
+		case <-p.allDone:
+			return
+		}
+	}
+}
+
+// Checks the health of every member, and then chooses the ones that serve
+// new connections.
+func (p *Pool) rebalance() {
+	var (
+		wg   sync.WaitGroup
+		live []*poolMember
+	)
+	for _, m := range p.members {
+		if m.terminated() {
+			continue
+		}
+		live = append(live, m)
+		wg.Add(1)
+		go func(m *poolMember) {
+			defer wg.Done()
+			latency, err := p.probe(m.sess)
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.latency, m.err = latency, err
+			m.healthy = err == nil
+		}(m)
+	}
+	wg.Wait()
+
+	// Healthy members first, then the rest by latency, with those whose
+	// latency couldn't be measured last.
+	sort.SliceStable(live, func(i, j int) bool {
+		a, b := live[i], live[j]
+		if a.healthy != b.healthy {
+			return a.healthy
+		}
+		if (a.latency == 0) != (b.latency == 0) {
+			return a.latency != 0
+		}
+		return a.latency < b.latency
+	})
+	for i, m := range live {
+		m.mu.Lock()
+		serving := m.healthy || i < p.cfg.MinServing
+		m.mu.Unlock()
+		wg.Add(1)
+		go func(m *poolMember) {
+			defer wg.Done()
+			p.setServing(m, serving)
+		}(m)
+	}
+	wg.Wait()
+}
+
+// Starts or stops the edge routing new connections to a member, by starting
+// its tunnel again or unbinding it, or by draining it if it can't be started
+// again.
+func (p *Pool) setServing(m *poolMember, serving bool) {
+	tun, unbound := m.current()
+	if m.cfg == nil {
+		tun.SetDraining(!serving)
+		m.mu.Lock()
+		m.serving = serving
+		m.mu.Unlock()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), poolRebindTimeout)
+	defer cancel()
+	switch {
+	case serving && unbound:
+		started, err := m.sess.Listen(ctx, m.cfg)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if err != nil {
+			m.err = fmt.Errorf("failed to start tunnel again: %w", err)
+			return
+		}
+		select {
+		case <-p.closed:
+			_ = started.Close()
+			return
+		default:
+		}
+		m.tun = started
+		close(m.unbound)
+		m.unbound = nil
+		m.serving = true
+	case !serving && !unbound:
+		m.mu.Lock()
+		m.unbound = make(chan struct{})
+		m.serving = false
+		m.mu.Unlock()
+		_ = tun.CloseWithContext(ctx)
+	}
+}
+
+// Checks the health of a member's session, returning its heartbeat latency
+// if it could be measured, and an error if the member is unhealthy.
+func (p *Pool) probe(sess Session) (time.Duration, error) {
+	if impl, ok := sess.(*sessionImpl); ok {
+		if state, _ := impl.state.get(); state != ConnStateConnected {
+			return 0, fmt.Errorf("session is %s", state)
+		}
+	}
+
+	heartbeater, ok := sess.(interface {
+		Heartbeat() (time.Duration, error)
+	})
+	if !ok {
+		return 0, nil
+	}
+
+	type result struct {
+		latency time.Duration
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		latency, err := heartbeater.Heartbeat()
+		done <- result{latency, err}
+	}()
+
+	// A heartbeat that takes longer than the interval between checks is
+	// as good as lost.
+	timer := p.clock.NewTimer(p.cfg.CheckInterval)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return 0, fmt.Errorf("heartbeat failed: %w", res.err)
+		}
+		if p.cfg.MaxLatency > 0 && res.latency > p.cfg.MaxLatency {
+			return res.latency, fmt.Errorf("heartbeat latency %s exceeds %s", res.latency, p.cfg.MaxLatency)
+		}
+		return res.latency, nil
+	case <-timer.C():
+		return 0, errors.New("heartbeat timed out")
+	case <-p.closed:
+		return 0, net.ErrClosed
+	}
+}
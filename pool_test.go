@@ -0,0 +1,247 @@
+package ngrok
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+const testPoolCheckInterval = 10 * time.Millisecond
+
+// A Session with a heartbeat latency that can be changed by tests, which
+// starts fake tunnels.
+type fakeHeartbeatSession struct {
+	noSession
+	// Nanoseconds, accessed atomically.
+	latency int64
+
+	t *testing.T
+	// Receives the addresses of the tunnels that are started.
+	listened chan string
+}
+
+func (s *fakeHeartbeatSession) Listen(ctx context.Context, cfg config.Tunnel) (Tunnel, error) {
+	tun, addr := fakeTunnel(s.t)
+	tun.(*tunnelImpl).Sess = s
+	tun.(*tunnelImpl).cfg = cfg
+	s.listened <- addr
+	return tun, nil
+}
+
+func (s *fakeHeartbeatSession) setLatency(latency time.Duration) {
+	atomic.StoreInt64(&s.latency, int64(latency))
+}
+
+func (s *fakeHeartbeatSession) Heartbeat() (time.Duration, error) {
+	return time.Duration(atomic.LoadInt64(&s.latency)), nil
+}
+
+// Returns a fake tunnel on a session with the given heartbeat latency.
+func fakePoolTunnel(t *testing.T, latency time.Duration) (Tunnel, string, *fakeHeartbeatSession) {
+	sess := &fakeHeartbeatSession{t: t, listened: make(chan string, 1)}
+	sess.setLatency(latency)
+	tun, err := sess.Listen(context.Background(), config.TCPEndpoint())
+	require.NoError(t, err)
+	return tun, <-sess.listened, sess
+}
+
+// Returns a fake tunnel on a session with the given heartbeat latency, which
+// can't be started again, as if it weren't started by Session.Listen.
+func fakeDrainedPoolTunnel(t *testing.T, latency time.Duration) (Tunnel, string, *fakeHeartbeatSession) {
+	tun, addr, sess := fakePoolTunnel(t, latency)
+	tun.(*tunnelImpl).cfg = nil
+	return tun, addr, sess
+}
+
+func requirePoolAccepts(t *testing.T, pool *Pool, addr string) {
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := pool.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hi"))
+	require.NoError(t, err)
+	buf := make([]byte, 2)
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = client.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hi", string(buf))
+}
+
+func servingMembers(pool *Pool) map[Session]bool {
+	serving := map[Session]bool{}
+	for _, m := range pool.Members() {
+		serving[m.Tunnel.Session()] = m.Serving
+	}
+	return serving
+}
+
+func TestPoolAccept(t *testing.T) {
+	first, firstAddr, _ := fakePoolTunnel(t, time.Millisecond)
+	second, secondAddr, _ := fakePoolTunnel(t, time.Millisecond)
+
+	pool := NewPool([]Tunnel{first, second})
+	require.Equal(t, first.Addr(), pool.Addr())
+
+	requirePoolAccepts(t, pool, firstAddr)
+	requirePoolAccepts(t, pool, secondAddr)
+
+	require.NoError(t, pool.Close())
+	_, err := pool.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+	require.ErrorIs(t, first.Err(), ErrTunnelClosed, "closing the pool closes its tunnels")
+	require.ErrorIs(t, second.Err(), ErrTunnelClosed, "closing the pool closes its tunnels")
+}
+
+func TestPoolSteersAwayFromSlowSessions(t *testing.T) {
+	fast, fastAddr, _ := fakePoolTunnel(t, time.Millisecond)
+	slow, slowAddr, slowSess := fakePoolTunnel(t, time.Millisecond)
+
+	pool := NewPool([]Tunnel{fast, slow}, WithPoolHealthCheck(testPoolCheckInterval, 50*time.Millisecond))
+	defer pool.Close()
+
+	// A connection accepted before the session turns slow keeps working.
+	client, err := net.Dial("tcp", slowAddr)
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := pool.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	slowSess.setLatency(time.Second)
+	require.Eventually(t, func() bool {
+		return !servingMembers(pool)[slowSess]
+	}, time.Second, testPoolCheckInterval, "slow sessions stop serving")
+
+	for _, m := range pool.Members() {
+		if m.Tunnel.Session() == slowSess {
+			require.False(t, m.Healthy)
+			require.Equal(t, time.Second, m.Latency)
+			require.Error(t, m.Err)
+		} else {
+			require.True(t, m.Healthy)
+			require.True(t, m.Serving)
+			require.NoError(t, m.Err)
+		}
+	}
+	require.ErrorIs(t, slow.Err(), ErrTunnelClosed, "the slow session's tunnel is unbound")
+	requirePoolAccepts(t, pool, fastAddr)
+	_, err = io.WriteString(conn, "hi")
+	require.NoError(t, err)
+	_, err = io.ReadFull(client, make([]byte, 2))
+	require.NoError(t, err)
+
+	slowSess.setLatency(time.Millisecond)
+	require.Eventually(t, func() bool {
+		return servingMembers(pool)[slowSess]
+	}, time.Second, testPoolCheckInterval, "recovered sessions serve again")
+	restartedAddr := <-slowSess.listened
+	requirePoolAccepts(t, pool, restartedAddr)
+	for _, m := range pool.Members() {
+		if m.Tunnel.Session() == slowSess {
+			require.NotEqual(t, slow, m.Tunnel, "the tunnel is started again")
+		}
+	}
+}
+
+func TestPoolDrainsTunnelsThatCantRestart(t *testing.T) {
+	fast, _, _ := fakeDrainedPoolTunnel(t, time.Millisecond)
+	slow, _, slowSess := fakeDrainedPoolTunnel(t, time.Millisecond)
+
+	pool := NewPool([]Tunnel{fast, slow}, WithPoolHealthCheck(testPoolCheckInterval, 50*time.Millisecond))
+	defer pool.Close()
+
+	slowSess.setLatency(time.Second)
+	require.Eventually(t, func() bool {
+		return !servingMembers(pool)[slowSess]
+	}, time.Second, testPoolCheckInterval, "slow sessions stop serving")
+	require.True(t, slow.Describe().Draining)
+	require.NoError(t, slow.Err())
+
+	slowSess.setLatency(time.Millisecond)
+	require.Eventually(t, func() bool {
+		return servingMembers(pool)[slowSess]
+	}, time.Second, testPoolCheckInterval, "recovered sessions serve again")
+	require.False(t, slow.Describe().Draining)
+}
+
+func TestPoolMinServing(t *testing.T) {
+	slower, _, slowerSess := fakePoolTunnel(t, time.Millisecond)
+	slow, slowAddr, slowSess := fakePoolTunnel(t, time.Millisecond)
+
+	pool := NewPool([]Tunnel{slower, slow}, WithPoolHealthCheck(testPoolCheckInterval, 50*time.Millisecond))
+	defer pool.Close()
+
+	slowerSess.setLatency(2 * time.Second)
+	slowSess.setLatency(time.Second)
+	require.Eventually(t, func() bool {
+		serving := servingMembers(pool)
+		return !serving[slowerSess] && serving[slowSess]
+	}, time.Second, testPoolCheckInterval, "the least unhealthy session keeps serving")
+
+	requirePoolAccepts(t, pool, slowAddr)
+}
+
+func TestPoolMemberTerminated(t *testing.T) {
+	first, _, _ := fakePoolTunnel(t, time.Millisecond)
+	second, secondAddr, _ := fakePoolTunnel(t, time.Millisecond)
+
+	pool := NewPool([]Tunnel{first, second})
+	defer pool.Close()
+
+	require.NoError(t, first.Close())
+	require.Eventually(t, func() bool {
+		return len(pool.Members()) == 1
+	}, time.Second, testPoolCheckInterval, "terminated tunnels are dropped")
+	requirePoolAccepts(t, pool, secondAddr)
+
+	require.NoError(t, second.Close())
+	_, err := pool.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestPoolEmpty(t *testing.T) {
+	pool := NewPool(nil)
+	require.Equal(t, "pool", pool.Addr().String())
+	_, err := pool.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+	require.NoError(t, pool.Close())
+}
+
+func TestServePool(t *testing.T) {
+	first, firstAddr, _ := fakePoolTunnel(t, time.Millisecond)
+	second, _, _ := fakePoolTunnel(t, time.Millisecond)
+	pool := NewPool([]Tunnel{first, second})
+
+	served := make(chan error, 1)
+	go func() {
+		served <- ServePool(context.Background(), pool, helloHandler)
+	}()
+
+	resp, err := http.Get("http://" + firstAddr)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.NotEmpty(t, body)
+
+	require.NoError(t, first.Close())
+	require.NoError(t, second.Close())
+	select {
+	case err := <-served:
+		require.ErrorIs(t, err, ErrTunnelClosed)
+	case <-time.After(time.Second):
+		require.FailNow(t, "ServePool didn't return once every tunnel terminated")
+	}
+}
@@ -0,0 +1,156 @@
+package ngrok
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+// The largest proxy header that ParseProxyHeader accepts, matching the
+// tunnel client.
+const maxProxyHeaderSize = 64 * 1024
+
+// ProxyInfo describes a connection proxied by the ngrok edge, as parsed from
+// the header that precedes it by [ParseProxyHeader].
+type ProxyInfo struct {
+	// The ID of the tunnel binding that the edge proxied the connection for.
+	BindID string
+	// The address of the client that initiated the connection at the edge.
+	ClientAddr string
+	// The IP address from ClientAddr. The zero netip.Addr if it couldn't be
+	// parsed.
+	ClientIP netip.Addr
+	// The protocol of the connection.
+	Proto string
+	// The type of edge that the connection arrived at, for labeled tunnels.
+	EdgeType string
+	// Whether the edge passed the client's TLS through without terminating
+	// it.
+	PassthroughTLS bool
+	// The server name that the client requested via SNI, if the edge passed
+	// its TLS through and it sent one.
+	ServerName string
+	// The fields of the header that aren't otherwise interpreted, keyed by
+	// name and left as the raw JSON sent by the edge. Nil if there are none.
+	Extra map[string]json.RawMessage
+}
+
+// ParseProxyHeader reads the header that the ngrok edge sends ahead of each
+// connection that it proxies to a tunnel, for applications that handle these
+// connections without a [Tunnel], e.g. behind a listener of their own. It
+// returns the parsed header, and a reader positioned at the start of the
+// connection's payload, which must be used in place of r from then on.
+//
+// If the edge passed the client's TLS through, the ServerName of the
+// ProxyInfo is read from the ClientHello, which is left to be read from the
+// returned reader too.
+//
+// If r doesn't start with a header, ParseProxyHeader returns the zero
+// ProxyInfo with a nil error, and a reader that replays everything read from
+// r. Telling this apart needs the first 8 bytes of r, so ParseProxyHeader
+// blocks until they've arrived or r is exhausted. Headers that are truncated
+// or malformed are reported as errors.
+func ParseProxyHeader(r io.Reader) (ProxyInfo, io.Reader, error) {
+	br := bufio.NewReaderSize(r, maxTLSRecordSize)
+
+	prefix, err := br.Peek(8)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			// Too short to be a header.
+			return ProxyInfo{}, br, nil
+		}
+		return ProxyInfo{}, nil, err
+	}
+	size := binary.LittleEndian.Uint64(prefix)
+	if size > maxProxyHeaderSize {
+		// Something other than a header, such as an HTTP request or a TLS
+		// ClientHello, which would have an implausibly large length.
+		return ProxyInfo{}, br, nil
+	}
+	_, _ = br.Discard(8)
+
+	raw := make([]byte, size)
+	if _, err := io.ReadFull(br, raw); err != nil {
+		return ProxyInfo{}, nil, fmt.Errorf("truncated proxy header: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return ProxyInfo{}, nil, fmt.Errorf("malformed proxy header: %w", err)
+	}
+	var header struct {
+		ID             string `json:"Id"`
+		ClientAddr     string
+		Proto          string
+		EdgeType       string
+		PassthroughTLS bool
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return ProxyInfo{}, nil, fmt.Errorf("malformed proxy header: %w", err)
+	}
+
+	info := ProxyInfo{
+		BindID:         header.ID,
+		ClientAddr:     header.ClientAddr,
+		Proto:          header.Proto,
+		EdgeType:       header.EdgeType,
+		PassthroughTLS: header.PassthroughTLS,
+	}
+	if addrPort, err := netip.ParseAddrPort(header.ClientAddr); err == nil {
+		info.ClientIP = addrPort.Addr()
+	}
+	info.Extra = extraProxyHeaderFields(fields)
+
+	if info.PassthroughTLS {
+		// The client speaks first in TLS, so this doesn't wait on the
+		// application. A payload that isn't a ClientHello is left for the
+		// application to reject.
+		info.ServerName, _ = peekReaderServerName(br)
+	}
+
+	return info, br, nil
+}
+
+// The proxy header fields that ProxyInfo and EdgeRouteInfo expose directly.
+var knownProxyHeaderFields = map[string]bool{
+	"Id":             true,
+	"ClientAddr":     true,
+	"Proto":          true,
+	"EdgeType":       true,
+	"PassthroughTLS": true,
+}
+
+// Returns the fields of a proxy header that aren't in ProxyHeader, or nil if
+// there are none.
+func extraProxyHeaderFields(fields map[string]json.RawMessage) map[string]json.RawMessage {
+	var extra map[string]json.RawMessage
+	for name, value := range fields {
+		if knownProxyHeaderFields[name] {
+			continue
+		}
+		if extra == nil {
+			extra = map[string]json.RawMessage{}
+		}
+		extra[name] = value
+	}
+	return extra
+}
+
+// Like peekServerName, but for a buffered reader.
+func peekReaderServerName(br *bufio.Reader) (string, error) {
+	header, err := br.Peek(5)
+	if err != nil {
+		return "", err
+	}
+	if header[0] != 0x16 {
+		return "", errors.New("not a TLS handshake")
+	}
+	record, err := br.Peek(5 + int(binary.BigEndian.Uint16(header[3:])))
+	if err != nil {
+		return "", err
+	}
+	return parseServerName(record[5:])
+}
@@ -0,0 +1,87 @@
+package ngrok
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Frames a proxy header as the ngrok edge sends it.
+func proxyHeader(body string) []byte {
+	header := make([]byte, 8, 8+len(body))
+	binary.LittleEndian.PutUint64(header, uint64(len(body)))
+	return append(header, body...)
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	input := append(proxyHeader(`{"Id":"bind_123","ClientAddr":"203.0.113.5:4444","Proto":"http","EdgeType":"https","RouteId":"edghtsrt_456"}`),
+		"GET / HTTP/1.1\r\n\r\n"...)
+
+	info, payload, err := ParseProxyHeader(bytes.NewReader(input))
+	require.NoError(t, err)
+	require.Equal(t, "bind_123", info.BindID)
+	require.Equal(t, "203.0.113.5:4444", info.ClientAddr)
+	require.Equal(t, netip.MustParseAddr("203.0.113.5"), info.ClientIP)
+	require.Equal(t, "http", info.Proto)
+	require.Equal(t, "https", info.EdgeType)
+	require.False(t, info.PassthroughTLS)
+	require.Empty(t, info.ServerName)
+	require.Len(t, info.Extra, 1)
+	require.JSONEq(t, `"edghtsrt_456"`, string(info.Extra["RouteId"]))
+
+	rest, err := io.ReadAll(payload)
+	require.NoError(t, err)
+	require.Equal(t, "GET / HTTP/1.1\r\n\r\n", string(rest))
+}
+
+func TestParseProxyHeaderServerName(t *testing.T) {
+	hello := clientHello(t, "app.example.com")
+	input := append(proxyHeader(`{"Id":"bind_123","ClientAddr":"[2001:db8::1]:4444","Proto":"tls","PassthroughTLS":true}`), hello...)
+
+	info, payload, err := ParseProxyHeader(bytes.NewReader(input))
+	require.NoError(t, err)
+	require.True(t, info.PassthroughTLS)
+	require.Equal(t, "app.example.com", info.ServerName)
+	require.Equal(t, netip.MustParseAddr("2001:db8::1"), info.ClientIP)
+	require.Nil(t, info.Extra)
+
+	rest, err := io.ReadAll(payload)
+	require.NoError(t, err)
+	require.Equal(t, hello, rest, "the ClientHello is left in the payload")
+}
+
+func TestParseProxyHeaderPassthrough(t *testing.T) {
+	for _, input := range []string{
+		"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03",
+		"short",
+		"",
+	} {
+		info, payload, err := ParseProxyHeader(strings.NewReader(input))
+		require.NoError(t, err)
+		require.Zero(t, info)
+		rest, err := io.ReadAll(payload)
+		require.NoError(t, err)
+		require.Equal(t, input, string(rest), "everything is replayed")
+	}
+}
+
+func TestParseProxyHeaderMalformed(t *testing.T) {
+	for name, input := range map[string][]byte{
+		"truncated body": proxyHeader(`{"Id":"bind_123"}`)[:12],
+		"invalid json":   proxyHeader(`{"Id":`),
+		"not an object":  proxyHeader(`["bind_123"]`),
+		"wrong types":    proxyHeader(`{"Id":123}`),
+		"empty":          proxyHeader(``),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := ParseProxyHeader(bytes.NewReader(input))
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package ngrok
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+// WithUpstreamProxyProto configures [Forward] and [Tunnel].Forward to send a
+// PROXY protocol header of the given version to the upstream ahead of each
+// forwarded connection, so that upstreams such as HAProxy, nginx, or Postgres
+// behind a proxy can see the address of the client that connected to the
+// ngrok edge rather than this process. The header's destination is the
+// upstream's own address, since the edge's isn't known.
+//
+// This is separate from config.WithProxyProto, which has the edge send a
+// PROXY header at the start of each connection's payload. That header is
+// forwarded as-is, so the two shouldn't be combined.
+//
+// Forward returns an error without accepting any connections if the version
+// isn't config.ProxyProtoV1 or config.ProxyProtoV2. config.ProxyProtoNone
+// disables the header, which is the default.
+func WithUpstreamProxyProto(version config.ProxyProtoVersion) ForwardOption {
+	return func(cfg *forwardConfig) {
+		switch version {
+		case config.ProxyProtoNone, config.ProxyProtoV1, config.ProxyProtoV2:
+			cfg.ProxyProto = version
+		default:
+			cfg.Err = fmt.Errorf("unsupported PROXY protocol version %d", version)
+		}
+	}
+}
+
+// The signature that starts every PROXY protocol v2 header.
+var proxyProtoV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Encodes a PROXY protocol header for a connection from src to dst. Addresses
+// that aren't TCP addresses are reported as unknown.
+func proxyProtoHeader(version config.ProxyProtoVersion, src, dst net.Addr) []byte {
+	srcTCP, srcOK := src.(*net.TCPAddr)
+	dstTCP, dstOK := dst.(*net.TCPAddr)
+	known := srcOK && dstOK && srcTCP.IP != nil && dstTCP.IP != nil
+
+	// Both addresses must be of the same family, so an IPv4 address is
+	// mapped into IPv6 when the other is IPv6.
+	var srcIP, dstIP net.IP
+	v4 := false
+	if known {
+		srcIP, dstIP = srcTCP.IP.To4(), dstTCP.IP.To4()
+		v4 = srcIP != nil && dstIP != nil
+		if !v4 {
+			srcIP, dstIP = srcTCP.IP.To16(), dstTCP.IP.To16()
+		}
+	}
+
+	if version == config.ProxyProtoV1 {
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		family := "TCP6"
+		if v4 {
+			family = "TCP4"
+		}
+		// net.IP would format mapped IPv4 addresses as IPv4.
+		srcAddr, _ := netip.AddrFromSlice(srcIP)
+		dstAddr, _ := netip.AddrFromSlice(dstIP)
+		return []byte("PROXY " + family + " " + srcAddr.String() + " " + dstAddr.String() + " " +
+			strconv.Itoa(srcTCP.Port) + " " + strconv.Itoa(dstTCP.Port) + "\r\n")
+	}
+
+	header := append([]byte(nil), proxyProtoV2Signature...)
+	// Version 2, PROXY command.
+	header = append(header, 0x21)
+	if !known {
+		// AF_UNSPEC, with no addresses.
+		return append(header, 0x00, 0x00, 0x00)
+	}
+	family := byte(0x21) // AF_INET6, STREAM
+	if v4 {
+		family = 0x11 // AF_INET, STREAM
+	}
+	addrs := make([]byte, 0, 2*len(srcIP)+4)
+	addrs = append(addrs, srcIP...)
+	addrs = append(addrs, dstIP...)
+	var ports [4]byte
+	binary.BigEndian.PutUint16(ports[:2], uint16(srcTCP.Port))
+	binary.BigEndian.PutUint16(ports[2:], uint16(dstTCP.Port))
+	addrs = append(addrs, ports[:]...)
+
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(addrs)))
+	header = append(header, family)
+	header = append(header, length[:]...)
+	return append(header, addrs...)
+}
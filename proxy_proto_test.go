@@ -0,0 +1,81 @@
+package ngrok
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+func TestProxyProtoHeader(t *testing.T) {
+	v4 := &net.TCPAddr{IP: net.ParseIP("203.0.113.5"), Port: 4444}
+	v4Dst := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080}
+	v6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 4444}
+
+	require.Equal(t, "PROXY TCP4 203.0.113.5 127.0.0.1 4444 8080\r\n",
+		string(proxyProtoHeader(config.ProxyProtoV1, v4, v4Dst)))
+	require.Equal(t, "PROXY TCP6 2001:db8::1 ::ffff:127.0.0.1 4444 8080\r\n",
+		string(proxyProtoHeader(config.ProxyProtoV1, v6, v4Dst)))
+	require.Equal(t, "PROXY UNKNOWN\r\n",
+		string(proxyProtoHeader(config.ProxyProtoV1, &net.UnixAddr{}, v4Dst)))
+
+	v2 := proxyProtoHeader(config.ProxyProtoV2, v4, v4Dst)
+	require.Equal(t, append(append([]byte(nil), proxyProtoV2Signature...),
+		0x21, 0x11, 0x00, 12,
+		203, 0, 113, 5,
+		127, 0, 0, 1,
+		0x11, 0x5c, 0x1f, 0x90,
+	), v2)
+
+	v2 = proxyProtoHeader(config.ProxyProtoV2, v6, v4Dst)
+	require.Equal(t, byte(0x21), v2[13], "mixed families are sent as IPv6")
+	require.Len(t, v2, 16+36)
+
+	require.Equal(t, append(append([]byte(nil), proxyProtoV2Signature...), 0x21, 0x00, 0x00, 0x00),
+		proxyProtoHeader(config.ProxyProtoV2, nil, v4Dst))
+}
+
+func TestForwardUpstreamProxyProto(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		lines <- line
+	}()
+
+	tun, addr := fakeTunnel(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = tun.Forward(ctx, l.Addr().String(), WithUpstreamProxyProto(config.ProxyProtoV1))
+	}()
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = io.WriteString(client, "payload\n")
+	require.NoError(t, err)
+
+	clientAddr := client.LocalAddr().(*net.TCPAddr)
+	upstreamAddr := l.Addr().(*net.TCPAddr)
+	require.Equal(t, string(proxyProtoHeader(config.ProxyProtoV1, clientAddr, upstreamAddr)), <-lines,
+		"the header precedes the payload, and carries the client's address")
+}
+
+func TestUpstreamProxyProtoInvalid(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+	err := Forward(context.Background(), tun, "127.0.0.1:1", WithUpstreamProxyProto(3))
+	require.Error(t, err)
+}
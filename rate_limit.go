@@ -0,0 +1,51 @@
+package ngrok
+
+// WithMaxBytesPerSecond limits the connections accepted from all of the
+// session's tunnels, together, to reading and writing bytesPerSec bytes per
+// second, independently in each direction, so that an embedded Session can't
+// saturate the host's uplink. Short bursts of up to one second's worth of
+// data are allowed. Tunnels can be limited further with
+// config.WithMaxBytesPerSecond.
+//
+// The limit can be changed on the running session with
+// [Session].SetMaxBytesPerSecond.
+//
+// Disabled when 0, the default.
+func WithMaxBytesPerSecond(bytesPerSec int64) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.MaxBytesPerSecond = bytesPerSec
+	}
+}
+
+// WithMaxConnsPerSecond limits the rate at which connections are accepted
+// from all of the session's tunnels, together, to connsPerSec connections per
+// second, with bursts of up to one second's worth. Connections that arrive
+// faster are rejected with [ErrRateLimited]: HTTP clients are sent a 429 Too
+// Many Requests response, and the rest are reset. Tunnels can be limited
+// further with config.WithMaxConnsPerSecond.
+//
+// The limit can be changed on the running session with
+// [Session].SetMaxConnsPerSecond.
+//
+// Disabled when 0, the default.
+func WithMaxConnsPerSecond(connsPerSec float64) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.MaxConnsPerSecond = connsPerSec
+	}
+}
+
+func (s *sessionImpl) SetMaxBytesPerSecond(bytesPerSec int64) {
+	s.limits.setBytesPerSecond(bytesPerSec)
+}
+
+func (s *sessionImpl) SetMaxConnsPerSecond(connsPerSec float64) {
+	s.limits.setConnsPerSecond(connsPerSec)
+}
+
+func (t *tunnelImpl) SetMaxBytesPerSecond(bytesPerSec int64) {
+	t.limits.setBytesPerSecond(bytesPerSec)
+}
+
+func (t *tunnelImpl) SetMaxConnsPerSecond(connsPerSec float64) {
+	t.limits.setConnsPerSecond(connsPerSec)
+}
@@ -0,0 +1,106 @@
+package ngrok
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// RequestMetadata describes an HTTP request served over a [Tunnel], as
+// returned by [RequestInfo].
+type RequestMetadata struct {
+	// The ID of the tunnel that the request arrived on. Empty if it didn't
+	// arrive over a tunnel.
+	TunnelID string
+	// The ID of the connection that the request arrived over, unique within
+	// its tunnel. See [Conn].ConnID.
+	ConnID uint64
+	// The region of the ngrok edge that the request arrived through. Empty
+	// if the tunnel isn't from a Session.
+	Region string
+	// The IP address of the client that connected to the ngrok edge, or of
+	// the request's remote address if it didn't arrive over a tunnel. The
+	// zero netip.Addr if neither could be parsed.
+	ClientIP netip.Addr
+	// The addresses in the request's X-Forwarded-For headers, in order. The
+	// ngrok edge appends the address of the client that connected to it, so
+	// any earlier entries were supplied by the client or the proxies in
+	// front of it, and shouldn't be trusted without knowing what those are.
+	ForwardedFor []string
+	// The scheme that the client used, from X-Forwarded-Proto if it's set,
+	// and otherwise "https" or "http" depending on whether the request was
+	// served over TLS.
+	Proto string
+	// The host that the client requested, from X-Forwarded-Host if it's set,
+	// and otherwise the request's Host.
+	Host string
+	// The value of the request's X-Request-Id header, which can be added
+	// at the ngrok edge with a Traffic Policy, or by proxies in front of it.
+	RequestID string
+}
+
+// RequestInfo returns what's known about an HTTP request from the ngrok edge
+// and the connection that it arrived over, for requests served by [Serve],
+// [ServeTLS], and the other functions of this package that serve HTTP from a
+// [Tunnel]. To use it with an [http.Server] of your own, set its ConnContext
+// to [ConnContext].
+//
+// Requests that didn't arrive over a tunnel are described from their headers
+// and remote address alone.
+func RequestInfo(req *http.Request) RequestMetadata {
+	info := RequestMetadata{
+		Proto:     req.Header.Get("X-Forwarded-Proto"),
+		Host:      req.Header.Get("X-Forwarded-Host"),
+		RequestID: req.Header.Get("X-Request-Id"),
+	}
+
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		for _, addr := range strings.Split(header, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				info.ForwardedFor = append(info.ForwardedFor, addr)
+			}
+		}
+	}
+
+	if info.Proto == "" {
+		info.Proto = "http"
+		if req.TLS != nil {
+			info.Proto = "https"
+		}
+	}
+	if info.Host == "" {
+		info.Host = req.Host
+	}
+
+	clientAddr := req.RemoteAddr
+	if conn, ok := ConnFromContext(req.Context()); ok {
+		edge := conn.EdgeProxyInfo()
+		info.TunnelID = conn.(*connImpl).Tun.ID()
+		info.ConnID = conn.ConnID()
+		info.Region = edge.Region
+		if edge.ClientAddr != "" {
+			clientAddr = edge.ClientAddr
+		}
+	}
+	if addrPort, err := netip.ParseAddrPort(clientAddr); err == nil {
+		info.ClientIP = addrPort.Addr().Unmap()
+	} else if addr, err := netip.ParseAddr(clientAddr); err == nil {
+		info.ClientIP = addr.Unmap()
+	}
+
+	return info
+}
+
+// ConnContext stores the connection accepted from a [Tunnel] in the context
+// of each request served over it, so that [ConnFromContext], [RequestInfo],
+// and [PeerCertificates] work with an [http.Server] of your own:
+//
+//	srv := &http.Server{Handler: handler, ConnContext: ngrok.ConnContext}
+//	srv.Serve(tun)
+//
+// Servers started by this package already do this.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return withTunnelConn(ctx, c)
+}
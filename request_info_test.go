@@ -0,0 +1,64 @@
+package ngrok
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestInfo(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).Sess = regionSession{}
+
+	infos := make(chan RequestMetadata, 1)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		infos <- RequestInfo(req)
+	})
+
+	// A server of the application's own, relying on ConnContext.
+	srv := &http.Server{Handler: handler, ConnContext: ConnContext}
+	go func() {
+		_ = srv.Serve(tun)
+	}()
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	req.Host = "example.ngrok.app"
+	req.Header.Add("X-Forwarded-For", "10.0.0.1, 203.0.113.7")
+	req.Header.Add("X-Forwarded-For", "127.0.0.1")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Request-Id", "req_123")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	info := <-infos
+	require.Equal(t, "fake", info.TunnelID)
+	require.Equal(t, uint64(1), info.ConnID)
+	require.Equal(t, "eu", info.Region)
+	require.Equal(t, netip.MustParseAddr("127.0.0.1"), info.ClientIP)
+	require.Equal(t, []string{"10.0.0.1", "203.0.113.7", "127.0.0.1"}, info.ForwardedFor)
+	require.Equal(t, "https", info.Proto)
+	require.Equal(t, "example.ngrok.app", info.Host)
+	require.Equal(t, "req_123", info.RequestID)
+}
+
+func TestRequestInfoNoTunnel(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.RemoteAddr = "[::ffff:192.0.2.1]:4567"
+
+	info := RequestInfo(req)
+	require.Empty(t, info.TunnelID)
+	require.Equal(t, netip.MustParseAddr("192.0.2.1"), info.ClientIP)
+	require.Equal(t, "http", info.Proto)
+	require.Equal(t, "example.com", info.Host)
+	require.Empty(t, info.ForwardedFor)
+
+	// Requests from contexts without a connection aren't affected.
+	require.Equal(t, context.Background(), ConnContext(context.Background(), nil))
+}
@@ -0,0 +1,136 @@
+package ngrok
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+// WithTrustedProxies configures the clients whose X-Forwarded-For,
+// X-Forwarded-Proto, and X-Forwarded-Host headers are trusted by
+// [ServeReverseProxy]. If a request arrives from a trusted client, the real
+// client's address is appended to its X-Forwarded-For header, and its other
+// forwarded headers are passed through. Otherwise, all three headers are
+// replaced.
+//
+// If unset, no clients are trusted.
+func WithTrustedProxies(prefixes ...netip.Prefix) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.TrustedProxies = append(cfg.TrustedProxies, prefixes...)
+	}
+}
+
+// ServeReverseProxy is like [Serve], but proxies every request to the target
+// URL, as with [httputil.NewSingleHostReverseProxy].
+//
+// Requests sent to the target carry the address of the client that connected
+// to the ngrok edge in X-Forwarded-For, along with X-Forwarded-Proto and
+// X-Forwarded-Host. See [WithTrustedProxies] for how these interact with
+// forwarded headers already present on the request.
+func ServeReverseProxy(ctx context.Context, tun Tunnel, target *url.URL, opts ...ServeOption) error {
+	cfg := serveConfig{}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	proxy := cfg.reverseProxy(target)
+	srv := cfg.proxyServer(proxy)
+
+	return serve(ctx, srv, func() error {
+		return srv.Serve(tun)
+	})
+}
+
+// Creates the proxy to a single target for ServeReverseProxy and
+// ServeBackends.
+func (cfg *serveConfig) reverseProxy(target *url.URL) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.BufferPool = copyBufferPool(0)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		cfg.setForwardedHeaders(req)
+	}
+	return proxy
+}
+
+// Creates the server for ServeReverseProxy and ServeBackends, which serves
+// requests to the handler with the address of the client at the ngrok edge as
+// their RemoteAddr.
+func (cfg *serveConfig) proxyServer(handler http.Handler) *http.Server {
+	srv := cfg.server(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// httputil.ReverseProxy appends the RemoteAddr of the incoming
+		// request to X-Forwarded-For, so it has to be the client's
+		// address rather than the tunnel's.
+		if proxyConn, ok := req.Context().Value(proxyHeaderKey{}).(*tunnel_client.ProxyConn); ok && proxyConn.Header.ClientAddr != "" {
+			req.RemoteAddr = proxyConn.Header.ClientAddr
+		}
+		handler.ServeHTTP(rw, req)
+	}))
+	srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		return withProxyHeader(withTunnelConn(ctx, c), c)
+	}
+	return srv
+}
+
+type proxyHeaderKey struct{}
+
+// Stores the ngrok proxy header for the connection in its context, so that
+// it's available to the handlers of each request made over it.
+func withProxyHeader(ctx context.Context, c net.Conn) context.Context {
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		c = tlsConn.NetConn()
+	}
+	if proxyConn, ok := c.(interface {
+		ProxyConn() *tunnel_client.ProxyConn
+	}); ok {
+		return context.WithValue(ctx, proxyHeaderKey{}, proxyConn.ProxyConn())
+	}
+	return ctx
+}
+
+// Sets the X-Forwarded-* headers on a request about to be sent to the
+// target. X-Forwarded-For is completed by httputil.ReverseProxy, which appends
+// the client's address to whatever is left in place.
+func (cfg *serveConfig) setForwardedHeaders(req *http.Request) {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if proxyConn, ok := req.Context().Value(proxyHeaderKey{}).(*tunnel_client.ProxyConn); ok && proxyConn.Header.Proto == ProtoHTTPS {
+		scheme = "https"
+	}
+
+	if !cfg.isTrustedProxy(req.RemoteAddr) {
+		req.Header.Del("X-Forwarded-For")
+		req.Header.Del("X-Forwarded-Proto")
+		req.Header.Del("X-Forwarded-Host")
+	}
+
+	if req.Header.Get("X-Forwarded-Proto") == "" {
+		req.Header.Set("X-Forwarded-Proto", scheme)
+	}
+	if req.Header.Get("X-Forwarded-Host") == "" {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+	}
+}
+
+func (cfg *serveConfig) isTrustedProxy(remoteAddr string) bool {
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range cfg.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package ngrok
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Starts a reverse proxy to a backend that echoes the forwarded headers it
+// receives, and returns the proxy's address.
+func startReverseProxy(t *testing.T, opts ...ServeOption) string {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(rw).Encode(map[string]string{
+			"For":   r.Header.Get("X-Forwarded-For"),
+			"Proto": r.Header.Get("X-Forwarded-Proto"),
+			"Host":  r.Header.Get("X-Forwarded-Host"),
+		})
+	}))
+	t.Cleanup(backend.Close)
+
+	target, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	// Use an address that can't be confused with the loopback address that
+	// the test connects from.
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).Tunnel.(*fakeClientTunnel).header.ClientAddr = "203.0.113.7:1234"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		_ = ServeReverseProxy(ctx, tun, target, opts...)
+	}()
+
+	return addr
+}
+
+func forwardedHeaders(t *testing.T, addr string, headers map[string]string) map[string]string {
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr, nil)
+	require.NoError(t, err)
+	req.Host = "example.com"
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var got map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	return got
+}
+
+func TestServeReverseProxy(t *testing.T) {
+	addr := startReverseProxy(t)
+
+	require.Equal(t, map[string]string{
+		"For":   "203.0.113.7",
+		"Proto": "http",
+		"Host":  "example.com",
+	}, forwardedHeaders(t, addr, nil))
+}
+
+func TestServeReverseProxyUntrusted(t *testing.T) {
+	addr := startReverseProxy(t, WithTrustedProxies(netip.MustParsePrefix("10.0.0.0/8")))
+
+	require.Equal(t, map[string]string{
+		"For":   "203.0.113.7",
+		"Proto": "http",
+		"Host":  "example.com",
+	}, forwardedHeaders(t, addr, map[string]string{
+		"X-Forwarded-For":   "1.2.3.4",
+		"X-Forwarded-Proto": "https",
+		"X-Forwarded-Host":  "spoofed.example.com",
+	}), "forwarded headers from untrusted clients are replaced")
+}
+
+func TestServeReverseProxyTrusted(t *testing.T) {
+	addr := startReverseProxy(t, WithTrustedProxies(netip.MustParsePrefix("203.0.113.0/24")))
+
+	require.Equal(t, map[string]string{
+		"For":   "1.2.3.4, 203.0.113.7",
+		"Proto": "https",
+		"Host":  "upstream.example.com",
+	}, forwardedHeaders(t, addr, map[string]string{
+		"X-Forwarded-For":   "1.2.3.4",
+		"X-Forwarded-Proto": "https",
+		"X-Forwarded-Host":  "upstream.example.com",
+	}), "forwarded headers from trusted clients are chained")
+}
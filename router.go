@@ -0,0 +1,175 @@
+package ngrok
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Router demultiplexes the HTTP requests arriving over a single [Tunnel]
+// between handlers registered by host and path prefix, for sessions that host
+// several virtual services behind one domain, or a tunnel whose domain is a
+// wildcard.
+//
+// Patterns take the form "[host]/path". A path that ends in a slash matches
+// every path beneath it, and any other path only matches itself. A host
+// matches requests for that host, ignoring the port, and a host of the form
+// "*.example.com" matches requests for any subdomain of example.com. Patterns
+// without a host match requests for every host.
+//
+// Requests are routed to the most specific matching pattern: patterns with an
+// exact host come before those with a wildcard host, which come before those
+// without any, and longer paths come before shorter ones. Requests that match
+// no pattern are answered with 404 Not Found. Register "/" to handle them
+// instead.
+//
+// Handlers may be registered while the Router is serving. Router is an
+// [http.Handler] itself, and may also be served with [Serve] and the rest of
+// this package's serving functions.
+type Router struct {
+	tun Tunnel
+
+	mu     sync.RWMutex
+	routes []*route
+}
+
+// A pattern registered with a Router.
+type route struct {
+	pattern string
+	// The exact host, or the suffix of a wildcard host including its leading
+	// dot. Empty if the route matches every host.
+	host     string
+	wildcard bool
+	path     string
+	handler  http.Handler
+}
+
+// NewRouter creates a [Router] for the requests arriving over the [Tunnel].
+func NewRouter(tun Tunnel) *Router {
+	return &Router{tun: tun}
+}
+
+// Handle registers the handler for the pattern. It panics if the pattern is
+// invalid or was already registered, as [http.ServeMux].Handle does.
+func (r *Router) Handle(pattern string, handler http.Handler) {
+	if handler == nil {
+		panic("ngrok: nil handler for pattern " + pattern)
+	}
+	rt, err := parseRoute(pattern)
+	if err != nil {
+		panic("ngrok: " + err.Error())
+	}
+	rt.handler = handler
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.routes {
+		if existing.host == rt.host && existing.wildcard == rt.wildcard && existing.path == rt.path {
+			panic(fmt.Sprintf("ngrok: pattern %q conflicts with %q", pattern, existing.pattern))
+		}
+	}
+	r.routes = append(r.routes, rt)
+	sort.SliceStable(r.routes, func(i, j int) bool {
+		return r.routes[i].before(r.routes[j])
+	})
+}
+
+// HandleFunc registers the handler function for the pattern. See Handle.
+func (r *Router) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	r.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// Handler returns the handler that the request would be routed to, and the
+// pattern that it was registered with. If no pattern matches, it returns a
+// handler answering with 404 Not Found and an empty pattern.
+func (r *Router) Handler(req *http.Request) (http.Handler, string) {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rt := range r.routes {
+		if rt.matches(host, req.URL.Path) {
+			return rt.handler, rt.pattern
+		}
+	}
+	return http.NotFoundHandler(), ""
+}
+
+// ServeHTTP routes the request to the handler of the most specific matching
+// pattern.
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	handler, _ := r.Handler(req)
+	handler.ServeHTTP(w, req)
+}
+
+// Serve serves the Router's routes over its [Tunnel] with [Serve].
+func (r *Router) Serve(ctx context.Context, opts ...ServeOption) error {
+	return Serve(ctx, r.tun, r, opts...)
+}
+
+func parseRoute(pattern string) (*route, error) {
+	slash := strings.Index(pattern, "/")
+	if slash < 0 {
+		return nil, fmt.Errorf("pattern %q has no path", pattern)
+	}
+	rt := &route{
+		pattern: pattern,
+		host:    strings.ToLower(pattern[:slash]),
+		path:    pattern[slash:],
+	}
+	if strings.HasPrefix(rt.host, "*.") {
+		rt.wildcard = true
+		rt.host = rt.host[1:]
+	}
+	if strings.Contains(rt.host, "*") || rt.host == "." {
+		return nil, fmt.Errorf("pattern %q has an invalid host", pattern)
+	}
+	return rt, nil
+}
+
+func (rt *route) matches(host, path string) bool {
+	switch {
+	case rt.host == "":
+	case rt.wildcard:
+		if !strings.HasSuffix(host, rt.host) || len(host) == len(rt.host) {
+			return false
+		}
+	case host != rt.host:
+		return false
+	}
+	if strings.HasSuffix(rt.path, "/") {
+		return strings.HasPrefix(path, rt.path)
+	}
+	return path == rt.path
+}
+
+// Whether the route is more specific than another, and so should be tried
+// first.
+func (rt *route) before(other *route) bool {
+	if rank, otherRank := rt.hostRank(), other.hostRank(); rank != otherRank {
+		return rank > otherRank
+	}
+	if len(rt.host) != len(other.host) {
+		return len(rt.host) > len(other.host)
+	}
+	return len(rt.path) > len(other.path)
+}
+
+func (rt *route) hostRank() int {
+	switch {
+	case rt.host == "":
+		return 0
+	case rt.wildcard:
+		return 1
+	default:
+		return 2
+	}
+}
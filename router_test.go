@@ -0,0 +1,112 @@
+package ngrok
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	router := NewRouter(nil)
+	for _, pattern := range []string{
+		"/",
+		"/api/",
+		"/api/v2/",
+		"/health",
+		"api.example.com/",
+		"*.example.com/",
+		"*.example.com/static/",
+		"admin.example.com/internal/",
+	} {
+		pattern := pattern
+		router.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, pattern)
+		})
+	}
+
+	cases := []struct {
+		host, path, pattern string
+	}{
+		{"other.com", "/", "/"},
+		{"other.com", "/api/users", "/api/"},
+		{"other.com", "/api/v2/users", "/api/v2/"},
+		{"other.com", "/health", "/health"},
+		{"other.com", "/health/deep", "/"},
+		{"api.example.com", "/api/users", "api.example.com/"},
+		{"API.example.com:443", "/", "api.example.com/"},
+		{"web.example.com", "/static/app.js", "*.example.com/static/"},
+		{"web.example.com", "/index.html", "*.example.com/"},
+		{"example.com", "/index.html", "/"},
+		{"admin.example.com", "/internal/users", "admin.example.com/internal/"},
+		{"admin.example.com", "/", "*.example.com/"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.host+tc.path, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			req.Host = tc.host
+			_, pattern := router.Handler(req)
+			require.Equal(t, tc.pattern, pattern)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			require.Equal(t, tc.pattern, rec.Body.String())
+		})
+	}
+}
+
+func TestRouterNotFound(t *testing.T) {
+	router := NewRouter(nil)
+	router.HandleFunc("api.example.com/", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = "other.com"
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestRouterInvalidPatterns(t *testing.T) {
+	router := NewRouter(nil)
+	router.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, pattern := range []string{"", "example.com", "*/", "a.*.com/", "/api/"} {
+		require.Panics(t, func() {
+			router.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {})
+		}, pattern)
+	}
+	require.Panics(t, func() {
+		router.Handle("/other", nil)
+	})
+}
+
+func TestRouterServe(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	router := NewRouter(tun)
+	router.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "api")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = router.Serve(ctx)
+	}()
+
+	// Routes registered while serving are picked up.
+	router.HandleFunc("/web/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "web")
+	})
+
+	for _, path := range []string{"/api/", "/web/"} {
+		resp, err := http.Get("http://" + addr + path)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, path[1:4], string(body))
+	}
+}
@@ -0,0 +1,611 @@
+package ngrok
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+var (
+	// ErrServeShutdown is matched by the errors returned by [Serve] and
+	// [ServeTLS] when they stop because their context was cancelled.
+	ErrServeShutdown = errors.New("server shut down")
+	// ErrTunnelClosed is matched by the errors returned by [Serve] and
+	// [ServeTLS] when they stop because the [Tunnel] was closed.
+	ErrTunnelClosed = errors.New("tunnel closed")
+)
+
+// ServeResult classifies the reason that [Serve] or [ServeTLS] returned, so
+// that supervisors can decide whether to start serving again.
+type ServeResult int
+
+const (
+	// The server failed for some other reason, such as an invalid
+	// configuration or an unexpected error accepting connections.
+	ServeResultError ServeResult = iota
+	// The server shut down cleanly because its context was cancelled.
+	ServeResultShutdown
+	// The server stopped because the [Tunnel] was closed, either locally or
+	// by the ngrok service.
+	ServeResultTunnelClosed
+)
+
+func (r ServeResult) String() string {
+	switch r {
+	case ServeResultShutdown:
+		return "shutdown"
+	case ServeResultTunnelClosed:
+		return "tunnel closed"
+	default:
+		return "error"
+	}
+}
+
+// ServeResultOf classifies an error returned by [Serve], [ServeTLS], or
+// [Forward].
+func ServeResultOf(err error) ServeResult {
+	switch {
+	case errors.Is(err, ErrServeShutdown):
+		return ServeResultShutdown
+	case errors.Is(err, ErrTunnelClosed):
+		return ServeResultTunnelClosed
+	default:
+		return ServeResultError
+	}
+}
+
+// ServeOption customizes the [http.Server] started by [Serve] and
+// [ServeTLS].
+type ServeOption func(*serveConfig)
+
+// Options to use when serving HTTP over a [Tunnel].
+type serveConfig struct {
+	// The certificates to present to clients when terminating TLS with
+	// [ServeTLS].
+	TLSCertificates []tls.Certificate
+	// The minimum TLS version accepted by [ServeTLS].
+	// Defaults to TLS 1.2.
+	TLSMinVersion uint16
+	// The cipher suites enabled by [ServeTLS] for TLS 1.2 and below.
+	// If empty, the crypto/tls defaults are used.
+	TLSCipherSuites []uint16
+	// Chooses the certificate for each connection to [ServeTLS] based on the
+	// SNI that the client sent. Takes precedence over TLSCertificates.
+	TLSCertFromSNI func(sni string) (*tls.Certificate, error)
+	// Handlers for the protocols, other than HTTP, that [ServeTLS] will
+	// negotiate via ALPN.
+	TLSNextProto map[string]func(*http.Server, *tls.Conn, http.Handler)
+	// Whether [ServeTLS] requests, and how it verifies, client certificates.
+	TLSClientAuth tls.ClientAuthType
+	// The roots used to verify client certificates. If nil, the system
+	// roots are used.
+	TLSClientCAs *x509.CertPool
+	// Clients whose X-Forwarded-* headers are trusted by
+	// [ServeReverseProxy].
+	TrustedProxies []netip.Prefix
+	// Called with the transport-level errors that the [http.Server] would
+	// otherwise log.
+	ErrorHandler func(error)
+	// Passed through to the [http.Server].
+	ReadTimeout time.Duration
+	IdleTimeout time.Duration
+	// How long connections taken over by a handler, such as for WebSockets,
+	// may be idle before they're closed.
+	// Disabled when 0.
+	UpgradeIdleTimeout time.Duration
+	// The largest request body that handlers may read, in bytes.
+	// Unlimited when 0.
+	MaxRequestBodySize int64
+	// Non-nil if requests are logged.
+	AccessLog *accessLog
+	// Non-nil if responses are compressed.
+	Compression *compressionConfig
+	// How [ServeBackends] chooses the backend for each request.
+	Balancing BalanceAlgorithm
+	// The key for sticky sessions. [StickyClientIP] if nil.
+	StickyKey func(*http.Request) string
+	// Whether HTTP/2 is accepted without TLS.
+	H2C bool
+	// How long [ServeUntil] waits for in-flight requests once it stops
+	// accepting connections.
+	// Waits until they complete when 0.
+	DrainTimeout time.Duration
+}
+
+// WithTLSCertificates configures the certificates presented to clients when
+// TLS is terminated locally by [ServeTLS].
+func WithTLSCertificates(certs ...tls.Certificate) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.TLSCertificates = append(cfg.TLSCertificates, certs...)
+	}
+}
+
+// WithTLSMinVersion configures the minimum TLS version that [ServeTLS] will
+// negotiate, e.g. [tls.VersionTLS13]. Clients that only support older
+// versions will fail the handshake.
+//
+// If unset, defaults to [tls.VersionTLS12].
+func WithTLSMinVersion(version uint16) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.TLSMinVersion = version
+	}
+}
+
+// WithTLSCipherSuites restricts the cipher suites that [ServeTLS] will
+// negotiate for TLS 1.2 and below. TLS 1.3 cipher suites are not
+// configurable.
+//
+// If unset, the [crypto/tls] defaults are used.
+func WithTLSCipherSuites(suites ...uint16) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.TLSCipherSuites = append(cfg.TLSCipherSuites, suites...)
+	}
+}
+
+// WithTLSCertFromSNI configures a function that chooses the certificate that
+// [ServeTLS] presents to each client, based on the server name that the client
+// requested via SNI. This allows a single [Tunnel] to serve several domains,
+// each with its own certificate. The server name is empty if the client didn't
+// send one.
+//
+// If the function returns a nil certificate and no error, the certificates
+// configured with [WithTLSCertificates], if any, are used instead.
+func WithTLSCertFromSNI(getCert func(sni string) (*tls.Certificate, error)) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.TLSCertFromSNI = getCert
+	}
+}
+
+// WithServeErrorHandler configures a function which is called with each
+// transport-level error encountered by [Serve] or [ServeTLS] that doesn't stop
+// the server, such as failed TLS handshakes or temporary errors accepting
+// connections. Without a handler, these are only written to the standard
+// logger by the [http.Server].
+//
+// Errors arising from the handling of individual requests, such as panics in
+// the [http.Handler], aren't passed to the handler and continue to be logged.
+func WithServeErrorHandler(handler func(error)) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.ErrorHandler = handler
+	}
+}
+
+// WithReadTimeout configures the [http.Server.ReadTimeout] used by [Serve] and
+// [ServeTLS], which bounds the time taken to read each request, including its
+// body. This defends against clients that send requests very slowly.
+//
+// Connections that are taken over by the handler with [http.Hijacker], as
+// WebSocket libraries do when upgrading, are exempt from the read timeout once
+// hijacked, so long-lived WebSockets aren't cut off by it. See
+// [WithUpgradeIdleTimeout] to bound them instead.
+func WithReadTimeout(timeout time.Duration) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.ReadTimeout = timeout
+	}
+}
+
+// WithIdleTimeout configures the [http.Server.IdleTimeout] used by [Serve] and
+// [ServeTLS], which is how long to wait for the next request on a keep-alive
+// connection.
+func WithIdleTimeout(timeout time.Duration) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.IdleTimeout = timeout
+	}
+}
+
+// WithUpgradeIdleTimeout configures [Serve] and [ServeTLS] to close
+// connections that have been taken over by the handler with [http.Hijacker],
+// such as upgraded WebSocket connections, once no data has been read from or
+// written to them for the timeout. Activity is measured on the [net.Conn]
+// returned by Hijack.
+//
+// Upgraded connections are otherwise left open until the handler closes them.
+func WithUpgradeIdleTimeout(timeout time.Duration) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.UpgradeIdleTimeout = timeout
+	}
+}
+
+// WithMaxRequestBodySize limits the size of the request bodies that [Serve]
+// and [ServeTLS] will accept to the provided number of bytes. Requests that
+// declare a larger Content-Length are answered with 413 Request Entity Too
+// Large without calling the handler. The bodies of other requests are wrapped
+// with [http.MaxBytesReader], so that reading past the limit returns an error
+// to the handler, and the connection is closed once it has responded.
+func WithMaxRequestBodySize(limit int64) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.MaxRequestBodySize = limit
+	}
+}
+
+// WithDrainTimeout bounds how long [ServeUntil] waits for in-flight requests
+// to complete after it has stopped accepting connections. Connections that are
+// still active once the timeout elapses are closed.
+//
+// If unset, ServeUntil waits for every in-flight request to complete, unless
+// its context is cancelled first.
+func WithDrainTimeout(timeout time.Duration) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.DrainTimeout = timeout
+	}
+}
+
+// Enforces the limit configured with WithMaxRequestBodySize.
+func maxRequestBodyHandler(handler http.Handler, limit int64) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.ContentLength > limit {
+			// Don't try to keep the connection alive by reading the rest
+			// of a body that's known to be too large.
+			rw.Header().Set("Connection", "close")
+			http.Error(rw, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		req.Body = http.MaxBytesReader(rw, req.Body, limit)
+		handler.ServeHTTP(rw, req)
+	})
+}
+
+// The prefixes of the messages logged by net/http for errors in accepting
+// connections or in the protocols on top of them.
+var transportErrorPrefixes = []string{
+	"http: Accept error",
+	"http: TLS handshake error",
+	"http2: ",
+}
+
+// Passes the transport-level errors logged by an [http.Server] to a handler,
+// and everything else on to the standard logger.
+type serveErrorLog func(error)
+
+func (h serveErrorLog) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	for _, prefix := range transportErrorPrefixes {
+		if strings.HasPrefix(msg, prefix) {
+			h(errors.New(msg))
+			return len(p), nil
+		}
+	}
+	log.Print(msg)
+	return len(p), nil
+}
+
+// Creates the server for [Serve] and [ServeTLS].
+func (cfg *serveConfig) server(handler http.Handler) *http.Server {
+	if cfg.Compression != nil {
+		handler = compressionHandler(handler, cfg.Compression)
+	}
+	if cfg.UpgradeIdleTimeout > 0 {
+		handler = upgradeHandler(handler, cfg.UpgradeIdleTimeout)
+	}
+	if cfg.MaxRequestBodySize > 0 {
+		handler = maxRequestBodyHandler(handler, cfg.MaxRequestBodySize)
+	}
+	if cfg.AccessLog != nil {
+		handler = accessLogHandler(handler, cfg.AccessLog)
+	}
+	handler = recordFirstHost(pingHandler(handler))
+	if cfg.H2C {
+		handler = h2cHandler(handler, cfg)
+	}
+	srv := &http.Server{
+		Handler:     handler,
+		ReadTimeout: cfg.ReadTimeout,
+		IdleTimeout: cfg.IdleTimeout,
+		ConnContext: withTunnelConn,
+	}
+	if cfg.ErrorHandler != nil {
+		srv.ErrorLog = log.New(serveErrorLog(cfg.ErrorHandler), "", 0)
+	}
+	return srv
+}
+
+type tunnelConnKey struct{}
+
+// Stores the connection accepted from the tunnel in its context, beneath any
+// TLS that the server terminated.
+func withTunnelConn(ctx context.Context, c net.Conn) context.Context {
+	if tlsConn, ok := c.(*tls.Conn); ok {
+		c = tlsConn.NetConn()
+	}
+	if conn, ok := c.(*connImpl); ok {
+		return context.WithValue(ctx, tunnelConnKey{}, conn)
+	}
+	return ctx
+}
+
+// Records the Host of the first request made over each connection.
+func recordFirstHost(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if conn, ok := req.Context().Value(tunnelConnKey{}).(*connImpl); ok {
+			conn.firstHost.CompareAndSwap(nil, req.Host)
+		}
+		handler.ServeHTTP(rw, req)
+	})
+}
+
+// WithTLSClientAuth configures [ServeTLS] to request certificates from
+// clients, for mutual TLS. The policy is one of the [tls.ClientAuthType]
+// values, e.g. [tls.RequireAndVerifyClientCert], and the pool holds the
+// certificate authorities that client certificates are verified against.
+//
+// The certificates presented by a client are available from the PeerCertificates
+// method of the connections accepted from the [Tunnel], and to handlers from
+// [PeerCertificates] or the request's TLS field.
+func WithTLSClientAuth(policy tls.ClientAuthType, clientCAs *x509.CertPool) ServeOption {
+	return func(cfg *serveConfig) {
+		cfg.TLSClientAuth = policy
+		cfg.TLSClientCAs = clientCAs
+	}
+}
+
+// PeerCertificates returns the certificates presented by the client during
+// the TLS handshake, leaf first, for a request served by [ServeTLS]. It
+// returns nil if the client didn't present any, or the context isn't that of
+// a request served over a [Tunnel].
+func PeerCertificates(ctx context.Context) []*x509.Certificate {
+	if conn, ok := ctx.Value(tunnelConnKey{}).(*connImpl); ok {
+		return conn.PeerCertificates()
+	}
+	return nil
+}
+
+// Records the client certificates for each connection once its TLS handshake
+// has completed, which is before it becomes active.
+func recordPeerCertificates(c net.Conn, state http.ConnState) {
+	tlsConn, ok := c.(*tls.Conn)
+	if !ok || state != http.StateActive {
+		return
+	}
+	if conn, ok := tlsConn.NetConn().(*connImpl); ok {
+		state := tlsConn.ConnectionState()
+		conn.setPeerCertificates(state.PeerCertificates)
+		conn.negotiatedProto.Store(state.NegotiatedProtocol)
+	}
+}
+
+// WithTLSNextProto registers a handler for connections to [ServeTLS] that
+// negotiate the named protocol via ALPN, as with [http.Server.TLSNextProto].
+// The handler takes over the connection once the TLS handshake completes,
+// which allows non-HTTP protocols to share a [Tunnel] with HTTP. It may be
+// given more than once to register several protocols.
+//
+// HTTP/1.1 and HTTP/2 continue to be negotiated as usual.
+func WithTLSNextProto(proto string, handler func(srv *http.Server, conn *tls.Conn, h http.Handler)) ServeOption {
+	return func(cfg *serveConfig) {
+		if cfg.TLSNextProto == nil {
+			cfg.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		}
+		cfg.TLSNextProto[proto] = handler
+	}
+}
+
+func (cfg *serveConfig) tlsConfig() *tls.Config {
+	minVersion := cfg.TLSMinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+
+	tlsCfg := &tls.Config{
+		Certificates: cfg.TLSCertificates,
+		MinVersion:   minVersion,
+		CipherSuites: cfg.TLSCipherSuites,
+		ClientAuth:   cfg.TLSClientAuth,
+		ClientCAs:    cfg.TLSClientCAs,
+	}
+	if getCert := cfg.TLSCertFromSNI; getCert != nil {
+		tlsCfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return getCert(hello.ServerName)
+		}
+	}
+
+	return tlsCfg
+}
+
+// Serve accepts connections from the [Tunnel] and serves HTTP requests to
+// them with the provided [http.Handler]. Serve blocks until the [Tunnel] is
+// closed or the context is cancelled, in which case the server is closed and
+// the context's error is returned.
+//
+// The returned error matches [ErrServeShutdown] if the context was cancelled,
+// or [ErrTunnelClosed] if the [Tunnel] was closed. [ServeResultOf] can be used
+// to tell these apart from other errors.
+//
+// As with [http.Serve], the [Tunnel] is closed when Serve returns.
+func Serve(ctx context.Context, tun Tunnel, handler http.Handler, opts ...ServeOption) error {
+	cfg := serveConfig{}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	srv := cfg.server(handler)
+
+	return serve(ctx, srv, func() error {
+		return srv.Serve(tun)
+	})
+}
+
+// ServeUntil is like [Serve], but stops once the handler has completed
+// maxRequests requests. It then closes the [Tunnel] so that no more
+// connections are accepted, waits for the requests that are still in flight to
+// complete, and returns nil. This makes it possible to recycle a worker after
+// a fixed amount of work, e.g. to mitigate memory leaks.
+//
+// Requests that were already in flight when the limit was reached are served
+// to completion, so the handler may complete slightly more than maxRequests
+// requests. The wait for them can be bounded with [WithDrainTimeout], in which
+// case ServeUntil returns an error matching [context.DeadlineExceeded] if
+// they didn't all complete in time. If the context is done while waiting, the
+// requests still in flight are cut off, and its error is returned. If
+// maxRequests isn't positive, ServeUntil behaves exactly like Serve.
+func ServeUntil(ctx context.Context, tun Tunnel, handler http.Handler, maxRequests int, opts ...ServeOption) error {
+	if maxRequests <= 0 {
+		return Serve(ctx, tun, handler, opts...)
+	}
+
+	cfg := serveConfig{}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	var (
+		srv       *http.Server
+		completed int64
+		once      sync.Once
+		drained   = make(chan error, 1)
+	)
+	srv = cfg.server(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		handler.ServeHTTP(rw, req)
+		if atomic.AddInt64(&completed, 1) >= int64(maxRequests) {
+			// Shutdown waits for this request to finish, so it can't be
+			// called from the handler itself.
+			once.Do(func() {
+				go func() { drained <- cfg.drain(ctx, srv) }()
+			})
+		}
+	}))
+
+	err := serve(ctx, srv, func() error {
+		return srv.Serve(tun)
+	})
+	if errors.Is(err, http.ErrServerClosed) {
+		return <-drained
+	}
+	return err
+}
+
+// Stops the server from accepting connections and waits for those that are
+// active to finish, for up to the configured drain timeout, or until the
+// context is done.
+func (cfg *serveConfig) drain(ctx context.Context, srv *http.Server) error {
+	if cfg.DrainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.DrainTimeout)
+		defer cancel()
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		_ = srv.Close()
+		return fmt.Errorf("draining connections: %w", err)
+	}
+	return nil
+}
+
+// ListenAndServeHTTP is a shortcut for calling [Listen] and then [Serve] in
+// the background, for quick starts where the tunnel just needs to serve an
+// [http.Handler]. The context only bounds connecting the [Session] and
+// starting the [Tunnel]; the handler is served until the returned Tunnel is
+// closed, including by its Session. Any error from connecting or starting the
+// tunnel is returned directly.
+//
+// Use the returned Tunnel's URL to find where it's being served, and its Done
+// and Err methods to find out when and why serving stopped.
+func ListenAndServeHTTP(ctx context.Context, tunnelConfig config.Tunnel, handler http.Handler, connectOpts ...ConnectOption) (Tunnel, error) {
+	tun, err := Listen(ctx, tunnelConfig, connectOpts...)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		_ = Serve(context.Background(), tun, handler)
+	}()
+	return tun, nil
+}
+
+// ListenAndServeTLS is like [ListenAndServeHTTP], but serves the handler with
+// [ServeTLS], terminating TLS in this process with the certificates. It's
+// meant for TLS and TCP tunnels, where the ngrok edge passes the raw byte
+// stream through to your application. An error is returned without connecting
+// if no certificates are provided.
+func ListenAndServeTLS(ctx context.Context, tunnelConfig config.Tunnel, handler http.Handler, certs []tls.Certificate, connectOpts ...ConnectOption) (Tunnel, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no TLS certificates provided to ListenAndServeTLS")
+	}
+	tun, err := Listen(ctx, tunnelConfig, connectOpts...)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		_ = ServeTLS(context.Background(), tun, handler, WithTLSCertificates(certs...))
+	}()
+	return tun, nil
+}
+
+// ServeTLS is like [Serve], but terminates TLS for each connection accepted
+// from the [Tunnel] before serving HTTP requests to it. This is most useful
+// with TLS and TCP tunnels, where the ngrok edge passes the raw byte stream
+// through to your application.
+//
+// At least one certificate must be provided with [WithTLSCertificates], or a
+// way to choose them with [WithTLSCertFromSNI].
+func ServeTLS(ctx context.Context, tun Tunnel, handler http.Handler, opts ...ServeOption) error {
+	cfg := serveConfig{}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	if len(cfg.TLSCertificates) == 0 && cfg.TLSCertFromSNI == nil {
+		return errors.New("no TLS certificates or certificate selector configured for ServeTLS")
+	}
+
+	srv := cfg.server(handler)
+	srv.TLSConfig = cfg.tlsConfig()
+	srv.ConnState = recordPeerCertificates
+
+	if len(cfg.TLSNextProto) > 0 {
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler), len(cfg.TLSNextProto))
+		protos := make([]string, 0, len(cfg.TLSNextProto))
+		for proto, handler := range cfg.TLSNextProto {
+			srv.TLSNextProto[proto] = handler
+			protos = append(protos, proto)
+		}
+		sort.Strings(protos)
+		srv.TLSConfig.NextProtos = append(srv.TLSConfig.NextProtos, protos...)
+
+		// The http.Server only sets up HTTP/2 on its own when TLSNextProto
+		// is nil.
+		if err := http2.ConfigureServer(srv, nil); err != nil {
+			return err
+		}
+	}
+
+	return serve(ctx, srv, func() error {
+		return srv.ServeTLS(tun, "", "")
+	})
+}
+
+// Runs the server until it exits on its own, or closes it when the context is
+// cancelled.
+func serve(ctx context.Context, srv *http.Server, run func() error) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = srv.Close()
+		case <-done:
+		}
+	}()
+
+	err := run()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return errServe{Result: ServeResultShutdown, Inner: ctxErr}
+	}
+	if errors.Is(err, net.ErrClosed) {
+		return errServe{Result: ServeResultTunnelClosed, Inner: err}
+	}
+	return err
+}
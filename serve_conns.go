@@ -0,0 +1,104 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+func (t *tunnelImpl) Serve(ctx context.Context, handler func(net.Conn)) error {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = t.Close()
+		case <-stop:
+		}
+	}()
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		active = map[net.Conn]struct{}{}
+		err    error
+	)
+	for {
+		conn, acceptErr := t.Accept()
+		if acceptErr != nil {
+			switch {
+			case ctx.Err() != nil:
+				err = errServe{Result: ServeResultShutdown, Inner: ctx.Err()}
+			case errors.Is(acceptErr, net.ErrClosed):
+				err = errServe{Result: ServeResultTunnelClosed, Inner: acceptErr}
+			default:
+				err = acceptErr
+			}
+			break
+		}
+
+		mu.Lock()
+		active[conn] = struct{}{}
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				delete(active, conn)
+				mu.Unlock()
+				_ = conn.Close()
+			}()
+			handler(conn)
+		}()
+	}
+	close(stop)
+
+	handled := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(handled)
+	}()
+
+	select {
+	case <-handled:
+		return err
+	case <-ctx.Done():
+	}
+
+	// Once the context is done, handlers have until its deadline, if it has
+	// one, before their connections are closed out from under them.
+	// The deadline is on the system clock, as the context's own timer is, so
+	// this waits on it rather than on the tunnel's clock.
+	var deadline <-chan time.Time
+	if d, ok := ctx.Deadline(); ok {
+		timer := time.NewTimer(time.Until(d))
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	select {
+	case <-handled:
+		return err
+	case <-deadline:
+	}
+
+	mu.Lock()
+	unfinished := len(active)
+	for conn := range active {
+		_ = conn.Close()
+	}
+	mu.Unlock()
+	<-handled
+
+	result := ServeResultShutdown
+	var stopped errServe
+	if errors.As(err, &stopped) {
+		result = stopped.Result
+	}
+	return errServe{
+		Result: result,
+		Inner:  fmt.Errorf("closed %d connections that were still being handled: %w", unfinished, context.DeadlineExceeded),
+	}
+}
@@ -0,0 +1,98 @@
+package ngrok
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTunnelServe(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	release := make(chan struct{})
+	served := make(chan error, 1)
+	go func() {
+		served <- tun.Serve(ctx, func(conn net.Conn) {
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return
+			}
+			<-release
+			_, _ = conn.Write(buf)
+		})
+	}()
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = io.WriteString(client, "ping")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return tun.ConnSet().Len() == 1 }, time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-served:
+		require.FailNow(t, "Serve returned before its handler", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err = tun.Accept()
+	require.ErrorIs(t, err, net.ErrClosed, "the tunnel is closed once the context is done")
+
+	// The in-flight handler finishes its work.
+	close(release)
+	rest, err := io.ReadAll(client)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(rest))
+
+	err = <-served
+	require.ErrorIs(t, err, ErrServeShutdown)
+	require.NotErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestTunnelServeDrainDeadline(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	accepted := make(chan struct{})
+	served := make(chan error, 1)
+	go func() {
+		served <- tun.Serve(ctx, func(conn net.Conn) {
+			close(accepted)
+			// Never finishes on its own.
+			_, _ = io.Copy(io.Discard, conn)
+		})
+	}()
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	<-accepted
+
+	err = <-served
+	require.ErrorIs(t, err, ErrServeShutdown)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Contains(t, err.Error(), "closed 1 connections")
+
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = client.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF, "unfinished connections are closed at the deadline")
+}
+
+func TestTunnelServeClosed(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- tun.Serve(context.Background(), func(net.Conn) {})
+	}()
+
+	require.NoError(t, tun.Close())
+	require.ErrorIs(t, <-served, ErrTunnelClosed)
+}
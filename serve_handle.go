@@ -0,0 +1,109 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// ServeHandle controls an HTTP server started on a [Tunnel] by
+// [StartServing].
+type ServeHandle struct {
+	tun  Tunnel
+	srv  *http.Server
+	done chan struct{}
+	err  error
+
+	// Closed once Shutdown has finished waiting for requests in flight.
+	shutdownOnce sync.Once
+	shutdown     chan struct{}
+}
+
+// StartServing is like [Serve], but serves the handler in the background,
+// returning a [ServeHandle] through which the server can be shut down and
+// waited on. This separates starting the server from waiting for it, for
+// applications that manage the lifecycles of several components at once.
+//
+// As with Serve, the server is closed when the context is cancelled, without
+// waiting for requests that are in flight. Use the handle's Shutdown method
+// to stop gracefully instead. Either way, the [Tunnel] is closed once the
+// server stops.
+//
+// StartServing returns an error if the Tunnel has already terminated.
+func StartServing(ctx context.Context, tun Tunnel, handler http.Handler, opts ...ServeOption) (*ServeHandle, error) {
+	select {
+	case <-tun.Done():
+		return nil, tun.Err()
+	default:
+	}
+
+	cfg := serveConfig{}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	h := &ServeHandle{
+		tun:      tun,
+		srv:      cfg.server(handler),
+		done:     make(chan struct{}),
+		shutdown: make(chan struct{}),
+	}
+	go func() {
+		defer close(h.done)
+		err := serve(ctx, h.srv, func() error {
+			return h.srv.Serve(h.tun)
+		})
+		if errors.Is(err, http.ErrServerClosed) {
+			// The server stops accepting connections as soon as Shutdown
+			// starts, but isn't done until the requests in flight are.
+			<-h.shutdown
+			err = errServe{Result: ServeResultShutdown, Inner: err}
+		}
+		h.err = err
+	}()
+	return h, nil
+}
+
+// Shutdown stops the server gracefully, as with [http.Server.Shutdown]:
+// the [Tunnel] is closed so that no more connections are accepted, and
+// Shutdown waits for the requests in flight to complete. If the context is
+// done first, the remaining connections are closed, and its error is
+// returned.
+func (h *ServeHandle) Shutdown(ctx context.Context) error {
+	err := h.srv.Shutdown(ctx)
+	if err != nil {
+		_ = h.srv.Close()
+	}
+	h.shutdownOnce.Do(func() { close(h.shutdown) })
+	<-h.done
+	return err
+}
+
+// URL returns the URL of the [Tunnel] that's being served.
+func (h *ServeHandle) URL() string {
+	return h.tun.URL()
+}
+
+// Tunnel returns the [Tunnel] that's being served.
+func (h *ServeHandle) Tunnel() Tunnel {
+	return h.tun
+}
+
+// Done returns a channel that's closed once the server has stopped.
+func (h *ServeHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Err returns nil if Done isn't yet closed. Afterwards, it returns the reason
+// that the server stopped, as it would have been returned by [Serve]. It
+// matches [ErrServeShutdown] if the server was stopped by Shutdown or by its
+// context being cancelled, and [ErrTunnelClosed] if the [Tunnel] was closed.
+func (h *ServeHandle) Err() error {
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
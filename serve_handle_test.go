@@ -0,0 +1,103 @@
+package ngrok
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartServing(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		_, _ = io.WriteString(rw, "done")
+	})
+
+	h, err := StartServing(context.Background(), tun, handler)
+	require.NoError(t, err)
+	require.Equal(t, tun.URL(), h.URL())
+	require.Equal(t, tun, h.Tunnel())
+	require.NoError(t, h.Err(), "no error while serving")
+
+	slow := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		slow <- string(body)
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- h.Shutdown(context.Background())
+	}()
+	select {
+	case <-h.Done():
+		require.FailNow(t, "stopped before the request in flight completed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-shutdown)
+	require.Equal(t, "done", <-slow)
+	<-h.Done()
+	require.ErrorIs(t, h.Err(), ErrServeShutdown)
+
+	_, err = tun.Accept()
+	require.ErrorIs(t, err, net.ErrClosed, "the tunnel is closed once the server stops")
+}
+
+func TestStartServingShutdownTimeout(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	h, err := StartServing(context.Background(), tun, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		close(started)
+		<-release
+	}))
+	require.NoError(t, err)
+
+	go func() {
+		resp, err := http.Get("http://" + addr)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, h.Shutdown(ctx), context.DeadlineExceeded)
+	require.ErrorIs(t, h.Err(), ErrServeShutdown)
+}
+
+func TestStartServingTunnelClosed(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+
+	h, err := StartServing(context.Background(), tun, helloHandler)
+	require.NoError(t, err)
+	require.NoError(t, tun.Close())
+	<-h.Done()
+	require.ErrorIs(t, h.Err(), ErrTunnelClosed)
+
+	_, err = StartServing(context.Background(), tun, helloHandler)
+	require.ErrorIs(t, err, ErrTunnelClosed, "closed tunnels can't be served")
+}
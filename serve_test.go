@@ -0,0 +1,614 @@
+package ngrok
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+// Generates a self-signed certificate valid for the provided DNS names.
+func testCertificate(t *testing.T, names ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err, "generate key")
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: names[0],
+		},
+		DNSNames:  names,
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+		KeyUsage:  x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err, "create certificate")
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+func startServeTLS(t *testing.T, opts ...ServeOption) string {
+	tun, addr := fakeTunnel(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan error)
+	go func() {
+		exited <- ServeTLS(ctx, tun, helloHandler, opts...)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		require.ErrorIs(t, <-exited, context.Canceled)
+	})
+
+	return addr
+}
+
+func TestServeTLS(t *testing.T) {
+	addr := startServeTLS(t,
+		WithTLSCertificates(testCertificate(t, "example.com")),
+	)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+		},
+	}
+
+	resp, err := client.Get("https://" + addr)
+	require.NoError(t, err, "GET tunnel")
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err, "Read response body")
+	require.Equal(t, "Hello, world!\n", string(body), "HTTP Body Contents")
+	require.NotNil(t, resp.TLS, "TLS established")
+}
+
+func TestServeTLSMinVersion(t *testing.T) {
+	addr := startServeTLS(t,
+		WithTLSCertificates(testCertificate(t, "example.com")),
+		WithTLSMinVersion(tls.VersionTLS13),
+	)
+
+	// TLS 1.2 is allowed by default, so only the option can refuse it.
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+	})
+	if conn != nil {
+		_ = conn.Close()
+	}
+	require.Error(t, err, "TLS 1.2 handshake should be rejected")
+
+	conn, err = tls.Dial("tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+	})
+	require.NoError(t, err, "TLS 1.3 handshake")
+	require.Equal(t, uint16(tls.VersionTLS13), conn.ConnectionState().Version)
+	_ = conn.Close()
+}
+
+func TestServeTLSCipherSuites(t *testing.T) {
+	suite := tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+	addr := startServeTLS(t,
+		WithTLSCertificates(testCertificate(t, "example.com")),
+		WithTLSCipherSuites(suite),
+	)
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+		CipherSuites:       []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305},
+	})
+	if conn != nil {
+		_ = conn.Close()
+	}
+	require.Error(t, err, "disallowed cipher suite should be rejected")
+
+	conn, err = tls.Dial("tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+		CipherSuites:       []uint16{suite},
+	})
+	require.NoError(t, err, "allowed cipher suite handshake")
+	require.Equal(t, suite, conn.ConnectionState().CipherSuite)
+	_ = conn.Close()
+}
+
+func TestServeTLSCertFromSNI(t *testing.T) {
+	certs := map[string]tls.Certificate{
+		"one.example.com": testCertificate(t, "one.example.com"),
+		"two.example.com": testCertificate(t, "two.example.com"),
+	}
+	addr := startServeTLS(t,
+		WithTLSCertificates(testCertificate(t, "default.example.com")),
+		WithTLSCertFromSNI(func(sni string) (*tls.Certificate, error) {
+			if sni == "unknown.example.com" {
+				return nil, errors.New("unknown server name")
+			}
+			if cert, ok := certs[sni]; ok {
+				return &cert, nil
+			}
+			return nil, nil
+		}),
+	)
+
+	peerName := func(sni string) (string, error) {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{
+			InsecureSkipVerify: true,
+			ServerName:         sni,
+		})
+		if err != nil {
+			return "", err
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	for _, sni := range []string{"one.example.com", "two.example.com"} {
+		name, err := peerName(sni)
+		require.NoError(t, err, "handshake with SNI %s", sni)
+		require.Equal(t, sni, name, "certificate is chosen by SNI")
+	}
+
+	name, err := peerName("other.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "default.example.com", name, "falls back to the configured certificates")
+
+	_, err = peerName("unknown.example.com")
+	require.Error(t, err, "errors fail the handshake")
+}
+
+func TestServeTLSNoCertificates(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+	require.Error(t, ServeTLS(context.Background(), tun, helloHandler))
+}
+
+func TestServeErrorHandler(t *testing.T) {
+	errs := make(chan error, 1)
+	addr := startServeTLS(t,
+		WithTLSCertificates(testCertificate(t, "example.com")),
+		WithServeErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}),
+	)
+
+	// Speaking plaintext HTTP to a TLS server fails the handshake.
+	resp, err := http.Get("http://" + addr)
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+
+	select {
+	case err := <-errs:
+		require.Contains(t, err.Error(), "TLS handshake error")
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "error handler wasn't called for a failed handshake")
+	}
+}
+
+func TestServeErrorLog(t *testing.T) {
+	var handled []error
+	errorLog := serveErrorLog(func(err error) {
+		handled = append(handled, err)
+	})
+
+	_, _ = errorLog.Write([]byte("http: TLS handshake error from 127.0.0.1:1234: EOF\n"))
+	_, _ = errorLog.Write([]byte("http: Accept error: too many open files; retrying in 5ms\n"))
+	_, _ = errorLog.Write([]byte("http: panic serving 127.0.0.1:1234: oops\n"))
+
+	require.Len(t, handled, 2, "application errors aren't passed to the handler")
+	require.Equal(t, "http: TLS handshake error from 127.0.0.1:1234: EOF", handled[0].Error())
+}
+
+func TestServeResult(t *testing.T) {
+	t.Run("shutdown", func(t *testing.T) {
+		tun, _ := fakeTunnel(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		exited := make(chan error)
+		go func() {
+			exited <- Serve(ctx, tun, helloHandler)
+		}()
+		cancel()
+
+		err := <-exited
+		require.ErrorIs(t, err, ErrServeShutdown)
+		require.ErrorIs(t, err, context.Canceled)
+		require.NotErrorIs(t, err, ErrTunnelClosed)
+		require.Equal(t, ServeResultShutdown, ServeResultOf(err))
+	})
+
+	t.Run("tunnel closed", func(t *testing.T) {
+		tun, _ := fakeTunnel(t)
+		exited := make(chan error)
+		go func() {
+			exited <- Serve(context.Background(), tun, helloHandler)
+		}()
+		require.NoError(t, tun.Close())
+
+		err := <-exited
+		require.ErrorIs(t, err, ErrTunnelClosed)
+		require.NotErrorIs(t, err, ErrServeShutdown)
+		require.Equal(t, ServeResultTunnelClosed, ServeResultOf(err))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		tun, _ := fakeTunnel(t)
+		err := ServeTLS(context.Background(), tun, helloHandler)
+		require.Error(t, err)
+		require.Equal(t, ServeResultError, ServeResultOf(err))
+	})
+}
+
+func TestFirstRequestHost(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	firstHosts := make(chan string, 2)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		conn := req.Context().Value(tunnelConnKey{}).(*connImpl)
+		firstHosts <- conn.FirstRequestHost()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = Serve(ctx, tun, handler)
+	}()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// Both requests are made over the same connection.
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		req, err := http.NewRequest(http.MethodGet, "http://"+host, nil)
+		require.NoError(t, err)
+		require.NoError(t, req.Write(conn))
+		resp, err := http.ReadResponse(reader, req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+
+	require.Equal(t, "a.example.com", <-firstHosts)
+	require.Equal(t, "a.example.com", <-firstHosts, "later requests don't replace the first host")
+}
+
+func TestMaxRequestBodySize(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	type result struct {
+		body []byte
+		err  error
+	}
+	results := make(chan result, 1)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		results <- result{body, err}
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusRequestEntityTooLarge)
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = Serve(ctx, tun, handler, WithMaxRequestBodySize(8))
+	}()
+
+	resp, err := http.Post("http://"+addr, "text/plain", strings.NewReader("small"))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "small", string((<-results).body))
+
+	// Declared too large, so the handler is never called.
+	resp, err = http.Post("http://"+addr, "text/plain", strings.NewReader("far too large"))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	require.Empty(t, results)
+
+	// Streamed without a length, so the handler sees the error.
+	resp, err = http.Post("http://"+addr, "text/plain", io.MultiReader(strings.NewReader("far too large")))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	require.Error(t, (<-results).err)
+}
+
+func TestServeTLSNextProto(t *testing.T) {
+	addr := startServeTLS(t,
+		WithTLSCertificates(testCertificate(t, "example.com")),
+		WithTLSNextProto("dummy/1", func(_ *http.Server, conn *tls.Conn, _ http.Handler) {
+			defer conn.Close()
+			_, _ = io.WriteString(conn, "dummy!")
+		}),
+	)
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"dummy/1"},
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, "dummy/1", conn.ConnectionState().NegotiatedProtocol)
+
+	greeting, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "dummy!", string(greeting))
+
+	// HTTP/2 is still available alongside the custom protocol.
+	h2, err := tls.Dial("tcp", addr, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"h2", "http/1.1"},
+	})
+	require.NoError(t, err)
+	defer h2.Close()
+	require.Equal(t, "h2", h2.ConnectionState().NegotiatedProtocol)
+}
+
+func TestListenAndServeHTTPConnectError(t *testing.T) {
+	// Nothing is listening here, so connecting the session fails.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	tun, err := ListenAndServeHTTP(context.Background(), config.HTTPEndpoint(), helloHandler, WithServer(addr))
+	require.Error(t, err)
+	require.ErrorIs(t, err, errSessionDial{})
+	require.Nil(t, tun)
+}
+
+func TestListenAndServeTLSErrors(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	_, err = ListenAndServeTLS(context.Background(), config.TLSEndpoint(), helloHandler, nil, WithServer(addr))
+	require.Error(t, err)
+	require.NotErrorIs(t, err, errSessionDial{}, "certificates are required before connecting")
+
+	certs := []tls.Certificate{testCertificate(t, "example.com")}
+	tun, err := ListenAndServeTLS(context.Background(), config.TLSEndpoint(), helloHandler, certs, WithServer(addr))
+	require.ErrorIs(t, err, errSessionDial{})
+	require.Nil(t, tun)
+}
+
+func TestServeTLSClientAuth(t *testing.T) {
+	clientCert := testCertificate(t, "client.example.com")
+	leaf, err := x509.ParseCertificate(clientCert.Certificate[0])
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(leaf)
+
+	tun, addr := fakeTunnel(t)
+	subjects := make(chan [2]string, 1)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		conn := req.Context().Value(tunnelConnKey{}).(*connImpl)
+		subjects <- [2]string{
+			conn.PeerCertificates()[0].Subject.CommonName,
+			PeerCertificates(req.Context())[0].Subject.CommonName,
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = ServeTLS(ctx, tun, handler,
+			WithTLSCertificates(testCertificate(t, "example.com")),
+			WithTLSClientAuth(tls.RequireAndVerifyClientCert, clientCAs),
+		)
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				Certificates:       []tls.Certificate{clientCert},
+			},
+		},
+	}
+	resp, err := client.Get("https://" + addr)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, [2]string{"client.example.com", "client.example.com"}, <-subjects)
+
+	// Clients without a certificate are turned away.
+	anonymous := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	_, err = anonymous.Get("https://" + addr)
+	require.Error(t, err)
+}
+
+func TestServeUntil(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- ServeUntil(context.Background(), tun, helloHandler, 2)
+	}()
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get("http://" + addr)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		require.Equal(t, "Hello, world!\n", string(body))
+	}
+
+	select {
+	case err := <-exited:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		require.FailNow(t, "ServeUntil didn't return after the last request")
+	}
+
+	_, err := tun.Accept()
+	require.ErrorIs(t, err, net.ErrClosed, "the tunnel is closed once the limit is reached")
+}
+
+func TestServeUntilDrainTimeout(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+	})
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- ServeUntil(context.Background(), tun, handler, 1, WithDrainTimeout(50*time.Millisecond))
+	}()
+
+	slowErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		slowErr <- err
+	}()
+	<-started
+
+	resp, err := http.Get("http://" + addr + "/fast")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	select {
+	case err := <-exited:
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(time.Second):
+		require.FailNow(t, "ServeUntil didn't give up on the slow request")
+	}
+	require.Error(t, <-slowErr, "requests still in flight after the drain timeout are cut off")
+}
+
+func TestServeUntilDrainCancelled(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- ServeUntil(ctx, tun, handler, 1)
+	}()
+
+	slowErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		slowErr <- err
+	}()
+	<-started
+
+	resp, err := http.Get("http://" + addr + "/fast")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	// Without a drain timeout, the wait is bounded by the context.
+	select {
+	case err := <-exited:
+		require.FailNow(t, "ServeUntil returned before the slow request finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case err := <-exited:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		require.FailNow(t, "ServeUntil didn't stop draining once its context was done")
+	}
+	require.Error(t, <-slowErr, "requests still in flight are cut off")
+}
+
+func TestServeRequestContextCancelled(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	cancelled := make(chan error, 2)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		go func() {
+			<-req.Context().Done()
+			cancelled <- req.Context().Err()
+		}()
+		_, _ = io.WriteString(rw, "done")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = Serve(ctx, tun, handler, WithUpgradeIdleTimeout(time.Minute), WithMaxRequestBodySize(1024))
+	}()
+
+	for _, keepAlive := range []bool{true, false} {
+		req, err := http.NewRequest(http.MethodGet, "http://"+addr, nil)
+		require.NoError(t, err)
+		req.Close = !keepAlive
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		select {
+		case err := <-cancelled:
+			require.ErrorIs(t, err, context.Canceled, "keep-alive: %v", keepAlive)
+		case <-time.After(time.Second):
+			require.FailNow(t, "request context wasn't cancelled after the handler returned", "keep-alive: %v", keepAlive)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package ngrok
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// WithServerPublicKeyPins pins the public keys that the ngrok service's
+// certificates may have while establishing the session. Each pin is the
+// base64-encoded SHA-256 digest of a DER-encoded SubjectPublicKeyInfo, as
+// produced by:
+//
+//	openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+//
+// The connection is refused unless a certificate in the verified chain, either
+// the server's or one of the CAs that issued it, has one of the pinned keys.
+// Pins are checked in addition to the usual verification against the CAs
+// configured with [WithCA], so pinning a CA's key restricts the session to the
+// certificates that it issued.
+//
+// [Connect] returns an error if any pin isn't a base64-encoded SHA-256
+// digest.
+func WithServerPublicKeyPins(pins ...string) ConnectOption {
+	return func(cfg *connectConfig) {
+		for _, pin := range pins {
+			digest, err := base64.StdEncoding.DecodeString(pin)
+			if err == nil && len(digest) != sha256.Size {
+				err = fmt.Errorf("expected a %d byte SHA-256 digest, got %d bytes", sha256.Size, len(digest))
+			}
+			if err != nil {
+				cfg.Err = fmt.Errorf("invalid server public key pin %q: %w", pin, err)
+				return
+			}
+			cfg.ServerPins = append(cfg.ServerPins, digest)
+		}
+	}
+}
+
+// ErrServerPinMismatch is matched by the error returned by [Connect] when none
+// of the ngrok service's certificates have a key pinned with
+// [WithServerPublicKeyPins].
+var ErrServerPinMismatch = errors.New("no certificate presented by the ngrok service has a pinned public key")
+
+// Returns a function for tls.Config.VerifyConnection that accepts only
+// connections whose verified chains include one of the pinned keys.
+func verifyServerPins(pins [][]byte) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		for _, chain := range state.VerifiedChains {
+			for _, cert := range chain {
+				digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				for _, pin := range pins {
+					if subtle.ConstantTimeCompare(digest[:], pin) == 1 {
+						return nil
+					}
+				}
+			}
+		}
+		return ErrServerPinMismatch
+	}
+}
@@ -0,0 +1,41 @@
+package ngrok
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyServerPins(t *testing.T) {
+	leafCert := testCertificate(t, "tunnel.example.com")
+	leaf, err := x509.ParseCertificate(leafCert.Certificate[0])
+	require.NoError(t, err)
+	digest := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(digest[:])
+
+	other := sha256.Sum256([]byte("some other key"))
+	otherPin := base64.StdEncoding.EncodeToString(other[:])
+
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf}}}
+
+	var cfg connectConfig
+	WithServerPublicKeyPins(otherPin, pin)(&cfg)
+	require.NoError(t, cfg.Err)
+	require.NoError(t, verifyServerPins(cfg.ServerPins)(state))
+
+	cfg = connectConfig{}
+	WithServerPublicKeyPins(otherPin)(&cfg)
+	require.ErrorIs(t, verifyServerPins(cfg.ServerPins)(state), ErrServerPinMismatch)
+}
+
+func TestServerPinErrors(t *testing.T) {
+	for _, pin := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		_, err := Connect(context.Background(), WithServerPublicKeyPins(pin))
+		require.ErrorContains(t, err, "invalid server public key pin", pin)
+	}
+}
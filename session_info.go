@@ -0,0 +1,52 @@
+package ngrok
+
+import "time"
+
+// SessionInfo describes a [Session] as the ngrok service reported it when the
+// Session last authenticated, as returned by [Session].Info. Its ID is the ID
+// of the tunnel session in the ngrok API, just as [Tunnel].ID is the ID of the
+// API's tunnel, so that applications can look up the Session and its tunnels
+// there, such as to manage their endpoints or fetch their traffic.
+type SessionInfo struct {
+	// The ID that the ngrok service assigned to the session.
+	ID string `json:"id"`
+	// The region that the session is connected to.
+	Region string `json:"region"`
+	// The name of the ngrok account that the session authenticated as.
+	AccountName string `json:"account_name"`
+	// The name of the account's plan.
+	PlanName string `json:"plan_name"`
+	// The version of this library, as reported to the ngrok service.
+	AgentVersion string `json:"agent_version"`
+	// The version of the tunnel protocol that the ngrok service chose.
+	ProtoVersion string `json:"proto_version"`
+	// The version of the ngrok server.
+	ServerVersion string `json:"server_version"`
+	// A message from the ngrok service to show to the user, if any.
+	Banner string `json:"banner,omitempty"`
+	// How long the ngrok service lets the session stay connected before it's
+	// ended. Unlimited when 0.
+	SessionDuration time.Duration `json:"session_duration,omitempty"`
+}
+
+func (s *sessionImpl) ID() string {
+	return s.Info().ID
+}
+
+func (s *sessionImpl) Info() SessionInfo {
+	inner := s.inner()
+	if inner == nil {
+		return SessionInfo{AgentVersion: libraryAgentVersion}
+	}
+	return SessionInfo{
+		ID:              inner.ClientID,
+		Region:          inner.Region,
+		AccountName:     inner.AccountName,
+		PlanName:        inner.PlanName,
+		AgentVersion:    libraryAgentVersion,
+		ProtoVersion:    inner.ProtoVersion,
+		ServerVersion:   inner.ServerVersion,
+		Banner:          inner.Banner,
+		SessionDuration: time.Duration(inner.SessionDuration) * time.Second,
+	}
+}
@@ -0,0 +1,389 @@
+package ngrok
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/inconshreveable/log15/v3"
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+func discardLogger() log15.Logger {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	return logger
+}
+
+func TestCallbackGuard(t *testing.T) {
+	var reported error
+	guard := callbackGuard{
+		Logger: discardLogger(),
+		errorHandler: func(_ context.Context, _ Session, err error) {
+			reported = err
+		},
+	}
+
+	err := guard.call(context.Background(), "stop", func() error {
+		return testError
+	})
+	require.ErrorIs(t, err, testError, "errors are passed through")
+	require.Nil(t, reported, "plain errors aren't reported")
+
+	err = guard.call(context.Background(), "stop", func() error {
+		panic("oh no")
+	})
+	require.ErrorIs(t, err, errCallbackPanic{})
+	require.Equal(t, err, reported, "panics are reported to the error handler")
+
+	var downcast errCallbackPanic
+	require.True(t, errors.As(err, &downcast))
+	require.Equal(t, "stop", downcast.Callback)
+	require.Equal(t, "oh no", downcast.Value)
+}
+
+func TestCallbackGuardPanickingErrorHandler(t *testing.T) {
+	guard := callbackGuard{
+		Logger: discardLogger(),
+		errorHandler: func(_ context.Context, _ Session, err error) {
+			panic("the error handler is broken too")
+		},
+	}
+
+	require.NotPanics(t, func() {
+		guard.run(context.Background(), "connect", func() {
+			panic("oh no")
+		})
+	})
+}
+
+func TestMaxTunnels(t *testing.T) {
+	sess := &sessionImpl{maxTunnels: 2}
+	require.Equal(t, 2, sess.MaxTunnels())
+
+	require.True(t, sess.acquireTunnel())
+	require.True(t, sess.acquireTunnel())
+	require.False(t, sess.acquireTunnel(), "the limit is enforced")
+
+	_, err := sess.Listen(context.Background(), config.TCPEndpoint())
+	require.ErrorIs(t, err, errTooManyTunnels{})
+
+	// Closing a tunnel frees up its slot, but only once.
+	tun, _ := fakeTunnel(t)
+	tun.(*tunnelImpl).Sess = sess
+	require.NoError(t, tun.Close())
+	_ = tun.Close()
+	require.True(t, sess.acquireTunnel())
+	require.False(t, sess.acquireTunnel())
+}
+
+// A tunnel_client.Session that only records being closed.
+type closeRecordingSession struct {
+	tunnel_client.Session
+	closed bool
+}
+
+func (s *closeRecordingSession) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestSessionShutdown(t *testing.T) {
+	raw := &closeRecordingSession{}
+	sess := &sessionImpl{}
+	sess.setInner(&sessionInner{Session: raw})
+
+	var tunnels []Tunnel
+	for i := 0; i < 2; i++ {
+		tun, _ := fakeTunnel(t)
+		require.True(t, sess.acquireTunnel())
+		tun.(*tunnelImpl).Sess = sess
+		sess.addTunnel(tun.(*tunnelImpl))
+		tunnels = append(tunnels, tun)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, sess.Shutdown(ctx))
+
+	require.True(t, raw.closed, "the session is ended")
+	for _, tun := range tunnels {
+		require.ErrorIs(t, tun.Err(), ErrTunnelClosed, "its tunnels are closed first")
+	}
+	require.Empty(t, sess.tunnels)
+	require.True(t, sess.acquireTunnel(), "closed tunnels free their slots")
+}
+
+func TestServerCommandHandlers(t *testing.T) {
+	var cfg connectConfig
+	for _, opt := range []ConnectOption{
+		WithRestartHandler(func(context.Context, Session) error {
+			return nil
+		}),
+		WithUpdateHandler(func(context.Context, Session) error {
+			return errors.New("updates are managed by the package manager")
+		}),
+	} {
+		opt(&cfg)
+	}
+
+	raw := &closeRecordingSession{}
+	sess := &sessionImpl{}
+	sess.setInner(&sessionInner{Session: raw})
+	rc := remoteCallbackHandler{
+		Logger:         discardLogger(),
+		sess:           sess,
+		guard:          callbackGuard{Logger: discardLogger(), sess: sess},
+		restartHandler: cfg.RestartHandler,
+		updateHandler:  cfg.UpdateHandler,
+	}
+
+	var resp any
+	respond := func(v any) error {
+		resp = v
+		return nil
+	}
+
+	rc.OnUpdate(&proto.Update{}, respond)
+	require.Equal(t, &proto.UpdateResp{Error: "updates are managed by the package manager"}, resp)
+	require.False(t, raw.closed, "updates leave the session open")
+
+	rc.OnRestart(&proto.Restart{}, respond)
+	require.Equal(t, &proto.RestartResp{}, resp)
+	require.True(t, raw.closed, "successful restarts close the session")
+	require.ErrorIs(t, sess.endReason(), ErrStoppedByService)
+}
+
+// A tunnel_client.Session that has given up reconnecting.
+type failedSession struct {
+	tunnel_client.Session
+	err error
+}
+
+func (s failedSession) Err() error {
+	return s.err
+}
+
+func TestTunnelErrSessionEnded(t *testing.T) {
+	gaveUp := errors.New("giving up after 3 attempts")
+	cases := []struct {
+		name   string
+		setup  func(*sessionImpl)
+		reason error
+	}{
+		{
+			name: "closed",
+			setup: func(sess *sessionImpl) {
+				sess.setInner(&sessionInner{Session: &closeRecordingSession{}})
+				require.NoError(t, sess.Close())
+			},
+			reason: ErrSessionClosed,
+		},
+		{
+			name: "lost",
+			setup: func(sess *sessionImpl) {
+				sess.setInner(&sessionInner{Session: failedSession{err: gaveUp}})
+			},
+			reason: ErrSessionLost,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sess := &sessionImpl{}
+			tun, _ := fakeTunnel(t)
+			tun.(*tunnelImpl).Sess = sess
+			tc.setup(sess)
+
+			// The session closes its tunnels as it ends.
+			require.NoError(t, tun.(*tunnelImpl).Tunnel.(*fakeClientTunnel).Listener.Close())
+			_, err := tun.Accept()
+			require.ErrorIs(t, err, net.ErrClosed)
+			require.ErrorIs(t, err, tc.reason)
+			<-tun.Done()
+			require.ErrorIs(t, tun.Err(), tc.reason)
+			require.NotErrorIs(t, tun.Err(), ErrTunnelClosed)
+		})
+	}
+
+	// Only the tunnel's own close is reported for tunnels closed directly.
+	tun, _ := fakeTunnel(t)
+	require.NoError(t, tun.Close())
+	require.ErrorIs(t, tun.Err(), ErrTunnelClosed)
+	require.NotErrorIs(t, tun.Err(), ErrSessionClosed)
+}
+
+func TestMaxTunnelsUnlimited(t *testing.T) {
+	sess := &sessionImpl{}
+	require.Equal(t, 0, sess.MaxTunnels())
+	for i := 0; i < 100; i++ {
+		require.True(t, sess.acquireTunnel())
+	}
+}
+
+func TestSessionSetMetadata(t *testing.T) {
+	sess := &sessionImpl{}
+	sess.metadata.Store("shard=2")
+	require.ErrorIs(t, sess.SetMetadata(context.Background(), "shard=3"), ErrSessionMetadataNotSupported)
+	require.Equal(t, "shard=2", sess.Metadata(), "the metadata is unchanged")
+}
+
+func TestTransportInfo(t *testing.T) {
+	sess := &sessionImpl{}
+	require.Zero(t, sess.TransportInfo(), "sessions that haven't connected have no transport")
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t, "tunnel.example.com")},
+	})
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+		ServerName:         "tunnel.example.com",
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+		CipherSuites:       []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	proxyURL, err := url.Parse("socks5://proxy.example.com:1080")
+	require.NoError(t, err)
+	cfg := &connectConfig{ServerAddr: "tunnel.example.com:443", ProxyURL: proxyURL}
+	sess.transport.Store(newTransportInfo(cfg, cfg.ServerAddr, conn.ConnectionState()))
+
+	info := sess.TransportInfo()
+	require.Equal(t, "tunnel.example.com:443", info.ServerAddr)
+	require.Equal(t, "tunnel.example.com", info.ServerName)
+	require.Equal(t, uint16(tls.VersionTLS12), info.TLSVersion)
+	require.Equal(t, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, info.CipherSuite)
+	require.True(t, info.Proxied)
+	require.False(t, info.CustomDialer)
+	require.WithinDuration(t, time.Now(), info.ConnectedAt, time.Minute)
+
+	// Custom dialers take precedence over the proxy.
+	cfg.Dialer = &net.Dialer{}
+	info = newTransportInfo(cfg, cfg.ServerAddr, conn.ConnectionState())
+	require.False(t, info.Proxied)
+	require.True(t, info.CustomDialer)
+}
+
+func TestDialerFunc(t *testing.T) {
+	type dialKey struct{}
+	var (
+		gotCtx   context.Context
+		gotAddr  string
+		upstream = errors.New("dialed")
+	)
+	dialer := DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		gotCtx, gotAddr = ctx, network+"://"+address
+		return nil, upstream
+	})
+
+	ctx := context.WithValue(context.Background(), dialKey{}, "value")
+	_, err := dialer.DialContext(ctx, "tcp", "tunnel.example.com:443")
+	require.ErrorIs(t, err, upstream)
+	require.Equal(t, "value", gotCtx.Value(dialKey{}))
+	require.Equal(t, "tcp://tunnel.example.com:443", gotAddr)
+
+	_, err = dialer.Dial("tcp", "tunnel.example.com:443")
+	require.ErrorIs(t, err, upstream)
+	require.NotNil(t, gotCtx)
+	require.Nil(t, gotCtx.Value(dialKey{}))
+
+	// Sessions dial the ngrok server with it.
+	_, err = Connect(ctx, WithServer("tunnel.example.com:443"), WithDialer(dialer))
+	require.ErrorIs(t, err, errSessionDial{})
+	require.ErrorIs(t, err, upstream)
+	require.Equal(t, "tcp://tunnel.example.com:443", gotAddr)
+	require.Equal(t, "value", gotCtx.Value(dialKey{}))
+}
+
+func TestServerFailover(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		dialed []string
+	)
+	dialer := DialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dialed = append(dialed, address)
+		return nil, errors.New("unreachable")
+	})
+
+	_, err := Connect(context.Background(),
+		WithServers("primary.example.com:443", "secondary.example.com:443"),
+		WithDialer(dialer),
+	)
+	require.ErrorIs(t, err, errSessionDial{})
+	require.ErrorContains(t, err, "secondary.example.com:443")
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.GreaterOrEqual(t, len(dialed), 2)
+	require.Equal(t, []string{"primary.example.com:443", "secondary.example.com:443"}, dialed[:2])
+}
+
+func TestWithRegions(t *testing.T) {
+	cfg := connectConfig{}
+	WithRegions("us", "", "eu")(&cfg)
+	require.Equal(t, "tunnel.us.ngrok.com:443", cfg.ServerAddr)
+	require.Equal(t, []string{"tunnel.eu.ngrok.com:443"}, cfg.FailoverAddrs)
+
+	// A single server or region replaces the whole list.
+	WithRegion("ap")(&cfg)
+	require.Equal(t, "tunnel.ap.ngrok.com:443", cfg.ServerAddr)
+	require.Empty(t, cfg.FailoverAddrs)
+
+	// An empty list leaves it as it was.
+	WithServers()(&cfg)
+	require.Equal(t, "tunnel.ap.ngrok.com:443", cfg.ServerAddr)
+}
+
+func TestSessionServerAddr(t *testing.T) {
+	sess := &sessionImpl{}
+	sess.transport.Store(newTransportInfo(&connectConfig{}, "tunnel.eu.ngrok.com:443", tls.ConnectionState{}))
+	require.Equal(t, "tunnel.eu.ngrok.com:443", sess.ServerAddr())
+}
+
+func TestSessionInfo(t *testing.T) {
+	sess := &sessionImpl{}
+	require.Empty(t, sess.ID(), "sessions have no ID until they authenticate")
+
+	sess.setInner(&sessionInner{
+		Region:          "eu",
+		ProtoVersion:    "3",
+		ServerVersion:   "prod",
+		ClientID:        "ts_123",
+		AccountName:     "Example",
+		PlanName:        "Pro",
+		SessionDuration: 3600,
+	})
+	require.Equal(t, "ts_123", sess.ID())
+	require.Equal(t, SessionInfo{
+		ID:              "ts_123",
+		Region:          "eu",
+		AccountName:     "Example",
+		PlanName:        "Pro",
+		AgentVersion:    libraryAgentVersion,
+		ProtoVersion:    "3",
+		ServerVersion:   "prod",
+		SessionDuration: time.Hour,
+	}, sess.Info())
+}
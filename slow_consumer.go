@@ -0,0 +1,102 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// The callback registered with [Tunnel].OnSlowConsumer.
+type slowConsumerHook struct {
+	watermark int64
+	fn        func(conn net.Conn, pending int64)
+}
+
+func (t *tunnelImpl) OnSlowConsumer(watermark int64, fn func(conn net.Conn, pending int64)) {
+	if fn == nil {
+		t.onSlowConsumer.Store((*slowConsumerHook)(nil))
+		return
+	}
+	t.onSlowConsumer.Store(&slowConsumerHook{watermark: watermark, fn: fn})
+}
+
+// Accounts for the bytes written to a connection that haven't yet been
+// accepted by the underlying connection, whether they're sitting in its write
+// buffer, or are part of a write that's blocked because the client isn't
+// reading.
+//
+// Writes to the underlying connection go through the writeQueue, which counts
+// them as sent once they return.
+type writeQueue struct {
+	// Accessed atomically. Kept first to guarantee 64-bit alignment.
+	queued int64
+	sent   int64
+	peak   int64
+	// Non-zero while the pending bytes are above the slow consumer
+	// watermark, so that the callback fires once per crossing.
+	slow int32
+
+	w io.Writer
+}
+
+func (q *writeQueue) Write(p []byte) (int, error) {
+	n, err := q.w.Write(p)
+	atomic.AddInt64(&q.sent, int64(n))
+	return n, err
+}
+
+// Records that n bytes are about to be written, returning the number that
+// will then be pending.
+func (q *writeQueue) enqueue(n int) int64 {
+	pending := atomic.AddInt64(&q.queued, int64(n)) - atomic.LoadInt64(&q.sent)
+	for {
+		peak := atomic.LoadInt64(&q.peak)
+		if pending <= peak || atomic.CompareAndSwapInt64(&q.peak, peak, pending) {
+			return pending
+		}
+	}
+}
+
+// Records that n of the bytes that were enqueued won't be written after all,
+// because the write failed.
+func (q *writeQueue) dequeue(n int) {
+	atomic.AddInt64(&q.queued, -int64(n))
+}
+
+func (q *writeQueue) pending() int64 {
+	return atomic.LoadInt64(&q.queued) - atomic.LoadInt64(&q.sent)
+}
+
+// Calls the tunnel's slow consumer callback if the connection's pending bytes
+// have just crossed its watermark.
+func (c *connImpl) checkSlowConsumer(pending int64) {
+	hook, _ := c.Tun.onSlowConsumer.Load().(*slowConsumerHook)
+	if hook == nil {
+		return
+	}
+	if pending <= hook.watermark {
+		atomic.StoreInt32(&c.queue.slow, 0)
+		return
+	}
+	if atomic.CompareAndSwapInt32(&c.queue.slow, 0, 1) {
+		atomic.AddUint64(&c.Tun.slowConsumers, 1)
+		go func() {
+			err := c.Tun.guarded("slow consumer", func() error {
+				hook.fn(c, pending)
+				return nil
+			})
+			if err != nil {
+				_ = c.Close()
+			}
+		}()
+	}
+}
+
+// PendingWrites returns the number of bytes written to the connection that
+// haven't yet been sent on to the ngrok edge, either because they're in its
+// write buffer, or because the client isn't reading fast enough for the
+// writes to complete. A count that stays high is the mark of a slow consumer.
+// See [Tunnel].OnSlowConsumer to be notified of them.
+func (c *connImpl) PendingWrites() int64 {
+	return c.queue.pending()
+}
@@ -0,0 +1,129 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlowConsumerBuffered(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.writeBufferSize = 1 << 10
+
+	type slowEvent struct {
+		conn    net.Conn
+		pending int64
+	}
+	events := make(chan slowEvent, 10)
+	tun.OnSlowConsumer(100, func(conn net.Conn, pending int64) {
+		events <- slowEvent{conn, pending}
+	})
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	pending := conn.(interface{ PendingWrites() int64 }).PendingWrites
+
+	_, err = conn.Write(make([]byte, 100))
+	require.NoError(t, err)
+	require.Equal(t, int64(100), pending())
+	select {
+	case ev := <-events:
+		require.FailNow(t, "fired at the watermark", ev.pending)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, err = conn.Write(make([]byte, 50))
+	require.NoError(t, err)
+	ev := <-events
+	require.Equal(t, conn, ev.conn)
+	require.Equal(t, int64(150), ev.pending)
+
+	// Fires once per crossing.
+	_, err = conn.Write(make([]byte, 50))
+	require.NoError(t, err)
+	require.NoError(t, conn.(interface{ Flush() error }).Flush())
+	require.Zero(t, pending())
+	_, err = conn.Write(make([]byte, 10))
+	require.NoError(t, err)
+	require.Empty(t, events)
+
+	_, err = conn.Write(make([]byte, 200))
+	require.NoError(t, err)
+	require.Equal(t, int64(210), (<-events).pending)
+	require.Equal(t, uint64(2), tun.Describe().SlowConsumers)
+
+	// Unregistered callbacks aren't called.
+	tun.OnSlowConsumer(0, nil)
+	require.NoError(t, conn.(interface{ Flush() error }).Flush())
+	_, err = conn.Write(make([]byte, 200))
+	require.NoError(t, err)
+	require.Empty(t, events)
+}
+
+func TestSlowConsumerBlocked(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	slow := make(chan int64, 1)
+	tun.OnSlowConsumer(1<<20, func(conn net.Conn, pending int64) {
+		slow <- pending
+		_ = conn.Close()
+	})
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The client never reads, so the socket buffers fill, and the writes
+	// block until the callback closes the connection. The writes that are
+	// blocked count as pending.
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			chunk := make([]byte, 256<<10)
+			for {
+				if _, err := conn.Write(chunk); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	require.Greater(t, <-slow, int64(1<<20))
+	for i := 0; i < cap(errs); i++ {
+		require.ErrorIs(t, <-errs, net.ErrClosed)
+	}
+}
+
+func TestSlowConsumerPanic(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).writeBufferSize = 1 << 10
+	tun.OnSlowConsumer(100, func(conn net.Conn, pending int64) {
+		panic("oops")
+	})
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The connection whose callback panicked is closed, once what it had
+	// buffered is flushed.
+	_, err = conn.Write(make([]byte, 200))
+	require.NoError(t, err)
+	n, err := io.Copy(io.Discard, client)
+	require.NoError(t, err)
+	require.Equal(t, int64(200), n)
+}
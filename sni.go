@@ -0,0 +1,154 @@
+package ngrok
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ErrSNIMismatch is matched by the reason given in the [AcceptEvent] for
+// connections that were rejected because the server name they requested via
+// SNI wasn't allowed by config.WithRequiredSNI.
+var ErrSNIMismatch = errors.New("TLS server name not allowed")
+
+// How long to wait for a ClientHello when the tunnel has no handshake timeout.
+const sniReadTimeout = 10 * time.Second
+
+// The largest TLS record, including its header.
+const maxTLSRecordSize = 5 + 16384
+
+// Reads the ClientHello from a newly accepted connection and checks its SNI
+// against the tunnel's required server names. Returns a connection which
+// replays the ClientHello, and the server name, which is empty if the client
+// didn't send one.
+func (t *tunnelImpl) checkSNI(conn net.Conn) (net.Conn, string, error) {
+	timeout := t.handshakeTimeout
+	if timeout == 0 {
+		timeout = sniReadTimeout
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+
+	peek := newPeekConnSize(conn, maxTLSRecordSize)
+	name, err := peekServerName(peek)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrSNIMismatch, err)
+	}
+	if !matchServerName(t.requiredSNI, name) {
+		return nil, name, fmt.Errorf("%w: %q", ErrSNIMismatch, name)
+	}
+	return peek, name, nil
+}
+
+// Reads the server name from the ClientHello at the start of the connection,
+// without consuming it.
+func peekServerName(peek *peekConn) (string, error) {
+	header, err := peek.PeekN(5)
+	if err != nil {
+		return "", err
+	}
+	if header[0] != 0x16 {
+		return "", errors.New("not a TLS handshake")
+	}
+	length := int(binary.BigEndian.Uint16(header[3:]))
+	record, err := peek.PeekN(5 + length)
+	if err != nil {
+		return "", err
+	}
+	return parseServerName(record[5:])
+}
+
+// Parses the server name from the handshake message in a TLS record, which
+// must be a ClientHello. Returns the empty string if there's no SNI extension.
+func parseServerName(msg []byte) (string, error) {
+	malformed := errors.New("malformed ClientHello")
+
+	s := cryptobyte.String(msg)
+	var (
+		msgType uint8
+		hello   cryptobyte.String
+	)
+	if !s.ReadUint8(&msgType) || msgType != 1 || !s.ReadUint24LengthPrefixed(&hello) {
+		return "", malformed
+	}
+
+	var sessionID, cipherSuites, compression cryptobyte.String
+	if !hello.Skip(2+32) ||
+		!hello.ReadUint8LengthPrefixed(&sessionID) ||
+		!hello.ReadUint16LengthPrefixed(&cipherSuites) ||
+		!hello.ReadUint8LengthPrefixed(&compression) {
+		return "", malformed
+	}
+	if hello.Empty() {
+		return "", nil
+	}
+
+	var extensions cryptobyte.String
+	if !hello.ReadUint16LengthPrefixed(&extensions) {
+		return "", malformed
+	}
+	for !extensions.Empty() {
+		var (
+			extType uint16
+			ext     cryptobyte.String
+		)
+		if !extensions.ReadUint16(&extType) || !extensions.ReadUint16LengthPrefixed(&ext) {
+			return "", malformed
+		}
+		if extType != 0 {
+			continue
+		}
+
+		var names cryptobyte.String
+		if !ext.ReadUint16LengthPrefixed(&names) {
+			return "", malformed
+		}
+		for !names.Empty() {
+			var (
+				nameType uint8
+				name     cryptobyte.String
+			)
+			if !names.ReadUint8(&nameType) || !names.ReadUint16LengthPrefixed(&name) {
+				return "", malformed
+			}
+			if nameType == 0 {
+				return string(name), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// Reports whether the server name matches any of the patterns, which must be
+// lowercase.
+func matchServerName(patterns []string, name string) bool {
+	if name == "" {
+		return false
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, pattern := range patterns {
+		if suffix := strings.TrimPrefix(pattern, "*"); suffix != pattern {
+			label, rest, ok := strings.Cut(name, ".")
+			if ok && label != "" && "."+rest == suffix {
+				return true
+			}
+			continue
+		}
+		if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// ServerName returns the server name that the client requested via SNI, as
+// checked for config.WithRequiredSNI. It returns the empty string for
+// connections to tunnels without that option.
+func (c *connImpl) ServerName() string {
+	return c.serverName
+}
@@ -0,0 +1,150 @@
+package ngrok
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchServerName(t *testing.T) {
+	patterns := []string{"app.example.com", "*.api.example.com"}
+
+	for name, matches := range map[string]bool{
+		"app.example.com":       true,
+		"APP.example.com":       true,
+		"app.example.com.":      true,
+		"v1.api.example.com":    true,
+		"api.example.com":       false,
+		"a.v1.api.example.com":  false,
+		".api.example.com":      false,
+		"other.example.com":     false,
+		"":                      false,
+		"app.example.com.evil":  false,
+		"v1.api.example.com.io": false,
+	} {
+		require.Equal(t, matches, matchServerName(patterns, name), name)
+	}
+}
+
+// Captures the ClientHello that a TLS client sends for the server name.
+func clientHello(t *testing.T, serverName string) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		_ = tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+	}()
+
+	peek := newPeekConnSize(server, maxTLSRecordSize)
+	_, err := peekServerName(peek)
+	require.NoError(t, err)
+	header, err := peek.PeekN(5)
+	require.NoError(t, err)
+	record, err := peek.PeekN(5 + int(binary.BigEndian.Uint16(header[3:])))
+	require.NoError(t, err)
+	return append([]byte(nil), record...)
+}
+
+func TestParseServerName(t *testing.T) {
+	hello := clientHello(t, "app.example.com")
+	name, err := parseServerName(hello[5:])
+	require.NoError(t, err)
+	require.Equal(t, "app.example.com", name)
+
+	// IP addresses aren't sent as SNI.
+	hello = clientHello(t, "127.0.0.1")
+	name, err = parseServerName(hello[5:])
+	require.NoError(t, err)
+	require.Empty(t, name)
+
+	_, err = parseServerName(hello[5:20])
+	require.Error(t, err)
+	_, err = parseServerName([]byte("GET / HTTP/1.1\r\n"))
+	require.Error(t, err)
+}
+
+func TestRequiredSNI(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.requiredSNI = []string{"*.example.com"}
+	impl.handshakeTimeout = time.Second
+
+	events := make(chan AcceptEvent, 10)
+	tun.OnAccept(func(ev AcceptEvent) { events <- ev })
+
+	dial := func(payload []byte) net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		_, err = conn.Write(payload)
+		require.NoError(t, err)
+		return conn
+	}
+
+	rejected := dial(clientHello(t, "evil.test"))
+	notTLS := dial([]byte("GET / HTTP/1.1\r\n\r\n"))
+	allowedHello := clientHello(t, "app.example.com")
+	dial(allowedHello)
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, "app.example.com", conn.(interface{ ServerName() string }).ServerName())
+
+	// The ClientHello is replayed to the application.
+	replayed := make([]byte, len(allowedHello))
+	_, err = io.ReadFull(conn, replayed)
+	require.NoError(t, err)
+	require.Equal(t, allowedHello, replayed)
+
+	for _, c := range []net.Conn{rejected, notTLS} {
+		require.NoError(t, c.SetReadDeadline(time.Now().Add(time.Second)))
+		_, err := c.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF, "rejected connections are closed")
+	}
+
+	// Handshakes are checked concurrently, so the events may come in any
+	// order.
+	var rejections int
+	for i := 0; i < 3; i++ {
+		if ev := <-events; ev.Rejected != nil {
+			require.ErrorIs(t, ev.Rejected, ErrSNIMismatch)
+			rejections++
+		}
+	}
+	require.Equal(t, 2, rejections)
+	require.Equal(t, uint64(2), tun.Describe().SNIRejections)
+}
+
+func TestRequiredSNISlowClient(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.requiredSNI = []string{"app.example.com"}
+	impl.handshakeTimeout = time.Minute
+
+	// A client that never sends its ClientHello doesn't hold up the others.
+	silent, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer silent.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write(clientHello(t, "app.example.com"))
+	require.NoError(t, err)
+
+	accepted := acceptAsync(t, tun)
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+		require.Equal(t, "app.example.com", conn.(Conn).ServerName())
+	case <-time.After(5 * time.Second):
+		t.Fatal("the silent client held up Accept")
+	}
+}
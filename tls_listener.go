@@ -0,0 +1,43 @@
+package ngrok
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+)
+
+// TerminateTLS returns a [net.Listener] that accepts connections from the
+// [Tunnel] and terminates TLS for them with the provided configuration, so
+// that the connections returned from its Accept method are decrypted. It's
+// meant for TLS and TCP tunnels, where the ngrok edge passes the client's TLS
+// through, and suits protocols other than HTTP. Use [ServeTLS] to serve HTTP.
+//
+// The returned connections are [*tls.Conn]s, whose handshake happens on their
+// first Read or Write, or when their Handshake method is called. Their
+// NetConn method returns the connection accepted from the Tunnel, which
+// implements [Conn]. Closing the listener closes the Tunnel.
+//
+// The configuration must provide certificates, either in its Certificates
+// field or with one of its GetCertificate or GetConfigForClient functions. When
+// there are several Certificates, the one presented to each client is chosen
+// by the server name that it sent (SNI), falling back to the first. To choose
+// them some other way, such as to load them on demand, set GetCertificate.
+// Certificate managers such as golang.org/x/crypto/acme/autocert provide a
+// suitable configuration, including for ACME's TLS-ALPN-01 challenge, since
+// the challenge's connections arrive through the Tunnel like any other:
+//
+//	m := &autocert.Manager{Prompt: autocert.AcceptTOS, HostPolicy: autocert.HostWhitelist("example.com")}
+//	l, err := ngrok.TerminateTLS(tun, m.TLSConfig())
+//
+// If the configuration doesn't set a MinVersion, TLS 1.2 is required, as it is
+// by [ServeTLS]. The configuration isn't modified.
+func TerminateTLS(tun Tunnel, tlsConfig *tls.Config) (net.Listener, error) {
+	if tlsConfig == nil || len(tlsConfig.Certificates) == 0 && tlsConfig.GetCertificate == nil && tlsConfig.GetConfigForClient == nil {
+		return nil, errors.New("no TLS certificates or certificate selector configured for TerminateTLS")
+	}
+	tlsConfig = tlsConfig.Clone()
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+	return tls.NewListener(tun, tlsConfig), nil
+}
@@ -0,0 +1,82 @@
+package ngrok
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerminateTLS(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	l, err := TerminateTLS(tun, &tls.Config{
+		Certificates: []tls.Certificate{
+			testCertificate(t, "a.example.com"),
+			testCertificate(t, "b.example.com"),
+		},
+	})
+	require.NoError(t, err)
+
+	for _, name := range []string{"a.example.com", "b.example.com"} {
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			// The handshake happens on the first read, which the client
+			// is waiting on.
+			_ = conn.(*tls.Conn).Handshake()
+			accepted <- conn
+		}()
+
+		client, err := tls.Dial("tcp", addr, &tls.Config{
+			InsecureSkipVerify: true,
+			ServerName:         name,
+		})
+		require.NoError(t, err)
+		defer client.Close()
+
+		conn, ok := <-accepted
+		require.True(t, ok)
+		defer conn.Close()
+
+		_, err = io.WriteString(client, "ping")
+		require.NoError(t, err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(buf), "the connection is decrypted")
+
+		tlsConn := conn.(*tls.Conn)
+		require.Equal(t, name, tlsConn.ConnectionState().ServerName)
+		_, ok = tlsConn.NetConn().(Conn)
+		require.True(t, ok)
+
+		peer := client.ConnectionState().PeerCertificates[0]
+		require.Equal(t, name, peer.Subject.CommonName, "certificates are chosen by SNI")
+		require.Equal(t, uint16(tls.VersionTLS13), client.ConnectionState().Version)
+	}
+
+	require.NoError(t, l.Close())
+	require.ErrorIs(t, tun.Err(), ErrTunnelClosed, "closing the listener closes the tunnel")
+}
+
+func TestTerminateTLSErrors(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+	_, err := TerminateTLS(tun, nil)
+	require.Error(t, err)
+	_, err = TerminateTLS(tun, &tls.Config{})
+	require.Error(t, err)
+
+	// Certificates may be chosen on demand instead.
+	cfg := &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return nil, nil
+	}}
+	_, err = TerminateTLS(tun, cfg)
+	require.NoError(t, err)
+	require.Zero(t, cfg.MinVersion, "the configuration isn't modified")
+}
@@ -0,0 +1,129 @@
+package ngrok
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Tracer is notified about each connection accepted from a [Tunnel]. It's a
+// deliberately small interface, so that tracing systems such as OpenTelemetry
+// can be adapted to it without the SDK depending on them.
+//
+// Configure one for a [Session] with [WithTracer].
+type Tracer interface {
+	// StartConn is called when a connection is accepted from a [Tunnel]. The
+	// returned context is made available from the connection's Context
+	// method, so that work done on behalf of the connection can be attached
+	// to its trace.
+	//
+	// The returned span is ended when the connection is closed.
+	StartConn(ctx context.Context, attrs ConnAttributes) (context.Context, ConnSpan)
+}
+
+// ConnSpan is the span for a connection started by a [Tracer].
+type ConnSpan interface {
+	// End is called exactly once, when the connection is closed.
+	End(stats ConnStats)
+}
+
+// ConnAttributes describes a connection as it's accepted from a [Tunnel].
+type ConnAttributes struct {
+	// The ID of the tunnel that the connection was accepted from. Use this
+	// to correlate the connection with the edge that it arrived on.
+	TunnelID string
+	// The connection's identifier, as returned by its ConnID method.
+	ConnID uint64
+	// The ID of the binding that the ngrok edge routed the connection to,
+	// from its proxy header.
+	BindID string
+	// The address of the client that initiated the connection at the ngrok
+	// edge.
+	ClientAddr string
+	// The protocol of the connection, e.g. "http" or "tcp".
+	Proto string
+	// The type of edge that the connection arrived on, if it was a labeled
+	// tunnel.
+	EdgeType string
+}
+
+// ConnStats summarizes a connection once it's closed.
+type ConnStats struct {
+	// The number of bytes read from the connection.
+	BytesRead int64
+	// The number of bytes written to the connection.
+	BytesWritten int64
+	// The most bytes that were written to the connection but not yet sent
+	// on to the ngrok edge at once. See the PendingWrites method of
+	// connections accepted from a [Tunnel].
+	PeakPendingWrites int64
+	// The time between the connection being accepted and closed.
+	Duration time.Duration
+	// The annotations added to the connection by the application. See
+	// the Annotate method of connections accepted from a [Tunnel].
+	Annotations map[string]string
+	// The location of the client, if the session was configured with
+	// [WithGeoIP] and the lookup succeeded.
+	Geo GeoInfo
+}
+
+// WithTracer configures a [Tracer] to be notified about each connection
+// accepted from the session's tunnels.
+func WithTracer(tracer Tracer) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.Tracer = tracer
+	}
+}
+
+// The tracing state of a single connection.
+type connTrace struct {
+	// Accessed atomically. Kept first to guarantee 64-bit alignment.
+	bytesRead    int64
+	bytesWritten int64
+
+	ctx       context.Context
+	span      ConnSpan
+	clock     clock
+	startedAt time.Time
+}
+
+func startConnTrace(clock clock, tracer Tracer, attrs ConnAttributes) *connTrace {
+	ctx, span := tracer.StartConn(context.Background(), attrs)
+	return &connTrace{
+		ctx:       ctx,
+		span:      span,
+		clock:     clock,
+		startedAt: clock.Now(),
+	}
+}
+
+func (t *connTrace) read(n int) {
+	if t != nil {
+		atomic.AddInt64(&t.bytesRead, int64(n))
+	}
+}
+
+func (t *connTrace) wrote(n int) {
+	if t != nil {
+		atomic.AddInt64(&t.bytesWritten, int64(n))
+	}
+}
+
+func (t *connTrace) end(c *connImpl) {
+	if t == nil || t.span == nil {
+		return
+	}
+	geo, _ := c.Geo()
+	stats := ConnStats{
+		BytesRead:         atomic.LoadInt64(&t.bytesRead),
+		BytesWritten:      atomic.LoadInt64(&t.bytesWritten),
+		PeakPendingWrites: atomic.LoadInt64(&c.queue.peak),
+		Duration:          t.clock.Now().Sub(t.startedAt),
+		Annotations:       c.Annotations(),
+		Geo:               geo,
+	}
+	_ = c.Tun.guarded("tracer", func() error {
+		t.span.End(stats)
+		return nil
+	})
+}
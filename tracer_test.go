@@ -0,0 +1,172 @@
+package ngrok
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type traceKey struct{}
+
+type testTracer struct {
+	started chan ConnAttributes
+	ended   chan ConnStats
+}
+
+func (tr *testTracer) StartConn(ctx context.Context, attrs ConnAttributes) (context.Context, ConnSpan) {
+	tr.started <- attrs
+	return context.WithValue(ctx, traceKey{}, "trace-"+attrs.TunnelID), tr
+}
+
+func (tr *testTracer) End(stats ConnStats) {
+	tr.ended <- stats
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &testTracer{
+		started: make(chan ConnAttributes, 1),
+		ended:   make(chan ConnStats, 1),
+	}
+
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).tracer = tracer
+	tun.(*tunnelImpl).Tunnel.(*fakeClientTunnel).header.ID = "bind_1"
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+
+	attrs := <-tracer.started
+	require.Equal(t, "fake", attrs.TunnelID)
+	require.Equal(t, conn.(Conn).ConnID(), attrs.ConnID)
+	require.Equal(t, "bind_1", attrs.BindID)
+	require.Equal(t, "127.0.0.1:1234", attrs.ClientAddr)
+
+	ctx := conn.(interface{ Context() context.Context }).Context()
+	require.Equal(t, "trace-fake", ctx.Value(traceKey{}))
+
+	_, err = io.WriteString(client, "ping")
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, "pong!")
+	require.NoError(t, err)
+
+	annotator := conn.(interface{ Annotate(key, value string) })
+	annotator.Annotate("tenant", "acme")
+	annotator.Annotate("user", "wile")
+	annotator.Annotate("user", "road-runner")
+
+	require.NoError(t, conn.Close())
+	_ = conn.Close()
+
+	stats := <-tracer.ended
+	require.Equal(t, int64(4), stats.BytesRead)
+	require.Equal(t, int64(5), stats.BytesWritten)
+	require.Positive(t, stats.Duration)
+	require.Equal(t, map[string]string{"tenant": "acme", "user": "road-runner"}, stats.Annotations)
+	require.Empty(t, tracer.ended, "spans are only ended once")
+}
+
+func TestNoTracer(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx := conn.(interface{ Context() context.Context }).Context()
+	require.Equal(t, context.Background(), ctx)
+}
+
+func TestConnAnnotations(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	annotated := conn.(interface {
+		Annotate(key, value string)
+		Annotations() map[string]string
+	})
+	require.Empty(t, annotated.Annotations())
+
+	annotated.Annotate("tenant", "acme")
+	annotations := annotated.Annotations()
+	require.Equal(t, map[string]string{"tenant": "acme"}, annotations)
+
+	annotations["tenant"] = "other"
+	require.Equal(t, "acme", annotated.Annotations()["tenant"], "Annotations returns a copy")
+}
+
+// Panics when starting or ending spans, if start or end are set.
+type panickyTracer struct {
+	start, end int32
+}
+
+func (tr *panickyTracer) StartConn(ctx context.Context, attrs ConnAttributes) (context.Context, ConnSpan) {
+	if atomic.LoadInt32(&tr.start) != 0 {
+		panic("oops")
+	}
+	return ctx, tr
+}
+
+func (tr *panickyTracer) End(stats ConnStats) {
+	if atomic.LoadInt32(&tr.end) != 0 {
+		panic("oops")
+	}
+}
+
+func TestTracerPanic(t *testing.T) {
+	tracer := &panickyTracer{start: 1}
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.tracer = tracer
+	errs := make(chan error, 2)
+	impl.guard.errorHandler = func(ctx context.Context, sess Session, err error) {
+		errs <- err
+	}
+
+	// The connection whose trace panicked is closed, and Accept moves on to
+	// the next.
+	closed, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer closed.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := tun.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	require.ErrorIs(t, <-errs, errCallbackPanic{})
+	_, err = closed.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	atomic.StoreInt32(&tracer.start, 0)
+	atomic.StoreInt32(&tracer.end, 1)
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	conn := <-accepted
+
+	// Panics when ending the span don't stop the connection from closing.
+	require.NoError(t, conn.Close())
+	require.ErrorIs(t, <-errs, errCallbackPanic{})
+}
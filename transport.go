@@ -0,0 +1,49 @@
+package ngrok
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// TransportInfo describes how a [Session] is connected to the ngrok service,
+// as returned by [Session].TransportInfo. It's a snapshot taken when the
+// connection was established, and is replaced each time the Session
+// reconnects.
+type TransportInfo struct {
+	// The address of the ngrok server, as configured with WithServer, or
+	// the one that was reached of those configured with WithServers.
+	ServerAddr string `json:"server_addr"`
+	// The server name that the server's certificate was verified against.
+	ServerName string `json:"server_name"`
+	// The negotiated TLS version, e.g. tls.VersionTLS13.
+	TLSVersion uint16 `json:"tls_version"`
+	// The negotiated cipher suite. See tls.CipherSuiteName.
+	CipherSuite uint16 `json:"cipher_suite"`
+	// Whether the connection was made through the proxy configured with
+	// [WithProxyURL].
+	Proxied bool `json:"proxied"`
+	// Whether the connection was made by the dialer configured with
+	// [WithDialer], in which case it may have been proxied by other means.
+	CustomDialer bool `json:"custom_dialer"`
+	// When the connection was established.
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// Describes a connection to the ngrok service that has completed its TLS
+// handshake.
+func newTransportInfo(cfg *connectConfig, addr string, state tls.ConnectionState) TransportInfo {
+	return TransportInfo{
+		ServerAddr:   addr,
+		ServerName:   state.ServerName,
+		TLSVersion:   state.Version,
+		CipherSuite:  state.CipherSuite,
+		Proxied:      cfg.Dialer == nil && cfg.ProxyURL != nil,
+		CustomDialer: cfg.Dialer != nil,
+		ConnectedAt:  clockOrSystem(cfg.Clock).Now(),
+	}
+}
+
+func (s *sessionImpl) TransportInfo() TransportInfo {
+	info, _ := s.transport.Load().(TransportInfo)
+	return info
+}
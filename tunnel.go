@@ -2,9 +2,21 @@ package ngrok
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/inconshreveable/log15/v3"
+	"golang.org/x/sync/errgroup"
+
 	"golang.ngrok.com/ngrok/config"
 	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
 )
@@ -14,7 +26,12 @@ import (
 // connections from endpoints created on the ngrok service.
 type Tunnel interface {
 	// Every Tunnel is a net.Listener. It can be plugged into any existing
-	// code that expects a net.Listener seamlessly without any changes.
+	// code that expects a net.Listener seamlessly without any changes, such
+	// as http.Serve, fcgi.Serve, or an accept loop feeding
+	// http2.Server.ServeConn.
+	//
+	// Once the Tunnel has been closed, Accept returns an error wrapping
+	// net.ErrClosed, which servers treat as a signal to stop accepting.
 	net.Listener
 
 	// Close is a convenience method for calling Tunnel.CloseWithContext
@@ -24,28 +41,353 @@ type Tunnel interface {
 	// CloseWithContext closes the Tunnel. Closing a tunnel is an operation
 	// that involves sending a "close" message over the parent session.
 	// Since this is a network operation, it is most correct to provide a
-	// context with a timeout.
+	// context with a timeout. If the context is done before the ngrok
+	// service replies, CloseWithContext returns the context's error, but
+	// the Tunnel stops accepting connections regardless.
+	//
+	// Connections that were already accepted are left open. Use Shutdown to
+	// wait for them as well.
 	CloseWithContext(context.Context) error
+	// Shutdown gracefully closes the Tunnel. It closes the Tunnel as
+	// CloseWithContext does, and then waits for the connections that were
+	// already accepted to be closed. If the context is done first, the
+	// remaining connections are closed, and the context's error is
+	// returned.
+	Shutdown(ctx context.Context) error
+	// Drain stops the ngrok edge from routing new connections to the
+	// Tunnel, by closing it as CloseWithContext does, and then waits for
+	// the connections that were already accepted to finish. Unlike
+	// Shutdown, connections still open when the context is done are left
+	// alone, and the context's error is returned.
+	//
+	// For rolling restarts, start the replacement tunnel with the same
+	// labels before draining the old one, so that the edge always has a
+	// tunnel to route to.
+	Drain(ctx context.Context) error
 	// ForwardsTo returns a human-readable string presented in the ngrok
 	// dashboard and the Tunnels API. Use config.WithForwardsTo when
-	// calling Session.Listen to set this value explicitly.
+	// calling Session.Listen to set this value explicitly, otherwise it
+	// describes the current process.
 	ForwardsTo() string
 	// ID returns a tunnel's unique ID.
 	ID() string
 	// Labels returns the labels set by config.WithLabel if this is a
 	// labeled tunnel. Non-labeled tunnels will return an empty map.
 	Labels() map[string]string
+	// LabelSet is like Labels, but returns a copy of the labels as a
+	// LabelSet, which supports matching against label selectors.
+	LabelSet() LabelSet
+	// SetLabels replaces the labels of a labeled tunnel while it keeps
+	// running, e.g. to shift an Edge's traffic between blue and green
+	// deployments from within the app. The tunnel is bound with the new
+	// labels before the old ones are removed, so connections keep arriving
+	// throughout, and those already accepted are unaffected. Its ID changes.
+	//
+	// Returns an error matching ErrNotLabeled for tunnels that weren't
+	// started with config.LabeledTunnel.
+	SetLabels(labels map[string]string) error
 	// Metadata returns the arbitraray metadata string for this tunnel.
 	Metadata() string
-	// Proto returns the protocol of the tunnel's endpoint.
+	// SetMetadata replaces the metadata of a labeled tunnel while it keeps
+	// running, so that long-running processes can publish their state, such
+	// as their version or health, to the ngrok API and dashboard. Like
+	// SetLabels, it binds the tunnel again before removing the old binding,
+	// so connections keep arriving throughout, and its ID changes. Calls are
+	// made one at a time, along with those to SetLabels. If the context is
+	// done before the call is made, the metadata is left unchanged. If it's
+	// done while the new binding is being made, SetMetadata returns the
+	// context's error, but the binding may still succeed, which is logged.
+	//
+	// Returns an error matching ErrNotLabeled for tunnels that weren't
+	// started with config.LabeledTunnel, since the ngrok service only
+	// accepts the metadata of endpoints when they're started.
+	SetMetadata(ctx context.Context, metadata string) error
+	// Proto returns the protocol of the tunnel's endpoint, which is one of
+	// ProtoHTTP, ProtoHTTPS, ProtoTCP, or ProtoTLS.
 	// Labeled tunnels will return the empty string.
 	Proto() string
 	// Session returns the tunnel's parent Session object that it
-	// was started on.
+	// was started on. It never returns nil: if the Tunnel has no Session,
+	// the returned Session's methods fail with ErrNoSession.
 	Session() Session
+	// HasSession reports whether the Tunnel has a parent Session.
+	HasSession() bool
 	// URL returns the tunnel endpoint's URL.
 	// Labeled tunnels will return the empty string.
 	URL() string
+	// Describe returns a snapshot of the tunnel's configuration that is safe
+	// to serialize, e.g. for admin APIs or CLI output.
+	Describe() TunnelInfo
+	// Bridge starts a TCP listener on localAddr, and pairs each local
+	// connection made to it with the next connection accepted from the
+	// Tunnel, copying data between them in both directions. This is for
+	// integrating with code that can only talk to a local address, rather
+	// than accept from a net.Listener.
+	//
+	// Closing the returned io.Closer closes the local listener, the Tunnel,
+	// and every bridged connection. It also has an Addr() net.Addr method
+	// which reports the local listener's address, e.g. to discover the port
+	// chosen for "127.0.0.1:0".
+	Bridge(localAddr string) (io.Closer, error)
+	// AcceptStream accepts the next connection from the Tunnel and returns
+	// it as a stream, for protocols that use a single long-lived connection
+	// rather than serving many. Unlike Accept, it gives up when the context
+	// is done, returning the context's error. The Tunnel stays open, and
+	// each call returns the next connection to arrive, so the Tunnel should
+	// be closed once no more are wanted.
+	//
+	// The returned stream is a net.Conn, and can be type-asserted to one
+	// when its addresses or deadlines are needed. AcceptStream shouldn't be
+	// mixed with Accept on the same Tunnel, since a connection may be held
+	// for the next call to AcceptStream after one gives up.
+	AcceptStream(ctx context.Context) (io.ReadWriteCloser, error)
+	// AcceptContext is like Accept, but gives up when the context is done,
+	// returning the context's error. As with AcceptStream, it shouldn't be
+	// mixed with Accept on the same Tunnel.
+	//
+	// If the context has a deadline, it's also set as the read and write
+	// deadline of the returned connection, so that the connection must
+	// produce data within the same window that it was accepted in. The
+	// deadline can be extended or cleared afterwards with SetDeadline, e.g.
+	// with conn.SetDeadline(time.Time{}) once the client has identified
+	// itself.
+	AcceptContext(ctx context.Context) (net.Conn, error)
+	// ServeWithGroup accepts connections from the Tunnel in a goroutine
+	// started with g.Go, and handles each of them in a goroutine of its own
+	// also started with g.Go. Connections are closed once handle returns.
+	//
+	// The context should be the one returned by errgroup.WithContext, so
+	// that the first error from a handler cancels it. Once it's done, the
+	// Tunnel is closed and the accept loop returns an error matching
+	// ErrServeShutdown. If the Tunnel is closed first, the accept loop
+	// returns an error matching ErrTunnelClosed, which also cancels the
+	// group. In either case, g.Wait returns only once every handler has.
+	ServeWithGroup(ctx context.Context, g *errgroup.Group, handle func(context.Context, net.Conn) error)
+	// Serve accepts connections from the Tunnel until ctx is done, and calls
+	// handler for each of them in a goroutine of its own, closing the
+	// connection once handler returns. It's the accept loop for servers of
+	// protocols other than HTTP; use the package-level Serve for those.
+	//
+	// Once ctx is done, the Tunnel is closed so that no more connections are
+	// accepted, and Serve waits for the handlers that are still running to
+	// return. If ctx has a deadline, they're given until then, after which
+	// their connections are closed, so that cancelling ctx early leaves the
+	// rest of its deadline for draining. Without one, Serve waits for them
+	// indefinitely.
+	//
+	// Serve always returns a non-nil error, once every handler has returned.
+	// It matches ErrServeShutdown if ctx was done first, and ErrTunnelClosed
+	// if the Tunnel was closed first. If connections had to be closed at the
+	// deadline, the error also matches context.DeadlineExceeded and reports
+	// how many were. Any other error is the one returned by Accept.
+	Serve(ctx context.Context, handler func(net.Conn)) error
+	// Forward forwards each connection accepted from the Tunnel to the
+	// upstream TCP address until ctx is done, copying data in both
+	// directions until either side closes. The upstream is either a host
+	// and port, such as "localhost:8080", or the same as a "tcp://" URL.
+	// This replaces the ngrok agent for simple reverse-proxy use cases.
+	//
+	// It's the package-level Forward, with the graceful shutdown of Serve:
+	// once ctx is done, the Tunnel is closed, and connections that are still
+	// being forwarded are given until ctx's deadline, if it has one, before
+	// they and their upstream connections are closed. Failures to connect to
+	// the upstream drop the connection, and are reported to the handler set
+	// with WithForwardErrorHandler, if any. The returned error is the same as
+	// Serve's, unless the upstream or options are invalid.
+	Forward(ctx context.Context, upstream string, opts ...ForwardOption) error
+	// PingRTT measures the round trip from this process, through the
+	// Tunnel's public URL and the ngrok edge, and back down the Tunnel to
+	// this process. Compare it to the Session's heartbeat latency, which
+	// only covers the link between this process and the edge, to see how
+	// much of a client's latency comes from its path to the edge.
+	//
+	// PingRTT only supports HTTP and HTTPS endpoints that are being served
+	// by Serve, ServeTLS, or ServeReverseProxy, which answer its requests
+	// without passing them to the handler. It returns ErrNotSupported for
+	// other tunnels. The request is made from this process, so its path to
+	// the edge is this host's rather than a real client's, and edge
+	// features such as OAuth or IP restrictions may reject it.
+	PingRTT(ctx context.Context) (time.Duration, error)
+	// ConnectionState reports whether the Tunnel's Session is connected to
+	// the ngrok service, reconnecting after losing its connection, or
+	// closed. It's updated as the Session's reconnect loop progresses, for
+	// health checks that need to see through the reconnects that the
+	// Session otherwise hides.
+	ConnectionState() ConnState
+	// WaitConnected blocks until ConnectionState is ConnStateConnected,
+	// returning nil, or the context is done, returning its error. If the
+	// Tunnel or its Session is closed first, it returns ErrTunnelClosed.
+	WaitConnected(ctx context.Context) error
+	// SetDraining puts the Tunnel into, or takes it out of, drain mode.
+	// While draining, connections arriving from the ngrok edge are closed
+	// as soon as they're accepted rather than being returned from Accept,
+	// but connections that were already accepted keep working. The tunnel
+	// itself stays open, so its URL is kept for when draining stops.
+	SetDraining(draining bool)
+	// SetMaxBytesPerSecond changes the number of bytes per second that the
+	// connections accepted from the Tunnel may read or write between them,
+	// as first configured with config.WithMaxBytesPerSecond. Connections
+	// that are already open are held to the new limit right away, except
+	// for those accepted while there was no limit, which stay unlimited.
+	// Zero removes the limit. The Session's limit, if any, still applies.
+	SetMaxBytesPerSecond(bytesPerSec int64)
+	// SetMaxConnsPerSecond changes the number of connections per second that
+	// may be accepted from the Tunnel, as first configured with
+	// config.WithMaxConnsPerSecond. Zero removes the limit. The Session's
+	// limit, if any, still applies.
+	SetMaxConnsPerSecond(connsPerSec float64)
+	// SetMaintenance puts the Tunnel into maintenance mode with the page, or
+	// takes it out of maintenance mode if the page is nil. While in
+	// maintenance mode, HTTP connections arriving from the ngrok edge are
+	// answered with the page, 503 Service Unavailable by default, and the
+	// rest are reset, rather than being returned from Accept. Connections
+	// that were already accepted keep working.
+	//
+	// Neither the Tunnel nor its bind is closed, so its URL is kept, and
+	// connections are returned from Accept again as soon as maintenance mode
+	// ends. The page is served by the SDK rather than by the ngrok edge, so
+	// the Session must stay connected for clients to receive it.
+	SetMaintenance(page *MaintenancePage)
+	// Pause stops Accept from returning new connections until Resume is
+	// called, without closing the Tunnel or rejecting anything. Connections
+	// that arrive while paused are held open by the Session, and returned
+	// from Accept once resumed. The SDK doesn't limit how
+	// long they're held, or how many are, but clients and the ngrok edge
+	// may give up on connections that wait too long, so pauses should be
+	// brief. Use SetDraining instead to turn new connections away.
+	Pause()
+	// Resume undoes Pause.
+	Resume()
+	// WaitDrained blocks until every connection accepted from the Tunnel
+	// has been closed, or the context is done. It's typically called after
+	// SetDraining(true) to wait for in-flight work to finish.
+	WaitDrained(ctx context.Context) error
+	// ConnSet returns the live set of connections accepted from the Tunnel
+	// that are still open, for servers that need to enumerate or broadcast
+	// to their clients.
+	ConnSet() *ConnSet
+	// Stats returns the counters of the Tunnel's connections and their
+	// traffic since it was started, e.g. for admin endpoints.
+	Stats() TunnelStats
+	// Connections returns a snapshot of the connections accepted from the
+	// Tunnel that are still open, oldest first. Each can be closed with the
+	// Close method of its ConnInfo.
+	Connections() []ConnInfo
+	// OnAccept registers a function which is called for each connection
+	// that arrives at the Tunnel, describing whether it was returned from
+	// Accept or rejected, and why. It replaces any function registered
+	// previously, and nil unregisters it.
+	//
+	// The function is called inline by Accept, before the connection is
+	// returned, so it must not block. Connections whose handshakes are
+	// checked, as for config.WithRequiredSNI and
+	// config.WithWebsocketUnwrapping, are checked concurrently, and
+	// the function is called for those that fail from the goroutine that
+	// checked them.
+	OnAccept(fn func(AcceptEvent))
+	// OnSlowConsumer registers a function which is called when the bytes
+	// written to a connection accepted from the Tunnel, but not yet sent on
+	// to the ngrok edge, exceed the watermark. This happens when the client
+	// isn't reading as fast as the server is writing, or when the
+	// connection's write buffer isn't flushed. The function may close the
+	// connection, or shed load some other way.
+	//
+	// The function is called in a goroutine of its own, with the pending
+	// byte count at the time. The count is checked as each write starts, and
+	// the function is only called again for the same connection once a
+	// write has started with the count back at or below the watermark. It
+	// replaces any function registered previously, and nil unregisters it.
+	// The count is also available from the PendingWrites method of each
+	// connection.
+	OnSlowConsumer(watermark int64, fn func(conn net.Conn, pending int64))
+	// Done returns a channel that's closed once the Tunnel has terminated,
+	// either because it was closed, or because Accept found that it had been
+	// closed by its Session or the ngrok service. Like context.Context's
+	// Done, it's meant for use in select statements, e.g. by supervisors
+	// deciding when to restart a tunnel.
+	Done() <-chan struct{}
+	// Err returns nil if Done isn't yet closed. Afterwards, it returns the
+	// reason that the Tunnel terminated: ErrTunnelClosed if it was closed
+	// with Close or CloseWithContext, and otherwise the error returned by
+	// Accept, which wraps net.ErrClosed. If the Tunnel terminated because its
+	// Session ended, the error also matches ErrSessionClosed if the
+	// application closed the Session, ErrStoppedByService if the ngrok
+	// service stopped it, or ErrSessionLost if it gave up reconnecting.
+	Err() error
+}
+
+// The kinds of [Tunnel] that can be started.
+const (
+	// A tunnel with a public endpoint, started with [config.HTTPEndpoint],
+	// [config.TCPEndpoint], or [config.TLSEndpoint].
+	TunnelKindEndpoint = "endpoint"
+	// A tunnel attached to an ngrok Edge, started with
+	// [config.LabeledTunnel].
+	TunnelKindLabeled = "labeled"
+)
+
+// The protocols of the endpoints that a [Tunnel] may have, as returned by
+// [Tunnel].Proto. Labeled tunnels have no protocol of their own, and report
+// the empty string.
+const (
+	ProtoHTTP  = "http"
+	ProtoHTTPS = "https"
+	ProtoTCP   = "tcp"
+	ProtoTLS   = "tls"
+)
+
+// ParseProto maps a protocol name, ignoring case and surrounding whitespace,
+// to the matching Proto constant, e.g. for validating user input before
+// comparing it against [Tunnel].Proto. It returns an error for protocols that
+// no [Tunnel] can have.
+func ParseProto(proto string) (string, error) {
+	switch normalized := strings.ToLower(strings.TrimSpace(proto)); normalized {
+	case ProtoHTTP, ProtoHTTPS, ProtoTCP, ProtoTLS:
+		return normalized, nil
+	default:
+		return "", fmt.Errorf("unknown tunnel protocol %q", proto)
+	}
+}
+
+// TunnelInfo is a point-in-time description of a [Tunnel], returned by
+// [Tunnel].Describe. It contains no references to the live tunnel and can be
+// marshaled to JSON as-is.
+type TunnelInfo struct {
+	// The tunnel's unique ID.
+	ID string `json:"id"`
+	// The tunnel endpoint's URL. Empty for labeled tunnels.
+	URL string `json:"url,omitempty"`
+	// The protocol of the tunnel's endpoint. Empty for labeled tunnels.
+	Proto string `json:"proto,omitempty"`
+	// One of [TunnelKindEndpoint] or [TunnelKindLabeled].
+	Kind string `json:"kind"`
+	// The human-readable description of the tunnel's backend.
+	ForwardsTo string `json:"forwards_to"`
+	// The opaque metadata string for the tunnel.
+	Metadata string `json:"metadata,omitempty"`
+	// The tunnel's labels. Empty for non-labeled tunnels.
+	Labels map[string]string `json:"labels,omitempty"`
+	// The time at which the tunnel was started.
+	StartedAt time.Time `json:"started_at"`
+	// How long the tunnel had been running when it was described.
+	Uptime time.Duration `json:"uptime"`
+	// Whether the tunnel was in drain mode. See [Tunnel].SetDraining.
+	Draining bool `json:"draining"`
+	// Whether the tunnel was paused. See [Tunnel].Pause.
+	Paused bool `json:"paused"`
+	// Whether the tunnel was in maintenance mode. See
+	// [Tunnel].SetMaintenance.
+	Maintenance bool `json:"maintenance"`
+	// The number of connections that were closed because of their SNI. See
+	// config.WithRequiredSNI.
+	SNIRejections uint64 `json:"sni_rejections"`
+	// The number of times that a connection's pending writes crossed the
+	// watermark set with [Tunnel].OnSlowConsumer.
+	SlowConsumers uint64 `json:"slow_consumers"`
+	// The number of connections that were closed because they timed out.
+	// See config.WithConnIdleTimeout, config.WithHandshakeTimeout, and
+	// config.WithConnMaxLifetime.
+	ConnTimeouts uint64 `json:"conn_timeouts"`
 }
 
 // Listen creates a new [Tunnel] after connecting a new [Session]. This is a
@@ -70,19 +412,244 @@ func Listen(ctx context.Context, tunnelConfig config.Tunnel, connectOpts ...Conn
 }
 
 type tunnelImpl struct {
-	Sess   Session
-	Tunnel tunnel_client.Tunnel
+	// The ID of the last connection to arrive. Accessed atomically. Kept
+	// first to guarantee 64-bit alignment.
+	lastConnID uint64
+	// The number of connections closed because of their SNI. Accessed
+	// atomically.
+	sniRejections uint64
+	// The number of times the slow consumer callback has fired. Accessed
+	// atomically.
+	slowConsumers uint64
+	// The number of connections closed by their idle, handshake, or lifetime
+	// timeouts. Accessed atomically.
+	connTimeouts uint64
+	// The number of connections returned from Accept, and turned away
+	// instead. Accessed atomically.
+	acceptedConns uint64
+	rejectedConns uint64
+	// The number of connections whose reads or writes failed. Accessed
+	// atomically.
+	connErrors uint64
+	// The bytes read from and written to connections that have been closed.
+	// Guarded by statsMu, which is held while connections are removed from
+	// conns, so that their bytes are counted exactly once.
+	closedBytesIn  uint64
+	closedBytesOut uint64
+	statsMu        sync.Mutex
+
+	Sess      Session
+	Tunnel    tunnel_client.Tunnel
+	StartedAt time.Time
+
+	// Activity tracking for the parent session's idle timeout.
+	idle      *idleTracker
+	closeOnce sync.Once
+
+	// Write buffering for accepted connections, set by
+	// config.WithConnWriteBuffer.
+	writeBufferSize int
+	flushInterval   time.Duration
+
+	// Notified about each accepted connection, if non-nil.
+	tracer Tracer
+	// Reports the traffic of each accepted connection, if non-nil.
+	metrics *sessionMetrics
+	// Resolves the location of each accepted connection, if non-nil.
+	geo *geoResolver
+	// The source of time for accepted connections' timers. The system clock
+	// if nil.
+	clock clock
+
+	// How long accepted connections may be idle before they're closed, set
+	// by config.WithConnIdleTimeout.
+	connIdleTimeout time.Duration
+	// How long accepted connections may wait for the client's first bytes,
+	// set by config.WithHandshakeTimeout.
+	handshakeTimeout time.Duration
+	// How long accepted connections may stay open, set by
+	// config.WithConnMaxLifetime.
+	connMaxLifetime time.Duration
+	// The patterns that the SNI of accepted connections must match, set by
+	// config.WithRequiredSNI.
+	requiredSNI []string
+	// Whether accepted connections are unwrapped from WebSockets, set by
+	// config.WithWebsocketUnwrapping.
+	unwrapWebsockets bool
+	// Decides whether to accept each connection, set by
+	// config.WithConnectionCallback.
+	connCallback config.ConnectionCallback
+	// The bytes per second that accepted connections may read or write, set
+	// by config.WithConnBandwidthLimit.
+	bandwidthLimit int64
+	// The rates shared by the tunnel's connections, set by
+	// config.WithMaxBytesPerSecond and config.WithMaxConnsPerSecond, and
+	// those shared with the rest of its session's tunnels. Unlimited if nil.
+	limits        *trafficLimit
+	sessionLimits *trafficLimit
+
+	// Receives the tunnel's events. Discards them if nil.
+	logger log15.Logger
+	// Recovers from panics in the application's callbacks for the tunnel's
+	// connections, and reports them to its session's error handler.
+	guard callbackGuard
+	// The configuration that the tunnel was started with, so that it can be
+	// started again, such as by a Pool. Nil for tunnels made by tests.
+	cfg config.Tunnel
+
+	// Non-nil if connections are accepted in parallel, as configured by
+	// config.WithAcceptConcurrency.
+	workers *acceptWorkers
+	// Checks the handshakes of arriving connections, once Accept is first
+	// called on a tunnel that needs them checked.
+	handshakesOnce sync.Once
+	handshakes     *handshakeQueue
+	// Accepts connections for AcceptStream and AcceptContext, once either is
+	// first called.
+	streamsMu sync.Mutex
+	streams   *acceptWorkers
+
+	// Non-zero while in drain mode. Accessed atomically.
+	draining int32
+
+	// The maintenanceState set by SetMaintenance.
+	maintenance atomic.Value
+	// Non-nil while paused, and closed when resumed.
+	pauseMu sync.Mutex
+	resumed chan struct{}
+
+	// The connections accepted from the tunnel that are still open.
+	conns ConnSet
+
+	// The pings that PingRTT is waiting on.
+	pings pingTokens
+
+	// Called for each connection that arrives, as a func(AcceptEvent).
+	onAccept atomic.Value
+	// Called for connections whose writes back up, as a *slowConsumerHook.
+	onSlowConsumer atomic.Value
+
+	// Closed once the tunnel has terminated, for any reason. Created lazily,
+	// so that the zero tunnelImpl is usable.
+	doneMu sync.Mutex
+	done   chan struct{}
+	err    error
 }
 
 func (t *tunnelImpl) Accept() (net.Conn, error) {
-	conn, err := t.Tunnel.Accept()
+	if t.workers != nil {
+		return t.workers.accept()
+	}
+	return t.acceptOne()
+}
+
+func (t *tunnelImpl) acceptOne() (net.Conn, error) {
+	for {
+		c, err := t.acceptNext()
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			return c, nil
+		}
+	}
+}
+
+// Accepts the next connection. Returns nil if it was closed instead, because
+// one of the application's callbacks panicked while it was being set up.
+func (t *tunnelImpl) acceptNext() (*connImpl, error) {
+	var (
+		conn       *tunnel_client.ProxyConn
+		serverName string
+		err        error
+	)
+	for {
+		conn, serverName, err = t.nextConn()
+		if err != nil {
+			break
+		}
+		if t.connCallback == nil {
+			break
+		}
+		info := config.ConnInfo{
+			TunnelID:   t.ID(),
+			ClientAddr: conn.Header.ClientAddr,
+			Proto:      conn.Header.Proto,
+			EdgeType:   conn.Header.EdgeType,
+			ServerName: serverName,
+		}
+		// A panic rejects the connection too.
+		rejected := t.guarded("connection", func() error {
+			return t.connCallback(context.Background(), info)
+		})
+		if rejected == nil {
+			break
+		}
+		rejectConn(conn, http.StatusForbidden)
+		t.notifyAccept(atomic.AddUint64(&t.lastConnID, 1), conn.Header.ClientAddr, rejected)
+	}
 	if err != nil {
-		return nil, errAcceptFailed{Inner: err}
+		err = errAcceptFailed{Inner: err, Reason: t.sessionEndReason()}
+		t.terminate(err)
+		return nil, err
 	}
-	return &connImpl{
+	t.idle.acquire()
+	c := &connImpl{
 		Conn:  conn.Conn,
 		Proxy: conn,
-	}, nil
+		Tun:   t,
+		id:    atomic.AddUint64(&t.lastConnID, 1),
+
+		serverName: serverName,
+	}
+	c.queue.w = conn.Conn
+	clock := clockOrSystem(t.clock)
+	c.acceptedAt = clock.Now()
+	if t.writeBufferSize > 0 {
+		c.buf = newWriteBuffer(clock, &c.queue, t.writeBufferSize, t.flushInterval)
+	}
+	c.limit = newBandwidthLimiter(clock, t.bandwidthLimit, t.limits, t.sessionLimits)
+	if t.connIdleTimeout > 0 {
+		c.idle = newConnIdleTimer(clock, t.connIdleTimeout, c.timedOut)
+	}
+	if t.handshakeTimeout > 0 {
+		c.handshake = newHandshakeTimer(clock, t.handshakeTimeout, c.timedOut)
+	}
+	if t.connMaxLifetime > 0 {
+		c.lifetime = newLifetimeTimer(clock, t.connMaxLifetime, c.timedOut)
+	}
+	if t.geo != nil {
+		c.geo = &connGeo{resolver: t.geo, clientAddr: conn.Header.ClientAddr}
+	}
+	var panicked error
+	if t.tracer != nil {
+		panicked = t.guarded("tracer", func() error {
+			c.trace = startConnTrace(clock, t.tracer, ConnAttributes{
+				TunnelID:   t.ID(),
+				ConnID:     c.id,
+				BindID:     conn.Header.ID,
+				ClientAddr: conn.Header.ClientAddr,
+				Proto:      conn.Header.Proto,
+				EdgeType:   conn.Header.EdgeType,
+			})
+			return nil
+		})
+	}
+	c.metrics = t.metrics.accepted(t.ID())
+	t.conns.add(c)
+	// Started last, since they close the connection, which uses everything
+	// set up above.
+	c.idle.start()
+	c.handshake.start()
+	c.lifetime.start()
+	if panicked == nil {
+		panicked = t.notifyAccept(c.id, conn.Header.ClientAddr, nil)
+	}
+	if panicked != nil {
+		_ = c.Close()
+		return nil, nil
+	}
+	return c, nil
 }
 
 func (t *tunnelImpl) Close() error {
@@ -91,8 +658,149 @@ func (t *tunnelImpl) Close() error {
 	return t.CloseWithContext(ctx)
 }
 
-func (t *tunnelImpl) CloseWithContext(_ context.Context) error {
-	return t.Tunnel.Close()
+func (t *tunnelImpl) CloseWithContext(ctx context.Context) error {
+	t.closeOnce.Do(func() {
+		// Before closing the underlying tunnel, so that a concurrent Accept
+		// can't report its error as the reason instead.
+		t.terminate(ErrTunnelClosed)
+		t.idle.release()
+		if sess, ok := t.Sess.(*sessionImpl); ok {
+			sess.releaseTunnel(t)
+		}
+	})
+	err := t.Tunnel.CloseWithContext(ctx)
+	t.workers.stop()
+	t.streamsMu.Lock()
+	t.streams.stop()
+	t.streamsMu.Unlock()
+	return err
+}
+
+func (t *tunnelImpl) Drain(ctx context.Context) error {
+	err := t.CloseWithContext(ctx)
+	if drainErr := t.WaitDrained(ctx); drainErr != nil {
+		return drainErr
+	}
+	return err
+}
+
+func (t *tunnelImpl) Shutdown(ctx context.Context) error {
+	err := t.Drain(ctx)
+	if ctx.Err() != nil {
+		for _, conn := range t.conns.Conns() {
+			_ = conn.Close()
+		}
+	}
+	return err
+}
+
+func (t *tunnelImpl) AcceptStream(ctx context.Context) (io.ReadWriteCloser, error) {
+	conn, err := t.acceptContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (t *tunnelImpl) AcceptContext(ctx context.Context) (net.Conn, error) {
+	conn, err := t.acceptContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Accepts the next connection from a worker shared by AcceptStream and
+// AcceptContext, giving up when the context is done.
+func (t *tunnelImpl) acceptContext(ctx context.Context) (net.Conn, error) {
+	t.streamsMu.Lock()
+	if t.streams == nil {
+		t.streams = startAcceptWorkers(1, t.Accept)
+	}
+	streams := t.streams
+	t.streamsMu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	select {
+	case res := <-streams.results:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return res.conn, nil
+	case <-t.Done():
+		// Including when it was closed by the ngrok service, after which
+		// the worker has nothing more to hand out.
+		return nil, errAcceptFailed{Inner: net.ErrClosed}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (t *tunnelImpl) Done() <-chan struct{} {
+	t.doneMu.Lock()
+	defer t.doneMu.Unlock()
+	if t.done == nil {
+		t.done = make(chan struct{})
+	}
+	return t.done
+}
+
+func (t *tunnelImpl) Err() error {
+	t.doneMu.Lock()
+	defer t.doneMu.Unlock()
+	return t.err
+}
+
+// Returns the reason that the tunnel's session ended, or nil if it hasn't, or
+// if it's unknown.
+func (t *tunnelImpl) sessionEndReason() error {
+	if sess, ok := t.Sess.(*sessionImpl); ok {
+		return sess.endReason()
+	}
+	return nil
+}
+
+// Records the reason that the tunnel terminated, and closes its Done channel.
+// Only the first reason is kept.
+func (t *tunnelImpl) terminate(reason error) {
+	t.doneMu.Lock()
+	if t.err != nil {
+		t.doneMu.Unlock()
+		return
+	}
+	t.err = reason
+	if t.done == nil {
+		t.done = make(chan struct{})
+	}
+	close(t.done)
+	t.doneMu.Unlock()
+
+	// Outside of the lock, since the logger may call back into the tunnel.
+	if errors.Is(reason, ErrTunnelClosed) {
+		t.log().Info("tunnel closed")
+	} else {
+		t.log().Warn("tunnel stopped", "reason", reason)
+	}
+}
+
+// Returns the tunnel's logger, which discards everything if it wasn't given
+// one.
+func (t *tunnelImpl) log() log15.Logger {
+	if t.logger == nil {
+		return nopLogger
+	}
+	return t.logger
 }
 
 func (t *tunnelImpl) Addr() net.Addr {
@@ -123,15 +831,587 @@ func (t *tunnelImpl) Labels() map[string]string {
 	return t.Tunnel.RemoteBindConfig().Labels
 }
 
+func (t *tunnelImpl) SetLabels(labels map[string]string) error {
+	set := make(LabelSet, len(labels))
+	for k, v := range labels {
+		set[k] = v
+	}
+	if len(set) == 0 {
+		return errSetLabels{errors.New("at least one label is required")}
+	}
+	if err := set.Validate(); err != nil {
+		return errSetLabels{err}
+	}
+	if err := t.Tunnel.SetLabels(set); err != nil {
+		return errSetLabels{err}
+	}
+	return nil
+}
+
+func (t *tunnelImpl) SetMetadata(ctx context.Context, metadata string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	set := make(chan error, 1)
+	go func() {
+		set <- t.Tunnel.SetMetadata(metadata)
+	}()
+	select {
+	case err := <-set:
+		if err != nil {
+			return errSetMetadata{err}
+		}
+		return nil
+	case <-ctx.Done():
+		// The rebind can't be interrupted, so its outcome is logged instead,
+		// since the caller has been told that it didn't finish.
+		go func() {
+			if err := <-set; err != nil {
+				t.log().Warn("failed to set tunnel metadata after its context was done", "err", err)
+				return
+			}
+			t.log().Info("set tunnel metadata after its context was done", "metadata", metadata)
+		}()
+		return ctx.Err()
+	}
+}
+
+func (t *tunnelImpl) LabelSet() LabelSet {
+	labels := t.Labels()
+	set := make(LabelSet, len(labels))
+	for k, v := range labels {
+		set[k] = v
+	}
+	return set
+}
+
+// Called exactly once for each connection returned by Accept when it's closed.
+func (t *tunnelImpl) connClosed(c *connImpl) {
+	t.idle.release()
+	t.statsMu.Lock()
+	t.closedBytesIn += atomic.LoadUint64(&c.bytesIn)
+	t.closedBytesOut += atomic.LoadUint64(&c.bytesOut)
+	t.conns.remove(c)
+	t.statsMu.Unlock()
+}
+
+func (t *tunnelImpl) ConnSet() *ConnSet {
+	return &t.conns
+}
+
+func (t *tunnelImpl) SetDraining(draining bool) {
+	var flag int32
+	if draining {
+		flag = 1
+	}
+	atomic.StoreInt32(&t.draining, flag)
+}
+
+func (t *tunnelImpl) Pause() {
+	t.pauseMu.Lock()
+	defer t.pauseMu.Unlock()
+	if t.resumed == nil {
+		t.resumed = make(chan struct{})
+	}
+}
+
+func (t *tunnelImpl) Resume() {
+	t.pauseMu.Lock()
+	defer t.pauseMu.Unlock()
+	if t.resumed != nil {
+		close(t.resumed)
+		t.resumed = nil
+	}
+}
+
+func (t *tunnelImpl) paused() bool {
+	t.pauseMu.Lock()
+	defer t.pauseMu.Unlock()
+	return t.resumed != nil
+}
+
+// Blocks while the tunnel is paused. Returns false if the tunnel terminated
+// first.
+func (t *tunnelImpl) waitResumed() bool {
+	t.pauseMu.Lock()
+	resumed := t.resumed
+	t.pauseMu.Unlock()
+	if resumed == nil {
+		return true
+	}
+
+	select {
+	case <-resumed:
+		return true
+	case <-t.Done():
+		return false
+	}
+}
+
+func (t *tunnelImpl) WaitDrained(ctx context.Context) error {
+	return t.conns.wait(ctx)
+}
+
+// ErrNoSession is returned by the methods of the [Session] returned from
+// [Tunnel].Session when the [Tunnel] has no parent session.
+var ErrNoSession = errors.New("tunnel has no session")
+
 func (t *tunnelImpl) Session() Session {
+	if t.Sess == nil {
+		return noSession{}
+	}
 	return t.Sess
 }
 
+func (t *tunnelImpl) HasSession() bool {
+	return t.Sess != nil
+}
+
+// Stands in for the Session of a tunnel that doesn't have one, so that
+// callers get an error rather than a nil pointer dereference.
+type noSession struct{}
+
+func (noSession) Listen(context.Context, config.Tunnel) (Tunnel, error) {
+	return nil, ErrNoSession
+}
+
+func (noSession) MaxTunnels() int {
+	return 0
+}
+
+func (noSession) TransportInfo() TransportInfo {
+	return TransportInfo{}
+}
+
+func (noSession) ID() string {
+	return ""
+}
+
+func (noSession) Info() SessionInfo {
+	return SessionInfo{}
+}
+
+func (noSession) Region() string {
+	return ""
+}
+
+func (noSession) ServerAddr() string {
+	return ""
+}
+
+func (noSession) Close() error {
+	return ErrNoSession
+}
+
+func (noSession) CloseWithContext(context.Context) error {
+	return ErrNoSession
+}
+
+func (noSession) Shutdown(context.Context) error {
+	return ErrNoSession
+}
+
+func (noSession) Metadata() string {
+	return ""
+}
+
+func (noSession) SetMetadata(context.Context, string) error {
+	return ErrNoSession
+}
+
+func (noSession) SetMaxBytesPerSecond(int64) {}
+
+func (noSession) SetMaxConnsPerSecond(float64) {}
+
+func (t *tunnelImpl) Describe() TunnelInfo {
+	cfg := t.Tunnel.RemoteBindConfig()
+
+	kind := TunnelKindEndpoint
+	var labels map[string]string
+	if cfg.Labels != nil {
+		kind = TunnelKindLabeled
+		labels = make(map[string]string, len(cfg.Labels))
+		for k, v := range cfg.Labels {
+			labels[k] = v
+		}
+	}
+
+	return TunnelInfo{
+		ID:          t.ID(),
+		URL:         cfg.URL,
+		Proto:       cfg.ConfigProto,
+		Kind:        kind,
+		ForwardsTo:  t.ForwardsTo(),
+		Metadata:    cfg.Metadata,
+		Labels:      labels,
+		StartedAt:   t.StartedAt,
+		Uptime:      clockOrSystem(t.clock).Now().Sub(t.StartedAt),
+		Draining:    atomic.LoadInt32(&t.draining) != 0,
+		Paused:      t.paused(),
+		Maintenance: t.maintenancePage() != nil,
+
+		SNIRejections: atomic.LoadUint64(&t.sniRejections),
+		SlowConsumers: atomic.LoadUint64(&t.slowConsumers),
+		ConnTimeouts:  atomic.LoadUint64(&t.connTimeouts),
+	}
+}
+
+// connImpl is deliberately not pooled. Callers routinely hold on to
+// connections after closing them (net/http does, for one), and a recycled
+// connImpl would let a late Close or Write land on someone else's connection.
+// At one small allocation per connection, it's also far from the dominant
+// cost of accepting one; see BenchmarkTunnelAccept, which accepts through the
+// same rate limits that Session.Listen sets up.
 type connImpl struct {
+	// The bytes read from and written to the connection. Accessed
+	// atomically. Kept first to guarantee 64-bit alignment.
+	bytesIn  uint64
+	bytesOut uint64
+	// Writes to Conn, counting the bytes that have been written. Kept after
+	// the counters above, which keeps its own counters aligned.
+	queue writeQueue
+
 	net.Conn
 	Proxy *tunnel_client.ProxyConn
+	Tun   *tunnelImpl
+
+	// Unique among the connections that arrived at Tun.
+	id uint64
+	// When the connection was accepted.
+	acceptedAt time.Time
+	// Set once the connection fails or is closed, after which its errors
+	// aren't counted. Accessed atomically.
+	settled int32
+	// The SNI that was checked for config.WithRequiredSNI.
+	serverName string
+
+	// Non-nil if writes are buffered.
+	buf *writeBuffer
+	// Non-nil if the session has a Tracer.
+	trace *connTrace
+	// Non-nil if the session has a Meter.
+	metrics *connMetrics
+	// Non-nil if idle connections are closed.
+	idle *connIdleTimer
+	// Non-nil if connections that don't receive data quickly are closed.
+	handshake *handshakeTimer
+	// Non-nil if connections are closed after a maximum lifetime.
+	lifetime *lifetimeTimer
+	// Non-nil if the connection's bandwidth is limited.
+	limit *bandwidthLimiter
+	// Non-nil if the session resolves client locations.
+	geo *connGeo
+
+	annotationsMu sync.Mutex
+	annotations   map[string]string
+
+	// The Host of the first HTTP request served over the connection by
+	// Serve and friends, as a string.
+	firstHost atomic.Value
+	// The certificates presented by the client when ServeTLS terminated TLS
+	// for the connection, as a []*x509.Certificate.
+	peerCerts atomic.Value
+	// The protocol negotiated via ALPN when ServeTLS terminated TLS for the
+	// connection, as a string.
+	negotiatedProto atomic.Value
+
+	closeOnce sync.Once
+}
+
+func (c *connImpl) Read(p []byte) (n int, err error) {
+	if c.limit == nil {
+		n, err = c.Conn.Read(p)
+	} else {
+		n, err = c.limit.Read(c.Conn, p)
+	}
+	if n > 0 {
+		atomic.AddUint64(&c.bytesIn, uint64(n))
+		c.trace.read(n)
+		c.metrics.read(n)
+		c.idle.touch()
+		c.handshake.received()
+	}
+	if err != nil {
+		c.countError(err)
+	}
+	return n, err
+}
+
+func (c *connImpl) Write(p []byte) (n int, err error) {
+	var w io.Writer = &c.queue
+	if c.buf != nil {
+		w = c.buf
+	}
+	c.checkSlowConsumer(c.queue.enqueue(len(p)))
+	if c.limit == nil {
+		n, err = w.Write(p)
+	} else {
+		n, err = c.limit.Write(w, p)
+	}
+	if n < len(p) {
+		c.queue.dequeue(len(p) - n)
+	}
+	if n > 0 {
+		atomic.AddUint64(&c.bytesOut, uint64(n))
+		c.trace.wrote(n)
+		c.metrics.wrote(n)
+		c.idle.touch()
+	}
+	if err != nil {
+		c.countError(err)
+	}
+	return n, err
+}
+
+func (c *connImpl) SetDeadline(t time.Time) error {
+	if c.limit != nil {
+		c.limit.setReadDeadline(t)
+		c.limit.setWriteDeadline(t)
+	}
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *connImpl) SetReadDeadline(t time.Time) error {
+	if c.limit != nil {
+		c.limit.setReadDeadline(t)
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *connImpl) SetWriteDeadline(t time.Time) error {
+	if c.limit != nil {
+		c.limit.setWriteDeadline(t)
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// Touch marks the connection as active, postponing its closure by
+// config.WithConnIdleTimeout. Use it when a protocol knows that the
+// connection is alive without data crossing it, e.g. on receiving an
+// application-level ping over another channel. It's a no-op if idle
+// connections aren't being closed.
+func (c *connImpl) Touch() {
+	c.idle.touch()
+}
+
+// Context returns the context created for the connection by the session's
+// Tracer, or the background context if there isn't one.
+func (c *connImpl) Context() context.Context {
+	if c.trace == nil || c.trace.ctx == nil {
+		return context.Background()
+	}
+	return c.trace.ctx
+}
+
+// Flush sends any writes buffered due to config.WithConnWriteBuffer. It's a
+// no-op for unbuffered connections.
+func (c *connImpl) Flush() error {
+	return c.buf.Flush()
+}
+
+// Annotate attaches an application-level key/value pair to the connection,
+// e.g. a tenant ID once the client has authenticated. Annotating the same key
+// again replaces its value.
+//
+// The ngrok protocol has no way to send annotations to the edge, so they
+// aren't visible in ngrok's own logs. Instead, they're kept with the
+// connection and reported to the session's Tracer, if any, when the
+// connection is closed.
+func (c *connImpl) Annotate(key, value string) {
+	c.annotationsMu.Lock()
+	defer c.annotationsMu.Unlock()
+	if c.annotations == nil {
+		c.annotations = map[string]string{}
+	}
+	c.annotations[key] = value
+}
+
+// Annotations returns a copy of the annotations added with Annotate.
+func (c *connImpl) Annotations() map[string]string {
+	c.annotationsMu.Lock()
+	defer c.annotationsMu.Unlock()
+	annotations := make(map[string]string, len(c.annotations))
+	for k, v := range c.annotations {
+		annotations[k] = v
+	}
+	return annotations
+}
+
+// CloseWrite shuts down the writing side of the connection, after sending
+// anything still buffered. Fails if the underlying connection doesn't support
+// half-closing.
+func (c *connImpl) CloseWrite() error {
+	if err := c.buf.Flush(); err != nil {
+		return err
+	}
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("connection doesn't support CloseWrite")
+}
+
+// ErrNotSupported is returned by the socket option methods of the connections
+// accepted from a [Tunnel], such as SetLinger, when the underlying connection
+// isn't a TCP connection. Connections from the ngrok service are streams
+// multiplexed over the [Session]'s connection, so socket options don't apply
+// to them individually.
+var ErrNotSupported = errors.New("operation not supported by the underlying connection")
+
+// SetLinger sets the SO_LINGER option of the underlying TCP connection, as
+// with [net.TCPConn.SetLinger]. A value of zero makes Close reset the
+// connection rather than shutting it down gracefully.
+//
+// Returns [ErrNotSupported] if the underlying connection isn't a TCP
+// connection.
+func (c *connImpl) SetLinger(sec int) error {
+	var conn net.Conn = c.Conn
+	for {
+		if lc, ok := conn.(interface{ SetLinger(int) error }); ok {
+			return lc.SetLinger(sec)
+		}
+		wrapper, ok := conn.(interface{ Unwrap() net.Conn })
+		if !ok {
+			return ErrNotSupported
+		}
+		conn = wrapper.Unwrap()
+	}
+}
+
+// Closes the connection when one of its timeouts expires.
+func (c *connImpl) timedOut() {
+	atomic.AddUint64(&c.Tun.connTimeouts, 1)
+	_ = c.Close()
+}
+
+func (c *connImpl) Close() error {
+	// Make a best effort to send anything still buffered, but close the
+	// connection regardless.
+	_ = c.buf.Flush()
+	atomic.StoreInt32(&c.settled, 1)
+	err := c.Conn.Close()
+	if c.limit != nil {
+		c.limit.close()
+	}
+	c.closeOnce.Do(func() {
+		c.idle.stop()
+		c.handshake.received()
+		c.lifetime.stop()
+		c.trace.end(c)
+		c.Tun.connClosed(c)
+	})
+	return err
+}
+
+// FirstRequestHost returns the Host of the first HTTP request that was served
+// over the connection by [Serve], [ServeTLS], or [ServeReverseProxy], or the
+// empty string if none has been yet. For connections to HTTP tunnels serving
+// several domains, such as with a wildcard domain, this identifies which one
+// the client connected for, even where the SNI only matched the wildcard.
+//
+// HTTP/2 clients may reuse a single connection for requests to several
+// hosts that share a certificate. Only the first of them is reported.
+func (c *connImpl) FirstRequestHost() string {
+	host, _ := c.firstHost.Load().(string)
+	return host
+}
+
+// PeerCertificates returns the certificates presented by the client when
+// [ServeTLS] terminated TLS for the connection, leaf first, as configured with
+// [WithTLSClientAuth]. It returns nil if the client didn't present any, or TLS
+// wasn't terminated by ServeTLS.
+func (c *connImpl) PeerCertificates() []*x509.Certificate {
+	certs, _ := c.peerCerts.Load().([]*x509.Certificate)
+	return certs
+}
+
+func (c *connImpl) setPeerCertificates(certs []*x509.Certificate) {
+	if len(certs) > 0 {
+		c.peerCerts.CompareAndSwap(nil, certs)
+	}
+}
+
+// ConnID returns an identifier for the connection, unique among those that
+// arrived at the same [Tunnel], which matches the ConnID of its [AcceptEvent].
+func (c *connImpl) ConnID() uint64 {
+	return c.id
+}
+
+// Geo returns the location of the client that initiated the connection, as
+// resolved by the lookup function configured with [WithGeoIP]. The lookup is
+// done on the first call, and its result is reused by subsequent ones.
+//
+// Returns the zero [GeoInfo] if the session wasn't configured with
+// [WithGeoIP].
+func (c *connImpl) Geo() (GeoInfo, error) {
+	return c.geo.get(c)
 }
 
 func (c *connImpl) ProxyConn() *tunnel_client.ProxyConn {
 	return c.Proxy
 }
+
+// RawProxyHeader returns a copy of the header that the ngrok edge sent ahead
+// of the connection's payload, exactly as it was received. This is intended
+// for diagnosing routing issues.
+//
+// The header is framed the same way for every type of tunnel: a
+// little-endian, 64-bit length, followed by that many bytes of JSON
+// describing the connection. For tunnels using PROXY protocol (see
+// config.WithProxyProto), the PROXY header is *not* included here, since the
+// edge sends it as part of the payload; it's the first data read from the
+// connection.
+//
+// The returned slice may be empty if the header wasn't captured.
+func (c *connImpl) RawProxyHeader() []byte {
+	if c.Proxy == nil || len(c.Proxy.RawHeader) == 0 {
+		return nil
+	}
+	return append([]byte(nil), c.Proxy.RawHeader...)
+}
+
+// EdgeRouteInfo describes how the ngrok edge routed a connection to a labeled
+// [Tunnel], as returned by the EdgeRoute method of the connections accepted
+// from it. It's read from the proxy header that the edge sends ahead of each
+// connection.
+type EdgeRouteInfo struct {
+	// The type of the edge that the connection arrived at, e.g. "https",
+	// "tcp", or "tls".
+	EdgeType string
+	// The ID of the tunnel binding that the edge proxied the connection for.
+	BindID string
+	// The protocol of the connection.
+	Proto string
+	// Whether the edge passed the client's TLS through without terminating it.
+	PassthroughTLS bool
+	// The fields of the proxy header that the SDK doesn't otherwise
+	// interpret, such as route-specific metadata, keyed by name and left as
+	// the raw JSON sent by the edge. Nil if there are none.
+	Extra map[string]json.RawMessage
+}
+
+// EdgeRoute returns the edge routing context for a connection accepted from a
+// labeled tunnel. It returns the zero [EdgeRouteInfo] for connections to
+// tunnels that aren't labeled, since those aren't routed by an edge.
+func (c *connImpl) EdgeRoute() EdgeRouteInfo {
+	if c.Proxy == nil || c.Tun == nil || len(c.Tun.Labels()) == 0 {
+		return EdgeRouteInfo{}
+	}
+
+	header := c.Proxy.Header
+	info := EdgeRouteInfo{
+		EdgeType:       header.EdgeType,
+		BindID:         header.ID,
+		Proto:          header.Proto,
+		PassthroughTLS: header.PassthroughTLS,
+	}
+
+	// The raw header is framed by a 64-bit length; see RawProxyHeader.
+	if raw := c.Proxy.RawHeader; len(raw) > 8 {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw[8:], &fields); err == nil {
+			info.Extra = extraProxyHeaderFields(fields)
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,276 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jpillora/backoff"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+// TunnelGroup manages several tunnels on the same [Session], as started by
+// [StartTunnels]. Each tunnel that stops while its session is still running,
+// such as because the ngrok service closed it, is started again with the same
+// configuration, with backoff between attempts. Tunnels that were closed
+// deliberately, or whose session ended, are left stopped.
+type TunnelGroup struct {
+	sess  Session
+	cfgs  []config.Tunnel
+	cfg   tunnelGroupConfig
+	clock clock
+
+	mu      sync.Mutex
+	tunnels []Tunnel
+	closing bool
+	// The reason that the first tunnel stopped for good, other than being
+	// closed.
+	err error
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// TunnelGroupOption customizes a [TunnelGroup].
+type TunnelGroupOption func(*tunnelGroupConfig)
+
+// Options to use when managing a [TunnelGroup].
+type tunnelGroupConfig struct {
+	// The bounds on the wait between attempts to restart a tunnel.
+	// Default to 500ms and 30s when 0.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Called after each attempt to restart a tunnel.
+	RestartHandler func(index int, tun Tunnel, err error)
+}
+
+// WithTunnelRestartBackoff bounds how long a [TunnelGroup] waits before each
+// attempt to restart a tunnel. The wait starts at min, and doubles after each
+// failed attempt up to max.
+//
+// If unset, the wait starts at 500ms and grows to at most 30s.
+func WithTunnelRestartBackoff(min, max time.Duration) TunnelGroupOption {
+	return func(cfg *tunnelGroupConfig) {
+		cfg.MinBackoff = min
+		cfg.MaxBackoff = max
+	}
+}
+
+// WithTunnelRestartHandler configures a function which is called after each
+// attempt by a [TunnelGroup] to restart one of its tunnels, with the index of
+// its configuration. On success, it's called with the new [Tunnel], which
+// replaces the old one in the group's Tunnels. Otherwise, it's called with
+// the error, and the group tries again after a while.
+func WithTunnelRestartHandler(handler func(index int, tun Tunnel, err error)) TunnelGroupOption {
+	return func(cfg *tunnelGroupConfig) {
+		cfg.RestartHandler = handler
+	}
+}
+
+// StartTunnels starts a tunnel on the [Session] for each of the
+// configurations, and returns a [TunnelGroup] that keeps them running. The
+// tunnels are started in order, and if any of them fails to start, those
+// already started are closed and the error is returned, so that either every
+// tunnel is started or none is.
+func StartTunnels(ctx context.Context, sess Session, cfgs []config.Tunnel, opts ...TunnelGroupOption) (*TunnelGroup, error) {
+	g := &TunnelGroup{
+		sess:    sess,
+		cfgs:    cfgs,
+		clock:   systemClock,
+		tunnels: make([]Tunnel, 0, len(cfgs)),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(&g.cfg)
+	}
+	if impl, ok := sess.(*sessionImpl); ok {
+		g.clock = clockOrSystem(impl.clock)
+	}
+
+	for i, cfg := range cfgs {
+		tun, err := sess.Listen(ctx, cfg)
+		if err != nil {
+			for _, started := range g.tunnels {
+				_ = started.Close()
+			}
+			return nil, errStartTunnels{Index: i, Inner: err}
+		}
+		g.tunnels = append(g.tunnels, tun)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(cfgs))
+	for i := range cfgs {
+		go func(i int) {
+			defer wg.Done()
+			g.supervise(i)
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(g.done)
+	}()
+	return g, nil
+}
+
+// Tunnels returns the group's current tunnels, in the order of their
+// configurations. Tunnels that have been restarted are replaced by their new
+// Tunnel.
+func (g *TunnelGroup) Tunnels() []Tunnel {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]Tunnel(nil), g.tunnels...)
+}
+
+// Done returns a channel that's closed once every tunnel in the group has
+// stopped, and won't be restarted.
+func (g *TunnelGroup) Done() <-chan struct{} {
+	return g.done
+}
+
+// Wait blocks until every tunnel in the group has stopped, and won't be
+// restarted. It returns nil if the tunnels were closed, whether with CloseAll
+// or individually, or otherwise the reason that the first of them stopped for
+// good, such as its [Session] ending.
+func (g *TunnelGroup) Wait() error {
+	<-g.done
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.err
+}
+
+// CloseAll stops restarting the group's tunnels, closes each of them with the
+// context, and waits for the group to finish. It returns the first error from
+// closing a tunnel, or the context's error if it's done first.
+func (g *TunnelGroup) CloseAll(ctx context.Context) error {
+	g.mu.Lock()
+	g.closing = true
+	tunnels := append([]Tunnel(nil), g.tunnels...)
+	g.mu.Unlock()
+	g.stopOnce.Do(func() {
+		close(g.stop)
+	})
+
+	var firstErr error
+	for _, tun := range tunnels {
+		if err := tun.CloseWithContext(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	select {
+	case <-g.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return firstErr
+}
+
+// Keeps the tunnel for the configuration at the index running, until it stops
+// for good.
+func (g *TunnelGroup) supervise(i int) {
+	minBackoff, maxBackoff := g.cfg.MinBackoff, g.cfg.MaxBackoff
+	if minBackoff == 0 {
+		minBackoff = 500 * time.Millisecond
+	}
+	if maxBackoff == 0 {
+		maxBackoff = 30 * time.Second
+	}
+	boff := &backoff.Backoff{Min: minBackoff, Max: maxBackoff, Factor: 2, Jitter: true}
+
+	for {
+		g.mu.Lock()
+		tun := g.tunnels[i]
+		g.mu.Unlock()
+
+		select {
+		case <-tun.Done():
+		case <-g.stop:
+			return
+		}
+		if cause := tun.Err(); !restartable(cause) {
+			g.fail(cause)
+			return
+		}
+
+		boff.Reset()
+		for {
+			timer := g.clock.NewTimer(boff.Duration())
+			select {
+			case <-timer.C():
+			case <-g.stop:
+				timer.Stop()
+				return
+			}
+
+			next, err := g.listen(i)
+			if g.cfg.RestartHandler != nil {
+				g.cfg.RestartHandler(i, next, err)
+			}
+			if err == nil {
+				break
+			}
+			if impl, ok := g.sess.(*sessionImpl); ok {
+				if reason := impl.endReason(); reason != nil {
+					g.fail(reason)
+					return
+				}
+			}
+		}
+	}
+}
+
+// Starts the tunnel for the configuration at the index again, replacing the
+// one that stopped.
+func (g *TunnelGroup) listen(i int) (Tunnel, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-g.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	tun, err := g.sess.Listen(ctx, g.cfgs[i])
+	if err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// CloseAll won't have seen the new tunnel.
+	if g.closing {
+		_ = tun.Close()
+		return nil, ErrTunnelClosed
+	}
+	g.tunnels[i] = tun
+	return tun, nil
+}
+
+func (g *TunnelGroup) fail(cause error) {
+	if errors.Is(cause, ErrTunnelClosed) {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.err == nil {
+		g.err = cause
+	}
+}
+
+// Reports whether a tunnel that stopped for the reason should be restarted,
+// which it should unless it was closed, or its session ended.
+func restartable(cause error) bool {
+	for _, final := range []error{ErrTunnelClosed, ErrSessionClosed, ErrStoppedByService, ErrSessionLost} {
+		if errors.Is(cause, final) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+// A session whose tunnels are fake. Its Listen fails while failures remain,
+// and once it has started limit tunnels, if that's positive.
+type groupSession struct {
+	Session
+	t *testing.T
+
+	mu       sync.Mutex
+	failures int
+	limit    int
+	started  []Tunnel
+}
+
+func (s *groupSession) Listen(_ context.Context, _ config.Tunnel) (Tunnel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 || (s.limit > 0 && len(s.started) >= s.limit) {
+		s.failures--
+		return nil, errors.New("listen failed")
+	}
+	tun, _ := fakeTunnel(s.t)
+	s.started = append(s.started, tun)
+	return tun, nil
+}
+
+func groupConfigs(n int) []config.Tunnel {
+	cfgs := make([]config.Tunnel, n)
+	for i := range cfgs {
+		cfgs[i] = config.TCPEndpoint()
+	}
+	return cfgs
+}
+
+func TestTunnelGroupRestart(t *testing.T) {
+	sess := &groupSession{t: t}
+	restarts := make(chan error, 2)
+	group, err := StartTunnels(context.Background(), sess, groupConfigs(2),
+		WithTunnelRestartBackoff(time.Millisecond, time.Millisecond),
+		WithTunnelRestartHandler(func(index int, tun Tunnel, err error) {
+			require.Equal(t, 1, index)
+			restarts <- err
+		}))
+	require.NoError(t, err)
+	tunnels := group.Tunnels()
+	require.Len(t, tunnels, 2)
+
+	// A tunnel that fails while its session is running is restarted, after
+	// retrying while the session can't start it.
+	sess.mu.Lock()
+	sess.failures = 1
+	sess.mu.Unlock()
+	tunnels[1].(*tunnelImpl).terminate(errors.New("tunnel failed"))
+	require.Error(t, <-restarts)
+	require.NoError(t, <-restarts)
+	restarted := group.Tunnels()
+	require.Equal(t, tunnels[0], restarted[0])
+	require.NotEqual(t, tunnels[1], restarted[1])
+
+	require.NoError(t, group.CloseAll(context.Background()))
+	require.NoError(t, group.Wait())
+	for _, tun := range restarted {
+		require.ErrorIs(t, tun.Err(), ErrTunnelClosed)
+	}
+}
+
+func TestTunnelGroupStartFailure(t *testing.T) {
+	// The second tunnel fails to start, so the first is closed.
+	sess := &groupSession{t: t, limit: 1}
+	_, err := StartTunnels(context.Background(), sess, groupConfigs(3))
+	require.ErrorIs(t, err, errStartTunnels{})
+	require.Contains(t, err.Error(), "tunnel 1")
+	require.Len(t, sess.started, 1)
+	require.ErrorIs(t, sess.started[0].Err(), ErrTunnelClosed)
+}
+
+func TestTunnelGroupSessionEnded(t *testing.T) {
+	group, err := StartTunnels(context.Background(), &groupSession{t: t}, groupConfigs(2))
+	require.NoError(t, err)
+	tunnels := group.Tunnels()
+
+	// Tunnels aren't restarted once their session has ended, nor once
+	// they're closed.
+	tunnels[0].(*tunnelImpl).terminate(errAcceptFailed{Inner: errors.New("closed"), Reason: errSessionLost{}})
+	require.NoError(t, tunnels[1].Close())
+	select {
+	case <-group.Done():
+	case <-time.After(time.Second):
+		require.FailNow(t, "group didn't finish")
+	}
+	require.ErrorIs(t, group.Wait(), ErrSessionLost)
+	require.Equal(t, tunnels, group.Tunnels())
+}
@@ -0,0 +1,118 @@
+package ngrok
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// TunnelStats are the counters of a [Tunnel]'s connections and their traffic
+// since it was started, as returned by [Tunnel].Stats. They're safe to
+// serialize, e.g. for admin APIs.
+type TunnelStats struct {
+	// The bytes read from the tunnel's connections, as sent by their
+	// clients.
+	BytesIn uint64 `json:"bytes_in"`
+	// The bytes written to the tunnel's connections, to be sent to their
+	// clients.
+	BytesOut uint64 `json:"bytes_out"`
+	// The number of connections accepted from the tunnel that are still
+	// open.
+	OpenConns int `json:"open_conns"`
+	// The number of connections returned from Accept.
+	AcceptedConns uint64 `json:"accepted_conns"`
+	// The number of connections that arrived at the tunnel but were turned
+	// away instead of being accepted, for any of the reasons reported to
+	// [Tunnel].OnAccept.
+	RejectedConns uint64 `json:"rejected_conns"`
+	// The number of accepted connections whose reads or writes failed, other
+	// than by reaching EOF, by being closed, or by passing a deadline.
+	ConnErrors uint64 `json:"conn_errors"`
+	// The number of connections that were closed because they timed out, as
+	// also reported by [Tunnel].Describe.
+	ConnTimeouts uint64 `json:"conn_timeouts"`
+}
+
+// ConnInfo describes a connection accepted from a [Tunnel] that was still
+// open when it was returned by [Tunnel].Connections.
+type ConnInfo struct {
+	// The connection's identifier, as returned by its ConnID method.
+	ConnID uint64 `json:"conn_id"`
+	// The address of the client that initiated the connection at the ngrok
+	// edge.
+	RemoteAddr string `json:"remote_addr"`
+	// When the connection was accepted.
+	AcceptedAt time.Time `json:"accepted_at"`
+	// How long the connection had been open.
+	Age time.Duration `json:"age"`
+	// The bytes read from and written to the connection.
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+
+	conn *connImpl
+}
+
+// Close closes the connection, as its own Close method does.
+func (info ConnInfo) Close() error {
+	if info.conn == nil {
+		return nil
+	}
+	return info.conn.Close()
+}
+
+func (t *tunnelImpl) Stats() TunnelStats {
+	t.statsMu.Lock()
+	stats := TunnelStats{
+		BytesIn:  t.closedBytesIn,
+		BytesOut: t.closedBytesOut,
+	}
+	conns := t.conns.snapshot()
+	for _, c := range conns {
+		stats.BytesIn += atomic.LoadUint64(&c.bytesIn)
+		stats.BytesOut += atomic.LoadUint64(&c.bytesOut)
+	}
+	t.statsMu.Unlock()
+
+	stats.OpenConns = len(conns)
+	stats.AcceptedConns = atomic.LoadUint64(&t.acceptedConns)
+	stats.RejectedConns = atomic.LoadUint64(&t.rejectedConns)
+	stats.ConnErrors = atomic.LoadUint64(&t.connErrors)
+	stats.ConnTimeouts = atomic.LoadUint64(&t.connTimeouts)
+	return stats
+}
+
+func (t *tunnelImpl) Connections() []ConnInfo {
+	conns := t.conns.snapshot()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+
+	now := clockOrSystem(t.clock).Now()
+	infos := make([]ConnInfo, len(conns))
+	for i, c := range conns {
+		infos[i] = ConnInfo{
+			ConnID:     c.id,
+			RemoteAddr: c.RemoteAddr().String(),
+			AcceptedAt: c.acceptedAt,
+			Age:        now.Sub(c.acceptedAt),
+			BytesIn:    atomic.LoadUint64(&c.bytesIn),
+			BytesOut:   atomic.LoadUint64(&c.bytesOut),
+			conn:       c,
+		}
+	}
+	return infos
+}
+
+// Counts the first error that a read or write of the connection fails with,
+// unless it's one that open connections see in the normal course of things,
+// or the connection has been closed, after which they all fail.
+func (c *connImpl) countError(err error) {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&c.settled, 0, 1) {
+		atomic.AddUint64(&c.Tun.connErrors, 1)
+	}
+}
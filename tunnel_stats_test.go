@@ -0,0 +1,102 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTunnelStats(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+
+	_, err = io.WriteString(client, "hello")
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 5))
+	require.NoError(t, err)
+	_, err = io.WriteString(conn, "hi")
+	require.NoError(t, err)
+
+	stats := tun.Stats()
+	require.Equal(t, TunnelStats{
+		BytesIn:       5,
+		BytesOut:      2,
+		OpenConns:     1,
+		AcceptedConns: 1,
+	}, stats)
+
+	conns := tun.Connections()
+	require.Len(t, conns, 1)
+	require.Equal(t, conn.(Conn).ConnID(), conns[0].ConnID)
+	require.Equal(t, client.LocalAddr().String(), conns[0].RemoteAddr)
+	require.WithinDuration(t, time.Now(), conns[0].AcceptedAt, time.Minute)
+	require.GreaterOrEqual(t, conns[0].Age, time.Duration(0))
+	require.Equal(t, uint64(5), conns[0].BytesIn)
+	require.Equal(t, uint64(2), conns[0].BytesOut)
+
+	// Closing a connection keeps its traffic in the totals.
+	require.NoError(t, conns[0].Close())
+	_, err = client.Read(make([]byte, 2))
+	require.NoError(t, err)
+	_, err = client.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.Empty(t, tun.Connections())
+	stats = tun.Stats()
+	require.Equal(t, 0, stats.OpenConns)
+	require.Equal(t, uint64(5), stats.BytesIn)
+	require.Equal(t, uint64(2), stats.BytesOut)
+
+	// Reads after closing fail without counting as errors.
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.Zero(t, tun.Stats().ConnErrors)
+}
+
+func TestTunnelStatsRejected(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	tun.SetDraining(true)
+
+	rejected, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer rejected.Close()
+	accepted := make(chan net.Conn, 1)
+	tun.OnAccept(func(ev AcceptEvent) {
+		if ev.Rejected != nil {
+			tun.SetDraining(false)
+		}
+	})
+	go func() {
+		conn, err := tun.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	// Waits for the first connection to be turned away before dialing the
+	// next, which is accepted.
+	_, err = rejected.Read(make([]byte, 1))
+	require.Error(t, err)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	conn := <-accepted
+	defer conn.Close()
+
+	// Resetting the connection fails its reads.
+	require.NoError(t, client.(*net.TCPConn).SetLinger(0))
+	require.NoError(t, client.Close())
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+
+	stats := tun.Stats()
+	require.Equal(t, uint64(1), stats.AcceptedConns)
+	require.Equal(t, uint64(1), stats.RejectedConns)
+	require.Equal(t, uint64(1), stats.ConnErrors)
+}
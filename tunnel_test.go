@@ -0,0 +1,632 @@
+package ngrok
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+// A tunnel_client.Tunnel backed by a local TCP listener, so that the public
+// Tunnel machinery can be exercised without connecting to the ngrok service.
+type fakeClientTunnel struct {
+	net.Listener
+	url       string
+	labels    map[string]string
+	metadata  string
+	header    proto.ProxyHeader
+	rawHeader []byte
+}
+
+func (f *fakeClientTunnel) Accept() (*tunnel_client.ProxyConn, error) {
+	conn, err := f.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &tunnel_client.ProxyConn{
+		Header:    f.header,
+		Conn:      conn,
+		RawHeader: f.rawHeader,
+	}, nil
+}
+
+func (f *fakeClientTunnel) RemoteBindConfig() *tunnel_client.RemoteBindConfig {
+	return &tunnel_client.RemoteBindConfig{
+		URL:      f.url,
+		Labels:   f.labels,
+		Metadata: f.metadata,
+	}
+}
+
+func (f *fakeClientTunnel) ID() string {
+	return "fake"
+}
+
+func (f *fakeClientTunnel) ForwardsTo() string {
+	return "fake-forwards-to"
+}
+
+func (f *fakeClientTunnel) CloseWithContext(context.Context) error {
+	return f.Listener.Close()
+}
+
+func (f *fakeClientTunnel) SetMetadata(metadata string) error {
+	if f.labels == nil {
+		return tunnel_client.ErrNotLabeled
+	}
+	f.metadata = metadata
+	return nil
+}
+
+func (f *fakeClientTunnel) SetLabels(labels map[string]string) error {
+	if f.labels == nil {
+		return tunnel_client.ErrNotLabeled
+	}
+	f.labels = labels
+	return nil
+}
+
+// Starts a Tunnel accepting connections from a local listener. The returned
+// address can be dialed to simulate connections arriving from the ngrok edge.
+func fakeTunnel(t *testing.T) (Tunnel, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "local listener")
+
+	addr := l.Addr().String()
+	tun := &tunnelImpl{
+		Tunnel: &fakeClientTunnel{
+			Listener: l,
+			url:      "tcp://" + addr,
+			header: proto.ProxyHeader{
+				ClientAddr: "127.0.0.1:1234",
+			},
+		},
+		StartedAt: time.Now(),
+		limits:    newTrafficLimit(systemClock, 0, 0),
+	}
+
+	t.Cleanup(func() {
+		_ = tun.Close()
+	})
+
+	return tun, addr
+}
+
+func TestDescribe(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	info := tun.Describe()
+	require.Equal(t, "fake", info.ID)
+	require.Equal(t, "tcp://"+addr, info.URL)
+	require.Equal(t, TunnelKindEndpoint, info.Kind)
+	require.Equal(t, "fake-forwards-to", info.ForwardsTo)
+	require.Nil(t, info.Labels)
+	require.Positive(t, info.Uptime)
+
+	buf, err := json.Marshal(info)
+	require.NoError(t, err, "marshal TunnelInfo")
+
+	var decoded TunnelInfo
+	require.NoError(t, json.Unmarshal(buf, &decoded), "unmarshal TunnelInfo")
+	require.Equal(t, info.ID, decoded.ID)
+	require.Equal(t, info.URL, decoded.URL)
+	require.Equal(t, info.Kind, decoded.Kind)
+	require.True(t, info.StartedAt.Equal(decoded.StartedAt))
+}
+
+func TestTunnelDone(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+
+	select {
+	case <-tun.Done():
+		require.FailNow(t, "Done closed before the tunnel terminated")
+	default:
+	}
+	require.NoError(t, tun.Err())
+
+	require.NoError(t, tun.Close())
+	<-tun.Done()
+	require.ErrorIs(t, tun.Err(), ErrTunnelClosed)
+
+	_, err := tun.Accept()
+	require.Error(t, err)
+	require.ErrorIs(t, tun.Err(), ErrTunnelClosed, "the first reason is kept")
+}
+
+func TestTunnelDoneRemoteClose(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+
+	// As when the session closes the tunnel out from under us.
+	require.NoError(t, tun.(*tunnelImpl).Tunnel.Close())
+	_, err := tun.Accept()
+	require.Error(t, err)
+
+	<-tun.Done()
+	require.ErrorIs(t, tun.Err(), net.ErrClosed)
+	require.NotErrorIs(t, tun.Err(), ErrTunnelClosed)
+}
+
+func TestTunnelShutdown(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = conn.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, tun.Shutdown(ctx), "waits for connections to close")
+	require.Zero(t, tun.ConnSet().Len())
+	require.ErrorIs(t, tun.Err(), ErrTunnelClosed)
+}
+
+func TestTunnelShutdownDeadline(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = tun.Accept()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, tun.Shutdown(ctx), context.DeadlineExceeded)
+
+	// The connection still open at the deadline is closed.
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = client.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.Zero(t, tun.ConnSet().Len())
+}
+
+func TestTunnelDrain(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	drained := make(chan error, 1)
+	go func() {
+		drained <- tun.Drain(ctx)
+	}()
+
+	<-tun.Done()
+	require.ErrorIs(t, tun.Err(), ErrTunnelClosed, "new connections are no longer routed")
+
+	// The connection that was already accepted keeps working.
+	_, err = io.WriteString(conn, "still here")
+	require.NoError(t, err)
+	buf := make([]byte, len("still here"))
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	select {
+	case <-drained:
+		require.FailNow(t, "drained with a connection open")
+	default:
+	}
+
+	require.NoError(t, conn.Close())
+	require.NoError(t, <-drained, "returns once the connections finish")
+}
+
+func TestTunnelDrainDeadline(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, tun.Drain(ctx), context.DeadlineExceeded)
+
+	// Unlike Shutdown, the connection is left open.
+	_, err = io.WriteString(conn, "still here")
+	require.NoError(t, err)
+	require.Equal(t, 1, tun.ConnSet().Len())
+}
+
+func TestPause(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	tun.Pause()
+	require.True(t, tun.Describe().Paused)
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := tun.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	select {
+	case <-accepted:
+		require.FailNow(t, "connection accepted while paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Held connections are delivered, intact, once resumed.
+	_, err = io.WriteString(client, "held")
+	require.NoError(t, err)
+	tun.Resume()
+	require.False(t, tun.Describe().Paused)
+
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+		buf := make([]byte, 4)
+		_, err := io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, "held", string(buf))
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "connection wasn't accepted after resuming")
+	}
+}
+
+func TestPauseClose(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+	tun.Pause()
+
+	exited := make(chan error)
+	go func() {
+		_, err := tun.Accept()
+		exited <- err
+	}()
+
+	require.NoError(t, tun.Close())
+	require.ErrorIs(t, <-exited, net.ErrClosed, "closing a paused tunnel unblocks Accept")
+}
+
+func TestConnSetLinger(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+
+	// Closing with a linger of zero resets the connection.
+	require.NoError(t, conn.(interface{ SetLinger(int) error }).SetLinger(0))
+	require.NoError(t, conn.Close())
+	_, err = client.Read(make([]byte, 1))
+	require.ErrorIs(t, err, syscall.ECONNRESET)
+
+	// Connections that aren't TCP don't support it.
+	local, remote := net.Pipe()
+	defer remote.Close()
+	pipe := &connImpl{Conn: local}
+	require.ErrorIs(t, pipe.SetLinger(0), ErrNotSupported)
+}
+
+func TestAcceptStream(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	// Gives up when the context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := tun.AcceptStream(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Each call returns the next connection, including one that arrived
+	// after an earlier call gave up.
+	for _, msg := range []string{"first", "second"} {
+		client, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer client.Close()
+		_, err = io.WriteString(client, msg)
+		require.NoError(t, err)
+
+		stream, err := tun.AcceptStream(context.Background())
+		require.NoError(t, err)
+		defer stream.Close()
+		buf := make([]byte, len(msg))
+		_, err = io.ReadFull(stream, buf)
+		require.NoError(t, err)
+		require.Equal(t, msg, string(buf))
+	}
+
+	exited := make(chan error)
+	go func() {
+		_, err := tun.AcceptStream(context.Background())
+		exited <- err
+	}()
+	require.NoError(t, tun.Close())
+	require.ErrorIs(t, <-exited, net.ErrClosed)
+}
+
+func TestAcceptContextDeadline(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	accept := func(ctx context.Context) (client, conn net.Conn) {
+		client, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = client.Close() })
+		conn, err = tun.AcceptContext(ctx)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		return client, conn
+	}
+
+	// The context's deadline carries over to the connection.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, conn := accept(ctx)
+	_, err := conn.Read(make([]byte, 1))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// And can be cleared.
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	client, conn := accept(ctx)
+	require.NoError(t, conn.SetDeadline(time.Time{}))
+	<-ctx.Done()
+	time.Sleep(10 * time.Millisecond)
+	_, err = io.WriteString(client, "late")
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err, "the cleared deadline no longer applies")
+	require.Equal(t, "late", string(buf))
+
+	// Without a deadline, none is set.
+	client, conn = accept(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = io.WriteString(client, "ok")
+	}()
+	buf = make([]byte, 2)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+
+	// Gives up when the context is done.
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = tun.AcceptContext(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestTunnelProtos(t *testing.T) {
+	cases := []struct {
+		name  string
+		cfg   config.Tunnel
+		proto string
+	}{
+		{"http", config.HTTPEndpoint(config.WithScheme(config.SchemeHTTP)), ProtoHTTP},
+		{"https", config.HTTPEndpoint(), ProtoHTTPS},
+		{"tcp", config.TCPEndpoint(), ProtoTCP},
+		{"tls", config.TLSEndpoint(), ProtoTLS},
+		{"labeled", config.LabeledTunnel(config.WithLabel("app", "web")), ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			proto := tc.cfg.(tunnelConfigPrivate).Proto()
+			require.Equal(t, tc.proto, proto)
+			if proto != "" {
+				parsed, err := ParseProto(proto)
+				require.NoError(t, err, "every endpoint reports a known proto")
+				require.Equal(t, proto, parsed)
+			}
+		})
+	}
+}
+
+func TestParseProto(t *testing.T) {
+	parsed, err := ParseProto(" HTTPS ")
+	require.NoError(t, err)
+	require.Equal(t, ProtoHTTPS, parsed)
+
+	for _, invalid := range []string{"", "udp", "http2", "ws"} {
+		_, err := ParseProto(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestDraining(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+
+	existing, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer existing.Close()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+
+	tun.SetDraining(true)
+	require.True(t, tun.Describe().Draining)
+
+	accepted := make(chan net.Conn)
+	go func() {
+		next, err := tun.Accept()
+		if err == nil {
+			accepted <- next
+		}
+	}()
+
+	// New connections are closed without being handed out.
+	rejected, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer rejected.Close()
+	require.NoError(t, rejected.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = rejected.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	// Existing ones keep working.
+	_, err = io.WriteString(existing, "ping")
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, tun.WaitDrained(ctx), context.DeadlineExceeded)
+
+	require.NoError(t, conn.Close())
+	require.NoError(t, tun.WaitDrained(context.Background()))
+
+	// Once draining stops, connections are handed out again.
+	tun.SetDraining(false)
+	require.False(t, tun.Describe().Draining)
+	resumed, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer resumed.Close()
+
+	select {
+	case next := <-accepted:
+		_ = next.Close()
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "connection wasn't accepted after draining stopped")
+	}
+}
+
+// A tunnel_client.Tunnel that hands out the same connection forever, to
+// measure the cost of the Accept path in isolation.
+type benchClientTunnel struct {
+	fakeClientTunnel
+	conn *tunnel_client.ProxyConn
+}
+
+func (b *benchClientTunnel) Accept() (*tunnel_client.ProxyConn, error) {
+	return b.conn, nil
+}
+
+type nopConn struct {
+	net.Conn
+}
+
+func (nopConn) Close() error { return nil }
+
+func BenchmarkTunnelAccept(b *testing.B) {
+	// Built like Session.Listen builds tunnels, with limits that are set but
+	// unlimited.
+	tun := &tunnelImpl{
+		Tunnel: &benchClientTunnel{
+			conn: &tunnel_client.ProxyConn{Conn: nopConn{}},
+		},
+		limits:        newTrafficLimit(systemClock, 0, 0),
+		sessionLimits: newTrafficLimit(systemClock, 0, 0),
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn, err := tun.Accept()
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestRawProxyHeader(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	raw := []byte("\x02\x00\x00\x00\x00\x00\x00\x00{}")
+	fake := tun.(*tunnelImpl).Tunnel.(*fakeClientTunnel)
+	fake.rawHeader = raw
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	rawer := conn.(interface{ RawProxyHeader() []byte })
+	actual := rawer.RawProxyHeader()
+	require.Equal(t, raw, actual)
+
+	actual[0] = 0xff
+	require.Equal(t, raw, rawer.RawProxyHeader(), "RawProxyHeader returns a copy")
+}
+
+func TestTunnelNoSession(t *testing.T) {
+	tun, _ := fakeTunnel(t)
+	require.False(t, tun.HasSession())
+
+	sess := tun.Session()
+	require.NotNil(t, sess)
+	_, err := sess.Listen(context.Background(), config.HTTPEndpoint())
+	require.ErrorIs(t, err, ErrNoSession)
+	require.ErrorIs(t, sess.Close(), ErrNoSession)
+	require.Zero(t, sess.MaxTunnels())
+
+	require.True(t, (&tunnelImpl{Sess: &sessionImpl{}}).HasSession())
+}
+
+func TestConnEdgeRoute(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	fake := tun.(*tunnelImpl).Tunnel.(*fakeClientTunnel)
+
+	accept := func() net.Conn {
+		client, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = client.Close() })
+		conn, err := tun.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn
+	}
+	edgeRoute := func(conn net.Conn) EdgeRouteInfo {
+		return conn.(interface{ EdgeRoute() EdgeRouteInfo }).EdgeRoute()
+	}
+
+	require.Zero(t, edgeRoute(accept()), "connections to endpoints aren't routed by an edge")
+
+	fake.labels = map[string]string{"edge": "gateway"}
+	fake.header = proto.ProxyHeader{
+		ID:         "bind_123",
+		ClientAddr: "203.0.113.5:4444",
+		Proto:      "https",
+		EdgeType:   "https",
+	}
+	// As captured from the edge, with fields the SDK doesn't know about.
+	fake.rawHeader = proxyHeader(`{"Id":"bind_123","ClientAddr":"203.0.113.5:4444","Proto":"https","EdgeType":"https","RouteId":"edghtsrt_456","RouteMetadata":{"tenant":"acme"}}`)
+
+	info := edgeRoute(accept())
+	require.Equal(t, "https", info.EdgeType)
+	require.Equal(t, "bind_123", info.BindID)
+	require.Equal(t, "https", info.Proto)
+	require.False(t, info.PassthroughTLS)
+	require.Len(t, info.Extra, 2)
+	require.JSONEq(t, `"edghtsrt_456"`, string(info.Extra["RouteId"]))
+	require.JSONEq(t, `{"tenant":"acme"}`, string(info.Extra["RouteMetadata"]))
+
+	// Without a raw header, only the parsed fields are available.
+	fake.rawHeader = nil
+	info = edgeRoute(accept())
+	require.Equal(t, "bind_123", info.BindID)
+	require.Nil(t, info.Extra)
+}
@@ -0,0 +1,84 @@
+package ngrok
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Wraps a handler so that the connections it hijacks are closed after being
+// idle for idleTimeout. The http.Server already clears its read and write
+// deadlines when a connection is hijacked, so this is the only bound on them.
+func upgradeHandler(handler http.Handler, idleTimeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if _, ok := rw.(http.Hijacker); ok {
+			clock := systemClock
+			if conn, ok := req.Context().Value(tunnelConnKey{}).(*connImpl); ok && conn.Tun != nil {
+				clock = clockOrSystem(conn.Tun.clock)
+			}
+			rw = &upgradeResponseWriter{ResponseWriter: rw, clock: clock, idleTimeout: idleTimeout}
+		}
+		handler.ServeHTTP(rw, req)
+	})
+}
+
+// An http.ResponseWriter that notices when its connection is hijacked.
+type upgradeResponseWriter struct {
+	http.ResponseWriter
+	clock       clock
+	idleTimeout time.Duration
+}
+
+// Unwrap allows http.ResponseController to reach the methods of the
+// underlying http.ResponseWriter that aren't wrapped here.
+func (w *upgradeResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *upgradeResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *upgradeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	upgraded := &upgradedConn{Conn: conn}
+	upgraded.idle = newConnIdleTimer(w.clock, w.idleTimeout, func() {
+		_ = upgraded.Close()
+	})
+	upgraded.idle.start()
+	return upgraded, brw, nil
+}
+
+// A hijacked connection that's closed once it has been idle for too long.
+type upgradedConn struct {
+	net.Conn
+	idle *connIdleTimer
+}
+
+func (c *upgradedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.idle.touch()
+	}
+	return n, err
+}
+
+func (c *upgradedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.idle.touch()
+	}
+	return n, err
+}
+
+func (c *upgradedConn) Close() error {
+	c.idle.stop()
+	return c.Conn.Close()
+}
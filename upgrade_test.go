@@ -0,0 +1,96 @@
+package ngrok
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Upgrades every request to a protocol that echoes back whatever it's sent,
+// standing in for a WebSocket server.
+var echoUpgradeHandler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+	conn, brw, err := rw.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	_, _ = io.Copy(conn, brw)
+})
+
+func startUpgradeServer(t *testing.T, opts ...ServeOption) *bufio.ReadWriter {
+	tun, addr := fakeTunnel(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := make(chan error)
+	go func() {
+		exited <- Serve(ctx, tun, echoUpgradeHandler, opts...)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-exited
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(10*time.Second)))
+
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	require.NoError(t, err)
+
+	brw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	resp, err := http.ReadResponse(brw.Reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	return brw
+}
+
+func echo(brw *bufio.ReadWriter, msg string) (string, error) {
+	if _, err := brw.WriteString(msg + "\n"); err != nil {
+		return "", err
+	}
+	if err := brw.Flush(); err != nil {
+		return "", err
+	}
+	return brw.ReadString('\n')
+}
+
+func TestUpgradeOutlivesReadTimeout(t *testing.T) {
+	readTimeout := 50 * time.Millisecond
+	brw := startUpgradeServer(t,
+		WithReadTimeout(readTimeout),
+		WithUpgradeIdleTimeout(time.Second),
+	)
+
+	// Keep talking well past the read timeout for the upgrade request.
+	for i := 0; i < 5; i++ {
+		time.Sleep(readTimeout)
+		reply, err := echo(brw, "ping")
+		require.NoError(t, err, "upgraded connection closed by the read timeout")
+		require.Equal(t, "ping\n", reply)
+	}
+}
+
+func TestUpgradeIdleTimeout(t *testing.T) {
+	brw := startUpgradeServer(t,
+		WithReadTimeout(time.Second),
+		WithUpgradeIdleTimeout(testIdleTimeout),
+	)
+
+	reply, err := echo(brw, "ping")
+	require.NoError(t, err)
+	require.Equal(t, "ping\n", reply)
+
+	// Without any traffic, the upgraded connection is closed.
+	_, err = brw.ReadString('\n')
+	require.ErrorIs(t, err, io.EOF)
+}
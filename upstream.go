@@ -0,0 +1,111 @@
+package ngrok
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ForwardDialer makes the connections to an upstream, as [net.Dialer] does. It's
+// called with the network and address that [Forward] parsed from its upstream:
+// "tcp" with a host and port, "unix" with a socket path, "pipe" with the path of
+// a Windows named pipe, or the scheme of any other URL with the rest of it.
+type ForwardDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// WithForwardDialer configures [Forward] and [Tunnel].Forward to connect to
+// their upstream with the dialer, rather than over the network. This lets the
+// upstream be a service in the same process, such as an [InMemoryListener], or
+// be reached over a transport that this package doesn't support, such as an
+// SSH connection. Upstream URLs with any scheme are accepted when a dialer is
+// configured.
+//
+// [WithForwardLocalAddr] has no effect when a dialer is configured.
+func WithForwardDialer(dialer ForwardDialer) ForwardOption {
+	return func(cfg *forwardConfig) {
+		cfg.Dialer = dialer
+	}
+}
+
+// Reports whether the upstream is the path of a Windows named pipe, such as
+// \\.\pipe\app or \\server\pipe\app.
+func isNamedPipe(upstream string) bool {
+	if !strings.HasPrefix(upstream, `\\`) {
+		return false
+	}
+	server, rest, ok := strings.Cut(upstream[2:], `\`)
+	return ok && server != "" && strings.HasPrefix(rest, `pipe\`)
+}
+
+// InMemoryListener is a [net.Listener] whose connections are made from within
+// the same process, without going through the network. It's a [ForwardDialer],
+// so that a [Tunnel] can be forwarded to a server listening on it, while still
+// applying the [ForwardOption]s such as [WithUpstreamProxyProto]:
+//
+//	l := ngrok.NewInMemoryListener()
+//	go http.Serve(l, handler)
+//	err := tun.Forward(ctx, "memory://app", ngrok.WithForwardDialer(l))
+//
+// The address that it's dialed with is ignored.
+type InMemoryListener struct {
+	conns     chan net.Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewInMemoryListener creates an [InMemoryListener].
+func NewInMemoryListener() *InMemoryListener {
+	return &InMemoryListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+// Accept waits for the next connection to be dialed.
+func (l *InMemoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the listener. Connections that were already accepted are left
+// open.
+func (l *InMemoryListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return nil
+}
+
+// Addr returns the listener's address, whose network is "memory".
+func (l *InMemoryListener) Addr() net.Addr {
+	return inMemoryAddr{}
+}
+
+// DialContext makes a connection to the listener, waiting for it to be
+// accepted. The network and address are ignored.
+func (l *InMemoryListener) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		_ = client.Close()
+		_ = server.Close()
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: inMemoryAddr{}, Err: net.ErrClosed}
+	case <-ctx.Done():
+		_ = client.Close()
+		_ = server.Close()
+		return nil, ctx.Err()
+	}
+}
+
+type inMemoryAddr struct{}
+
+func (inMemoryAddr) Network() string { return "memory" }
+func (inMemoryAddr) String() string  { return "memory" }
@@ -0,0 +1,46 @@
+package ngrok
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// WithUpstreamTLS configures [Forward] and [Tunnel].Forward to connect to their
+// upstream over TLS, with the provided configuration. Set its Certificates to
+// present a client certificate to the upstream for mutual TLS, and its RootCAs
+// to verify the upstream's certificate against a private CA, e.g.
+//
+//	cert, err := tls.LoadX509KeyPair("client.crt", "client.key")
+//	...
+//	err = tun.Forward(ctx, "upstream.internal:8443", ngrok.WithUpstreamTLS(&tls.Config{
+//		Certificates: []tls.Certificate{cert},
+//		RootCAs:      upstreamCAs,
+//	}))
+//
+// If the configuration has no ServerName, the host of the upstream address is
+// used. A nil configuration verifies the upstream against the system's roots.
+// If [WithUpstreamProxyProto] is also used, the PROXY header is sent before the
+// TLS handshake, as upstreams expect.
+//
+// Connections whose handshake fails are dropped, and the error is reported to
+// the handler configured with [WithForwardErrorHandler].
+func WithUpstreamTLS(tlsConfig *tls.Config) ForwardOption {
+	return func(cfg *forwardConfig) {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		cfg.TLS = tlsConfig
+	}
+}
+
+// Returns the configuration for TLS connections to the upstream address,
+// which names the upstream's host if the configuration doesn't name a server.
+func upstreamTLSConfig(tlsConfig *tls.Config, addr string) *tls.Config {
+	tlsConfig = tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			tlsConfig.ServerName = host
+		}
+	}
+	return tlsConfig
+}
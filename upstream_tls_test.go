@@ -0,0 +1,124 @@
+package ngrok
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Starts a TLS echo server that requires clients to present the given
+// certificate, and reports the names they asked for.
+func startMutualTLSUpstream(t *testing.T, serverCert, clientCert tls.Certificate) (string, <-chan string) {
+	clientCAs := x509.NewCertPool()
+	leaf, err := x509.ParseCertificate(clientCert.Certificate[0])
+	require.NoError(t, err)
+	clientCAs.AddCert(leaf)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	names := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tlsConn := conn.(*tls.Conn)
+				if err := tlsConn.Handshake(); err != nil {
+					return
+				}
+				names <- tlsConn.ConnectionState().ServerName
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String(), names
+}
+
+func TestForwardUpstreamTLS(t *testing.T) {
+	serverCert := testCertificate(t, "upstream.internal")
+	clientCert := testCertificate(t, "client")
+	upstream, names := startMutualTLSUpstream(t, serverCert, clientCert)
+
+	rootCAs := x509.NewCertPool()
+	leaf, err := x509.ParseCertificate(serverCert.Certificate[0])
+	require.NoError(t, err)
+	rootCAs.AddCert(leaf)
+
+	tun, addr := fakeTunnel(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = tun.Forward(ctx, upstream, WithUpstreamTLS(&tls.Config{
+			ServerName:   "upstream.internal",
+			Certificates: []tls.Certificate{clientCert},
+			RootCAs:      rootCAs,
+		}))
+	}()
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = io.WriteString(client, "ping")
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+	require.Equal(t, "upstream.internal", <-names)
+}
+
+func TestForwardUpstreamTLSErrors(t *testing.T) {
+	upstream, _ := startMutualTLSUpstream(t, testCertificate(t, "upstream.internal"), testCertificate(t, "client"))
+
+	tun, addr := fakeTunnel(t)
+	errs := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		// The upstream's certificate isn't trusted by the system roots.
+		_ = tun.Forward(ctx, upstream, WithUpstreamTLS(nil), WithForwardErrorHandler(func(err error) {
+			errs <- err
+		}))
+	}()
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	select {
+	case err := <-errs:
+		require.Contains(t, err.Error(), "TLS handshake")
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "handshake error wasn't reported")
+	}
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = client.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF, "connections are dropped if the handshake fails")
+}
+
+func TestUpstreamTLSConfig(t *testing.T) {
+	base := &tls.Config{MinVersion: tls.VersionTLS13}
+	cfg := upstreamTLSConfig(base, "upstream.internal:8443")
+	require.Equal(t, "upstream.internal", cfg.ServerName)
+	require.Equal(t, uint16(tls.VersionTLS13), cfg.MinVersion)
+	require.Empty(t, base.ServerName, "the provided configuration isn't modified")
+
+	cfg = upstreamTLSConfig(&tls.Config{ServerName: "other.internal"}, "10.0.0.1:8443")
+	require.Equal(t, "other.internal", cfg.ServerName)
+}
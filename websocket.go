@@ -0,0 +1,291 @@
+package ngrok
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrWebsocketHandshake is matched by the reason given in the [AcceptEvent]
+// for connections that were rejected because they didn't open with a valid
+// WebSocket handshake, on a tunnel configured with
+// config.WithWebsocketUnwrapping.
+var ErrWebsocketHandshake = errors.New("invalid WebSocket handshake")
+
+// How long to wait for the handshake when the tunnel has no handshake timeout.
+const websocketHandshakeTimeout = 10 * time.Second
+
+// The GUID that's hashed with the client's key to accept its handshake, from
+// RFC 6455.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The opcodes of WebSocket frames.
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+// The longest payload of a control frame.
+const wsMaxControlPayload = 125
+
+// Reads the WebSocket handshake from a newly accepted connection and answers
+// it. Returns a connection that reads and writes the payloads of the client's
+// messages. Clients whose handshake is invalid are sent an error response, but
+// their connection isn't closed.
+func (t *tunnelImpl) acceptWebsocket(conn net.Conn) (net.Conn, error) {
+	timeout := t.handshakeTimeout
+	if timeout == 0 {
+		timeout = websocketHandshakeTimeout
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+
+	r := bufio.NewReader(conn)
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebsocketHandshake, err)
+	}
+	_ = req.Body.Close()
+
+	key := req.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case req.Method != http.MethodGet,
+		!headerContainsToken(req.Header, "Connection", "upgrade"),
+		!headerContainsToken(req.Header, "Upgrade", "websocket"),
+		key == "":
+		writeResponse(conn, http.StatusBadRequest, nil, nil)
+		return nil, fmt.Errorf("%w: not a WebSocket upgrade", ErrWebsocketHandshake)
+	case req.Header.Get("Sec-WebSocket-Version") != "13":
+		writeResponse(conn, http.StatusUpgradeRequired, http.Header{"Sec-Websocket-Version": {"13"}}, nil)
+		return nil, fmt.Errorf("%w: unsupported version %q", ErrWebsocketHandshake, req.Header.Get("Sec-WebSocket-Version"))
+	}
+
+	_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+	defer func() { _ = conn.SetWriteDeadline(time.Time{}) }()
+	_, err = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: "+websocketAccept(key)+"\r\n\r\n")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebsocketHandshake, err)
+	}
+	return &websocketConn{Conn: conn, r: r}, nil
+}
+
+// Reports whether one of the comma-separated values of the header is the
+// token, ignoring case.
+func headerContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// The Sec-WebSocket-Accept header that answers the client's key.
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// A WebSocket connection from a client, carrying a stream of bytes in the
+// payloads of its messages. Reads return the payloads of the client's data
+// frames, answering its pings along the way, and writes are sent as binary
+// messages.
+type websocketConn struct {
+	net.Conn
+	// Reads from the connection, including anything that was buffered while
+	// reading the handshake.
+	r *bufio.Reader
+
+	readMu sync.Mutex
+	// What's left of the payload of the data frame being read, and its mask.
+	remaining uint64
+	mask      [4]byte
+	maskPos   int
+	// Set once the client has sent a close frame.
+	readClosed bool
+
+	writeMu sync.Mutex
+	// Set once a close frame has been sent.
+	writeClosed bool
+}
+
+func (c *websocketConn) Read(p []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for c.remaining == 0 {
+		if c.readClosed {
+			return 0, io.EOF
+		}
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.unmask(p[:n])
+	c.remaining -= uint64(n)
+	return n, err
+}
+
+// Reads frame headers until one starts a data frame, handling any control
+// frames before it.
+func (c *websocketConn) nextFrame() error {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return err
+	}
+	opcode := head[0] & 0x0f
+	if head[1]&0x80 == 0 {
+		return c.protocolError("client frame isn't masked")
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if _, err := io.ReadFull(c.r, c.mask[:]); err != nil {
+		return err
+	}
+	c.maskPos = 0
+
+	switch opcode {
+	case wsOpContinuation, wsOpText, wsOpBinary:
+		c.remaining = length
+		return nil
+	case wsOpClose, wsOpPing, wsOpPong:
+	default:
+		return c.protocolError(fmt.Sprintf("unknown opcode %#x", opcode))
+	}
+
+	if length > wsMaxControlPayload {
+		return c.protocolError("control frame is too long")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return err
+	}
+	c.unmask(payload)
+
+	var err error
+	switch opcode {
+	case wsOpPing:
+		err = c.writeFrame(wsOpPong, payload)
+	case wsOpClose:
+		c.readClosed = true
+		// Echo the client's status code, as the close handshake expects.
+		if len(payload) > 2 {
+			payload = payload[:2]
+		}
+		err = c.writeFrame(wsOpClose, payload)
+	}
+	// The client's frames are still read after a close frame has been sent.
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	return err
+}
+
+func (c *websocketConn) unmask(p []byte) {
+	for i := range p {
+		p[i] ^= c.mask[c.maskPos&3]
+		c.maskPos++
+	}
+}
+
+// Closes the connection with a protocol error status, and returns the error.
+func (c *websocketConn) protocolError(reason string) error {
+	_ = c.writeFrame(wsOpClose, []byte{0x03, 0xea}) // 1002
+	_ = c.Conn.Close()
+	return fmt.Errorf("websocket protocol error: %s", reason)
+}
+
+func (c *websocketConn) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := c.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Writes a single unmasked frame, as servers send.
+func (c *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.writeClosed {
+		return net.ErrClosed
+	}
+	if opcode == wsOpClose {
+		c.writeClosed = true
+	}
+
+	var head []byte
+	switch length := len(payload); {
+	case length <= wsMaxControlPayload:
+		head = []byte{0x80 | opcode, byte(length)}
+	case length <= 0xffff:
+		head = make([]byte, 4)
+		head[0], head[1] = 0x80|opcode, 126
+		binary.BigEndian.PutUint16(head[2:], uint16(length))
+	default:
+		head = make([]byte, 10)
+		head[0], head[1] = 0x80|opcode, 127
+		binary.BigEndian.PutUint64(head[2:], uint64(length))
+	}
+	buffers := net.Buffers{head, payload}
+	_, err := buffers.WriteTo(c.Conn)
+	return err
+}
+
+// CloseWrite sends the client a close frame, which ends the stream in that
+// direction. The client's data can still be read until it answers.
+func (c *websocketConn) CloseWrite() error {
+	err := c.writeFrame(wsOpClose, []byte{0x03, 0xe8}) // 1000
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func (c *websocketConn) Close() error {
+	_ = c.Conn.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+	_ = c.CloseWrite()
+	return c.Conn.Close()
+}
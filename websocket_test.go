@@ -0,0 +1,203 @@
+package ngrok
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The sample handshake key from RFC 6455, and its answer.
+const (
+	testWebsocketKey    = "dGhlIHNhbXBsZSBub25jZQ=="
+	testWebsocketAccept = "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="
+)
+
+// Writes a masked frame, as clients send.
+func writeClientFrame(t *testing.T, w io.Writer, fin bool, opcode byte, payload []byte) {
+	head := []byte{opcode, 0x80}
+	if fin {
+		head[0] |= 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		head[1] |= byte(len(payload))
+	default:
+		head[1] |= 126
+		head = append(head, byte(len(payload)>>8), byte(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i%4]
+	}
+	_, err := w.Write(append(append(head, mask...), masked...))
+	require.NoError(t, err)
+}
+
+// Reads an unmasked frame, as servers send.
+func readServerFrame(t *testing.T, r io.Reader) (byte, []byte) {
+	var head [2]byte
+	_, err := io.ReadFull(r, head[:])
+	require.NoError(t, err)
+	require.Zero(t, head[1]&0x80, "server frames aren't masked")
+	length := int(head[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		_, err := io.ReadFull(r, ext[:])
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	require.NoError(t, err)
+	return head[0] & 0x0f, payload
+}
+
+func websocketTunnel(t *testing.T) (Tunnel, string) {
+	tun, addr := fakeTunnel(t)
+	tun.(*tunnelImpl).unwrapWebsockets = true
+	return tun, addr
+}
+
+// Accepts the next connection from the tunnel in the background, since
+// handshakes are only answered once Accept has been called.
+func acceptAsync(t *testing.T, tun Tunnel) <-chan net.Conn {
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := tun.Accept()
+		if err == nil {
+			t.Cleanup(func() { _ = conn.Close() })
+			accepted <- conn
+		}
+	}()
+	return accepted
+}
+
+func dialWebsocket(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	_, err = io.WriteString(client, "GET /tcp HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: "+testWebsocketKey+"\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	require.NoError(t, err)
+
+	r := bufio.NewReader(client)
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	require.Equal(t, testWebsocketAccept, resp.Header.Get("Sec-WebSocket-Accept"))
+	return client, r
+}
+
+func TestWebsocketUnwrapping(t *testing.T) {
+	tun, addr := websocketTunnel(t)
+	accepted := acceptAsync(t, tun)
+
+	client, r := dialWebsocket(t, addr)
+	// A message in two fragments, with a ping between them.
+	writeClientFrame(t, client, false, wsOpText, []byte("hello "))
+	writeClientFrame(t, client, true, wsOpPing, []byte("ping"))
+	writeClientFrame(t, client, true, wsOpContinuation, make([]byte, 300))
+	conn := <-accepted
+
+	buf := make([]byte, 6+300)
+	_, err := io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello ", string(buf[:6]))
+
+	opcode, payload := readServerFrame(t, r)
+	require.Equal(t, byte(wsOpPong), opcode)
+	require.Equal(t, "ping", string(payload))
+
+	_, err = conn.Write([]byte("reply"))
+	require.NoError(t, err)
+	opcode, payload = readServerFrame(t, r)
+	require.Equal(t, byte(wsOpBinary), opcode)
+	require.Equal(t, "reply", string(payload))
+
+	// The client closing the WebSocket ends the stream, and its close frame
+	// is answered.
+	writeClientFrame(t, client, true, wsOpClose, []byte{0x03, 0xe8, 'b', 'y', 'e'})
+	_, err = conn.Read(buf)
+	require.ErrorIs(t, err, io.EOF)
+	opcode, payload = readServerFrame(t, r)
+	require.Equal(t, byte(wsOpClose), opcode)
+	require.Equal(t, []byte{0x03, 0xe8}, payload)
+}
+
+func TestWebsocketUnwrappingCloseWrite(t *testing.T) {
+	tun, addr := websocketTunnel(t)
+	accepted := acceptAsync(t, tun)
+	client, r := dialWebsocket(t, addr)
+	conn := <-accepted
+
+	require.NoError(t, conn.(Conn).CloseWrite())
+	opcode, _ := readServerFrame(t, r)
+	require.Equal(t, byte(wsOpClose), opcode)
+
+	// The client can still send data, and pings, after it's been closed.
+	writeClientFrame(t, client, true, wsOpPing, nil)
+	writeClientFrame(t, client, true, wsOpBinary, []byte("late"))
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "late", string(buf))
+}
+
+func TestWebsocketUnwrappingRejects(t *testing.T) {
+	tun, addr := websocketTunnel(t)
+	events := make(chan AcceptEvent, 2)
+	tun.OnAccept(func(event AcceptEvent) {
+		events <- event
+	})
+
+	accepted := acceptAsync(t, tun)
+
+	plain, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer plain.Close()
+	_, err = io.WriteString(plain, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(plain), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	event := <-events
+	require.ErrorIs(t, event.Rejected, ErrWebsocketHandshake)
+
+	// Accept moves on to the next connection.
+	dialWebsocket(t, addr)
+	<-accepted
+	require.NoError(t, (<-events).Rejected)
+}
+
+func TestWebsocketUnwrappingSlowClient(t *testing.T) {
+	tun, addr := websocketTunnel(t)
+	tun.(*tunnelImpl).handshakeTimeout = time.Minute
+	accepted := acceptAsync(t, tun)
+
+	// A client that never sends its upgrade request doesn't hold up the
+	// others.
+	silent, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer silent.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	dialWebsocket(t, addr)
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the silent client held up Accept")
+	}
+}
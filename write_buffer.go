@@ -0,0 +1,70 @@
+package ngrok
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"time"
+)
+
+// Buffers writes to a connection, flushing them once the buffer fills, on
+// demand, and at most flushInterval after the first unflushed write.
+//
+// All methods are safe to call on a nil writeBuffer, which has nothing to
+// flush.
+type writeBuffer struct {
+	mu            sync.Mutex
+	w             *bufio.Writer
+	flushInterval time.Duration
+	timer         clockTimer
+	pending       bool
+}
+
+func newWriteBuffer(clock clock, w io.Writer, size int, flushInterval time.Duration) *writeBuffer {
+	b := &writeBuffer{
+		w:             bufio.NewWriterSize(w, size),
+		flushInterval: flushInterval,
+	}
+	if flushInterval > 0 {
+		b.timer = clock.AfterFunc(flushInterval, b.timedFlush)
+		b.timer.Stop()
+	}
+	return b
+}
+
+func (b *writeBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, err := b.w.Write(p)
+	if b.timer != nil && !b.pending && b.w.Buffered() > 0 {
+		b.pending = true
+		b.timer.Reset(b.flushInterval)
+	}
+	return n, err
+}
+
+// Flush sends any buffered data to the underlying connection.
+func (b *writeBuffer) Flush() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flushLocked()
+}
+
+func (b *writeBuffer) flushLocked() error {
+	if b.pending {
+		b.pending = false
+		b.timer.Stop()
+	}
+	return b.w.Flush()
+}
+
+func (b *writeBuffer) timedFlush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Errors are sticky in the bufio.Writer, so they'll be returned by the
+	// next Write or Flush.
+	_ = b.flushLocked()
+}
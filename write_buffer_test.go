@@ -0,0 +1,94 @@
+package ngrok
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// An io.Writer that records each call to Write separately.
+type recordingWriter struct {
+	mu     sync.Mutex
+	writes [][]byte
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes = append(w.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (w *recordingWriter) Writes() [][]byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writes
+}
+
+func TestWriteBufferCoalesces(t *testing.T) {
+	rec := &recordingWriter{}
+	buf := newWriteBuffer(systemClock, rec, 1024, -1)
+
+	for i := 0; i < 10; i++ {
+		_, err := buf.Write([]byte("x"))
+		require.NoError(t, err)
+	}
+	require.Empty(t, rec.Writes(), "small writes are buffered")
+
+	require.NoError(t, buf.Flush())
+	require.Equal(t, [][]byte{bytes.Repeat([]byte("x"), 10)}, rec.Writes())
+}
+
+func TestWriteBufferFlushInterval(t *testing.T) {
+	rec := &recordingWriter{}
+	buf := newWriteBuffer(systemClock, rec, 1024, 10*time.Millisecond)
+
+	_, err := buf.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(rec.Writes()) == 1
+	}, time.Second, time.Millisecond, "buffered data is flushed on the interval")
+	require.Equal(t, "hello", string(rec.Writes()[0]))
+}
+
+func TestWriteBufferNil(t *testing.T) {
+	var buf *writeBuffer
+	require.NoError(t, buf.Flush())
+}
+
+func TestConnWriteBuffer(t *testing.T) {
+	tun, addr := fakeTunnel(t)
+	impl := tun.(*tunnelImpl)
+	impl.writeBufferSize = 1024
+	impl.flushInterval = -1
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+
+	_, err = io.WriteString(conn, "hello, ")
+	require.NoError(t, err)
+	require.NoError(t, conn.(interface{ Flush() error }).Flush())
+
+	got := make([]byte, len("hello, "))
+	_, err = io.ReadFull(client, got)
+	require.NoError(t, err)
+	require.Equal(t, "hello, ", string(got))
+
+	_, err = io.WriteString(conn, "world!")
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	rest, err := io.ReadAll(client)
+	require.NoError(t, err)
+	require.Equal(t, "world!", string(rest), "buffered data is flushed on close")
+}